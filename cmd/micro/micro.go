@@ -228,6 +228,7 @@ func LoadInput(args []string) []*buffer.Buffer {
 
 func main() {
 	defer func() {
+		action.ShutdownLSPServers()
 		if util.Stdout.Len() > 0 {
 			fmt.Fprint(os.Stdout, util.Stdout.String())
 		}
@@ -435,6 +436,7 @@ func DoEvent() {
 				b.Fini()
 			}
 		}
+		action.ShutdownLSPServers()
 		os.Exit(0)
 	case <-sigterm:
 		for _, b := range buffer.OpenBuffers {
@@ -442,6 +444,7 @@ func DoEvent() {
 				b.Fini()
 			}
 		}
+		action.ShutdownLSPServers()
 
 		if screen.Screen != nil {
 			screen.Screen.Fini()
@@ -459,6 +462,7 @@ func DoEvent() {
 					b.Fini()
 				}
 			}
+			action.ShutdownLSPServers()
 
 			if screen.Screen != nil {
 				screen.Screen.Fini()
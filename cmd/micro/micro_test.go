@@ -5,12 +5,14 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/go-errors/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/zyedidia/micro/v2/internal/action"
 	"github.com/zyedidia/micro/v2/internal/buffer"
+	"github.com/zyedidia/micro/v2/internal/clipboard"
 	"github.com/zyedidia/micro/v2/internal/config"
 	"github.com/zyedidia/micro/v2/internal/screen"
 	"github.com/zyedidia/tcell/v2"
@@ -328,6 +330,68 @@ func TestSearchAndReplace(t *testing.T) {
 	assert.Equal(t, srTest3, string(data))
 }
 
+func runCommand(cmd string) {
+	injectKey(tcell.KeyCtrlE, rune(tcell.KeyCtrlE), tcell.ModCtrl)
+	injectString(cmd)
+	injectKey(tcell.KeyEnter, rune(tcell.KeyEnter), tcell.ModNone)
+}
+
+func TestRegisters(t *testing.T) {
+	file, err := createTestFile("micro_registers_test", "one\ntwo\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.Remove(file)
+
+	openFile(file)
+
+	runCommand("bind Alt-1 CopyToRegister")
+	runCommand("bind Alt-2 PasteFromRegister")
+	selectLine := func() {
+		injectKey(tcell.KeyHome, 0, tcell.ModNone)
+		injectKey(tcell.KeyEnd, 0, tcell.ModShift)
+	}
+	toRegister := func(key rune, name string) {
+		injectKey(tcell.KeyRune, key, tcell.ModAlt)
+		injectString(name)
+		injectKey(tcell.KeyEnter, rune(tcell.KeyEnter), tcell.ModNone)
+	}
+
+	// an uppercase register name appends to the register
+	selectLine()
+	toRegister('1', "r")
+	injectKey(tcell.KeyDown, 0, tcell.ModNone)
+	selectLine()
+	toRegister('1', "R")
+	text, err := clipboard.Read('r')
+	assert.NoError(t, err)
+	assert.Equal(t, "onetwo", text)
+
+	// the read-only registers cannot be written
+	selectLine()
+	toRegister('1', "/")
+	assert.True(t, action.InfoBar.HasError)
+	assert.Equal(t, clipboard.ErrReadOnly.Error(), action.InfoBar.Msg)
+
+	injectKey(tcell.KeyDown, 0, tcell.ModNone)
+	toRegister('2', "r")
+	injectKey(tcell.KeyCtrlS, rune(tcell.KeyCtrlS), tcell.ModCtrl)
+	data, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "one\ntwo\nonetwo\n", string(data))
+
+	// the registers are saved next to the history
+	assert.NoError(t, os.MkdirAll(filepath.Join(config.ConfigDir, "buffers"), os.ModePerm))
+	action.InfoBar.SaveHistory()
+	assert.FileExists(t, filepath.Join(config.ConfigDir, "buffers", "history"))
+	assert.FileExists(t, filepath.Join(config.ConfigDir, "buffers", "registers"))
+	assert.NoError(t, clipboard.Write("", 'r'))
+	action.InfoBar.LoadHistory()
+	text, _ = clipboard.Read('r')
+	assert.Equal(t, "onetwo", text)
+}
+
 func TestMultiCursor(t *testing.T) {
 	// TODO
 }
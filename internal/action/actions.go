@@ -85,6 +85,7 @@ func (h *BufPane) MousePress(e *tcell.EventMouse) bool {
 			h.tripleClick = false
 			h.lastClickTime = time.Now()
 
+			h.Cursor.Block = false
 			h.Cursor.OrigSelection[0] = h.Cursor.Loc
 			h.Cursor.CurSelection[0] = h.Cursor.Loc
 			h.Cursor.CurSelection[1] = h.Cursor.Loc
@@ -129,7 +130,7 @@ func (h *BufPane) Center() bool {
 
 // MoveCursorUp is not an action
 func (h *BufPane) MoveCursorUp(n int) {
-	if !h.Buf.Settings["softwrap"].(bool) {
+	if !h.Buf.Settings["softwrap"].(bool) && !h.Folds().HasFolds() {
 		h.Cursor.UpN(n)
 	} else {
 		vloc := h.VLocFromLoc(h.Cursor.Loc)
@@ -148,7 +149,7 @@ func (h *BufPane) MoveCursorUp(n int) {
 
 // MoveCursorDown is not an action
 func (h *BufPane) MoveCursorDown(n int) {
-	if !h.Buf.Settings["softwrap"].(bool) {
+	if !h.Buf.Settings["softwrap"].(bool) && !h.Folds().HasFolds() {
 		h.Cursor.DownN(n)
 	} else {
 		vloc := h.VLocFromLoc(h.Cursor.Loc)
@@ -419,6 +420,40 @@ func (h *BufPane) SelectToEndOfLine() bool {
 	return true
 }
 
+// SelectBlockUp extends the block selection up one line
+func (h *BufPane) SelectBlockUp() bool {
+	h.Cursor.StartBlock()
+	h.Cursor.Up()
+	h.Cursor.UpdateBlock()
+	h.Relocate()
+	return true
+}
+
+// SelectBlockDown extends the block selection down one line
+func (h *BufPane) SelectBlockDown() bool {
+	h.Cursor.StartBlock()
+	h.Cursor.Down()
+	h.Cursor.UpdateBlock()
+	h.Relocate()
+	return true
+}
+
+// SelectBlockLeft extends the block selection left one column
+func (h *BufPane) SelectBlockLeft() bool {
+	h.Cursor.StartBlock()
+	h.Cursor.BlockLeft()
+	h.Relocate()
+	return true
+}
+
+// SelectBlockRight extends the block selection right one column
+func (h *BufPane) SelectBlockRight() bool {
+	h.Cursor.StartBlock()
+	h.Cursor.BlockRight()
+	h.Relocate()
+	return true
+}
+
 // ParagraphPrevious moves the cursor to the previous empty line, or beginning of the buffer if there's none
 func (h *BufPane) ParagraphPrevious() bool {
 	var line int
@@ -465,6 +500,7 @@ func (h *BufPane) Retab() bool {
 
 // CursorStart moves the cursor to the start of the buffer
 func (h *BufPane) CursorStart() bool {
+	h.addJump(h.Cursor.Loc)
 	h.Cursor.Deselect(true)
 	h.Cursor.X = 0
 	h.Cursor.Y = 0
@@ -475,6 +511,7 @@ func (h *BufPane) CursorStart() bool {
 
 // CursorEnd moves the cursor to the end of the buffer
 func (h *BufPane) CursorEnd() bool {
+	h.addJump(h.Cursor.Loc)
 	h.Cursor.Deselect(true)
 	h.Cursor.Loc = h.Buf.End()
 	h.Cursor.StoreVisualX()
@@ -539,7 +576,9 @@ func (h *BufPane) InsertNewline() bool {
 
 // Backspace deletes the previous character
 func (h *BufPane) Backspace() bool {
-	if h.Cursor.HasSelection() {
+	if h.Cursor.Block && h.Cursor.BlockCols[0] == h.Cursor.BlockCols[1] {
+		h.Cursor.BlockDeleteChar(false)
+	} else if h.Cursor.HasSelection() {
 		h.Cursor.DeleteSelection()
 		h.Cursor.ResetSelection()
 	} else if h.Cursor.Loc.GreaterThan(h.Buf.Start()) {
@@ -591,7 +630,9 @@ func (h *BufPane) DeleteWordLeft() bool {
 
 // Delete deletes the next character
 func (h *BufPane) Delete() bool {
-	if h.Cursor.HasSelection() {
+	if h.Cursor.Block && h.Cursor.BlockCols[0] == h.Cursor.BlockCols[1] {
+		h.Cursor.BlockDeleteChar(true)
+	} else if h.Cursor.HasSelection() {
 		h.Cursor.DeleteSelection()
 		h.Cursor.ResetSelection()
 	} else {
@@ -836,6 +877,7 @@ func (h *BufPane) saveBufToFile(filename string, action string, callback func())
 				} else {
 					h.Buf.Path = filename
 					h.Buf.SetName(filename)
+					lspSaved(h.Buf)
 					InfoBar.Message("Saved " + filename)
 					if callback != nil {
 						callback()
@@ -862,6 +904,7 @@ func (h *BufPane) saveBufToFile(filename string, action string, callback func())
 	} else {
 		h.Buf.Path = filename
 		h.Buf.SetName(filename)
+		lspSaved(h.Buf)
 		InfoBar.Message("Saved " + filename)
 		if callback != nil {
 			callback()
@@ -892,6 +935,7 @@ func (h *BufPane) Search(str string, useRegex bool, searchDown bool) error {
 		return err
 	}
 	if found {
+		h.addJump(h.Cursor.Loc)
 		h.Cursor.SetSelectionStart(match[0])
 		h.Cursor.SetSelectionEnd(match[1])
 		h.Cursor.OrigSelection[0] = h.Cursor.CurSelection[0]
@@ -899,6 +943,9 @@ func (h *BufPane) Search(str string, useRegex bool, searchDown bool) error {
 		h.GotoLoc(h.Cursor.CurSelection[1])
 		h.Buf.LastSearch = str
 		h.Buf.LastSearchRegex = useRegex
+		h.Buf.LastSearchMatchCase = false
+		h.Buf.LastSearchWholeWord = false
+		h.Buf.LastSearchSelection = false
 		h.Buf.HighlightSearch = h.Buf.Settings["hlsearch"].(bool)
 	} else {
 		h.Cursor.ResetSelection()
@@ -906,23 +953,135 @@ func (h *BufPane) Search(str string, useRegex bool, searchDown bool) error {
 	return nil
 }
 
+// A findPrompt holds the options of the open find prompt, which can be
+// changed with key bindings while the prompt is open
+type findPrompt struct {
+	flags       buffer.SearchFlags
+	inSelection bool
+
+	// the location and the selection of every cursor when the prompt
+	// was opened
+	locs       []buffer.Loc
+	selections [][2]buffer.Loc
+}
+
+// activeFind is the find prompt that is open, if any
+var activeFind *findPrompt
+
+// label returns the prompt text, which shows the options that are on
+func (f *findPrompt) label() string {
+	var opts []string
+	if f.flags.Regex {
+		opts = append(opts, "regex")
+	}
+	if f.flags.MatchCase {
+		opts = append(opts, "case")
+	}
+	if f.flags.WholeWord {
+		opts = append(opts, "word")
+	}
+	if f.inSelection {
+		opts = append(opts, "selection")
+	}
+	if len(opts) == 0 {
+		return "Find: "
+	}
+	return "Find (" + strings.Join(opts, ", ") + "): "
+}
+
+// hasSelection returns whether a cursor had a selection when the prompt
+// was opened
+func (f *findPrompt) hasSelection() bool {
+	for _, sel := range f.selections {
+		if sel[0] != sel[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// restore puts the cursors back where they were when the prompt was opened
+func (f *findPrompt) restore(b *buffer.Buffer) {
+	for i, c := range b.GetCursors() {
+		if i >= len(f.selections) {
+			break
+		}
+		c.ResetSelection()
+		if sel := f.selections[i]; sel[0] != sel[1] {
+			c.SetSelectionStart(sel[0])
+			c.SetSelectionEnd(sel[1])
+		}
+		c.GotoLoc(f.locs[i])
+	}
+}
+
+// search selects the first match after the cursor, or the first match in
+// the selection of every cursor if the search is limited to the selection
+func (f *findPrompt) search(h *BufPane, resp string) (bool, error) {
+	if !f.inSelection {
+		match, found, err := h.Buf.FindNextFlags(resp, h.Buf.Start(), h.Buf.End(), h.searchOrig, true, f.flags)
+		if found {
+			h.Cursor.SetSelectionStart(match[0])
+			h.Cursor.SetSelectionEnd(match[1])
+			h.Cursor.OrigSelection[0] = h.Cursor.CurSelection[0]
+			h.Cursor.OrigSelection[1] = h.Cursor.CurSelection[1]
+			h.GotoLoc(match[1])
+		}
+		return found, err
+	}
+
+	if resp == "" {
+		return false, nil
+	}
+	anyFound := false
+	for i, c := range h.Buf.GetCursors() {
+		if i >= len(f.selections) {
+			break
+		}
+		sel := f.selections[i]
+		c.SearchRange = sel
+		match, found, err := h.Buf.FindNextFlags(resp, sel[0], sel[1], sel[0], true, f.flags)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			c.SetSelectionStart(match[0])
+			c.SetSelectionEnd(match[1])
+			c.OrigSelection[0] = c.CurSelection[0]
+			c.OrigSelection[1] = c.CurSelection[1]
+			c.GotoLoc(match[1])
+			anyFound = true
+		} else {
+			c.ResetSelection()
+			c.GotoLoc(sel[0])
+		}
+	}
+	h.Relocate()
+	return anyFound, nil
+}
+
 func (h *BufPane) find(useRegex bool) bool {
 	h.searchOrig = h.Cursor.Loc
-	prompt := "Find: "
-	if useRegex {
-		prompt = "Find (regex): "
+	f := &findPrompt{flags: buffer.SearchFlags{Regex: useRegex}}
+	for _, c := range h.Buf.GetCursors() {
+		sel := [2]buffer.Loc{c.Loc, c.Loc}
+		if c.HasSelection() {
+			sel = c.CurSelection
+			if sel[0].GreaterThan(sel[1]) {
+				sel[0], sel[1] = sel[1], sel[0]
+			}
+		}
+		f.locs = append(f.locs, c.Loc)
+		f.selections = append(f.selections, sel)
 	}
+	activeFind = f
+
 	var eventCallback func(resp string)
 	if h.Buf.Settings["incsearch"].(bool) {
 		eventCallback = func(resp string) {
-			match, found, _ := h.Buf.FindNext(resp, h.Buf.Start(), h.Buf.End(), h.searchOrig, true, useRegex)
-			if found {
-				h.Cursor.SetSelectionStart(match[0])
-				h.Cursor.SetSelectionEnd(match[1])
-				h.Cursor.OrigSelection[0] = h.Cursor.CurSelection[0]
-				h.Cursor.OrigSelection[1] = h.Cursor.CurSelection[1]
-				h.GotoLoc(match[1])
-			} else {
+			f.restore(h.Buf)
+			found, _ := f.search(h, resp)
+			if !found && !f.inSelection {
 				h.GotoLoc(h.searchOrig)
 				h.Cursor.ResetSelection()
 			}
@@ -930,24 +1089,27 @@ func (h *BufPane) find(useRegex bool) bool {
 	}
 	findCallback := func(resp string, canceled bool) {
 		// Finished callback
+		activeFind = nil
 		if !canceled {
-			match, found, err := h.Buf.FindNext(resp, h.Buf.Start(), h.Buf.End(), h.searchOrig, true, useRegex)
+			f.restore(h.Buf)
+			found, err := f.search(h, resp)
 			if err != nil {
 				InfoBar.Error(err)
 			}
 			if found {
-				h.Cursor.SetSelectionStart(match[0])
-				h.Cursor.SetSelectionEnd(match[1])
-				h.Cursor.OrigSelection[0] = h.Cursor.CurSelection[0]
-				h.Cursor.OrigSelection[1] = h.Cursor.CurSelection[1]
-				h.GotoLoc(h.Cursor.CurSelection[1])
+				h.addJump(h.searchOrig)
 				h.Buf.LastSearch = resp
-				h.Buf.LastSearchRegex = useRegex
+				h.Buf.LastSearchRegex = f.flags.Regex
+				h.Buf.LastSearchMatchCase = f.flags.MatchCase
+				h.Buf.LastSearchWholeWord = f.flags.WholeWord
+				h.Buf.LastSearchSelection = f.inSelection
 				h.Buf.HighlightSearch = h.Buf.Settings["hlsearch"].(bool)
 			} else {
 				h.Cursor.ResetSelection()
 				InfoBar.Message("No matches found")
 			}
+		} else if f.inSelection {
+			f.restore(h.Buf)
 		} else {
 			h.Cursor.ResetSelection()
 		}
@@ -956,7 +1118,7 @@ func (h *BufPane) find(useRegex bool) bool {
 	if eventCallback != nil && pattern != "" {
 		eventCallback(pattern)
 	}
-	InfoBar.Prompt(prompt, pattern, "Find", eventCallback, findCallback)
+	InfoBar.Prompt(f.label(), pattern, "Find", eventCallback, findCallback)
 	if pattern != "" {
 		InfoBar.SelectAll()
 	}
@@ -985,11 +1147,13 @@ func (h *BufPane) FindNext() bool {
 	if h.Cursor.HasSelection() {
 		searchLoc = h.Cursor.CurSelection[1]
 	}
-	match, found, err := h.Buf.FindNext(h.Buf.LastSearch, h.Buf.Start(), h.Buf.End(), searchLoc, true, h.Buf.LastSearchRegex)
+	start, end := h.Buf.SearchBounds(h.Cursor)
+	match, found, err := h.Buf.FindNextFlags(h.Buf.LastSearch, start, end, searchLoc, true, h.Buf.LastSearchFlags())
 	if err != nil {
 		InfoBar.Error(err)
 	}
 	if found {
+		h.addJump(h.Cursor.Loc)
 		h.Cursor.SetSelectionStart(match[0])
 		h.Cursor.SetSelectionEnd(match[1])
 		h.Cursor.OrigSelection[0] = h.Cursor.CurSelection[0]
@@ -1011,11 +1175,13 @@ func (h *BufPane) FindPrevious() bool {
 	if h.Cursor.HasSelection() {
 		searchLoc = h.Cursor.CurSelection[0]
 	}
-	match, found, err := h.Buf.FindNext(h.Buf.LastSearch, h.Buf.Start(), h.Buf.End(), searchLoc, false, h.Buf.LastSearchRegex)
+	start, end := h.Buf.SearchBounds(h.Cursor)
+	match, found, err := h.Buf.FindNextFlags(h.Buf.LastSearch, start, end, searchLoc, false, h.Buf.LastSearchFlags())
 	if err != nil {
 		InfoBar.Error(err)
 	}
 	if found {
+		h.addJump(h.Cursor.Loc)
 		h.Cursor.SetSelectionStart(match[0])
 		h.Cursor.SetSelectionEnd(match[1])
 		h.Cursor.OrigSelection[0] = h.Cursor.CurSelection[0]
@@ -1104,7 +1270,7 @@ func (h *BufPane) CutLine() bool {
 			if clip, err := clipboard.Read(clipboard.ClipboardReg); err != nil {
 				InfoBar.Error(err)
 			} else {
-				clipboard.WriteMulti(clip+string(h.Cursor.GetSelection()), clipboard.ClipboardReg, h.Cursor.Num, h.Buf.NumCursors())
+				clipboard.AppendMulti(clip+string(h.Cursor.GetSelection()), clipboard.ClipboardReg, h.Cursor.Num, h.Buf.NumCursors())
 			}
 		}
 	} else if time.Since(h.lastCutTime)/time.Second > 10*time.Second || !h.freshClip {
@@ -1249,21 +1415,33 @@ func (h *BufPane) MoveLinesDown() bool {
 // Paste whatever is in the system clipboard into the buffer
 // Delete and paste if the user has a selection
 func (h *BufPane) Paste() bool {
-	clip, err := clipboard.ReadMulti(clipboard.ClipboardReg, h.Cursor.Num, h.Buf.NumCursors())
+	clip, err := clipboard.Read(clipboard.ClipboardReg)
+	if h.Cursor.Num == 0 {
+		index := -1
+		if err == nil {
+			index = clipboardHistoryIndex(clip)
+		}
+		h.startPaste(index)
+	}
 	if err != nil {
 		InfoBar.Error(err)
+	} else if text, block := clipboard.BlockText(clipboard.ClipboardReg, clip, h.Cursor.Num, h.Buf.NumCursors()); block {
+		h.pasteBlock(text)
 	} else {
-		h.paste(clip)
+		h.paste(text)
 	}
+	h.endPaste()
 	h.Relocate()
 	return true
 }
 
 // PastePrimary pastes from the primary clipboard (only use on linux)
 func (h *BufPane) PastePrimary() bool {
-	clip, err := clipboard.ReadMulti(clipboard.PrimaryReg, h.Cursor.Num, h.Buf.NumCursors())
+	clip, block, err := clipboard.ReadBlock(clipboard.PrimaryReg, h.Cursor.Num, h.Buf.NumCursors())
 	if err != nil {
 		InfoBar.Error(err)
+	} else if block {
+		h.pasteBlock(clip)
 	} else {
 		h.paste(clip)
 	}
@@ -1279,6 +1457,14 @@ func (h *BufPane) paste(clip string) {
 		}
 	}
 
+	if h.Cursor.Block && !strings.Contains(clip, "\n") {
+		// a single line is pasted on every line of the block
+		h.Cursor.BlockInsert(clip)
+		h.freshClip = false
+		InfoBar.Message("Pasted clipboard")
+		return
+	}
+
 	if h.Cursor.HasSelection() {
 		h.Cursor.DeleteSelection()
 		h.Cursor.ResetSelection()
@@ -1290,6 +1476,13 @@ func (h *BufPane) paste(clip string) {
 	InfoBar.Message("Pasted clipboard")
 }
 
+// pasteBlock pastes text that was copied from a block selection as a block
+func (h *BufPane) pasteBlock(clip string) {
+	h.Cursor.PasteBlock(strings.Split(clip, "\n"))
+	h.freshClip = false
+	InfoBar.Message("Pasted block")
+}
+
 // JumpToMatchingBrace moves the cursor to the matching brace if it is
 // currently on a brace
 func (h *BufPane) JumpToMatchingBrace() bool {
@@ -1299,6 +1492,7 @@ func (h *BufPane) JumpToMatchingBrace() bool {
 		if r == bp[0] || r == bp[1] || rl == bp[0] || rl == bp[1] {
 			matchingBrace, left, found := h.Buf.FindMatchingBrace(bp, h.Cursor.Loc)
 			if found {
+				h.addJump(h.Cursor.Loc)
 				if left {
 					h.Cursor.GotoLoc(matchingBrace)
 				} else {
@@ -1499,7 +1693,8 @@ func (h *BufPane) ShellMode() bool {
 
 // CommandMode lets the user enter a command
 func (h *BufPane) CommandMode() bool {
-	InfoBar.Prompt("> ", "", "Command", nil, func(resp string, canceled bool) {
+	InfoBar.Prompt("> ", "", "Command", h.previewReplace, func(resp string, canceled bool) {
+		h.Buf.ReplacePreview = nil
 		if !canceled {
 			h.HandleCommand(resp)
 		}
@@ -1533,6 +1728,7 @@ func (h *BufPane) ClearInfo() bool {
 // ForceQuit closes the current tab or view even if there are unsaved changes
 // (no prompt)
 func (h *BufPane) ForceQuit() bool {
+	forgetJumps(h.Buf)
 	h.Buf.Close()
 	if len(MainTab().Panes) > 1 {
 		h.Unsplit()
@@ -1617,6 +1813,7 @@ func (h *BufPane) AddTab() bool {
 func (h *BufPane) PreviousTab() bool {
 	tabsLen := len(Tabs.List)
 	a := Tabs.Active() + tabsLen
+	addSwitchJump()
 	Tabs.SetActive((a - 1) % tabsLen)
 
 	return true
@@ -1625,6 +1822,7 @@ func (h *BufPane) PreviousTab() bool {
 // NextTab switches to the next tab in the tab list
 func (h *BufPane) NextTab() bool {
 	a := Tabs.Active()
+	addSwitchJump()
 	Tabs.SetActive((a + 1) % len(Tabs.List))
 
 	return true
@@ -1668,6 +1866,7 @@ func (h *BufPane) NextSplit() bool {
 		a = 0
 	}
 
+	addSwitchJump()
 	h.tab.SetActive(a)
 
 	return true
@@ -1681,6 +1880,7 @@ func (h *BufPane) PreviousSplit() bool {
 	} else {
 		a = len(h.tab.Panes) - 1
 	}
+	addSwitchJump()
 	h.tab.SetActive(a)
 
 	return true
@@ -1827,6 +2027,74 @@ func (h *BufPane) SpawnMultiCursorSelect() bool {
 	return true
 }
 
+// SelectMatches adds a cursor that selects every match of the last search.
+// If the last search was limited to the selection, only the matches in the
+// search range of every cursor are selected
+func (h *BufPane) SelectMatches() bool {
+	if h.Buf.LastSearch == "" {
+		InfoBar.Message("No search to select the matches of")
+		return false
+	}
+	r, err := h.Buf.CompileSearch(h.Buf.LastSearch, h.Buf.LastSearchFlags())
+	if err != nil {
+		InfoBar.Error(err)
+		return false
+	}
+
+	var ranges [][2]buffer.Loc
+	if h.Buf.LastSearchSelection {
+		for _, c := range h.Buf.GetCursors() {
+			ranges = append(ranges, c.SearchRange)
+		}
+	} else {
+		ranges = [][2]buffer.Loc{{h.Buf.Start(), h.Buf.End()}}
+	}
+	return h.selectMatches(r, ranges)
+}
+
+// selectMatches replaces the cursors with one cursor for every match of r
+// in the ranges. Empty matches are skipped
+func (h *BufPane) selectMatches(r *buffer.Search, ranges [][2]buffer.Loc) bool {
+	var matches [][2]buffer.Loc
+	for _, rg := range ranges {
+		for _, m := range h.Buf.FindAll(r, rg[0], rg[1]) {
+			if m[0] != m[1] {
+				matches = append(matches, m)
+			}
+		}
+	}
+	if len(matches) == 0 {
+		InfoBar.Message("No matches found")
+		return false
+	}
+
+	h.Buf.ClearCursors()
+	for i, m := range matches {
+		c := h.Buf.GetActiveCursor()
+		if i > 0 {
+			c = buffer.NewCursor(h.Buf, buffer.Loc{})
+			h.Buf.AddCursor(c)
+		}
+		c.SetSelectionStart(m[0])
+		c.SetSelectionEnd(m[1])
+		c.OrigSelection[0] = c.CurSelection[0]
+		c.OrigSelection[1] = c.CurSelection[1]
+		c.Loc = c.CurSelection[1]
+	}
+	h.Buf.MergeCursors()
+	h.Buf.SetCurCursor(0)
+	h.Cursor = h.Buf.GetActiveCursor()
+	h.multiWord = false
+	h.Relocate()
+
+	if len(matches) == 1 {
+		InfoBar.Message("Selected 1 match")
+	} else {
+		InfoBar.Message(fmt.Sprintf("Selected %d matches", len(matches)))
+	}
+	return true
+}
+
 // MouseMultiCursor is a mouse action which puts a new cursor at the mouse position
 func (h *BufPane) MouseMultiCursor(e *tcell.EventMouse) bool {
 	b := h.Buf
@@ -1839,6 +2107,25 @@ func (h *BufPane) MouseMultiCursor(e *tcell.EventMouse) bool {
 	return true
 }
 
+// MouseBlockSelect is a mouse action which selects a block from the
+// position where the mouse was pressed to the mouse position
+func (h *BufPane) MouseBlockSelect(e *tcell.EventMouse) bool {
+	mx, my := e.Position()
+	mouseLoc := h.LocFromVisual(buffer.Loc{X: mx, Y: my})
+	h.Cursor.Loc = mouseLoc
+	if h.mouseReleased {
+		h.Cursor.ResetSelection()
+		h.Cursor.StartBlock()
+		h.mouseReleased = false
+	} else {
+		h.Cursor.StoreVisualX()
+		h.Cursor.UpdateBlock()
+	}
+	h.lastLoc = mouseLoc
+	h.Relocate()
+	return true
+}
+
 // SkipMultiCursor moves the current multiple cursor to the next available position
 func (h *BufPane) SkipMultiCursor() bool {
 	lastC := h.Buf.GetCursor(h.Buf.NumCursors() - 1)
@@ -0,0 +1,122 @@
+package action
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/zyedidia/micro/v2/internal/buffer"
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+// bookmarkPrompt asks the user for the name of a bookmark and calls the
+// callback with the name
+func (h *BufPane) bookmarkPrompt(prompt string, callback func(name string)) {
+	InfoBar.Prompt(prompt, "", "Bookmark", nil, func(resp string, canceled bool) {
+		if canceled {
+			return
+		}
+		name := strings.TrimSpace(resp)
+		if r := []rune(name); len(r) != 1 || !unicode.IsLetter(r[0]) && !unicode.IsDigit(r[0]) {
+			InfoBar.Error(errors.New("Invalid bookmark name: " + name))
+			return
+		}
+		callback(name)
+	})
+}
+
+// gotoBookmark moves the cursor to a bookmark
+func (h *BufPane) gotoBookmark(m *buffer.Bookmark) {
+	h.addJump(h.Cursor.Loc)
+	h.Cursor.ResetSelection()
+	h.Cursor.GotoLoc(m.Loc)
+	h.Relocate()
+	if m.Name != "" {
+		InfoBar.Message("Bookmark ", m.Name)
+	}
+}
+
+// ToggleBookmark adds a bookmark on the current line, or removes the
+// bookmarks of the line if it already has one
+func (h *BufPane) ToggleBookmark() bool {
+	if h.Buf.ToggleBookmark(h.Cursor.Loc) {
+		InfoBar.Message("Added bookmark")
+	} else {
+		InfoBar.Message("Removed bookmark")
+	}
+	return true
+}
+
+// NextBookmark moves the cursor to the next bookmark in the buffer
+func (h *BufPane) NextBookmark() bool {
+	return h.cycleBookmark(1)
+}
+
+// PreviousBookmark moves the cursor to the previous bookmark in the buffer
+func (h *BufPane) PreviousBookmark() bool {
+	return h.cycleBookmark(-1)
+}
+
+func (h *BufPane) cycleBookmark(dir int) bool {
+	m := h.Buf.NextBookmark(h.Cursor.Loc, dir)
+	if m == nil {
+		InfoBar.Message("No bookmarks")
+		return false
+	}
+	h.gotoBookmark(m)
+	return true
+}
+
+// SetBookmark asks for a name and places the bookmark with that name at
+// the cursor
+func (h *BufPane) SetBookmark() bool {
+	h.bookmarkPrompt("Set bookmark: ", func(name string) {
+		h.Buf.SetBookmark(name, h.Cursor.Loc)
+		InfoBar.Message("Set bookmark ", name)
+	})
+	return true
+}
+
+// JumpToBookmark asks for a name and moves the cursor to the bookmark
+// with that name
+func (h *BufPane) JumpToBookmark() bool {
+	h.bookmarkPrompt("Jump to bookmark: ", func(name string) {
+		m := h.Buf.GetBookmark(name)
+		if m == nil {
+			InfoBar.Error("No bookmark ", name)
+			return
+		}
+		h.gotoBookmark(m)
+	})
+	return true
+}
+
+// ClearBookmarks removes all bookmarks of the buffer
+func (h *BufPane) ClearBookmarks() bool {
+	h.Buf.ClearBookmarks()
+	InfoBar.Message("Removed all bookmarks")
+	return true
+}
+
+// BookmarksCmd lists the bookmarks of the buffer. Pressing enter on a
+// bookmark jumps to it
+func (h *BufPane) BookmarksCmd(args []string) {
+	marks := h.Buf.SortedBookmarks()
+	if len(marks) == 0 {
+		InfoBar.Message("No bookmarks")
+		return
+	}
+
+	items := make([]string, len(marks))
+	for i, m := range marks {
+		line := strings.TrimSpace(string(h.Buf.LineBytes(m.Loc.Y)))
+		if util.CharacterCountInString(line) > 100 {
+			line = string([]rune(line)[:100]) + "..."
+		}
+		items[i] = fmt.Sprintf("%c %5d:%-3d  %s", m.Sign(), m.Loc.Y+1, m.Loc.X+1, line)
+	}
+	h.OpenPicker("bookmarks", items, func(i int) {
+		h.gotoBookmark(marks[i])
+	})
+}
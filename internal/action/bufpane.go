@@ -220,6 +220,10 @@ type BufPane struct {
 	// freshClip returns true if the clipboard has never been pasted.
 	freshClip bool
 
+	// lastPaste stores the most recent paste from the clipboard so that
+	// PasteCycle can replace it with an older clipboard history entry
+	lastPaste pasteState
+
 	// Was the last mouse event actually a double click?
 	// Useful for detecting triple clicks -- if a double click is detected
 	// but the last mouse event was actually a double click, it's a triple click
@@ -252,6 +256,7 @@ func newBufPane(buf *buffer.Buffer, win display.BWindow, tab *Tab) *BufPane {
 	h.Cursor = h.Buf.GetActiveCursor()
 	h.mouseReleased = true
 
+	lspAttach(buf)
 	return h
 }
 
@@ -324,6 +329,14 @@ func (h *BufPane) PluginCBRune(cb string, r rune) bool {
 
 // OpenBuffer opens the given buffer in this pane.
 func (h *BufPane) OpenBuffer(b *buffer.Buffer) {
+	h.addJump(h.Cursor.Loc)
+	h.openBuffer(b)
+}
+
+// openBuffer opens the given buffer in this pane without recording the
+// location in the jump list
+func (h *BufPane) openBuffer(b *buffer.Buffer) {
+	forgetJumps(h.Buf)
 	h.Buf.Close()
 	h.Buf = b
 	h.BWindow.SetBuffer(b)
@@ -337,6 +350,7 @@ func (h *BufPane) OpenBuffer(b *buffer.Buffer) {
 	// mode when editor is opened
 	h.isOverwriteMode = false
 	h.lastClickTime = time.Time{}
+	lspAttach(b)
 }
 
 // GotoLoc moves the cursor to a new location and adjusts the view accordingly.
@@ -592,17 +606,21 @@ func (h *BufPane) DoRuneInsert(r rune) {
 		if !h.PluginCBRune("preRune", r) {
 			continue
 		}
-		if c.HasSelection() {
-			c.DeleteSelection()
-			c.ResetSelection()
-		}
-
-		if h.isOverwriteMode {
-			next := c.Loc
-			next.X++
-			h.Buf.Replace(c.Loc, next, string(r))
+		if c.Block {
+			c.BlockInsert(string(r))
 		} else {
-			h.Buf.Insert(c.Loc, string(r))
+			if c.HasSelection() {
+				c.DeleteSelection()
+				c.ResetSelection()
+			}
+
+			if h.isOverwriteMode {
+				next := c.Loc
+				next.X++
+				h.Buf.Replace(c.Loc, next, string(r))
+			} else {
+				h.Buf.Insert(c.Loc, string(r))
+			}
 		}
 		if recordingMacro {
 			curmacro = append(curmacro, r)
@@ -644,6 +662,8 @@ func (h *BufPane) HSplitBuf(buf *buffer.Buffer) *BufPane {
 
 // Close this pane.
 func (h *BufPane) Close() {
+	forgetJumps(h.Buf)
+	h.Buf.RemoveFolds(h.Folds())
 	h.Buf.Close()
 }
 
@@ -695,6 +715,10 @@ var BufKeyActions = map[string]BufKeyAction{
 	"SelectToStartOfText":       (*BufPane).SelectToStartOfText,
 	"SelectToStartOfTextToggle": (*BufPane).SelectToStartOfTextToggle,
 	"SelectToEndOfLine":         (*BufPane).SelectToEndOfLine,
+	"SelectBlockUp":             (*BufPane).SelectBlockUp,
+	"SelectBlockDown":           (*BufPane).SelectBlockDown,
+	"SelectBlockLeft":           (*BufPane).SelectBlockLeft,
+	"SelectBlockRight":          (*BufPane).SelectBlockRight,
 	"ParagraphPrevious":         (*BufPane).ParagraphPrevious,
 	"ParagraphNext":             (*BufPane).ParagraphNext,
 	"InsertNewline":             (*BufPane).InsertNewline,
@@ -729,6 +753,32 @@ var BufKeyActions = map[string]BufKeyAction{
 	"IndentLine":                (*BufPane).IndentLine,
 	"Paste":                     (*BufPane).Paste,
 	"PastePrimary":              (*BufPane).PastePrimary,
+	"CopyToRegister":            (*BufPane).CopyToRegister,
+	"CutToRegister":             (*BufPane).CutToRegister,
+	"PasteFromRegister":         (*BufPane).PasteFromRegister,
+	"PasteCycle":                (*BufPane).PasteCycle,
+	"PasteHistory":              (*BufPane).PasteHistory,
+	"ToggleBookmark":            (*BufPane).ToggleBookmark,
+	"NextBookmark":              (*BufPane).NextBookmark,
+	"PreviousBookmark":          (*BufPane).PreviousBookmark,
+	"SetBookmark":               (*BufPane).SetBookmark,
+	"JumpToBookmark":            (*BufPane).JumpToBookmark,
+	"ClearBookmarks":            (*BufPane).ClearBookmarks,
+	"JumpBack":                  (*BufPane).JumpBack,
+	"JumpForward":               (*BufPane).JumpForward,
+	"Fold":                      (*BufPane).Fold,
+	"Unfold":                    (*BufPane).Unfold,
+	"ToggleFold":                (*BufPane).ToggleFold,
+	"FoldAll":                   (*BufPane).FoldAll,
+	"UnfoldAll":                 (*BufPane).UnfoldAll,
+	"ToggleFoldAll":             (*BufPane).ToggleFoldAll,
+	"NextGrepResult":            (*BufPane).NextGrepResult,
+	"PreviousGrepResult":        (*BufPane).PreviousGrepResult,
+	"GotoDefinition":            (*BufPane).GotoDefinition,
+	"Hover":                     (*BufPane).Hover,
+	"FindReferences":            (*BufPane).FindReferences,
+	"CodeAction":                (*BufPane).CodeAction,
+	"FindFile":                  (*BufPane).FindFile,
 	"SelectAll":                 (*BufPane).SelectAll,
 	"OpenFile":                  (*BufPane).OpenFile,
 	"Start":                     (*BufPane).Start,
@@ -774,6 +824,7 @@ var BufKeyActions = map[string]BufKeyAction{
 	"SpawnMultiCursorUp":        (*BufPane).SpawnMultiCursorUp,
 	"SpawnMultiCursorDown":      (*BufPane).SpawnMultiCursorDown,
 	"SpawnMultiCursorSelect":    (*BufPane).SpawnMultiCursorSelect,
+	"SelectMatches":             (*BufPane).SelectMatches,
 	"RemoveMultiCursor":         (*BufPane).RemoveMultiCursor,
 	"RemoveAllMultiCursors":     (*BufPane).RemoveAllMultiCursors,
 	"SkipMultiCursor":           (*BufPane).SkipMultiCursor,
@@ -791,6 +842,7 @@ var BufKeyActions = map[string]BufKeyAction{
 var BufMouseActions = map[string]BufMouseAction{
 	"MousePress":       (*BufPane).MousePress,
 	"MouseMultiCursor": (*BufPane).MouseMultiCursor,
+	"MouseBlockSelect": (*BufPane).MouseBlockSelect,
 }
 
 // MultiActions is a list of actions that should be executed multiple
@@ -823,6 +875,10 @@ var MultiActions = map[string]bool{
 	"SelectToStartOfText":       true,
 	"SelectToStartOfTextToggle": true,
 	"SelectToEndOfLine":         true,
+	"SelectBlockUp":             true,
+	"SelectBlockDown":           true,
+	"SelectBlockLeft":           true,
+	"SelectBlockRight":          true,
 	"ParagraphPrevious":         true,
 	"ParagraphNext":             true,
 	"InsertNewline":             true,
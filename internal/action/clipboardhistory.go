@@ -0,0 +1,114 @@
+package action
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zyedidia/micro/v2/internal/buffer"
+	"github.com/zyedidia/micro/v2/internal/clipboard"
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+// pasteState describes the most recent paste from the clipboard
+type pasteState struct {
+	// the size of the undo stack before the paste
+	start int
+	// the last event of the paste, nil if the paste cannot be cycled
+	top *buffer.TextEvent
+	// the clipboard history entry that was pasted, -1 if the clipboard
+	// contained text that is not in the history
+	index int
+}
+
+// clipboardHistoryIndex returns 0 if clip, the text read from the
+// clipboard, is the newest entry of the clipboard history, and -1 if the
+// clipboard was changed outside micro
+func clipboardHistoryIndex(clip string) int {
+	if clip != clipboard.HistoryText(0) {
+		return -1
+	}
+	return 0
+}
+
+// startPaste must be called before the text of a paste that can be cycled
+// is inserted for the first cursor
+func (h *BufPane) startPaste(index int) {
+	h.lastPaste = pasteState{
+		start: h.Buf.UndoStack.Len(),
+		index: index,
+	}
+}
+
+// endPaste must be called after the text of a paste that can be cycled is
+// inserted for a cursor
+func (h *BufPane) endPaste() {
+	h.lastPaste.top = h.Buf.UndoStack.Peek()
+}
+
+// pasteHistory pastes entry i of the clipboard history at every cursor
+func (h *BufPane) pasteHistory(i int) {
+	h.startPaste(i)
+	h.forEachCursor(func(c *buffer.Cursor) {
+		clip, block := clipboard.ReadHistory(i, c.Num, h.Buf.NumCursors())
+		if block {
+			h.pasteBlock(clip)
+		} else {
+			h.paste(clip)
+		}
+		h.endPaste()
+	})
+}
+
+// PasteCycle replaces the text that was just pasted with the previous
+// entry of the clipboard history
+func (h *BufPane) PasteCycle() bool {
+	p := h.lastPaste
+	if p.top == nil || h.Buf.UndoStack.Peek() != p.top {
+		InfoBar.Error("The last change was not a paste")
+		return false
+	}
+	n := clipboard.HistoryLen()
+	if n == 0 {
+		InfoBar.Error("The clipboard history is empty")
+		return false
+	}
+
+	for h.Buf.UndoStack.Len() > p.start {
+		h.Buf.UndoOneEvent()
+	}
+	i := (p.index + 1) % n
+	h.pasteHistory(i)
+	InfoBar.Message("Pasted clipboard history entry ", i+1, " of ", n)
+	return true
+}
+
+// PasteHistory opens a picker to choose an entry of the clipboard history
+// to paste
+func (h *BufPane) PasteHistory() bool {
+	n := clipboard.HistoryLen()
+	if n == 0 {
+		InfoBar.Message("The clipboard history is empty")
+		return false
+	}
+
+	items := make([]string, n)
+	for i := range items {
+		text := clipboard.HistoryText(i)
+		text = strings.NewReplacer("\n", "^J", "\r", "^M", "\t", "^I").Replace(text)
+		if util.CharacterCountInString(text) > 100 {
+			text = string([]rune(text)[:100]) + "..."
+		}
+		items[i] = fmt.Sprintf("%2d  %s", i+1, text)
+	}
+	h.OpenPicker("clipboard history", items, func(i int) {
+		h.pasteHistory(i)
+		h.Relocate()
+	})
+	return true
+}
+
+// PasteHistoryCmd opens a picker to choose an entry of the clipboard
+// history to paste
+func (h *BufPane) PasteHistoryCmd(args []string) {
+	h.PasteHistory()
+}
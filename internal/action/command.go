@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -18,6 +19,7 @@ import (
 	"github.com/zyedidia/micro/v2/internal/screen"
 	"github.com/zyedidia/micro/v2/internal/shell"
 	"github.com/zyedidia/micro/v2/internal/util"
+	"github.com/zyedidia/micro/v2/pkg/highlight"
 )
 
 // A Command contains information about how to execute a command
@@ -31,38 +33,50 @@ var commands map[string]Command
 
 func InitCommands() {
 	commands = map[string]Command{
-		"set":        {(*BufPane).SetCmd, OptionValueComplete},
-		"reset":      {(*BufPane).ResetCmd, OptionValueComplete},
-		"setlocal":   {(*BufPane).SetLocalCmd, OptionValueComplete},
-		"show":       {(*BufPane).ShowCmd, OptionComplete},
-		"showkey":    {(*BufPane).ShowKeyCmd, nil},
-		"run":        {(*BufPane).RunCmd, nil},
-		"bind":       {(*BufPane).BindCmd, nil},
-		"unbind":     {(*BufPane).UnbindCmd, nil},
-		"quit":       {(*BufPane).QuitCmd, nil},
-		"goto":       {(*BufPane).GotoCmd, nil},
-		"save":       {(*BufPane).SaveCmd, nil},
-		"replace":    {(*BufPane).ReplaceCmd, nil},
-		"replaceall": {(*BufPane).ReplaceAllCmd, nil},
-		"vsplit":     {(*BufPane).VSplitCmd, buffer.FileComplete},
-		"hsplit":     {(*BufPane).HSplitCmd, buffer.FileComplete},
-		"tab":        {(*BufPane).NewTabCmd, buffer.FileComplete},
-		"help":       {(*BufPane).HelpCmd, HelpComplete},
-		"eval":       {(*BufPane).EvalCmd, nil},
-		"log":        {(*BufPane).ToggleLogCmd, nil},
-		"plugin":     {(*BufPane).PluginCmd, PluginComplete},
-		"reload":     {(*BufPane).ReloadCmd, nil},
-		"reopen":     {(*BufPane).ReopenCmd, nil},
-		"cd":         {(*BufPane).CdCmd, buffer.FileComplete},
-		"pwd":        {(*BufPane).PwdCmd, nil},
-		"open":       {(*BufPane).OpenCmd, buffer.FileComplete},
-		"tabmove":    {(*BufPane).TabMoveCmd, nil},
-		"tabswitch":  {(*BufPane).TabSwitchCmd, nil},
-		"term":       {(*BufPane).TermCmd, nil},
-		"memusage":   {(*BufPane).MemUsageCmd, nil},
-		"retab":      {(*BufPane).RetabCmd, nil},
-		"raw":        {(*BufPane).RawCmd, nil},
-		"textfilter": {(*BufPane).TextFilterCmd, nil},
+		"set":            {(*BufPane).SetCmd, OptionValueComplete},
+		"reset":          {(*BufPane).ResetCmd, OptionValueComplete},
+		"setlocal":       {(*BufPane).SetLocalCmd, OptionValueComplete},
+		"show":           {(*BufPane).ShowCmd, OptionComplete},
+		"showkey":        {(*BufPane).ShowKeyCmd, nil},
+		"run":            {(*BufPane).RunCmd, nil},
+		"bind":           {(*BufPane).BindCmd, nil},
+		"unbind":         {(*BufPane).UnbindCmd, nil},
+		"quit":           {(*BufPane).QuitCmd, nil},
+		"goto":           {(*BufPane).GotoCmd, nil},
+		"save":           {(*BufPane).SaveCmd, nil},
+		"replace":        {(*BufPane).ReplaceCmd, nil},
+		"replaceall":     {(*BufPane).ReplaceAllCmd, nil},
+		"selectmatches":  {(*BufPane).SelectMatchesCmd, nil},
+		"vsplit":         {(*BufPane).VSplitCmd, buffer.FileComplete},
+		"hsplit":         {(*BufPane).HSplitCmd, buffer.FileComplete},
+		"tab":            {(*BufPane).NewTabCmd, buffer.FileComplete},
+		"help":           {(*BufPane).HelpCmd, HelpComplete},
+		"eval":           {(*BufPane).EvalCmd, nil},
+		"log":            {(*BufPane).ToggleLogCmd, nil},
+		"plugin":         {(*BufPane).PluginCmd, PluginComplete},
+		"reload":         {(*BufPane).ReloadCmd, nil},
+		"reopen":         {(*BufPane).ReopenCmd, nil},
+		"cd":             {(*BufPane).CdCmd, buffer.FileComplete},
+		"pwd":            {(*BufPane).PwdCmd, nil},
+		"open":           {(*BufPane).OpenCmd, buffer.FileComplete},
+		"tabmove":        {(*BufPane).TabMoveCmd, nil},
+		"tabswitch":      {(*BufPane).TabSwitchCmd, nil},
+		"term":           {(*BufPane).TermCmd, nil},
+		"memusage":       {(*BufPane).MemUsageCmd, nil},
+		"retab":          {(*BufPane).RetabCmd, nil},
+		"raw":            {(*BufPane).RawCmd, nil},
+		"textfilter":     {(*BufPane).TextFilterCmd, nil},
+		"undotree":       {(*BufPane).UndoTreeCmd, nil},
+		"earlier":        {(*BufPane).EarlierCmd, nil},
+		"later":          {(*BufPane).LaterCmd, nil},
+		"registers":      {(*BufPane).RegistersCmd, nil},
+		"pastehistory":   {(*BufPane).PasteHistoryCmd, nil},
+		"bookmarks":      {(*BufPane).BookmarksCmd, nil},
+		"grep":           {(*BufPane).GrepCmd, nil},
+		"replaceproject": {(*BufPane).ReplaceProjectCmd, nil},
+		"rename":         {(*BufPane).RenameCmd, nil},
+		"importsyntax":   {(*BufPane).ImportSyntaxCmd, buffer.FileComplete},
+		"filetype":       {(*BufPane).FiletypeCmd, nil},
 	}
 }
 
@@ -79,7 +93,8 @@ func MakeCommand(name string, action func(bp *BufPane, args []string), completer
 // enter
 func CommandEditAction(prompt string) BufKeyAction {
 	return func(h *BufPane) bool {
-		InfoBar.Prompt("> ", prompt, "Command", nil, func(resp string, canceled bool) {
+		InfoBar.Prompt("> ", prompt, "Command", h.previewReplace, func(resp string, canceled bool) {
+			h.Buf.ReplacePreview = nil
 			if !canceled {
 				MainTab().CurPane().HandleCommand(resp)
 			}
@@ -216,6 +231,7 @@ func (h *BufPane) TabSwitchCmd(args []string) {
 			found := false
 			for i, t := range Tabs.List {
 				if t.Panes[t.active].Name() == args[0] {
+					addSwitchJump()
 					Tabs.SetActive(i)
 					found = true
 				}
@@ -226,6 +242,7 @@ func (h *BufPane) TabSwitchCmd(args []string) {
 		} else {
 			num--
 			if num >= 0 && num < len(Tabs.List) {
+				addSwitchJump()
 				Tabs.SetActive(num)
 			} else {
 				InfoBar.Error("Invalid tab index")
@@ -293,30 +310,34 @@ func (h *BufPane) OpenCmd(args []string) {
 		if len(args) == 0 {
 			return
 		}
-		filename = strings.Join(args, " ")
+		h.openFile(strings.Join(args, " "))
+	} else {
+		InfoBar.Error("No filename")
+	}
+}
 
-		open := func() {
-			b, err := buffer.NewBufferFromFile(filename, buffer.BTDefault)
-			if err != nil {
-				InfoBar.Error(err)
-				return
-			}
-			h.OpenBuffer(b)
-		}
-		if h.Buf.Modified() {
-			InfoBar.YNPrompt("Save changes to "+h.Buf.GetName()+" before closing? (y,n,esc)", func(yes, canceled bool) {
-				if !canceled && !yes {
-					open()
-				} else if !canceled && yes {
-					h.Save()
-					open()
-				}
-			})
-		} else {
-			open()
+// openFile opens a file in this pane, asking to save the current buffer
+// first if it is modified
+func (h *BufPane) openFile(filename string) {
+	open := func() {
+		b, err := buffer.NewBufferFromFile(filename, buffer.BTDefault)
+		if err != nil {
+			InfoBar.Error(err)
+			return
 		}
+		h.OpenBuffer(b)
+	}
+	if h.Buf.Modified() {
+		InfoBar.YNPrompt("Save changes to "+h.Buf.GetName()+" before closing? (y,n,esc)", func(yes, canceled bool) {
+			if !canceled && !yes {
+				open()
+			} else if !canceled && yes {
+				h.Save()
+				open()
+			}
+		})
 	} else {
-		InfoBar.Error("No filename")
+		open()
 	}
 }
 
@@ -357,6 +378,99 @@ func ReloadConfig() {
 	}
 }
 
+// ImportSyntaxCmd converts a TextMate or Sublime Text grammar to a syntax
+// file in the syntax directory of the config directory. The filetype is
+// the name of the grammar file unless it is given as second argument
+func (h *BufPane) ImportSyntaxCmd(args []string) {
+	if len(args) < 1 {
+		InfoBar.Error("Not enough arguments")
+		return
+	}
+	filename, err := util.ReplaceHome(args[0])
+	if err != nil {
+		InfoBar.Error(err)
+		return
+	}
+	filetype := ""
+	if len(args) > 1 {
+		filetype = args[1]
+		if strings.ContainsAny(filetype, `/\`) {
+			InfoBar.Error("Invalid filetype ", filetype)
+			return
+		}
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		InfoBar.Error(err)
+		return
+	}
+	grammar, err := highlight.ConvertGrammar(filename, filetype, data)
+	if err != nil {
+		InfoBar.Error("Error converting ", filename, ": ", err)
+		return
+	}
+
+	dir := filepath.Join(config.ConfigDir, "syntax")
+	name := grammar.FileType + ".yaml"
+	path := filepath.Join(dir, name)
+	write := func() {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			InfoBar.Error(err)
+			return
+		}
+		if err := ioutil.WriteFile(path, grammar.Data, 0644); err != nil {
+			InfoBar.Error(err)
+			return
+		}
+		if config.HasRealRuntimeFile(config.RTSyntax, path) {
+			// the file was registered when it was first written or
+			// when micro started, and only its content changed
+			config.RuntimeFilesChanged()
+		} else {
+			config.AddRuntimeFilesFromDirectory(config.RTSyntax, dir, name)
+		}
+		for _, b := range buffer.OpenBuffers {
+			b.UpdateRules()
+		}
+
+		if len(grammar.Warnings) == 0 {
+			InfoBar.Message("Imported ", filename, " to ", path)
+			return
+		}
+		WriteLog("Warnings when importing " + filename + ":\n")
+		for _, w := range grammar.Warnings {
+			WriteLog("  " + w + "\n")
+		}
+		InfoBar.Message("Imported ", filename, " to ", path, " with ", len(grammar.Warnings), " warnings (see the log)")
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		InfoBar.YNPrompt("Overwrite "+path+"? (y,n,esc)", func(yes, canceled bool) {
+			if yes && !canceled {
+				write()
+			}
+		})
+	} else {
+		write()
+	}
+}
+
+// FiletypeCmd detects the filetype of the buffer again with `filetype
+// detect`, and shows why it is chosen
+func (h *BufPane) FiletypeCmd(args []string) {
+	if len(args) != 1 || args[0] != "detect" {
+		InfoBar.Error("Usage: filetype detect")
+		return
+	}
+	ft, reason := h.Buf.DetectFiletype()
+	if err := h.Buf.SetOptionNative("filetype", ft); err != nil {
+		InfoBar.Error(err)
+		return
+	}
+	InfoBar.Message("Filetype ", ft, ": ", reason)
+}
+
 // ReopenCmd reopens the buffer (reload from disk)
 func (h *BufPane) ReopenCmd(args []string) {
 	if h.Buf.Modified() {
@@ -421,6 +535,7 @@ func (h *BufPane) VSplitCmd(args []string) {
 		return
 	}
 
+	h.addJump(h.Cursor.Loc)
 	h.VSplitBuf(buf)
 }
 
@@ -439,6 +554,7 @@ func (h *BufPane) HSplitCmd(args []string) {
 		return
 	}
 
+	h.addJump(h.Cursor.Loc)
 	h.HSplitBuf(buf)
 }
 
@@ -452,6 +568,7 @@ func (h *BufPane) NewTabCmd(args []string) {
 	width, height := screen.Screen.Size()
 	iOffset := config.GetInfoBarOffset()
 	if len(args) > 0 {
+		h.addJump(h.Cursor.Loc)
 		for _, a := range args {
 			b, err := buffer.NewBufferFromFile(a, buffer.BTDefault)
 			if err != nil {
@@ -705,6 +822,7 @@ func (h *BufPane) GotoCmd(args []string) {
 		InfoBar.Error("Not enough arguments")
 	} else {
 		h.RemoveAllMultiCursors()
+		h.addJump(h.Cursor.Loc)
 		if strings.Contains(args[0], ":") {
 			parts := strings.SplitN(args[0], ":", 2)
 			line, err := strconv.Atoi(parts[0])
@@ -747,85 +865,232 @@ func (h *BufPane) SaveCmd(args []string) {
 	}
 }
 
-// ReplaceCmd runs search and replace
-func (h *BufPane) ReplaceCmd(args []string) {
-	if len(args) < 2 || len(args) > 4 {
+// replaceArgs are the arguments of a replace command
+type replaceArgs struct {
+	// the regular expression to search for, which is quoted if the
+	// search is literal
+	search  string
+	replace []byte
+
+	all         bool
+	inSelection bool
+	matchCase   bool
+	wholeWord   bool
+	// the replacements take the case of the text they replace
+	preserveCase bool
+}
+
+// parseReplaceArgs parses the arguments of a replace command. The value
+// is optional if needValue is false
+func parseReplaceArgs(args []string, needValue bool) (*replaceArgs, error) {
+	if len(args) < 1 || needValue && len(args) < 2 || len(args) > 8 {
 		// We need to find both a search and replace expression
-		InfoBar.Error("Invalid replace statement: " + strings.Join(args, " "))
-		return
+		return nil, errors.New("Invalid replace statement: " + strings.Join(args, " "))
 	}
 
-	all := false
+	a := new(replaceArgs)
 	noRegex := false
 
 	foundSearch := false
 	foundReplace := false
-	var search string
-	var replaceStr string
 	for _, arg := range args {
 		switch arg {
 		case "-a":
-			all = true
+			a.all = true
 		case "-l":
 			noRegex = true
+		case "-s":
+			a.inSelection = true
+		case "-c":
+			a.matchCase = true
+		case "-w":
+			a.wholeWord = true
+		case "-p":
+			a.preserveCase = true
 		default:
 			if !foundSearch {
 				foundSearch = true
-				search = arg
+				a.search = arg
 			} else if !foundReplace {
 				foundReplace = true
-				replaceStr = arg
+				a.replace = []byte(arg)
 			} else {
-				InfoBar.Error("Invalid flag: " + arg)
-				return
+				return nil, errors.New("Invalid flag: " + arg)
 			}
 		}
 	}
+	if !foundSearch || needValue && !foundReplace {
+		return nil, errors.New("Invalid replace statement: " + strings.Join(args, " "))
+	}
 
 	if noRegex {
-		search = regexp.QuoteMeta(search)
+		a.search = regexp.QuoteMeta(a.search)
 	}
+	return a, nil
+}
 
-	replace := []byte(replaceStr)
+// flags returns the options of the search
+func (a *replaceArgs) flags() buffer.SearchFlags {
+	return buffer.SearchFlags{Regex: true, MatchCase: a.matchCase, WholeWord: a.wholeWord}
+}
 
-	var regex *regexp.Regexp
-	var err error
-	if h.Buf.Settings["ignorecase"].(bool) {
-		regex, err = regexp.Compile("(?im)" + search)
-	} else {
-		regex, err = regexp.Compile("(?m)" + search)
+// compile compiles the search expression with the options of the buffer.
+// ^ and $ match at the start and end of every line
+func (a *replaceArgs) compile(b *buffer.Buffer) (*buffer.Search, error) {
+	search := a.search
+	if !buffer.IsMultiline(search) {
+		search = "(?m)" + search
+	}
+	r, err := b.CompileSearch(search, a.flags())
+	if err != nil {
+		return nil, err
+	}
+	r.PreserveCase = a.preserveCase
+	return r, nil
+}
+
+// replaceRanges returns the ranges that a replace command changes, from
+// the top of the buffer to the bottom. These are the selection of every
+// cursor if inSelection is true, together with the cursors, or else the
+// selection of the current cursor or the whole buffer
+func (h *BufPane) replaceRanges(inSelection bool) ([][2]buffer.Loc, []*buffer.Cursor, error) {
+	if !inSelection {
+		if h.Cursor.HasSelection() {
+			return [][2]buffer.Loc{{h.Cursor.CurSelection[0], h.Cursor.CurSelection[1]}}, nil, nil
+		}
+		return [][2]buffer.Loc{{h.Buf.Start(), h.Buf.End()}}, nil, nil
+	}
+
+	var ranges [][2]buffer.Loc
+	var cursors []*buffer.Cursor
+	for _, c := range h.Buf.GetCursors() {
+		r := c.CurSelection
+		if h.Buf.LastSearchSelection && c.SearchRange[0] != c.SearchRange[1] {
+			// replace in the selection of the last search
+			r = c.SearchRange
+		} else if !c.HasSelection() {
+			continue
+		}
+		if r[0].GreaterThan(r[1]) {
+			r[0], r[1] = r[1], r[0]
+		}
+		i := len(ranges)
+		for i > 0 && r[0].LessThan(ranges[i-1][0]) {
+			i--
+		}
+		ranges = append(ranges[:i], append([][2]buffer.Loc{r}, ranges[i:]...)...)
+		cursors = append(cursors[:i], append([]*buffer.Cursor{c}, cursors[i:]...)...)
 	}
+	if len(ranges) == 0 {
+		return nil, nil, errors.New("No selection to replace in")
+	}
+	return ranges, cursors, nil
+}
+
+// replaceIn replaces the matches of regex between start and end and
+// returns the number of replacements and where end is moved to. The
+// replacements may add or remove lines if regex matches across lines
+func (h *BufPane) replaceIn(start, end buffer.Loc, regex *buffer.Search, replace []byte) (int, buffer.Loc) {
+	// the text after end is not changed
+	after := util.CharacterCount(h.Buf.LineBytes(end.Y)) - end.X
+	fromBottom := h.Buf.LinesNum() - end.Y
+
+	n, _ := h.Buf.ReplaceSearch(start, end, regex, replace)
+
+	y := h.Buf.LinesNum() - fromBottom
+	return n, buffer.Loc{X: util.CharacterCount(h.Buf.LineBytes(y)) - after, Y: y}
+}
+
+// ReplaceCmd runs search and replace
+func (h *BufPane) ReplaceCmd(args []string) {
+	a, err := parseReplaceArgs(args, true)
+	if err != nil {
+		InfoBar.Error(err)
+		return
+	}
+	all := a.all
+	inSelection := a.inSelection
+	search := a.search
+	replace := a.replace
+
+	regex, err := a.compile(h.Buf)
 	if err != nil {
 		// There was an error with the user's regex
 		InfoBar.Error(err)
 		return
 	}
 
-	nreplaced := 0
-	start := h.Buf.Start()
-	end := h.Buf.End()
-	selection := h.Cursor.HasSelection()
-	if selection {
-		start = h.Cursor.CurSelection[0]
-		end = h.Cursor.CurSelection[1]
+	selection := inSelection || h.Cursor.HasSelection()
+
+	// the ranges to replace in and the cursors whose selection they come
+	// from
+	ranges, cursors, err := h.replaceRanges(inSelection)
+	if err != nil {
+		InfoBar.Error(err)
+		return
 	}
+
+	// move moves the ranges from i on after a replacement moved the end
+	// of the replaced text from end to newEnd
+	move := func(i int, end, newEnd buffer.Loc) {
+		for ; i < len(ranges); i++ {
+			for k := range ranges[i] {
+				l := &ranges[i][k]
+				if l.Y == end.Y && l.X >= end.X {
+					*l = buffer.Loc{X: newEnd.X + l.X - end.X, Y: newEnd.Y}
+				} else if l.Y > end.Y {
+					l.Y += newEnd.Y - end.Y
+				}
+			}
+		}
+	}
+
+	// done updates the selections of the cursors to the ranges after all
+	// replacements were made
+	done := func() {
+		for i, c := range cursors {
+			c.SearchRange = ranges[i]
+			c.SetSelectionStart(ranges[i][0])
+			c.SetSelectionEnd(ranges[i][1])
+			c.Loc = ranges[i][1]
+		}
+	}
+
+	nreplaced := 0
 	if all {
-		nreplaced, _ = h.Buf.ReplaceRegex(start, end, regex, replace)
+		for i, r := range ranges {
+			n, end := h.replaceIn(r[0], r[1], regex, replace)
+			nreplaced += n
+			move(i, r[1], end)
+		}
+		done()
 	} else {
-		inRange := func(l buffer.Loc) bool {
-			return l.GreaterEqual(start) && l.LessEqual(end)
+		inRange := func(i int, l buffer.Loc) bool {
+			return l.GreaterEqual(ranges[i][0]) && l.LessEqual(ranges[i][1])
 		}
 
-		searchLoc := h.Cursor.Loc
+		cur := 0
+		searchLoc := ranges[0][0]
+		if !inSelection {
+			searchLoc = h.Cursor.Loc
+		}
 		var doReplacement func()
 		doReplacement = func() {
-			locs, found, err := h.Buf.FindNext(search, start, end, searchLoc, true, true)
+			locs, found, err := h.Buf.FindNextFlags(search, ranges[cur][0], ranges[cur][1], searchLoc, true, a.flags())
 			if err != nil {
 				InfoBar.Error(err)
 				return
 			}
-			if !found || !inRange(locs[0]) || !inRange(locs[1]) {
+			if !found || !inRange(cur, locs[0]) || !inRange(cur, locs[1]) || inSelection && locs[0].LessThan(searchLoc) {
+				if cur+1 < len(ranges) {
+					// continue in the next selection
+					cur++
+					searchLoc = ranges[cur][0]
+					doReplacement()
+					return
+				}
 				h.Cursor.ResetSelection()
+				done()
 				h.Buf.RelocateCursors()
 
 				return
@@ -836,23 +1101,24 @@ func (h *BufPane) ReplaceCmd(args []string) {
 			h.GotoLoc(locs[0])
 			h.Buf.LastSearch = search
 			h.Buf.LastSearchRegex = true
+			h.Buf.LastSearchMatchCase = a.matchCase
+			h.Buf.LastSearchWholeWord = a.wholeWord
+			h.Buf.LastSearchSelection = false
 			h.Buf.HighlightSearch = h.Buf.Settings["hlsearch"].(bool)
 
 			InfoBar.YNPrompt("Perform replacement (y,n,esc)", func(yes, canceled bool) {
 				if !canceled && yes {
-					_, nrunes := h.Buf.ReplaceRegex(locs[0], locs[1], regex, replace)
+					_, end := h.replaceIn(locs[0], locs[1], regex, replace)
 
-					searchLoc = locs[0]
-					searchLoc.X += nrunes + locs[0].Diff(locs[1], h.Buf)
-					if end.Y == locs[1].Y {
-						end = end.Move(nrunes, h.Buf)
-					}
+					searchLoc = end
+					move(cur, locs[1], end)
 					h.Cursor.Loc = searchLoc
 					nreplaced++
 				} else if !canceled && !yes {
 					searchLoc = locs[1]
 				} else if canceled {
 					h.Cursor.ResetSelection()
+					done()
 					h.Buf.RelocateCursors()
 					return
 				}
@@ -874,7 +1140,9 @@ func (h *BufPane) ReplaceCmd(args []string) {
 		s = fmt.Sprintf("Nothing matched %s", search)
 	}
 
-	if selection {
+	if len(ranges) > 1 {
+		s += fmt.Sprintf(" in %d selections", len(ranges))
+	} else if selection {
 		s += " in selection"
 	}
 
@@ -887,6 +1155,90 @@ func (h *BufPane) ReplaceAllCmd(args []string) {
 	h.ReplaceCmd(append(args, "-a"))
 }
 
+// SelectMatchesCmd adds a cursor that selects every match of a regex. The
+// flags are those of the replace command, except -a and -p
+func (h *BufPane) SelectMatchesCmd(args []string) {
+	a, err := parseReplaceArgs(args, false)
+	if err == nil && (a.all || a.preserveCase || a.replace != nil) {
+		err = errors.New("Invalid selectmatches statement: " + strings.Join(args, " "))
+	}
+	if err != nil {
+		InfoBar.Error(err)
+		return
+	}
+
+	r, err := a.compile(h.Buf)
+	if err != nil {
+		InfoBar.Error(err)
+		return
+	}
+	ranges, _, err := h.replaceRanges(a.inSelection)
+	if err != nil {
+		InfoBar.Error(err)
+		return
+	}
+	h.selectMatches(r, ranges)
+}
+
+// previewCountLimit is the number of matches after which the replace
+// preview stops counting
+const previewCountLimit = 1000
+
+// previewReplace shows in the buffer how a replace or replaceall command
+// that is being typed in the command bar would change the visible lines,
+// and the number of matches in the prompt. Like incsearch, this is done
+// on every keystroke
+func (h *BufPane) previewReplace(input string) {
+	h.Buf.ReplacePreview = nil
+	InfoBar.PromptStatus = ""
+	if !h.Buf.Settings["incsearch"].(bool) {
+		return
+	}
+
+	// the closing quote of the argument being typed may be missing
+	var args []string
+	var err error
+	for _, quote := range []string{"", "'", "\""} {
+		if args, err = shellquote.Split(input + quote); err == nil {
+			break
+		}
+	}
+	if err != nil || len(args) < 2 || args[0] != "replace" && args[0] != "replaceall" || args[1] == "" {
+		return
+	}
+
+	a, err := parseReplaceArgs(args[1:], false)
+	if err != nil {
+		return
+	}
+	regex, err := a.compile(h.Buf)
+	if err != nil {
+		return
+	}
+	ranges, _, err := h.replaceRanges(a.inSelection)
+	if err != nil {
+		return
+	}
+
+	h.Buf.ReplacePreview = &buffer.ReplacePreview{
+		Search:   regex,
+		Template: a.replace,
+		Ranges:   ranges,
+	}
+	if h.Buf.LargeFile {
+		// counting the matches would search the whole file on every
+		// keystroke
+		return
+	}
+	if n, more := h.Buf.PreviewCount(previewCountLimit); more {
+		InfoBar.PromptStatus = fmt.Sprintf("%d+ matches", n)
+	} else if n == 1 {
+		InfoBar.PromptStatus = "1 match"
+	} else {
+		InfoBar.PromptStatus = fmt.Sprintf("%d matches", n)
+	}
+}
+
 // TermCmd opens a terminal in the current view
 func (h *BufPane) TermCmd(args []string) {
 	ps := h.tab.Panes
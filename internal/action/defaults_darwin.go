@@ -25,6 +25,10 @@ var bufdefaults = map[string]string{
 	"CtrlDown":       "CursorEnd",
 	"CtrlShiftUp":    "SelectToStart",
 	"CtrlShiftDown":  "SelectToEnd",
+	"CtrlAltUp":      "SelectBlockUp",
+	"CtrlAltDown":    "SelectBlockDown",
+	"CtrlAltLeft":    "SelectBlockLeft",
+	"CtrlAltRight":   "SelectBlockRight",
 	"Alt-{":          "ParagraphPrevious",
 	"Alt-}":          "ParagraphNext",
 	"Enter":          "InsertNewline",
@@ -97,6 +101,7 @@ var bufdefaults = map[string]string{
 	"MouseLeft":      "MousePress",
 	"MouseMiddle":    "PastePrimary",
 	"Ctrl-MouseLeft": "MouseMultiCursor",
+	"Alt-MouseLeft":  "MouseBlockSelect",
 
 	"Alt-n":        "SpawnMultiCursor",
 	"AltShiftUp":   "SpawnMultiCursorUp",
@@ -105,6 +110,13 @@ var bufdefaults = map[string]string{
 	"Alt-p":        "RemoveMultiCursor",
 	"Alt-c":        "RemoveAllMultiCursors",
 	"Alt-x":        "SkipMultiCursor",
+
+	"Alt-y": "PasteCycle",
+	"Alt-o": "JumpBack",
+	"Alt-i": "JumpForward",
+
+	"Alt-P": "FindFile",
+	"Alt-X": "CommandPalette",
 }
 
 var infodefaults = map[string]string{
@@ -170,6 +182,17 @@ var infodefaults = map[string]string{
 	"Alt-a": "StartOfText",
 	"Alt-e": "EndOfLine",
 
+	// Find prompt options
+	"Alt-s": "ToggleFindSelection",
+	"Alt-c": "ToggleFindCase",
+	"Alt-w": "ToggleFindWord",
+	"Alt-r": "ToggleFindRegex",
+
+	// File finder
+	"Alt-h": "FindFileHSplit",
+	"Alt-v": "FindFileVSplit",
+	"Alt-t": "FindFileTab",
+
 	// Integration with file managers
 	"F10": "AbortCommand",
 	"Esc": "AbortCommand",
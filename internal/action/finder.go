@@ -0,0 +1,313 @@
+package action
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/zyedidia/micro/v2/internal/buffer"
+	"github.com/zyedidia/micro/v2/internal/project"
+	"github.com/zyedidia/micro/v2/internal/shell"
+)
+
+// BTFinderPreview is the type of the buffer that shows the selected file
+// of the file finder. Syntax highlighting is enabled once the filetype is
+// known
+var BTFinderPreview = buffer.BufType{Kind: buffer.BTScratch.Kind, Readonly: true, Scratch: true, Syntax: false}
+
+// maxPreviewSize is the number of bytes at the start of a file that are
+// shown in the preview
+const maxPreviewSize = 64 * 1024
+
+// The ways to open the file selected in the file finder
+const (
+	findFileHere = iota
+	findFileHSplit
+	findFileVSplit
+	findFileTab
+)
+
+// A fileIndex is the list of the files under a directory that the file
+// finder picks from. It is built in the background, and it is kept when
+// the finder closes: an index that is not complete yet goes on in the
+// background, so that the finder continues with it the next time it opens
+// in the same directory. A complete index is shown while it is built again
+type fileIndex struct {
+	root     string
+	files    []string
+	complete bool
+	done     chan struct{}
+
+	// the files of the complete index that this one replaces, if any
+	old []string
+	// the open finder that shows this index, or nil
+	finder *fileFinder
+}
+
+var lastIndex *fileIndex
+
+// cancel stops the indexing if it is still running
+func (ix *fileIndex) cancel() {
+	select {
+	case <-ix.done:
+	default:
+		close(ix.done)
+	}
+}
+
+// run indexes the files in the background. The files are passed to the
+// finder from the main goroutine through the jobs channel
+func (ix *fileIndex) run() {
+	batches := make(chan []string)
+	go project.IndexFiles(ix.root, ix.done, batches)
+	go func() {
+		for files := range batches {
+			files := files
+			shell.Jobs <- shell.JobFunction{
+				Function: func(string, []interface{}) {
+					ix.files = append(ix.files, files...)
+					if f := ix.finder; f != nil && !f.stale {
+						f.add(ix.files, files)
+					}
+				},
+			}
+		}
+		shell.Jobs <- shell.JobFunction{
+			Function: func(string, []interface{}) {
+				ix.finish()
+			},
+		}
+	}()
+}
+
+// finish marks the index as complete, and replaces the files of an earlier
+// index that the finder shows
+func (ix *fileIndex) finish() {
+	select {
+	case <-ix.done:
+		// canceled, the index is incomplete
+		return
+	default:
+	}
+	ix.complete = true
+	ix.old = nil
+
+	f := ix.finder
+	if f == nil {
+		return
+	}
+	if f.stale {
+		f.stale = false
+		f.files = ix.files
+		f.list.setMatches(project.FuzzyFilter(f.query, f.files))
+	}
+	f.updateStatus()
+}
+
+// A fileFinder is the state of the file finder while its prompt is open.
+// The files matching the prompt are listed in a split below the pane that
+// opened the finder, next to a preview of the selected file
+type fileFinder struct {
+	index *fileIndex
+	// the files that are matched, which are those of the last complete
+	// index while stale is set
+	files []string
+	stale bool
+
+	query string
+	mode  int
+
+	target      *BufPane
+	list        *matchList
+	preview     *BufPane
+	previewPath string
+}
+
+var activeFinder *fileFinder
+
+// FindFile opens the fuzzy file finder, which lists the files in the
+// current directory and its subdirectories that match what is typed in
+// the prompt. Files ignored by .gitignore and binary files are skipped
+func (h *BufPane) FindFile() bool {
+	root, err := os.Getwd()
+	if err != nil {
+		InfoBar.Error(err)
+		return false
+	}
+
+	f := &fileFinder{target: h}
+	f.index = &fileIndex{root: root, done: make(chan struct{})}
+	f.list = newMatchList(h, "Files", func(m project.FuzzyMatch) string {
+		return m.Str
+	})
+
+	preview := buffer.NewBufferFromString("", "", BTFinderPreview)
+	f.preview = NewBufPaneFromBuf(preview, h.tab)
+	f.preview.splitID = MainTab().GetNode(f.list.splitID).VSplit(true)
+	MainTab().Panes = append(MainTab().Panes, f.preview)
+	MainTab().Resize()
+	f.list.onSelect = f.showPreview
+
+	activeFinder = f
+	InfoBar.Prompt("Open file: ", "", "FindFile", func(resp string) {
+		f.setQuery(resp)
+	}, func(resp string, canceled bool) {
+		f.close()
+		if !canceled {
+			f.open()
+		}
+	})
+	ix := lastIndex
+	switch {
+	case ix != nil && ix.root == root && !ix.complete:
+		// continue with the index that was not complete when the
+		// finder was closed
+		f.index = ix
+		f.files, f.stale = ix.files, ix.old != nil
+		if f.stale {
+			f.files = ix.old
+		}
+	case ix != nil && ix.root == root:
+		f.files, f.stale = ix.files, true
+		f.index.old = ix.files
+	case ix != nil:
+		ix.cancel()
+	}
+	f.index.finder = f
+	f.list.setMatches(project.FuzzyFilter("", f.files))
+	f.updateStatus()
+	if f.index != ix {
+		lastIndex = f.index
+		f.index.run()
+	}
+	return true
+}
+
+// add matches the files that were just indexed against the query. files
+// are all the indexed files, of which added are the new ones
+func (f *fileFinder) add(files, added []string) {
+	f.files = files
+	matches := project.FuzzyFilter(f.query, added)
+	for i := range matches {
+		matches[i].Index += len(files) - len(added)
+	}
+	if strings.TrimSpace(f.query) != "" {
+		matches = project.MergeFuzzyMatches(f.list.matches, matches)
+	} else {
+		matches = append(f.list.matches, matches...)
+	}
+	f.list.setMatches(matches)
+	f.updateStatus()
+}
+
+// setQuery matches the files against a new query
+func (f *fileFinder) setQuery(query string) {
+	if query == f.query {
+		return
+	}
+	files := f.files
+	if f.query != "" && strings.HasPrefix(query, f.query) {
+		// every file that matches the longer query also matched the
+		// last one
+		files = make([]string, len(f.list.matches))
+		for i, m := range f.list.matches {
+			files[i] = m.Str
+		}
+	}
+	f.query = query
+	f.list.selected = 0
+	f.list.setMatches(project.FuzzyFilter(query, files))
+	f.updateStatus()
+}
+
+// updateStatus shows the number of matches in the prompt
+func (f *fileFinder) updateStatus() {
+	status := fmt.Sprintf("%d/%d", len(f.list.matches), len(f.files))
+	if !f.index.complete {
+		status += " (indexing)"
+	}
+	InfoBar.PromptStatus = status
+}
+
+// showPreview shows the start of the selected file next to the list
+func (f *fileFinder) showPreview() {
+	path := ""
+	if m, ok := f.list.selection(); ok {
+		path = m.Str
+	}
+	if path == f.previewPath {
+		return
+	}
+	f.previewPath = path
+
+	text := ""
+	if path != "" {
+		text = readPreview(path)
+	}
+	b := buffer.NewBufferFromString(text, "", BTFinderPreview)
+	if path != "" {
+		b.SetName(path)
+		// the path is only set to detect the filetype and is cleared
+		// again, so the preview is never saved or serialized as the file
+		b.Type.Syntax = true
+		b.Path = path
+		b.UpdateRules()
+		b.Path = ""
+	}
+	f.preview.openBuffer(b)
+}
+
+// readPreview returns the start of the file at path
+func readPreview(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(file, maxPreviewSize))
+	if err != nil {
+		return ""
+	}
+	if len(data) == maxPreviewSize {
+		// don't show a partial last line
+		if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+			data = data[:i]
+		}
+	}
+	return string(data)
+}
+
+// close closes the list and the preview. The indexing goes on if it is
+// not complete
+func (f *fileFinder) close() {
+	activeFinder = nil
+	f.index.finder = nil
+	f.preview.ForceQuit()
+	f.list.ForceQuit()
+	activatePane(f.target)
+}
+
+// open opens the selected file in the way chosen by the key that closed
+// the prompt
+func (f *fileFinder) open() {
+	m, ok := f.list.selection()
+	if !ok {
+		return
+	}
+	path := m.Str
+	h := f.target
+	switch f.mode {
+	case findFileHere:
+		h.openFile(path)
+	case findFileHSplit:
+		h.HSplitCmd([]string{path})
+	case findFileVSplit:
+		h.VSplitCmd([]string{path})
+	case findFileTab:
+		h.NewTabCmd([]string{path})
+	}
+}
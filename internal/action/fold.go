@@ -0,0 +1,68 @@
+package action
+
+import (
+	"github.com/zyedidia/micro/v2/internal/buffer"
+)
+
+// afterFold moves the cursor to the first line of the fold if it was
+// hidden by folding, and relocates the view
+func (h *BufPane) afterFold() {
+	if y := h.Folds().FoldStart(h.Cursor.Y); y != h.Cursor.Y {
+		h.Cursor.ResetSelection()
+		h.Cursor.GotoLoc(buffer.Loc{X: 0, Y: y})
+	}
+	h.Relocate()
+}
+
+// Fold folds the innermost foldable range of lines around the cursor
+func (h *BufPane) Fold() bool {
+	if !h.Folds().Fold(h.Buf, h.Cursor.Y) {
+		InfoBar.Message("Nothing to fold")
+		return false
+	}
+	h.afterFold()
+	return true
+}
+
+// Unfold opens the folds on the cursor line
+func (h *BufPane) Unfold() bool {
+	if !h.Folds().Unfold(h.Cursor.Y) {
+		return false
+	}
+	h.Relocate()
+	return true
+}
+
+// ToggleFold opens the folds on the cursor line, or folds the range around
+// the cursor if the cursor line is not folded
+func (h *BufPane) ToggleFold() bool {
+	if !h.Folds().ToggleFold(h.Buf, h.Cursor.Y) {
+		InfoBar.Message("Nothing to fold")
+		return false
+	}
+	h.afterFold()
+	return true
+}
+
+// FoldAll folds all the foldable ranges of the buffer
+func (h *BufPane) FoldAll() bool {
+	h.Folds().FoldAll(h.Buf)
+	h.afterFold()
+	return true
+}
+
+// UnfoldAll opens all the folds of the buffer
+func (h *BufPane) UnfoldAll() bool {
+	h.Folds().UnfoldAll()
+	h.Relocate()
+	return true
+}
+
+// ToggleFoldAll opens all folds if the buffer has any, and folds all the
+// foldable ranges otherwise
+func (h *BufPane) ToggleFoldAll() bool {
+	if h.Folds().HasFolds() {
+		return h.UnfoldAll()
+	}
+	return h.FoldAll()
+}
@@ -0,0 +1,344 @@
+package action
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/zyedidia/micro/v2/internal/buffer"
+	"github.com/zyedidia/micro/v2/internal/project"
+	"github.com/zyedidia/micro/v2/internal/shell"
+	"github.com/zyedidia/micro/v2/internal/util"
+	"github.com/zyedidia/tcell/v2"
+)
+
+// BTGrep is the type of the buffer that lists the results of a grep
+var BTGrep = buffer.BufType{Kind: buffer.BTScratch.Kind, Readonly: true, Scratch: true, Syntax: false}
+
+// maxGrepLineLen is the number of characters of a matching line that are
+// shown in the results
+const maxGrepLineLen = 200
+
+// A grepSearch holds the results of a grep command. The results of the
+// last search are shared by all panes so that NextGrepResult and
+// PreviousGrepResult work from anywhere
+type grepSearch struct {
+	root    string
+	pattern string
+	results []project.Match
+	files   int
+
+	// the result that was visited last, or -1
+	index int
+
+	pane *GrepPane
+	done chan struct{}
+}
+
+var lastGrep *grepSearch
+
+// cancel stops the search if it is still running
+func (s *grepSearch) cancel() {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+}
+
+// add appends the matches of a file to the results
+func (s *grepSearch) add(matches []project.Match) {
+	lines := make([]string, len(matches))
+	for i, m := range matches {
+		text := strings.TrimSpace(m.Text)
+		if util.CharacterCountInString(text) > maxGrepLineLen {
+			text = string([]rune(text)[:maxGrepLineLen]) + "..."
+		}
+		lines[i] = fmt.Sprintf("%s:%d:%d: %s", m.Path, m.Line+1, m.Start+1, text)
+	}
+
+	text := strings.Join(lines, "\n")
+	if len(s.results) > 0 {
+		text = "\n" + text
+	}
+	s.pane.Buf.AppendText(text)
+
+	s.results = append(s.results, matches...)
+	s.files++
+}
+
+// finish reports the number of results when the search is complete
+func (s *grepSearch) finish() {
+	if s != lastGrep {
+		return
+	}
+	select {
+	case <-s.done:
+		InfoBar.Message(fmt.Sprintf("Grep canceled: %d matches in %d files", len(s.results), s.files))
+		return
+	default:
+	}
+	s.cancel()
+	if len(s.results) == 0 {
+		InfoBar.Message("No matches for ", s.pattern)
+	} else {
+		InfoBar.Message(fmt.Sprintf("%d matches in %d files", len(s.results), s.files))
+	}
+}
+
+// run searches the files in the background. The results are added to the
+// buffer from the main goroutine through the jobs channel
+func (s *grepSearch) run(re *regexp.Regexp) {
+	results := make(chan []project.Match)
+	go project.Grep(s.root, re, s.done, results)
+	go func() {
+		for matches := range results {
+			matches := matches
+			shell.Jobs <- shell.JobFunction{
+				Function: func(string, []interface{}) {
+					if s == lastGrep {
+						s.add(matches)
+					}
+				},
+			}
+		}
+		shell.Jobs <- shell.JobFunction{
+			Function: func(string, []interface{}) {
+				s.finish()
+			},
+		}
+	}()
+}
+
+// A GrepPane is a split that lists the results of a grep command, one per
+// line. Pressing enter opens the file of the result on the cursor line at
+// the location of the match
+type GrepPane struct {
+	*BufPane
+
+	// the pane that files are opened in
+	target *BufPane
+	search *grepSearch
+}
+
+func (p *GrepPane) bufPane() *BufPane {
+	return p.BufPane
+}
+
+// GrepCmd searches the files in the current directory and its
+// subdirectories for a regular expression. Files ignored by .gitignore and
+// binary files are skipped. The results are listed in a split below the
+// current pane while the search runs
+func (h *BufPane) GrepCmd(args []string) {
+	noRegex := false
+	var pattern string
+	found := false
+	for _, arg := range args {
+		switch {
+		case arg == "-l":
+			noRegex = true
+		case !found:
+			pattern = arg
+			found = true
+		default:
+			InfoBar.Error("Invalid flag: " + arg)
+			return
+		}
+	}
+	if !found || pattern == "" {
+		InfoBar.Error("Not enough arguments: provide a pattern to search for")
+		return
+	}
+
+	search := pattern
+	if noRegex {
+		search = regexp.QuoteMeta(search)
+	}
+	if h.Buf.IgnoreCase(pattern, buffer.SearchFlags{Regex: !noRegex}) {
+		search = "(?i)" + search
+	}
+	re, err := regexp.Compile(search)
+	if err != nil {
+		InfoBar.Error(err)
+		return
+	}
+	root, err := os.Getwd()
+	if err != nil {
+		InfoBar.Error(err)
+		return
+	}
+
+	s := h.newGrepSearch(root, "grep: "+pattern)
+	s.pattern = pattern
+	InfoBar.Message("Searching for ", pattern, "...")
+	s.run(re)
+}
+
+// newGrepSearch starts a list of results with the given name. The results
+// pane of the last search is reused if it is open, and a split is opened
+// below the pane otherwise
+func (h *BufPane) newGrepSearch(root, name string) *grepSearch {
+	var p *GrepPane
+	if lastGrep != nil {
+		lastGrep.cancel()
+		if paneTab(lastGrep.pane.BufPane) >= 0 {
+			// reuse the results pane of the last search
+			p = lastGrep.pane
+		}
+	}
+	s := &grepSearch{
+		root:  root,
+		index: -1,
+		done:  make(chan struct{}),
+	}
+	lastGrep = s
+
+	if p != nil {
+		if !p.isPane(h) {
+			p.target = h
+		}
+		p.Buf.SetText("")
+		p.Cursor.GotoLoc(buffer.Loc{X: 0, Y: 0})
+		activatePane(p.BufPane)
+	} else {
+		b := buffer.NewBufferFromString("", "", BTGrep)
+		p = new(GrepPane)
+		p.BufPane = NewBufPaneFromBuf(b, h.tab)
+		p.target = h
+		p.splitID = MainTab().GetNode(h.splitID).HSplit(true)
+		MainTab().Panes = append(MainTab().Panes, p)
+		MainTab().Resize()
+		MainTab().SetActive(len(MainTab().Panes) - 1)
+	}
+	p.Buf.SetName(name)
+	p.search = s
+	s.pane = p
+
+	return s
+}
+
+// isPane returns whether h is the BufPane of the grep results pane
+func (p *GrepPane) isPane(h *BufPane) bool {
+	return p.BufPane == h
+}
+
+// HandleEvent opens the result on the cursor line when enter is pressed
+// and closes the pane with escape or q. Other events are handled like in
+// a normal BufPane
+func (p *GrepPane) HandleEvent(event tcell.Event) {
+	if e, ok := event.(*tcell.EventKey); ok && e.Modifiers() == 0 {
+		switch {
+		case e.Key() == tcell.KeyEnter:
+			if p.Cursor.Y < len(p.search.results) {
+				p.open(p.Cursor.Y)
+			}
+			return
+		case e.Key() == tcell.KeyEscape, e.Key() == tcell.KeyRune && e.Rune() == 'q':
+			p.ForceQuit()
+			activatePane(p.target)
+			return
+		}
+	}
+	p.BufPane.HandleEvent(event)
+}
+
+// open opens result i in the pane that started the search, or in a new
+// split above the results if that pane was closed
+func (p *GrepPane) open(i int) {
+	s := p.search
+	s.index = i
+	m := s.results[i]
+	path := m.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.root, path)
+	}
+	j := jump{path: path, loc: buffer.Loc{X: m.Start, Y: m.Line}}
+
+	if activatePane(p.target) {
+		p.target.addJump(p.target.Cursor.Loc)
+		p.target.gotoLocation(j)
+	} else {
+		b, err := buffer.NewBufferFromFile(j.path, buffer.BTDefault)
+		if err != nil {
+			InfoBar.Error(err)
+			return
+		}
+		p.target = p.HSplitIndex(b, false)
+		p.target.gotoJumpLoc(j.loc)
+	}
+	InfoBar.Message(fmt.Sprintf("Match %d of %d", i+1, len(s.results)))
+}
+
+// paneTab returns the index of the tab showing the pane, or -1 if the
+// pane was closed
+func paneTab(bp *BufPane) int {
+	for i, t := range Tabs.List {
+		for _, pane := range t.Panes {
+			if pane.ID() == bp.splitID {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// activatePane makes the pane and its tab active. It returns false if the
+// pane was closed
+func activatePane(bp *BufPane) bool {
+	t := paneTab(bp)
+	if t < 0 {
+		return false
+	}
+	if t != Tabs.Active() {
+		Tabs.SetActive(t)
+	}
+	tab := Tabs.List[t]
+	tab.SetActive(tab.GetPane(bp.splitID))
+	return true
+}
+
+// NextGrepResult opens the next result of the last grep command
+func (h *BufPane) NextGrepResult() bool {
+	return h.cycleGrepResult(1)
+}
+
+// PreviousGrepResult opens the previous result of the last grep command
+func (h *BufPane) PreviousGrepResult() bool {
+	return h.cycleGrepResult(-1)
+}
+
+func (h *BufPane) cycleGrepResult(dir int) bool {
+	s := lastGrep
+	if s == nil || len(s.results) == 0 {
+		InfoBar.Message("No grep results")
+		return false
+	}
+
+	i := s.index + dir
+	if s.index < 0 && dir < 0 {
+		i = len(s.results) - 1
+	}
+	if i < 0 || i >= len(s.results) {
+		if dir > 0 {
+			InfoBar.Message("No more grep results")
+		} else {
+			InfoBar.Message("Already at the first grep result")
+		}
+		return false
+	}
+
+	p := s.pane
+	if paneTab(p.BufPane) >= 0 {
+		// keep the results list in sync with the visited result
+		p.Cursor.ResetSelection()
+		p.Cursor.GotoLoc(buffer.Loc{X: 0, Y: i})
+		p.Relocate()
+	}
+	if !p.isPane(h) {
+		p.target = h
+	}
+	p.open(i)
+	return true
+}
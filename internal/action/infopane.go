@@ -149,16 +149,38 @@ func (h *InfoPane) DoKeyEvent(e KeyEvent) bool {
 	return more
 }
 
-// HistoryUp cycles history up
+// HistoryUp cycles history up. In the file finder and the command palette
+// it selects the previous match instead
 func (h *InfoPane) HistoryUp() {
+	if l := h.matchList(); l != nil {
+		l.move(-1)
+		return
+	}
 	h.UpHistory(h.History[h.PromptType])
 }
 
-// HistoryDown cycles history down
+// HistoryDown cycles history down. In the file finder and the command
+// palette it selects the next match instead
 func (h *InfoPane) HistoryDown() {
+	if l := h.matchList(); l != nil {
+		l.move(1)
+		return
+	}
 	h.DownHistory(h.History[h.PromptType])
 }
 
+// matchList returns the list of matches of the file finder or the command
+// palette if the prompt belongs to one of them
+func (h *InfoPane) matchList() *matchList {
+	switch {
+	case h.PromptType == "FindFile" && activeFinder != nil:
+		return activeFinder.list
+	case h.PromptType == "Palette" && activePalette != nil:
+		return activePalette.list
+	}
+	return nil
+}
+
 // HistorySearchUp fetches the previous history item beginning with the text
 // in the infobuffer before cursor
 func (h *InfoPane) HistorySearchUp() {
@@ -212,6 +234,81 @@ func (h *InfoPane) AbortCommand() {
 	h.DonePrompt(true)
 }
 
+// ToggleFindSelection limits the search of the find prompt to the
+// selections that the cursors had when the prompt was opened, or searches
+// the whole buffer again
+func (h *InfoPane) ToggleFindSelection() {
+	f := h.activeFind()
+	if f == nil {
+		return
+	}
+	if !f.inSelection && !f.hasSelection() {
+		return
+	}
+	f.inSelection = !f.inSelection
+	h.Msg = f.label()
+}
+
+// ToggleFindCase makes the search of the find prompt case sensitive, or
+// lets the ignorecase and smartcase options decide again
+func (h *InfoPane) ToggleFindCase() {
+	if f := h.activeFind(); f != nil {
+		f.flags.MatchCase = !f.flags.MatchCase
+		h.Msg = f.label()
+	}
+}
+
+// ToggleFindWord limits the matches of the find prompt to whole words
+func (h *InfoPane) ToggleFindWord() {
+	if f := h.activeFind(); f != nil {
+		f.flags.WholeWord = !f.flags.WholeWord
+		h.Msg = f.label()
+	}
+}
+
+// ToggleFindRegex switches the find prompt between a regex search and a
+// literal one
+func (h *InfoPane) ToggleFindRegex() {
+	if f := h.activeFind(); f != nil {
+		f.flags.Regex = !f.flags.Regex
+		h.Msg = f.label()
+	}
+}
+
+// activeFind returns the options of the find prompt if it is open
+func (h *InfoPane) activeFind() *findPrompt {
+	if activeFind == nil || !h.HasPrompt || h.PromptType != "Find" {
+		return nil
+	}
+	return activeFind
+}
+
+// FindFileHSplit opens the file selected in the file finder in a
+// horizontal split
+func (h *InfoPane) FindFileHSplit() {
+	h.findFileOpen(findFileHSplit)
+}
+
+// FindFileVSplit opens the file selected in the file finder in a vertical
+// split
+func (h *InfoPane) FindFileVSplit() {
+	h.findFileOpen(findFileVSplit)
+}
+
+// FindFileTab opens the file selected in the file finder in a new tab
+func (h *InfoPane) FindFileTab() {
+	h.findFileOpen(findFileTab)
+}
+
+func (h *InfoPane) findFileOpen(mode int) {
+	f := activeFinder
+	if f == nil || !h.HasPrompt || h.PromptType != "FindFile" {
+		return
+	}
+	f.mode = mode
+	h.DonePrompt(false)
+}
+
 // InfoKeyActions contains the list of all possible key actions the infopane could execute
 var InfoKeyActions = map[string]InfoKeyAction{
 	"HistoryUp":         (*InfoPane).HistoryUp,
@@ -221,4 +318,13 @@ var InfoKeyActions = map[string]InfoKeyAction{
 	"CommandComplete":   (*InfoPane).CommandComplete,
 	"ExecuteCommand":    (*InfoPane).ExecuteCommand,
 	"AbortCommand":      (*InfoPane).AbortCommand,
+
+	"ToggleFindSelection": (*InfoPane).ToggleFindSelection,
+	"ToggleFindCase":      (*InfoPane).ToggleFindCase,
+	"ToggleFindWord":      (*InfoPane).ToggleFindWord,
+	"ToggleFindRegex":     (*InfoPane).ToggleFindRegex,
+
+	"FindFileHSplit": (*InfoPane).FindFileHSplit,
+	"FindFileVSplit": (*InfoPane).FindFileVSplit,
+	"FindFileTab":    (*InfoPane).FindFileTab,
 }
@@ -0,0 +1,209 @@
+package action
+
+import (
+	"github.com/zyedidia/micro/v2/internal/buffer"
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+// maxJumps is the maximum number of locations in the jump list
+const maxJumps = 100
+
+// A jump is a location in the jump list. The buffer is remembered by its
+// path so that it can be opened again if it was closed. Only the jumps to
+// buffers without a file refer to the buffer itself, and they are removed
+// when it is closed
+type jump struct {
+	buf  *buffer.Buffer
+	path string
+	loc  buffer.Loc
+}
+
+// same returns whether two jumps are on the same line of the same buffer
+func (j jump) same(o jump) bool {
+	if j.path != "" || o.path != "" {
+		return j.path == o.path && j.loc.Y == o.loc.Y
+	}
+	return j.buf == o.buf && j.loc.Y == o.loc.Y
+}
+
+// The jump list is shared by all panes. jumpIndex is the position in the
+// list after going back, or len(jumpList) if JumpBack was not used since
+// the last jump
+var jumpList []jump
+var jumpIndex int
+
+func (h *BufPane) jumpAt(loc buffer.Loc) jump {
+	// the AbsPath of a buffer without a file is the working directory, so
+	// such buffers are only told apart by the buffer itself
+	if h.Buf.Path == "" {
+		return jump{buf: h.Buf, loc: loc}
+	}
+	return jump{path: h.Buf.AbsPath, loc: loc}
+}
+
+// forgetJumps removes the jumps to a buffer without a file when it is
+// closed, since they cannot be reached anymore
+func forgetJumps(b *buffer.Buffer) {
+	kept := jumpList[:0]
+	index := jumpIndex
+	for i, j := range jumpList {
+		if j.buf == b {
+			if i < jumpIndex {
+				index--
+			}
+			continue
+		}
+		kept = append(kept, j)
+	}
+	for i := len(kept); i < len(jumpList); i++ {
+		jumpList[i] = jump{}
+	}
+	jumpList, jumpIndex = kept, index
+}
+
+// addSwitchJump records the location of the active pane in the jump list
+// before another pane or tab is made active, so that JumpBack returns to it
+func addSwitchJump() {
+	if bp := MainTab().CurPane(); bp != nil {
+		bp.addJump(bp.Cursor.Loc)
+	}
+}
+
+// addJump records a location of the pane's buffer in the jump list before
+// the cursor jumps away from it. The locations after the current position
+// in the list are dropped
+func (h *BufPane) addJump(loc buffer.Loc) {
+	j := h.jumpAt(loc)
+	jumpList = jumpList[:jumpIndex]
+	if len(jumpList) > 0 && jumpList[len(jumpList)-1].same(j) {
+		jumpList[len(jumpList)-1] = j
+	} else {
+		jumpList = append(jumpList, j)
+	}
+	if len(jumpList) > maxJumps {
+		jumpList = jumpList[len(jumpList)-maxJumps:]
+	}
+	jumpIndex = len(jumpList)
+}
+
+// JumpBack moves the cursor to the previous location in the jump list,
+// switching to or reopening its buffer if necessary
+func (h *BufPane) JumpBack() bool {
+	cur := h.jumpAt(h.Cursor.Loc)
+	if jumpIndex >= len(jumpList) {
+		// remember where we came from so that JumpForward can return
+		h.addJump(h.Cursor.Loc)
+		jumpIndex = len(jumpList) - 1
+	} else {
+		jumpList[jumpIndex] = cur
+	}
+
+	start := jumpIndex
+	for i := start - 1; i >= 0; i-- {
+		if jumpList[i].same(cur) {
+			continue
+		}
+		jumpIndex = i
+		if h.gotoJump(i) {
+			return true
+		}
+	}
+	jumpIndex = start
+	InfoBar.Message("Already at oldest jump")
+	return false
+}
+
+// JumpForward moves the cursor to the next location in the jump list after
+// using JumpBack
+func (h *BufPane) JumpForward() bool {
+	cur := h.jumpAt(h.Cursor.Loc)
+	if jumpIndex < len(jumpList) {
+		jumpList[jumpIndex] = cur
+	}
+
+	start := jumpIndex
+	for i := start + 1; i < len(jumpList); i++ {
+		if jumpList[i].same(cur) {
+			continue
+		}
+		jumpIndex = i
+		if h.gotoJump(i) {
+			return true
+		}
+	}
+	jumpIndex = start
+	InfoBar.Message("Already at newest jump")
+	return false
+}
+
+// findJumpPane returns the tab and pane showing the buffer of a jump, or
+// -1 if there is none. The current pane is preferred
+func (h *BufPane) findJumpPane(j jump) (int, int) {
+	shows := func(bp *BufPane) bool {
+		if j.path != "" {
+			return bp.Buf.AbsPath == j.path
+		}
+		return bp.Buf == j.buf
+	}
+	if shows(h) {
+		return Tabs.Active(), h.tab.active
+	}
+	for t, tab := range Tabs.List {
+		for p, pane := range tab.Panes {
+			if bp, ok := pane.(*BufPane); ok && shows(bp) {
+				return t, p
+			}
+		}
+	}
+	return -1, -1
+}
+
+// gotoJump moves the cursor to entry i of the jump list. It returns false
+// if the buffer of the entry was closed and cannot be opened again
+func (h *BufPane) gotoJump(i int) bool {
+	return h.gotoLocation(jumpList[i])
+}
+
+// gotoLocation moves the cursor to the location of j in the tab and pane
+// that shows its buffer. If no pane shows it, the file is opened in this
+// pane, or in a split if this pane's buffer is modified
+func (h *BufPane) gotoLocation(j jump) bool {
+	t, p := h.findJumpPane(j)
+	if t < 0 {
+		if j.path == "" {
+			return false
+		}
+		b, err := buffer.NewBufferFromFile(j.path, buffer.BTDefault)
+		if err != nil {
+			InfoBar.Error(err)
+			return false
+		}
+		bp := h
+		if h.Buf.Modified() {
+			// don't close a modified buffer, open the file in a split
+			bp = h.HSplitBuf(b)
+		} else {
+			h.openBuffer(b)
+		}
+		bp.gotoJumpLoc(j.loc)
+		return true
+	}
+
+	if t != Tabs.Active() {
+		Tabs.SetActive(t)
+	}
+	tab := Tabs.List[t]
+	if p != tab.active {
+		tab.SetActive(p)
+	}
+	tab.Panes[p].(*BufPane).gotoJumpLoc(j.loc)
+	return true
+}
+
+func (h *BufPane) gotoJumpLoc(loc buffer.Loc) {
+	h.Cursor.ResetSelection()
+	h.RemoveAllMultiCursors()
+	loc.Y = util.Clamp(loc.Y, 0, h.Buf.LinesNum()-1)
+	loc.X = util.Clamp(loc.X, 0, util.CharacterCount(h.Buf.LineBytes(loc.Y)))
+	h.GotoLoc(loc)
+}
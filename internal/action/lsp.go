@@ -0,0 +1,727 @@
+package action
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	shellquote "github.com/kballard/go-shellquote"
+	"github.com/zyedidia/micro/v2/internal/buffer"
+	"github.com/zyedidia/micro/v2/internal/lsp"
+	"github.com/zyedidia/micro/v2/internal/project"
+	"github.com/zyedidia/micro/v2/internal/shell"
+)
+
+// lspSyncDelay is how long the changes to a document are collected before
+// they are sent to the language server
+const lspSyncDelay = 200 * time.Millisecond
+
+// An lspServer is a language server started with the command of the
+// lspserver option. A server is started once and shared by all the
+// buffers with the same command
+type lspServer struct {
+	command string
+	// client is nil while the server is starting
+	client *lsp.Client
+	// err is set if the server failed to start or exited
+	err error
+}
+
+// An lspDocument is a buffer that is synced with a language server. The
+// text is shared by the buffers of a file, so there is one document for
+// every SharedBuffer
+type lspDocument struct {
+	server *lspServer
+	// buf is one of the buffers of the document
+	buf *buffer.Buffer
+	uri lsp.DocumentURI
+
+	opened  bool
+	version int
+	// the changes that were not sent yet. If full is set, the whole text
+	// is sent instead
+	changes []lsp.TextDocumentContentChangeEvent
+	full    bool
+	timer   *time.Timer
+
+	diagnostics []lsp.Diagnostic
+}
+
+var lspServers = make(map[string]*lspServer)
+var lspDocuments = make(map[*buffer.SharedBuffer]*lspDocument)
+
+// lspJob runs f in the main goroutine
+func lspJob(f func()) {
+	shell.Jobs <- shell.JobFunction{
+		Function: func(string, []interface{}) {
+			f()
+		},
+	}
+}
+
+// lspAttach opens a buffer in the language server of its lspserver option,
+// starting the server if it is not running
+func lspAttach(b *buffer.Buffer) {
+	if b.Type != buffer.BTDefault || b.Path == "" {
+		return
+	}
+	command := b.Settings["lspserver"].(string)
+	if command == "" {
+		return
+	}
+	if _, ok := lspDocuments[b.SharedBuffer]; ok {
+		return
+	}
+
+	s, ok := lspServers[command]
+	if !ok {
+		s = startLSPServer(command)
+	}
+	if s.err != nil {
+		return
+	}
+
+	doc := &lspDocument{
+		server: s,
+		buf:    b,
+		uri:    lsp.URIFromPath(b.AbsPath),
+	}
+	lspDocuments[b.SharedBuffer] = doc
+	b.OnChange = doc.change
+	b.OnClose = doc.close
+	if s.client != nil {
+		doc.open()
+	}
+}
+
+// startLSPServer starts a language server in the background. The
+// documents that are attached while it starts are opened once it runs
+func startLSPServer(command string) *lspServer {
+	s := &lspServer{command: command}
+	lspServers[command] = s
+
+	args, err := shellquote.Split(command)
+	if err == nil && len(args) == 0 {
+		err = errors.New("empty command")
+	}
+	if err != nil {
+		s.failed(err)
+		return s
+	}
+	root, err := os.Getwd()
+	if err != nil {
+		s.failed(err)
+		return s
+	}
+
+	go func() {
+		c, err := lsp.Start(args, root, lspHandler{s})
+		lspJob(func() {
+			if err != nil {
+				s.failed(err)
+				return
+			}
+			s.client = c
+			for _, doc := range lspDocuments {
+				if doc.server == s {
+					doc.open()
+				}
+			}
+		})
+		if err != nil {
+			return
+		}
+
+		<-c.Done()
+		lspJob(func() {
+			err := c.Err()
+			if err == nil {
+				err = errors.New("exited")
+			}
+			s.failed(err)
+		})
+	}()
+	return s
+}
+
+// ShutdownLSPServers asks the running language servers to exit, and waits
+// until they have, which micro does when it quits
+func ShutdownLSPServers() {
+	var wg sync.WaitGroup
+	for _, s := range lspServers {
+		if s.client == nil || s.err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(c *lsp.Client) {
+			c.Shutdown()
+			wg.Done()
+		}(s.client)
+	}
+	wg.Wait()
+}
+
+// failed reports an error of the server and detaches its documents. The
+// server is not started again until micro is restarted
+func (s *lspServer) failed(err error) {
+	s.err = err
+	InfoBar.Error("Language server ", s.command, ": ", err)
+	for _, doc := range lspDocuments {
+		if doc.server == s {
+			doc.detach()
+		}
+	}
+}
+
+// An lspHandler receives the messages of a server and handles them in the
+// main goroutine
+type lspHandler struct {
+	server *lspServer
+}
+
+func (h lspHandler) Diagnostics(params lsp.PublishDiagnosticsParams) {
+	lspJob(func() {
+		for _, doc := range lspDocuments {
+			if doc.server == h.server && doc.uri == params.URI {
+				doc.setDiagnostics(params.Diagnostics)
+			}
+		}
+	})
+}
+
+func (h lspHandler) ApplyEdit(edit lsp.WorkspaceEdit, reply func(bool)) {
+	lspJob(func() {
+		_, err := applyWorkspaceEdit(edit)
+		if err != nil {
+			InfoBar.Error(err)
+		}
+		reply(err == nil)
+	})
+}
+
+func (h lspHandler) ShowMessage(params lsp.ShowMessageParams) {
+	lspJob(func() {
+		switch params.Type {
+		case lsp.MessageError:
+			InfoBar.Error(params.Message)
+		case lsp.MessageWarning:
+			InfoBar.Message(params.Message)
+		default:
+			log.Println(h.server.command+":", params.Message)
+		}
+	})
+}
+
+// open sends the text of the document to the server
+func (doc *lspDocument) open() {
+	doc.opened = true
+	doc.changes = nil
+	doc.full = false
+	doc.version = 1
+	ft := doc.buf.Settings["filetype"].(string)
+	if err := doc.server.client.DidOpen(doc.uri, ft, doc.version, string(bufferBytes(doc.buf))); err != nil {
+		InfoBar.Error(err)
+	}
+}
+
+// detach stops syncing the buffer with the server and removes its
+// diagnostics
+func (doc *lspDocument) detach() {
+	if doc.timer != nil {
+		doc.timer.Stop()
+	}
+	doc.buf.OnChange = nil
+	doc.buf.OnClose = nil
+	doc.buf.ClearMessages("lsp")
+	delete(lspDocuments, doc.buf.SharedBuffer)
+}
+
+// close tells the server that the document was closed
+func (doc *lspDocument) close() {
+	if doc.opened && doc.server.err == nil {
+		doc.server.client.DidClose(doc.uri)
+	}
+	doc.detach()
+}
+
+// position returns the position of a location in the protocol
+func (doc *lspDocument) position(loc buffer.Loc) lsp.Position {
+	return lsp.Position{Line: loc.Y, Character: lsp.UTF16Offset(doc.buf.LineBytes(loc.Y), loc.X)}
+}
+
+// loc returns the location of a position of the protocol
+func (doc *lspDocument) loc(pos lsp.Position) buffer.Loc {
+	return lspLoc(doc.buf, pos)
+}
+
+func lspLoc(b *buffer.Buffer, pos lsp.Position) buffer.Loc {
+	if pos.Line >= b.LinesNum() {
+		return b.End()
+	}
+	return buffer.Loc{X: lsp.CharacterIndex(b.LineBytes(pos.Line), pos.Character), Y: pos.Line}
+}
+
+// change records a change of the text, which is made after this call, and
+// sends it after a short delay
+func (doc *lspDocument) change(start, end buffer.Loc, text []byte) {
+	if !doc.opened {
+		return
+	}
+	switch doc.server.client.Capabilities.SyncKind() {
+	case lsp.SyncNone:
+		return
+	case lsp.SyncIncremental:
+		doc.changes = append(doc.changes, lsp.TextDocumentContentChangeEvent{
+			Range: &lsp.Range{Start: doc.position(start), End: doc.position(end)},
+			Text:  string(text),
+		})
+	default:
+		doc.full = true
+	}
+
+	if doc.timer != nil {
+		doc.timer.Stop()
+	}
+	doc.timer = time.AfterFunc(lspSyncDelay, func() {
+		lspJob(doc.flush)
+	})
+}
+
+// flush sends the changes that were not sent yet
+func (doc *lspDocument) flush() {
+	if lspDocuments[doc.buf.SharedBuffer] != doc || !doc.opened || len(doc.changes) == 0 && !doc.full {
+		return
+	}
+	if doc.timer != nil {
+		doc.timer.Stop()
+	}
+	changes := doc.changes
+	if doc.full {
+		changes = []lsp.TextDocumentContentChangeEvent{{Text: string(bufferBytes(doc.buf))}}
+	}
+	doc.changes = nil
+	doc.full = false
+	doc.version++
+	if err := doc.server.client.DidChange(doc.uri, doc.version, changes); err != nil {
+		InfoBar.Error(err)
+	}
+}
+
+// setDiagnostics shows the diagnostics of the document in the gutter
+func (doc *lspDocument) setDiagnostics(diagnostics []lsp.Diagnostic) {
+	doc.diagnostics = diagnostics
+	doc.buf.ClearMessages("lsp")
+	for _, d := range diagnostics {
+		var kind buffer.MsgType = buffer.MTInfo
+		switch d.Severity {
+		case lsp.SeverityError:
+			kind = buffer.MTError
+		case lsp.SeverityWarning:
+			kind = buffer.MTWarning
+		}
+		msg := d.Message
+		if d.Source != "" {
+			msg = d.Source + ": " + msg
+		}
+		doc.buf.AddMessage(buffer.NewMessage("lsp", msg, doc.loc(d.Range.Start), doc.loc(d.Range.End), kind))
+	}
+}
+
+// lspSaved tells the server that a buffer was saved. A buffer that was
+// saved under a new name is opened again
+func lspSaved(b *buffer.Buffer) {
+	doc, ok := lspDocuments[b.SharedBuffer]
+	if ok && doc.uri != lsp.URIFromPath(b.AbsPath) {
+		doc.close()
+		ok = false
+	}
+	if !ok {
+		lspAttach(b)
+		return
+	}
+	if doc.opened {
+		doc.flush()
+		doc.server.client.DidSave(doc.uri)
+	}
+}
+
+// lspDocument returns the document of the pane's buffer if its server
+// supports a feature. An error is shown otherwise. The changes that were
+// not sent are sent first so that the server has the current text
+func (h *BufPane) lspDocument(feature string, provider func(*lsp.ServerCapabilities) json.RawMessage) *lspDocument {
+	doc, ok := lspDocuments[h.Buf.SharedBuffer]
+	switch {
+	case !ok:
+		InfoBar.Error("No language server for this buffer (see the lspserver option)")
+		return nil
+	case !doc.opened:
+		InfoBar.Message("The language server is starting")
+		return nil
+	case !lsp.Supports(provider(&doc.server.client.Capabilities)):
+		InfoBar.Error("The language server does not support ", feature)
+		return nil
+	}
+	doc.flush()
+	return doc
+}
+
+// lspFiles reads lines of files to convert the positions of locations. The
+// lines of open files are read from their buffer
+type lspFiles map[string][]string
+
+func (f lspFiles) line(path string, y int) []byte {
+	for _, b := range buffer.OpenBuffers {
+		if b.AbsPath == path && b.Type == buffer.BTDefault {
+			if y < b.LinesNum() {
+				return b.LineBytes(y)
+			}
+			return nil
+		}
+	}
+
+	lines, ok := f[path]
+	if !ok {
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			lines = strings.Split(string(data), "\n")
+		}
+		f[path] = lines
+	}
+	if y < len(lines) {
+		return []byte(strings.TrimSuffix(lines[y], "\r"))
+	}
+	return nil
+}
+
+// jump returns the jump to the start of a location
+func (f lspFiles) jump(l lsp.Location) jump {
+	path := l.URI.Path()
+	pos := l.Range.Start
+	return jump{path: path, loc: buffer.Loc{X: lsp.CharacterIndex(f.line(path, pos.Line), pos.Character), Y: pos.Line}}
+}
+
+// showLocations lists locations in the results pane of grep. The pane and
+// the NextGrepResult and PreviousGrepResult actions go to them
+func (h *BufPane) showLocations(name string, locs []lsp.Location) {
+	root, err := os.Getwd()
+	if err != nil {
+		InfoBar.Error(err)
+		return
+	}
+
+	s := h.newGrepSearch(root, name)
+	s.cancel()
+	files := make(lspFiles)
+	var byFile [][]project.Match
+	index := make(map[string]int)
+	for _, l := range locs {
+		j := files.jump(l)
+		if j.path == "" {
+			continue
+		}
+		m := project.Match{
+			Path:  j.path,
+			Line:  j.loc.Y,
+			Start: j.loc.X,
+			End:   j.loc.X,
+			Text:  string(files.line(j.path, j.loc.Y)),
+		}
+		if rel, err := filepath.Rel(root, j.path); err == nil && !strings.HasPrefix(rel, "..") {
+			m.Path = rel
+		}
+		i, ok := index[j.path]
+		if !ok {
+			i = len(byFile)
+			index[j.path] = i
+			byFile = append(byFile, nil)
+		}
+		byFile[i] = append(byFile[i], m)
+	}
+	for _, matches := range byFile {
+		s.add(matches)
+	}
+	InfoBar.Message(fmt.Sprintf("%d %s in %d files", len(s.results), name, s.files))
+}
+
+// lspPaneAlive returns whether the pane was not closed while waiting for a
+// response of a language server
+func lspPaneAlive(h *BufPane) bool {
+	return paneTab(h) >= 0
+}
+
+// GotoDefinition moves the cursor to the definition of the symbol under
+// the cursor, as found by the language server. If there are several
+// definitions they are listed like the results of grep
+func (h *BufPane) GotoDefinition() bool {
+	doc := h.lspDocument("definitions", func(c *lsp.ServerCapabilities) json.RawMessage {
+		return c.DefinitionProvider
+	})
+	if doc == nil {
+		return false
+	}
+	doc.server.client.Definition(doc.uri, doc.position(h.Cursor.Loc), func(locs []lsp.Location, err error) {
+		lspJob(func() {
+			switch {
+			case err != nil:
+				InfoBar.Error(err)
+			case !lspPaneAlive(h):
+			case len(locs) == 0:
+				InfoBar.Message("No definition found")
+			case len(locs) == 1:
+				h.addJump(h.Cursor.Loc)
+				h.gotoLocation(make(lspFiles).jump(locs[0]))
+			default:
+				h.showLocations("definitions", locs)
+			}
+		})
+	})
+	return true
+}
+
+// FindReferences lists the uses of the symbol under the cursor, as found by
+// the language server, like the results of grep
+func (h *BufPane) FindReferences() bool {
+	doc := h.lspDocument("references", func(c *lsp.ServerCapabilities) json.RawMessage {
+		return c.ReferencesProvider
+	})
+	if doc == nil {
+		return false
+	}
+	doc.server.client.References(doc.uri, doc.position(h.Cursor.Loc), func(locs []lsp.Location, err error) {
+		lspJob(func() {
+			switch {
+			case err != nil:
+				InfoBar.Error(err)
+			case !lspPaneAlive(h):
+			case len(locs) == 0:
+				InfoBar.Message("No references found")
+			default:
+				h.showLocations("references", locs)
+			}
+		})
+	})
+	return true
+}
+
+// Hover shows the information of the language server about the symbol under
+// the cursor. A single line is shown in the infobar and longer text in a
+// split
+func (h *BufPane) Hover() bool {
+	doc := h.lspDocument("hover", func(c *lsp.ServerCapabilities) json.RawMessage {
+		return c.HoverProvider
+	})
+	if doc == nil {
+		return false
+	}
+	doc.server.client.Hover(doc.uri, doc.position(h.Cursor.Loc), func(text string, err error) {
+		lspJob(func() {
+			if err != nil {
+				InfoBar.Error(err)
+				return
+			}
+			if !lspPaneAlive(h) {
+				return
+			}
+
+			var lines []string
+			for _, l := range strings.Split(text, "\n") {
+				// drop the fences of markdown code blocks
+				if !strings.HasPrefix(l, "```") {
+					lines = append(lines, strings.TrimRight(l, " \t\r"))
+				}
+			}
+			for len(lines) > 0 && lines[0] == "" {
+				lines = lines[1:]
+			}
+			for len(lines) > 0 && lines[len(lines)-1] == "" {
+				lines = lines[:len(lines)-1]
+			}
+
+			switch len(lines) {
+			case 0:
+				InfoBar.Message("No information")
+			case 1:
+				InfoBar.Message(lines[0])
+			default:
+				h.OpenPicker("hover", lines, func(int) {})
+			}
+		})
+	})
+	return true
+}
+
+// CodeAction lists the code actions that the language server offers for the
+// selection or the cursor location, such as fixes for the diagnostics
+// there. Pressing enter on an action applies it
+func (h *BufPane) CodeAction() bool {
+	doc := h.lspDocument("code actions", func(c *lsp.ServerCapabilities) json.RawMessage {
+		return c.CodeActionProvider
+	})
+	if doc == nil {
+		return false
+	}
+
+	start, end := h.Cursor.Loc, h.Cursor.Loc
+	if h.Cursor.HasSelection() {
+		start, end = h.Cursor.CurSelection[0], h.Cursor.CurSelection[1]
+		if end.LessThan(start) {
+			start, end = end, start
+		}
+	}
+	r := lsp.Range{Start: doc.position(start), End: doc.position(end)}
+	var diagnostics []lsp.Diagnostic
+	for _, d := range doc.diagnostics {
+		if d.Range.Start.Line <= r.End.Line && d.Range.End.Line >= r.Start.Line {
+			diagnostics = append(diagnostics, d)
+		}
+	}
+
+	doc.server.client.CodeActions(doc.uri, r, diagnostics, func(actions []lsp.CodeAction, err error) {
+		lspJob(func() {
+			switch {
+			case err != nil:
+				InfoBar.Error(err)
+			case !lspPaneAlive(h):
+			case len(actions) == 0:
+				InfoBar.Message("No code actions")
+			default:
+				titles := make([]string, len(actions))
+				for i, a := range actions {
+					titles[i] = a.Title
+				}
+				h.OpenPicker("code actions", titles, func(i int) {
+					doc.runCodeAction(actions[i], true)
+				})
+			}
+		})
+	})
+	return true
+}
+
+// runCodeAction applies the edit of a code action and executes its
+// command. An action that has neither is resolved first if resolve is set
+func (doc *lspDocument) runCodeAction(a lsp.CodeAction, resolve bool) {
+	c := doc.server.client
+	if doc.server.err != nil {
+		InfoBar.Error("Language server ", doc.server.command, ": ", doc.server.err)
+		return
+	}
+	if a.Edit == nil && a.Command == nil {
+		if !resolve || !c.Capabilities.CanResolveCodeActions() {
+			InfoBar.Message("The code action has nothing to do")
+			return
+		}
+		c.ResolveCodeAction(a, func(resolved lsp.CodeAction, err error) {
+			lspJob(func() {
+				if err != nil {
+					InfoBar.Error(err)
+					return
+				}
+				doc.runCodeAction(resolved, false)
+			})
+		})
+		return
+	}
+
+	if a.Edit != nil {
+		if _, err := applyWorkspaceEdit(*a.Edit); err != nil {
+			InfoBar.Error(err)
+			return
+		}
+	}
+	if a.Command != nil {
+		doc.flush()
+		c.ExecuteCommand(*a.Command, func(err error) {
+			if err != nil {
+				lspJob(func() {
+					InfoBar.Error(err)
+				})
+			}
+		})
+	}
+}
+
+// RenameCmd renames the symbol under the cursor in every file where it is
+// used, as found by the language server
+func (h *BufPane) RenameCmd(args []string) {
+	if len(args) != 1 || args[0] == "" {
+		InfoBar.Error("Usage: rename newname")
+		return
+	}
+	doc := h.lspDocument("renaming", func(c *lsp.ServerCapabilities) json.RawMessage {
+		return c.RenameProvider
+	})
+	if doc == nil {
+		return
+	}
+	doc.server.client.Rename(doc.uri, doc.position(h.Cursor.Loc), args[0], func(edit *lsp.WorkspaceEdit, err error) {
+		lspJob(func() {
+			if err != nil {
+				InfoBar.Error(err)
+				return
+			}
+			if edit == nil {
+				InfoBar.Message("Nothing to rename")
+				return
+			}
+			files, err := applyWorkspaceEdit(*edit)
+			if err != nil {
+				InfoBar.Error(err)
+				return
+			}
+			InfoBar.Message(fmt.Sprintf("Renamed to %s in %d files", args[0], files))
+		})
+	})
+}
+
+// applyWorkspaceEdit applies the edits of a language server and returns
+// the number of files that were changed. Files that are open are changed
+// in their buffer, so the changes can be undone and still have to be
+// saved, and other files are written directly
+func applyWorkspaceEdit(edit lsp.WorkspaceEdit) (int, error) {
+	files := 0
+	for uri, edits := range edit.Edits() {
+		path := uri.Path()
+		if path == "" {
+			return files, errors.New("cannot edit " + string(uri))
+		}
+
+		var buf *buffer.Buffer
+		for _, b := range buffer.OpenBuffers {
+			if b.AbsPath == path && b.Type == buffer.BTDefault {
+				buf = b
+				break
+			}
+		}
+
+		if buf != nil {
+			deltas := make([]buffer.Delta, 0, len(edits))
+			for _, e := range lsp.EditsFromEnd(edits) {
+				deltas = append(deltas, buffer.Delta{
+					Text:  []byte(e.NewText),
+					Start: lspLoc(buf, e.Range.Start),
+					End:   lspLoc(buf, e.Range.End),
+				})
+			}
+			buf.MultipleReplace(deltas)
+			buf.RelocateCursors()
+		} else {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return files, err
+			}
+			if err := project.WriteFileAtomic(path, lsp.ApplyTextEdits(data, edits)); err != nil {
+				return files, err
+			}
+		}
+		files++
+	}
+	return files, nil
+}
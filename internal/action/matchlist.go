@@ -0,0 +1,108 @@
+package action
+
+import (
+	"strings"
+
+	"github.com/zyedidia/micro/v2/internal/buffer"
+	"github.com/zyedidia/micro/v2/internal/project"
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+// BTMatchList is the type of the buffer that lists the matches of a fuzzy
+// prompt, such as the file finder or the command palette
+var BTMatchList = buffer.BufType{Kind: buffer.BTScratch.Kind, Readonly: true, Scratch: true, Syntax: false}
+
+// A matchList is a split that lists the matches of a fuzzy prompt, one
+// per line, and highlights the selected one. Only the matches that fit in
+// the split are put in its buffer, and they scroll with the selection. The
+// prompt keeps the focus, and its history keys move the selection instead
+type matchList struct {
+	*BufPane
+
+	matches  []project.FuzzyMatch
+	selected int
+	// the index of the first match that is shown
+	top int
+
+	// format returns the line that is shown for a match
+	format func(m project.FuzzyMatch) string
+	// onSelect is called when another match is selected, and may be nil
+	onSelect func()
+}
+
+// newMatchList opens a match list in a horizontal split below h. It is not
+// made active
+func newMatchList(h *BufPane, name string, format func(m project.FuzzyMatch) string) *matchList {
+	b := buffer.NewBufferFromString("", "", BTMatchList)
+	b.SetName(name)
+
+	l := &matchList{format: format}
+	l.BufPane = NewBufPaneFromBuf(b, h.tab)
+	l.splitID = MainTab().GetNode(h.splitID).HSplit(true)
+	MainTab().Panes = append(MainTab().Panes, l.BufPane)
+	MainTab().Resize()
+	return l
+}
+
+// setMatches lists the given matches, which are sorted from best to worst
+func (l *matchList) setMatches(matches []project.FuzzyMatch) {
+	l.matches = matches
+	l.selected = util.Clamp(l.selected, 0, util.Max(len(matches)-1, 0))
+	l.render()
+}
+
+// move moves the selection by n matches
+func (l *matchList) move(n int) {
+	if len(l.matches) == 0 {
+		return
+	}
+	l.selected = util.Clamp(l.selected+n, 0, len(l.matches)-1)
+	l.render()
+}
+
+// render puts the matches that fit in the split around the selection in
+// its buffer, if they changed, and highlights the selection
+func (l *matchList) render() {
+	height := util.Max(l.BufView().Height, 1)
+	if l.selected < l.top {
+		l.top = l.selected
+	} else if l.selected >= l.top+height {
+		l.top = l.selected - height + 1
+	}
+	l.top = util.Clamp(l.top, 0, util.Max(len(l.matches)-height, 0))
+
+	end := util.Min(l.top+height, len(l.matches))
+	lines := make([]string, 0, end-l.top)
+	for _, m := range l.matches[l.top:end] {
+		lines = append(lines, l.format(m))
+	}
+	if text := strings.Join(lines, "\n"); text != string(l.Buf.Bytes()) {
+		l.Buf.SetText(text)
+	}
+	l.highlight()
+}
+
+// selection returns the selected match, if there is one
+func (l *matchList) selection() (project.FuzzyMatch, bool) {
+	if l.selected >= len(l.matches) {
+		return project.FuzzyMatch{}, false
+	}
+	return l.matches[l.selected], true
+}
+
+// highlight selects the line of the selected match
+func (l *matchList) highlight() {
+	c := l.Cursor
+	c.ResetSelection()
+	if l.selected < len(l.matches) {
+		y := l.selected - l.top
+		c.GotoLoc(buffer.Loc{X: 0, Y: y})
+		c.SetSelectionStart(buffer.Loc{X: 0, Y: y})
+		c.SetSelectionEnd(buffer.Loc{X: util.CharacterCount(l.Buf.LineBytes(y)), Y: y})
+	}
+	l.Relocate()
+
+	if l.onSelect != nil {
+		l.onSelect()
+	}
+}
@@ -0,0 +1,188 @@
+package action
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zyedidia/micro/v2/internal/config"
+	"github.com/zyedidia/micro/v2/internal/project"
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+// The kinds of the items of the command palette
+const (
+	paletteAction = iota
+	paletteCommand
+	paletteOption
+)
+
+var paletteKinds = []string{"action", "command", "option"}
+
+// A paletteItem is an action, a command or an option in the command
+// palette. detail is shown next to the name: the keys an action is bound
+// to, or the value of an option
+type paletteItem struct {
+	kind   int
+	name   string
+	detail string
+}
+
+// A palette is the state of the command palette while its prompt is open
+type palette struct {
+	items  []paletteItem
+	query  string
+	target *BufPane
+	list   *matchList
+}
+
+var activePalette *palette
+
+func init() {
+	// the action is added here rather than in the map literal since the
+	// palette lists the actions, which would make the map refer to itself
+	BufKeyActions["CommandPalette"] = (*BufPane).CommandPalette
+}
+
+// CommandPalette opens the command palette, which lists the actions,
+// commands and options whose names match what is typed in the prompt.
+// Choosing an action runs it, choosing a command opens the command prompt
+// to enter its arguments, and choosing an option toggles it if it is
+// boolean or opens the command prompt to set it otherwise
+func (h *BufPane) CommandPalette() bool {
+	p := &palette{target: h, items: paletteItems(h)}
+
+	names := make([]string, len(p.items))
+	width := 0
+	for i, it := range p.items {
+		names[i] = it.name
+		width = util.Max(width, util.CharacterCountInString(it.name))
+	}
+	p.list = newMatchList(h, "Command palette", func(m project.FuzzyMatch) string {
+		it := p.items[m.Index]
+		line := fmt.Sprintf("%-*s  %-7s", width, it.name, paletteKinds[it.kind])
+		if it.detail != "" {
+			line += "  " + it.detail
+		}
+		return line
+	})
+
+	activePalette = p
+	InfoBar.Prompt("Palette: ", "", "Palette", func(resp string) {
+		if resp == p.query {
+			return
+		}
+		p.query = resp
+		p.list.selected = 0
+		p.list.setMatches(project.FuzzyFilter(resp, names))
+		InfoBar.PromptStatus = fmt.Sprintf("%d/%d", len(p.list.matches), len(names))
+	}, func(resp string, canceled bool) {
+		activePalette = nil
+		p.list.ForceQuit()
+		activatePane(h)
+		if !canceled {
+			if m, ok := p.list.selection(); ok {
+				p.run(p.items[m.Index])
+			}
+		}
+	})
+	p.list.setMatches(project.FuzzyFilter("", names))
+	InfoBar.PromptStatus = fmt.Sprintf("%d/%d", len(names), len(names))
+	return true
+}
+
+// paletteItems returns the items of the command palette: the actions,
+// then the commands and then the options, each sorted by name
+func paletteItems(h *BufPane) []paletteItem {
+	keys := make(map[string][]string)
+	for k, action := range config.Bindings["buffer"] {
+		for _, a := range strings.FieldsFunc(action, func(r rune) bool {
+			return r == '&' || r == '|' || r == ','
+		}) {
+			keys[a] = append(keys[a], k)
+		}
+	}
+
+	var items []paletteItem
+	add := func(kind int, names []string, detail func(name string) string) {
+		sort.Strings(names)
+		for _, name := range names {
+			items = append(items, paletteItem{kind: kind, name: name, detail: detail(name)})
+		}
+	}
+
+	var actions []string
+	for name := range BufKeyActions {
+		actions = append(actions, name)
+	}
+	add(paletteAction, actions, func(name string) string {
+		sort.Strings(keys[name])
+		return strings.Join(keys[name], ", ")
+	})
+
+	var cmds []string
+	for name := range commands {
+		cmds = append(cmds, name)
+	}
+	add(paletteCommand, cmds, func(string) string {
+		return ""
+	})
+
+	// plugins may add options that have no default value
+	options := config.DefaultAllSettings()
+	for name, v := range config.GlobalSettings {
+		options[name] = v
+	}
+	var opts []string
+	for name := range options {
+		opts = append(opts, name)
+	}
+	add(paletteOption, opts, func(name string) string {
+		return fmt.Sprint(h.optionValue(name))
+	})
+
+	return items
+}
+
+// optionValue returns the value of an option in this pane's buffer, or
+// its global value if it is not a buffer option
+func (h *BufPane) optionValue(name string) interface{} {
+	if v, ok := h.Buf.Settings[name]; ok {
+		return v
+	}
+	return config.GlobalSettings[name]
+}
+
+// run runs the chosen item in the pane that opened the palette
+func (p *palette) run(it paletteItem) {
+	h := p.target
+	switch it.kind {
+	case paletteAction:
+		h.runAction(it.name)
+	case paletteCommand:
+		CommandEditAction(it.name + " ")(h)
+	case paletteOption:
+		if v, ok := h.optionValue(it.name).(bool); ok {
+			h.SetCmd([]string{it.name, strconv.FormatBool(!v)})
+			InfoBar.Message(it.name, " is now ", h.optionValue(it.name))
+		} else {
+			CommandEditAction("set " + it.name + " ")(h)
+		}
+	}
+}
+
+// runAction runs an action like a key binding does, once for every cursor
+// if it is a multi cursor action
+func (h *BufPane) runAction(name string) {
+	action := BufKeyActions[name]
+	for i, c := range h.Buf.GetCursors() {
+		if c == nil {
+			continue
+		}
+		h.Buf.SetCurCursor(c.Num)
+		h.Cursor = c
+		h.execAction(action, name, i)
+	}
+	h.Relocate()
+}
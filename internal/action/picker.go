@@ -0,0 +1,77 @@
+package action
+
+import (
+	"strings"
+
+	"github.com/zyedidia/micro/v2/internal/buffer"
+	"github.com/zyedidia/tcell/v2"
+)
+
+// BTPicker is the type of the buffer that lists the items of a picker
+var BTPicker = buffer.BufType{Kind: buffer.BTScratch.Kind, Readonly: true, Scratch: true, Syntax: false}
+
+// A PickerPane is a split that lists some items, one per line. Pressing
+// enter closes the pane and calls a callback with the item on the cursor
+// line from the pane that opened the picker
+type PickerPane struct {
+	*BufPane
+
+	target   *BufPane
+	items    int
+	callback func(i int)
+}
+
+func (p *PickerPane) bufPane() *BufPane {
+	return p.BufPane
+}
+
+// NewPickerPane creates a pane listing the given items
+func NewPickerPane(name string, items []string, target *BufPane, callback func(i int)) *PickerPane {
+	b := buffer.NewBufferFromString(strings.Join(items, "\n"), "", BTPicker)
+	b.SetName(name)
+
+	p := new(PickerPane)
+	p.BufPane = NewBufPaneFromBuf(b, target.tab)
+	p.target = target
+	p.items = len(items)
+	p.callback = callback
+	return p
+}
+
+// OpenPicker opens a picker in a horizontal split below the pane
+func (h *BufPane) OpenPicker(name string, items []string, callback func(i int)) *PickerPane {
+	p := NewPickerPane(name, items, h, callback)
+	p.splitID = MainTab().GetNode(h.splitID).HSplit(true)
+	MainTab().Panes = append(MainTab().Panes, p)
+	MainTab().Resize()
+	MainTab().SetActive(len(MainTab().Panes) - 1)
+	return p
+}
+
+// HandleEvent picks the item on the cursor line when enter is pressed and
+// closes the pane with escape or q. Other events are handled like in a
+// normal BufPane
+func (p *PickerPane) HandleEvent(event tcell.Event) {
+	if e, ok := event.(*tcell.EventKey); ok && e.Modifiers() == 0 {
+		switch {
+		case e.Key() == tcell.KeyEnter:
+			i := p.Cursor.Y
+			p.close()
+			if i < p.items {
+				p.callback(i)
+			}
+			return
+		case e.Key() == tcell.KeyEscape, e.Key() == tcell.KeyRune && e.Rune() == 'q':
+			p.close()
+			return
+		}
+	}
+	p.BufPane.HandleEvent(event)
+}
+
+// close closes the picker and makes the pane that opened it active again
+func (p *PickerPane) close() {
+	p.ForceQuit()
+	tab := p.target.tab
+	tab.SetActive(tab.GetPane(p.target.splitID))
+}
@@ -0,0 +1,149 @@
+package action
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/zyedidia/micro/v2/internal/buffer"
+	"github.com/zyedidia/micro/v2/internal/clipboard"
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+// updateReadOnlyRegisters stores the last search, the last command and the
+// filename of the buffer in the read-only registers
+func (h *BufPane) updateReadOnlyRegisters() {
+	clipboard.SetReadOnly(clipboard.SearchReg, h.Buf.LastSearch)
+	clipboard.SetReadOnly(clipboard.FilenameReg, h.Buf.Path)
+
+	cmd := ""
+	hist := InfoBar.History["Command"]
+	for i := len(hist) - 1; i >= 0; i-- {
+		if hist[i] != "" {
+			cmd = hist[i]
+			break
+		}
+	}
+	clipboard.SetReadOnly(clipboard.CommandReg, cmd)
+}
+
+// registerPrompt asks the user for a register name and calls the callback
+// with the register
+func (h *BufPane) registerPrompt(prompt string, callback func(r clipboard.Register, appendText bool)) {
+	InfoBar.Prompt(prompt, "", "Register", nil, func(resp string, canceled bool) {
+		if canceled {
+			return
+		}
+		r, appendText, err := clipboard.ParseRegister(strings.TrimSpace(resp))
+		if err != nil {
+			InfoBar.Error(err)
+			return
+		}
+		callback(r, appendText)
+	})
+}
+
+// forEachCursor calls fn with every cursor of the buffer as the active
+// cursor
+func (h *BufPane) forEachCursor(fn func(c *buffer.Cursor)) {
+	active := h.Buf.GetActiveCursor().Num
+	for _, c := range h.Buf.GetCursors() {
+		h.Buf.SetCurCursor(c.Num)
+		h.Cursor = c
+		fn(c)
+	}
+	h.Buf.SetCurCursor(active)
+	h.Cursor = h.Buf.GetActiveCursor()
+	h.Relocate()
+}
+
+// copyToRegister copies the selection of every cursor to a register
+func (h *BufPane) copyToRegister(r clipboard.Register, appendText bool) error {
+	var err error
+	h.forEachCursor(func(c *buffer.Cursor) {
+		if !c.HasSelection() || err != nil {
+			return
+		}
+		if appendText || r.ReadOnly() {
+			err = clipboard.WriteRegister(string(c.GetSelection()), r, appendText, c.Num, h.Buf.NumCursors())
+		} else {
+			c.CopySelection(r)
+		}
+	})
+	return err
+}
+
+// CopyToRegister copies the selection to a register chosen by the user
+func (h *BufPane) CopyToRegister() bool {
+	h.registerPrompt("Copy to register: ", func(r clipboard.Register, appendText bool) {
+		if err := h.copyToRegister(r, appendText); err != nil {
+			InfoBar.Error(err)
+			return
+		}
+		InfoBar.Message("Copied selection to register ", r.Name())
+	})
+	return true
+}
+
+// CutToRegister cuts the selection to a register chosen by the user
+func (h *BufPane) CutToRegister() bool {
+	h.registerPrompt("Cut to register: ", func(r clipboard.Register, appendText bool) {
+		if err := h.copyToRegister(r, appendText); err != nil {
+			InfoBar.Error(err)
+			return
+		}
+		h.forEachCursor(func(c *buffer.Cursor) {
+			if c.HasSelection() {
+				c.DeleteSelection()
+				c.ResetSelection()
+			}
+		})
+		InfoBar.Message("Cut selection to register ", r.Name())
+	})
+	return true
+}
+
+// PasteFromRegister pastes the contents of a register chosen by the user
+func (h *BufPane) PasteFromRegister() bool {
+	h.registerPrompt("Paste from register: ", func(r clipboard.Register, appendText bool) {
+		h.updateReadOnlyRegisters()
+		h.forEachCursor(func(c *buffer.Cursor) {
+			clip, block, err := clipboard.ReadBlock(r, c.Num, h.Buf.NumCursors())
+			if err != nil {
+				InfoBar.Error(err)
+			} else if block {
+				h.pasteBlock(clip)
+			} else {
+				h.paste(clip)
+			}
+		})
+	})
+	return true
+}
+
+// RegistersCmd lists the contents of the clipboard registers
+func (h *BufPane) RegistersCmd(args []string) {
+	h.updateReadOnlyRegisters()
+
+	var b bytes.Buffer
+	b.WriteString("Register  Contents\n")
+	for _, r := range clipboard.NamedRegisters() {
+		if r == clipboard.PrimaryReg && !h.Buf.Settings["useprimary"].(bool) {
+			continue
+		}
+		text, err := clipboard.Read(r)
+		if err != nil || text == "" {
+			continue
+		}
+		text = strings.NewReplacer("\n", "^J", "\r", "^M", "\t", "^I").Replace(text)
+		if util.CharacterCountInString(text) > 100 {
+			text = string([]rune(text)[:100]) + "..."
+		}
+		fmt.Fprintf(&b, "%-8s  %s\n", r.Name(), text)
+	}
+
+	regs := buffer.NewBufferFromString(b.String(), "", buffer.BTScratch)
+	regs.SetName("registers")
+	regs.Type.Readonly = true
+	h.HSplitBuf(regs)
+}
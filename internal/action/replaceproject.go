@@ -0,0 +1,413 @@
+package action
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/zyedidia/micro/v2/internal/buffer"
+	"github.com/zyedidia/micro/v2/internal/project"
+	"github.com/zyedidia/micro/v2/internal/shell"
+	"github.com/zyedidia/micro/v2/internal/util"
+	"github.com/zyedidia/tcell/v2"
+)
+
+// BTReplacePreview is the type of the buffer that previews the changes of
+// a replaceproject command
+var BTReplacePreview = buffer.BufType{Kind: buffer.BTScratch.Kind, Readonly: true, Scratch: true, Syntax: false}
+
+// A replaceFile is a file with matches of a replaceproject command
+type replaceFile struct {
+	path string
+	// the open buffer of the file, or nil if the file is not open
+	buf      *buffer.Buffer
+	reps     []project.Replacement
+	accepted []bool
+}
+
+// numAccepted returns the number of accepted replacements in the file
+func (f *replaceFile) numAccepted() int {
+	n := 0
+	for _, a := range f.accepted {
+		if a {
+			n++
+		}
+	}
+	return n
+}
+
+// apply makes the accepted replacements. Open buffers are changed with a
+// single undoable event, other files are written directly
+func (f *replaceFile) apply(root string) (int, error) {
+	var reps []project.Replacement
+	for i, r := range f.reps {
+		if f.accepted[i] {
+			reps = append(reps, r)
+		}
+	}
+	if len(reps) == 0 {
+		return 0, nil
+	}
+	if f.buf == nil {
+		return len(reps), project.ReplaceInFile(root, f.path, reps)
+	}
+
+	byLine := make(map[int][]project.Replacement)
+	for _, r := range reps {
+		byLine[r.Line] = append(byLine[r.Line], r)
+	}
+	var deltas []buffer.Delta
+	for y, reps := range byLine {
+		if y >= f.buf.LinesNum() || string(f.buf.LineBytes(y)) != reps[0].Text {
+			return 0, fmt.Errorf("%s was modified since the search", f.path)
+		}
+		text := project.ReplaceLine(reps[0].Text, reps)
+		deltas = append(deltas, buffer.Delta{
+			Text:  []byte(text),
+			Start: buffer.Loc{X: 0, Y: y},
+			End:   buffer.Loc{X: util.CharacterCountInString(reps[0].Text), Y: y},
+		})
+	}
+	// replace from the bottom so that the locations of the other lines
+	// stay valid
+	sort.Slice(deltas, func(i, j int) bool {
+		return deltas[i].Start.Y > deltas[j].Start.Y
+	})
+	f.buf.MultipleReplace(deltas)
+	f.buf.RelocateCursors()
+	return len(reps), nil
+}
+
+// bufferBytes returns the text of a buffer with unix line endings
+func bufferBytes(b *buffer.Buffer) []byte {
+	lines := make([][]byte, b.LinesNum())
+	for y := range lines {
+		lines[y] = b.LineBytes(y)
+	}
+	return bytes.Join(lines, []byte{'\n'})
+}
+
+// A replaceItem is what a line of the preview shows: a file, a match of
+// the file, or nothing if match and file are -1
+type replaceItem struct {
+	file, match int
+}
+
+// A ReplacePane previews the changes of a replaceproject command, grouped
+// by file. Every match shows the line before and after the replacement,
+// and can be accepted or rejected on its own or together with the other
+// matches of its file before the accepted changes are made
+type ReplacePane struct {
+	*BufPane
+
+	target  *BufPane
+	root    string
+	search  string
+	replace string
+	files   []*replaceFile
+	items   []replaceItem
+}
+
+func (p *ReplacePane) bufPane() *BufPane {
+	return p.BufPane
+}
+
+// ReplaceProjectCmd replaces a regular expression in all files of the
+// current directory and its subdirectories, after showing a preview of
+// the changes
+func (h *BufPane) ReplaceProjectCmd(args []string) {
+	noRegex := false
+	var search, replace string
+	n := 0
+	for _, arg := range args {
+		switch {
+		case arg == "-l":
+			noRegex = true
+		case n == 0:
+			search = arg
+			n++
+		case n == 1:
+			replace = arg
+			n++
+		default:
+			InfoBar.Error("Invalid flag: " + arg)
+			return
+		}
+	}
+	if n < 2 || search == "" {
+		InfoBar.Error("Invalid replaceproject statement: " + strings.Join(args, " "))
+		return
+	}
+
+	expr := search
+	if noRegex {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if h.Buf.IgnoreCase(search, buffer.SearchFlags{Regex: !noRegex}) {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		InfoBar.Error(err)
+		return
+	}
+	root, err := os.Getwd()
+	if err != nil {
+		InfoBar.Error(err)
+		return
+	}
+
+	template := []byte(replace)
+	if noRegex {
+		template = []byte(strings.ReplaceAll(replace, "$", "$$"))
+	}
+
+	InfoBar.Message("Searching for ", search, "...")
+	results := make(chan []project.Match)
+	go project.Grep(root, re, nil, results)
+	go func() {
+		var matches []project.Match
+		for m := range results {
+			matches = append(matches, m...)
+		}
+		shell.Jobs <- shell.JobFunction{
+			Function: func(string, []interface{}) {
+				files := collectReplaceFiles(root, matches, re, template)
+				if len(files) == 0 {
+					InfoBar.Message("Nothing matched ", search)
+					return
+				}
+				h.openReplacePane(root, search, replace, files)
+			},
+		}
+	}()
+}
+
+// collectReplaceFiles groups the matches by file. The files that are open
+// are searched again in their buffer, which may have unsaved changes
+func collectReplaceFiles(root string, matches []project.Match, re *regexp.Regexp, template []byte) []*replaceFile {
+	byPath := make(map[string][]project.Match)
+	for _, m := range matches {
+		byPath[m.Path] = append(byPath[m.Path], m)
+	}
+	bufs := make(map[string]*buffer.Buffer)
+	for _, b := range buffer.OpenBuffers {
+		if b.Type != buffer.BTDefault || b.AbsPath == "" {
+			continue
+		}
+		rel, err := filepath.Rel(root, b.AbsPath)
+		if err != nil || strings.HasPrefix(rel, "..") || project.Ignored(root, rel) {
+			continue
+		}
+		data := bufferBytes(b)
+		if project.IsBinary(data) {
+			continue
+		}
+		bufs[rel] = b
+		byPath[rel] = project.GrepBytes(rel, data, re)
+	}
+
+	var files []*replaceFile
+	for path, matches := range byPath {
+		if len(matches) == 0 {
+			continue
+		}
+		f := &replaceFile{path: path, buf: bufs[path]}
+		for _, m := range matches {
+			f.reps = append(f.reps, project.Replacement{Match: m, New: m.Expand(re, template)})
+			f.accepted = append(f.accepted, true)
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].path < files[j].path
+	})
+	return files
+}
+
+// openReplacePane opens the preview in a split below the pane
+func (h *BufPane) openReplacePane(root, search, replace string, files []*replaceFile) {
+	if !activatePane(h) {
+		// the pane was closed during the search
+		if h = MainTab().CurPane(); h == nil {
+			return
+		}
+	}
+
+	b := buffer.NewBufferFromString("", "", BTReplacePreview)
+	b.SetName("replaceproject")
+
+	p := new(ReplacePane)
+	p.BufPane = NewBufPaneFromBuf(b, h.tab)
+	p.target = h
+	p.root = root
+	p.search = search
+	p.replace = replace
+	p.files = files
+	p.splitID = MainTab().GetNode(h.splitID).HSplit(true)
+	MainTab().Panes = append(MainTab().Panes, p)
+	MainTab().Resize()
+	MainTab().SetActive(len(MainTab().Panes) - 1)
+
+	p.Cursor.GotoLoc(buffer.Loc{X: 0, Y: 2})
+	p.update()
+}
+
+// previewLine shortens a line of a file for the preview
+func previewLine(line string) string {
+	line = strings.TrimSpace(line)
+	if util.CharacterCountInString(line) > maxGrepLineLen {
+		line = string([]rune(line)[:maxGrepLineLen]) + "..."
+	}
+	return line
+}
+
+// update redraws the preview after matches were accepted or rejected
+func (p *ReplacePane) update() {
+	total, accepted := 0, 0
+	for _, f := range p.files {
+		total += len(f.reps)
+		accepted += f.numAccepted()
+	}
+
+	check := func(a bool) string {
+		if a {
+			return "[x]"
+		}
+		return "[ ]"
+	}
+
+	p.items = p.items[:0]
+	lines := []string{
+		fmt.Sprintf("Replace %q with %q: %d of %d matches accepted (y/n: match, Y/N: file, a: apply, q: cancel)", p.search, p.replace, accepted, total),
+		"",
+	}
+	p.items = append(p.items, replaceItem{-1, -1}, replaceItem{-1, -1})
+	for i, f := range p.files {
+		n := f.numAccepted()
+		lines = append(lines, fmt.Sprintf("%s %s (%d of %d)", check(n == len(f.reps)), f.path, n, len(f.reps)))
+		p.items = append(p.items, replaceItem{i, -1})
+		for j, r := range f.reps {
+			prefix := fmt.Sprintf("    %s %5d ", check(f.accepted[j]), r.Line+1)
+			after := project.ReplaceLine(r.Text, []project.Replacement{r})
+			lines = append(lines, prefix+"- "+previewLine(r.Text))
+			lines = append(lines, strings.Repeat(" ", len(prefix))+"+ "+previewLine(after))
+			p.items = append(p.items, replaceItem{i, j}, replaceItem{i, j})
+		}
+	}
+
+	loc := p.Cursor.Loc
+	p.Buf.SetText(strings.Join(lines, "\n"))
+	loc.Y = util.Clamp(loc.Y, 0, p.Buf.LinesNum()-1)
+	loc.X = 0
+	p.Cursor.GotoLoc(loc)
+	p.Relocate()
+}
+
+// setAccepted accepts or rejects the match on the cursor line, or all
+// matches of the file if the cursor is on the name of a file or wholeFile
+// is true. The cursor moves on to the next match
+func (p *ReplacePane) setAccepted(accept func(bool) bool, wholeFile bool) {
+	it := p.items[p.Cursor.Y]
+	if it.file < 0 {
+		return
+	}
+	f := p.files[it.file]
+	if it.match < 0 || wholeFile {
+		all := accept(f.numAccepted() == len(f.reps))
+		for i := range f.accepted {
+			f.accepted[i] = all
+		}
+	} else {
+		f.accepted[it.match] = accept(f.accepted[it.match])
+	}
+
+	// move to the next match or file
+	y := p.Cursor.Y + 1
+	for y < len(p.items) && p.items[y] == it {
+		y++
+	}
+	if wholeFile {
+		for y < len(p.items) && p.items[y].file == it.file {
+			y++
+		}
+	}
+	if y < len(p.items) {
+		p.Cursor.GotoLoc(buffer.Loc{X: 0, Y: y})
+	}
+	p.update()
+}
+
+// apply makes the accepted changes and closes the preview
+func (p *ReplacePane) apply() {
+	nreplaced, nfiles := 0, 0
+	var errs []string
+	for _, f := range p.files {
+		n, err := f.apply(p.root)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if n > 0 {
+			nreplaced += n
+			nfiles++
+		}
+	}
+	p.close()
+
+	if len(errs) > 0 {
+		InfoBar.Error(fmt.Sprintf("Replaced %d occurrences in %d files, failed: %s", nreplaced, nfiles, strings.Join(errs, ", ")))
+	} else {
+		InfoBar.Message(fmt.Sprintf("Replaced %d occurrences in %d files", nreplaced, nfiles))
+	}
+}
+
+// close closes the preview and makes the pane that opened it active again
+func (p *ReplacePane) close() {
+	p.ForceQuit()
+	activatePane(p.target)
+}
+
+// HandleEvent accepts and rejects matches with y and n, or all matches of
+// a file with Y and N. Space and enter toggle a match, a makes the
+// accepted changes and escape or q closes the preview without changes.
+// Other events are handled like in a normal BufPane
+func (p *ReplacePane) HandleEvent(event tcell.Event) {
+	if e, ok := event.(*tcell.EventKey); ok && e.Modifiers() == 0 {
+		yes := func(bool) bool { return true }
+		no := func(bool) bool { return false }
+		toggle := func(a bool) bool { return !a }
+		switch {
+		case e.Key() == tcell.KeyEnter, e.Key() == tcell.KeyRune && e.Rune() == ' ':
+			p.setAccepted(toggle, false)
+			return
+		case e.Key() == tcell.KeyEscape, e.Key() == tcell.KeyRune && e.Rune() == 'q':
+			p.close()
+			InfoBar.Message("Canceled replacement")
+			return
+		case e.Key() == tcell.KeyRune:
+			switch e.Rune() {
+			case 'y':
+				p.setAccepted(yes, false)
+				return
+			case 'n':
+				p.setAccepted(no, false)
+				return
+			case 'Y':
+				p.setAccepted(yes, true)
+				return
+			case 'N':
+				p.setAccepted(no, true)
+				return
+			case 'a':
+				p.apply()
+				return
+			}
+		}
+	}
+	p.BufPane.HandleEvent(event)
+}
@@ -117,6 +117,9 @@ func (t *TabList) HandleEvent(event tcell.Event) {
 			if len(t.List) > 1 {
 				ind := t.LocFromVisual(buffer.Loc{mx, my})
 				if ind != -1 {
+					if ind != t.Active() {
+						addSwitchJump()
+					}
 					t.SetActive(ind)
 					return
 				}
@@ -246,6 +249,9 @@ func (t *Tab) HandleEvent(event tcell.Event) {
 					v := p.GetView()
 					inpane := mx >= v.X && mx < v.X+v.Width && my >= v.Y && my < v.Y+v.Height
 					if inpane {
+						if i != t.active {
+							addSwitchJump()
+						}
 						t.SetActive(i)
 						break
 					}
@@ -318,11 +324,20 @@ func (t *Tab) Resize() {
 	}
 }
 
-// CurPane returns the currently active pane
+// A wrapperPane is a pane that wraps a BufPane to show a list, such as
+// the results of grep, and passes the other events to the BufPane
+type wrapperPane interface {
+	bufPane() *BufPane
+}
+
+// CurPane returns the currently active pane, or the BufPane of a pane that
+// wraps one
 func (t *Tab) CurPane() *BufPane {
-	p, ok := t.Panes[t.active].(*BufPane)
-	if !ok {
-		return nil
+	switch p := t.Panes[t.active].(type) {
+	case *BufPane:
+		return p
+	case wrapperPane:
+		return p.bufPane()
 	}
-	return p
+	return nil
 }
@@ -0,0 +1,370 @@
+package action
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zyedidia/micro/v2/internal/buffer"
+	"github.com/zyedidia/micro/v2/internal/util"
+	"github.com/zyedidia/tcell/v2"
+)
+
+// BTUndoTree is the type of the buffer that lists the undo tree
+var BTUndoTree = buffer.BufType{Kind: buffer.BTScratch.Kind, Readonly: true, Scratch: true, Syntax: false}
+
+// maxPreviewLines is the maximum number of lines of the diff preview
+const maxPreviewLines = 100
+
+// EarlierCmd restores the buffer to an earlier state. The argument is a
+// number of changes, or a time such as 30s, 5m, 2h or 1d, or a number of
+// file saves such as 3f
+func (h *BufPane) EarlierCmd(args []string) {
+	h.timeTravel(args, -1)
+}
+
+// LaterCmd goes forward in time again after using earlier. It takes the
+// same arguments as earlier
+func (h *BufPane) LaterCmd(args []string) {
+	h.timeTravel(args, 1)
+}
+
+func (h *BufPane) timeTravel(args []string, dir int) {
+	arg := "1"
+	if len(args) > 0 {
+		arg = args[0]
+	}
+
+	t := h.Buf.Tree
+	n, err := parseTimeTravel(arg, dir, t)
+	if err != nil {
+		InfoBar.Error(err)
+		return
+	}
+	if n == t.Seq {
+		if dir < 0 {
+			InfoBar.Message("Already at oldest change")
+		} else {
+			InfoBar.Message("Already at newest change")
+		}
+		return
+	}
+
+	h.Buf.RestoreState(n)
+	h.Relocate()
+	if n == 0 {
+		InfoBar.Message("Restored the original text")
+	} else {
+		InfoBar.Message("Restored state ", n, " from ", formatUndoTime(t.Time(n)))
+	}
+}
+
+// parseTimeTravel returns the state of the undo tree that an argument of
+// earlier (dir < 0) or later (dir > 0) refers to
+func parseTimeTravel(arg string, dir int, t *buffer.UndoTree) (int, error) {
+	unit := ""
+	if i := strings.IndexFunc(arg, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		arg, unit = arg[:i], arg[i:]
+	}
+	count, err := strconv.Atoi(arg)
+	if err != nil || count <= 0 {
+		return 0, errors.New("Invalid count: " + arg + unit)
+	}
+	count *= dir
+
+	switch unit {
+	case "":
+		return t.StateSteps(count), nil
+	case "f":
+		return t.SaveSteps(count), nil
+	case "s":
+		return t.StateAt(time.Duration(count) * time.Second), nil
+	case "m":
+		return t.StateAt(time.Duration(count) * time.Minute), nil
+	case "h":
+		return t.StateAt(time.Duration(count) * time.Hour), nil
+	case "d":
+		return t.StateAt(time.Duration(count) * 24 * time.Hour), nil
+	}
+	return 0, errors.New("Invalid unit: " + unit + " (use s, m, h, d or f)")
+}
+
+// An UndoTreePane is a side pane that shows the undo tree of another
+// pane's buffer. Every line is one state of the buffer, pressing enter
+// changes the buffer to the state on the cursor line
+type UndoTreePane struct {
+	*BufPane
+
+	target *BufPane
+
+	// the undo tree node shown on every line of the list
+	nodes []int
+	// the first node of the group of nodes shown on every line
+	first []int
+
+	// the lines of the list, which are only rebuilt when the undo tree
+	// changes, and the changes made by every node of the tree
+	lines  []string
+	counts []undoCount
+
+	// the state of the target and the cursor when the list was last drawn
+	tree     *buffer.UndoTree
+	numNodes int
+	cur      int
+	line     int
+}
+
+// undoCount is the number of characters inserted and removed and the
+// number of replacements in some events
+type undoCount struct {
+	ins, rem, rep int
+}
+
+func (p *UndoTreePane) bufPane() *BufPane {
+	return p.BufPane
+}
+
+// NewUndoTreePane creates a pane showing the undo tree of the given pane
+func NewUndoTreePane(target *BufPane, tab *Tab) *UndoTreePane {
+	b := buffer.NewBufferFromString("", "", BTUndoTree)
+	b.SetName("undotree")
+
+	p := new(UndoTreePane)
+	p.BufPane = NewBufPaneFromBuf(b, tab)
+	p.target = target
+	p.line = -1
+	p.update()
+	return p
+}
+
+// UndoTreeCmd opens the undo tree of the current buffer in a vertical
+// split on the left
+func (h *BufPane) UndoTreeCmd(args []string) {
+	p := NewUndoTreePane(h, h.tab)
+	p.splitID = MainTab().GetNode(h.splitID).VSplit(false)
+	MainTab().Panes = append(MainTab().Panes, p)
+	MainTab().Resize()
+	MainTab().SetActive(len(MainTab().Panes) - 1)
+
+	// start on the line of the current state
+	for i, n := range p.nodes {
+		if n == h.Buf.Tree.Cur {
+			p.Cursor.GotoLoc(buffer.Loc{X: 0, Y: i})
+			break
+		}
+	}
+	p.update()
+}
+
+// HandleEvent jumps to the selected state when enter is pressed, and
+// closes the pane with escape or q. Other events are handled like in
+// a normal BufPane
+func (p *UndoTreePane) HandleEvent(event tcell.Event) {
+	if e, ok := event.(*tcell.EventKey); ok && e.Modifiers() == 0 {
+		switch {
+		case e.Key() == tcell.KeyEnter:
+			if p.Cursor.Y < len(p.nodes) {
+				p.target.Buf.UndoTreeGoto(p.nodes[p.Cursor.Y])
+				p.target.Relocate()
+				p.update()
+			}
+			return
+		case e.Key() == tcell.KeyEscape, e.Key() == tcell.KeyRune && e.Rune() == 'q':
+			p.ForceQuit()
+			return
+		}
+	}
+	p.BufPane.HandleEvent(event)
+	p.update()
+}
+
+// Display redraws the list if the undo tree or the selected line changed
+func (p *UndoTreePane) Display() {
+	p.update()
+	p.BufPane.Display()
+}
+
+func (p *UndoTreePane) update() {
+	t := p.target.Buf.Tree
+	changed := t != p.tree || len(t.Nodes) != p.numNodes || t.Cur != p.cur
+	if !changed && p.Cursor.Y == p.line {
+		return
+	}
+
+	if changed {
+		if t != p.tree || len(t.Nodes) < len(p.counts) {
+			p.counts = nil
+		}
+		for n := len(p.counts); n < len(t.Nodes); n++ {
+			var c undoCount
+			if n > 0 {
+				undoEvents(t, n, n, func(e *buffer.TextEvent, kind int) {
+					c.add(e, kind)
+				})
+			}
+			p.counts = append(p.counts, c)
+		}
+
+		p.nodes = p.nodes[:0]
+		p.first = p.first[:0]
+		p.lines = p.lines[:0]
+		p.list(t, 0, 0)
+	}
+
+	loc := p.Cursor.Loc
+	if loc.Y >= len(p.lines) {
+		loc = buffer.Loc{X: 0, Y: len(p.lines) - 1}
+	}
+
+	preview := strings.Join(p.preview(t, p.first[loc.Y], p.nodes[loc.Y]), "\n")
+	if changed {
+		p.Buf.SetText(strings.Join(p.lines, "\n") + "\n\n" + preview)
+	} else {
+		// only the preview below the list changes
+		p.Buf.ReplaceEnd(buffer.Loc{X: 0, Y: len(p.lines) + 1}, preview)
+	}
+	p.Cursor.GotoLoc(loc)
+	p.Relocate()
+
+	p.tree = t
+	p.numNodes = len(t.Nodes)
+	p.cur = t.Cur
+	p.line = p.Cursor.Y
+}
+
+// list adds the lines for the group of nodes starting at n and all of
+// its descendants. Older branches are indented below the state they
+// branch off from, and the newest branch continues at the same level
+func (p *UndoTreePane) list(t *buffer.UndoTree, n, indent int) {
+	last := n
+	for t.Merged(last) {
+		last = t.Nodes[last].Children[0]
+	}
+
+	mark := " "
+	if last == t.Cur {
+		mark = "*"
+	}
+	desc := "original"
+	if n != 0 {
+		desc = formatUndoTime(t.Time(last)) + "  " + p.summary(t, n, last)
+	}
+	p.lines = append(p.lines, fmt.Sprintf("%s%s %3d  %s", strings.Repeat("| ", indent), mark, last, desc))
+	p.nodes = append(p.nodes, last)
+	p.first = append(p.first, n)
+
+	children := t.Nodes[last].Children
+	for i, c := range children {
+		if i < len(children)-1 {
+			p.list(t, c, indent+1)
+		} else {
+			p.list(t, c, indent)
+		}
+	}
+}
+
+func formatUndoTime(t time.Time) string {
+	now := time.Now()
+	if t.Year() == now.Year() && t.YearDay() == now.YearDay() {
+		return t.Format("15:04:05")
+	}
+	return t.Format("Jan _2 15:04")
+}
+
+// undoEvents calls fn with every event of the nodes from first to last
+// together with the type the event had when it was made. first must be
+// last or one of its ancestors, and not the root
+func undoEvents(t *buffer.UndoTree, first, last int, fn func(e *buffer.TextEvent, kind int)) {
+	var nodes []int
+	for n := last; n != first; n = t.Nodes[n].Parent {
+		nodes = append(nodes, n)
+	}
+	nodes = append(nodes, first)
+
+	// events that are not applied are stored undone. Only the last node
+	// of a group can be the current state, so the events of the group
+	// are either all applied or all undone
+	undone := !t.OnPath(last)
+	for i := len(nodes) - 1; i >= 0; i-- {
+		e := t.Nodes[nodes[i]].Event
+		kind := e.EventType
+		if undone {
+			kind = -kind
+		}
+		fn(e, kind)
+	}
+}
+
+// add counts the deltas of the event e, which had the given type when it
+// was made
+func (c *undoCount) add(e *buffer.TextEvent, kind int) {
+	for _, d := range e.Deltas {
+		switch kind {
+		case buffer.TextEventInsert:
+			c.ins += util.CharacterCount(d.Text)
+		case buffer.TextEventRemove:
+			c.rem += util.CharacterCount(d.Text)
+		default:
+			c.rep++
+		}
+	}
+}
+
+// summary returns the number of characters that were inserted and
+// removed to get from the parent of first to last
+func (p *UndoTreePane) summary(t *buffer.UndoTree, first, last int) string {
+	var c undoCount
+	for n := last; ; n = t.Nodes[n].Parent {
+		c.ins += p.counts[n].ins
+		c.rem += p.counts[n].rem
+		c.rep += p.counts[n].rep
+		if n == first {
+			break
+		}
+	}
+	s := fmt.Sprintf("+%d -%d", c.ins, c.rem)
+	if c.rep > 0 {
+		s += fmt.Sprintf(" ~%d", c.rep)
+	}
+	return s
+}
+
+// preview returns the changes made in the group of nodes from first to
+// last, in a format similar to a diff
+func (p *UndoTreePane) preview(t *buffer.UndoTree, first, last int) []string {
+	if first == 0 {
+		return []string{"Original state of the buffer"}
+	}
+
+	lines := []string{fmt.Sprintf("State %d, %s", last, t.Time(last).Format("2006-01-02 15:04:05"))}
+	addText := func(prefix string, text []byte) {
+		for _, l := range strings.Split(string(text), "\n") {
+			lines = append(lines, prefix+l)
+		}
+	}
+	undoEvents(t, first, last, func(e *buffer.TextEvent, kind int) {
+		for _, d := range e.Deltas {
+			lines = append(lines, fmt.Sprintf("@@ %d,%d @@", d.Start.Y+1, d.Start.X+1))
+			switch kind {
+			case buffer.TextEventInsert:
+				addText("+", d.Text)
+			case buffer.TextEventRemove:
+				addText("-", d.Text)
+			default:
+				// a replace event only stores the text that is not
+				// currently in the buffer
+				if t.OnPath(last) {
+					addText("-", d.Text)
+				} else {
+					addText("+", d.Text)
+				}
+			}
+		}
+	})
+	if len(lines) > maxPreviewLines {
+		lines = append(lines[:maxPreviewLines], "...")
+	}
+	return lines
+}
@@ -80,7 +80,7 @@ func (b *Buffer) Backup() error {
 	name := filepath.Join(backupdir, util.EscapePath(b.AbsPath))
 
 	err = overwriteFile(name, encoding.Nop, func(file io.Writer) (e error) {
-		if len(b.lines) == 0 {
+		if b.LinesNum() == 0 {
 			return
 		}
 
@@ -88,18 +88,15 @@ func (b *Buffer) Backup() error {
 		eol := []byte{'\n'}
 
 		// write lines
-		if _, e = file.Write(b.lines[0].data); e != nil {
-			return
-		}
-
-		for _, l := range b.lines[1:] {
-			if _, e = file.Write(eol); e != nil {
-				return
-			}
-			if _, e = file.Write(l.data); e != nil {
-				return
+		b.forEach(0, func(i int, l *Line) bool {
+			if i > 0 {
+				if _, e = file.Write(eol); e != nil {
+					return false
+				}
 			}
-		}
+			_, e = file.Write(l.data)
+			return e == nil
+		})
 		return
 	}, false)
 
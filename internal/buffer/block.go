@@ -0,0 +1,236 @@
+package buffer
+
+import (
+	"strings"
+
+	"github.com/zyedidia/micro/v2/internal/clipboard"
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+// A block selection is a rectangle of text. It covers the lines between
+// the two ends of CurSelection and the visual columns stored in BlockCols,
+// so that it keeps its shape over tabs, wide characters and lines that
+// are too short to reach the columns. The anchor of the block is stored
+// in OrigSelection[0] and BlockCols[0], and the other corner follows the
+// cursor.
+
+// BlockBounds returns the lines and the visual columns covered by a block
+// selection. The block covers the columns from left up to but not
+// including right
+func (c *Cursor) BlockBounds() (top, bottom, left, right int) {
+	top, bottom = c.CurSelection[0].Y, c.CurSelection[1].Y
+	if top > bottom {
+		top, bottom = bottom, top
+	}
+	left, right = c.BlockCols[0], c.BlockCols[1]
+	if left > right {
+		left, right = right, left
+	}
+	return
+}
+
+func (c *Cursor) tabsize() int {
+	return util.IntOpt(c.buf.Settings["tabsize"])
+}
+
+// lineWidth returns the visual width of line y
+func (c *Cursor) lineWidth(y int) int {
+	line := c.buf.LineBytes(y)
+	return util.StringWidth(line, util.CharacterCount(line), c.tabsize())
+}
+
+// blockRange returns the part of line y that is inside the visual columns
+// from left to right. Characters that are only partly inside, such as
+// tabs and wide characters, are included
+func (c *Cursor) blockRange(y, left, right int) (Loc, Loc) {
+	line := c.buf.LineBytes(y)
+	tabsize := c.tabsize()
+	start := util.GetCharPosInLine(line, left, tabsize)
+	end := start
+	if right > left {
+		end = util.GetCharPosInLine(line, right, tabsize)
+		if end < util.CharacterCount(line) && util.StringWidth(line, end, tabsize) < right {
+			end++
+		}
+	}
+	return Loc{start, y}, Loc{end, y}
+}
+
+// setBlock selects the block with its anchor at visual column acol on
+// line ay and the cursor at visual column ccol on line cy
+func (c *Cursor) setBlock(ay, acol, cy, ccol int) {
+	c.Block = true
+	c.BlockCols = [2]int{acol, ccol}
+	c.OrigSelection[0], _ = c.blockRange(ay, acol, acol)
+	c.Loc, _ = c.blockRange(cy, ccol, ccol)
+	c.LastVisualX = ccol
+	c.CurSelection[0] = c.OrigSelection[0]
+	c.CurSelection[1] = c.Loc
+}
+
+// StartBlock starts a block selection at the cursor if the cursor does
+// not already have one
+func (c *Cursor) StartBlock() {
+	if c.Block {
+		return
+	}
+	x := c.GetVisualX()
+	c.setBlock(c.Y, x, c.Y, x)
+}
+
+// UpdateBlock moves the corner of the block selection that follows the
+// cursor to the cursor's line and to the column given by LastVisualX,
+// which may be past the end of the line
+func (c *Cursor) UpdateBlock() {
+	c.setBlock(c.OrigSelection[0].Y, c.BlockCols[0], c.Y, c.LastVisualX)
+}
+
+// BlockLeft moves the cursor corner of the block selection one column to
+// the left
+func (c *Cursor) BlockLeft() {
+	if c.LastVisualX > c.GetVisualX() {
+		// the cursor is past the end of the line
+		c.LastVisualX--
+	} else if c.X > 0 {
+		c.X--
+		c.StoreVisualX()
+	}
+	c.UpdateBlock()
+}
+
+// BlockRight moves the cursor corner of the block selection one column to
+// the right. The block may extend past the end of the line
+func (c *Cursor) BlockRight() {
+	if c.X < util.CharacterCount(c.buf.LineBytes(c.Y)) {
+		c.X++
+		c.StoreVisualX()
+	} else {
+		c.LastVisualX++
+	}
+	c.UpdateBlock()
+}
+
+// InSelection returns whether the character at loc is selected
+func (c *Cursor) InSelection(loc Loc) bool {
+	if c.Block {
+		top, bottom, left, right := c.BlockBounds()
+		if loc.Y < top || loc.Y > bottom {
+			return false
+		}
+		start, end := c.blockRange(loc.Y, left, right)
+		return loc.X >= start.X && loc.X < end.X
+	}
+	return loc.GreaterEqual(c.CurSelection[0]) && loc.LessThan(c.CurSelection[1]) ||
+		loc.LessThan(c.CurSelection[0]) && loc.GreaterEqual(c.CurSelection[1])
+}
+
+// BlockSelection returns the selected text of every line of a block
+// selection
+func (c *Cursor) BlockSelection() []string {
+	top, bottom, left, right := c.BlockBounds()
+	rows := make([]string, 0, bottom-top+1)
+	for y := top; y <= bottom && y < c.buf.LinesNum(); y++ {
+		start, end := c.blockRange(y, left, right)
+		rows = append(rows, string(c.buf.Substr(start, end)))
+	}
+	return rows
+}
+
+// deleteBlock removes the text of a block selection and places the
+// cursor at the top left corner of the block
+func (c *Cursor) deleteBlock() {
+	top, bottom, left, right := c.BlockBounds()
+	for y := bottom; y >= top; y-- {
+		start, end := c.blockRange(y, left, right)
+		if start != end {
+			c.buf.Remove(start, end)
+		}
+	}
+	c.Loc, _ = c.blockRange(top, left, left)
+}
+
+// BlockInsert replaces the text of the block selection with the given
+// text on every line. The cursor is then left with an empty block after
+// the inserted text, so that typing continues on all the lines. Lines
+// that are too short to reach the block are not changed. The text must
+// not contain newlines
+func (c *Cursor) BlockInsert(text string) {
+	ay, cy := c.CurSelection[0].Y, c.CurSelection[1].Y
+	top, bottom, left, right := c.BlockBounds()
+	for y := bottom; y >= top; y-- {
+		if c.lineWidth(y) < left {
+			continue
+		}
+		start, end := c.blockRange(y, left, right)
+		if start != end {
+			c.buf.Remove(start, end)
+		}
+		c.buf.Insert(start, text)
+	}
+	col := left + util.StringWidth([]byte(text), util.CharacterCountInString(text), c.tabsize())
+	c.setBlock(ay, col, cy, col)
+}
+
+// BlockDeleteChar deletes the character before the columns of an empty
+// block selection (or after them if forward is true) on every line
+func (c *Cursor) BlockDeleteChar(forward bool) {
+	ay, cy := c.CurSelection[0].Y, c.CurSelection[1].Y
+	top, bottom, left, _ := c.BlockBounds()
+	col := left
+	for y := bottom; y >= top; y-- {
+		if c.lineWidth(y) < left {
+			continue
+		}
+		start, _ := c.blockRange(y, left, left)
+		if forward {
+			if start.X < util.CharacterCount(c.buf.LineBytes(y)) {
+				c.buf.Remove(start, start.Move(1, c.buf))
+			}
+		} else if start.X > 0 {
+			prev := Loc{start.X - 1, y}
+			if y == cy {
+				col = util.StringWidth(c.buf.LineBytes(y), prev.X, c.tabsize())
+			}
+			c.buf.Remove(prev, start)
+		}
+	}
+	c.setBlock(ay, col, cy, col)
+}
+
+// PasteBlock inserts rows of text as a block with its top left corner at
+// the cursor. Lines that are too short are padded with spaces and lines
+// are added at the end of the buffer if needed. If the cursor has a block
+// selection, the block is replaced
+func (c *Cursor) PasteBlock(rows []string) {
+	col := c.GetVisualX()
+	if c.Block {
+		_, _, col, _ = c.BlockBounds()
+		c.deleteBlock()
+	}
+	c.ResetSelection()
+
+	y0 := c.Y
+	for i, row := range rows {
+		y := y0 + i
+		if y >= c.buf.LinesNum() {
+			c.buf.Insert(c.buf.End(), "\n")
+		}
+		if w := c.lineWidth(y); w < col {
+			if row == "" {
+				continue
+			}
+			end := Loc{util.CharacterCount(c.buf.LineBytes(y)), y}
+			c.buf.Insert(end, strings.Repeat(" ", col-w))
+		}
+		start, _ := c.blockRange(y, col, col)
+		c.buf.Insert(start, row)
+	}
+	c.Loc, _ = c.blockRange(y0, col, col)
+	c.StoreVisualX()
+}
+
+// copyBlock copies the rows of a block selection to the clipboard so that
+// pasting them again inserts a block
+func (c *Cursor) copyBlock(target clipboard.Register) {
+	clipboard.WriteBlock(c.BlockSelection(), target, c.Num, c.buf.NumCursors())
+}
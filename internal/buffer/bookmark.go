@@ -0,0 +1,171 @@
+package buffer
+
+import (
+	"sort"
+)
+
+// bookmarkOwner is the owner of the gutter messages that show bookmarks
+const bookmarkOwner = "bookmark"
+
+// A Bookmark is a location in the buffer that the user can come back to.
+// Bookmarks move with edits like the cursors. A bookmark with a name can
+// be jumped to by its name, other bookmarks have an empty name
+type Bookmark struct {
+	Name string
+	Loc  Loc
+}
+
+// Sign returns the character shown in the gutter for the bookmark
+func (m *Bookmark) Sign() rune {
+	if m.Name == "" {
+		return '*'
+	}
+	return []rune(m.Name)[0]
+}
+
+// moveBookmarks moves every bookmark with the given function after an
+// edit and updates their gutter messages
+func (b *SharedBuffer) moveBookmarks(move func(Loc) Loc) {
+	if len(b.Bookmarks) == 0 {
+		return
+	}
+	for _, m := range b.Bookmarks {
+		m.Loc = clamp(move(m.Loc), b.LineArray)
+	}
+	b.updateBookmarkMessages()
+}
+
+// updateBookmarkMessages replaces the gutter messages of the bookmarks
+func (b *SharedBuffer) updateBookmarkMessages() {
+	for i := len(b.Messages) - 1; i >= 0; i-- {
+		if b.Messages[i].Owner == bookmarkOwner {
+			copy(b.Messages[i:], b.Messages[i+1:])
+			b.Messages[len(b.Messages)-1] = nil
+			b.Messages = b.Messages[:len(b.Messages)-1]
+		}
+	}
+	for _, m := range b.Bookmarks {
+		msg := "Bookmark"
+		if m.Name != "" {
+			msg += " " + m.Name
+		}
+		b.Messages = append(b.Messages, &Message{
+			Msg:   msg,
+			Start: m.Loc,
+			End:   m.Loc,
+			Kind:  MTInfo,
+			Owner: bookmarkOwner,
+			Sign:  m.Sign(),
+		})
+	}
+}
+
+// SortedBookmarks returns the bookmarks in the order of their locations
+func (b *Buffer) SortedBookmarks() []*Bookmark {
+	marks := make([]*Bookmark, len(b.Bookmarks))
+	copy(marks, b.Bookmarks)
+	sort.SliceStable(marks, func(i, j int) bool {
+		return marks[i].Loc.LessThan(marks[j].Loc)
+	})
+	return marks
+}
+
+// ToggleBookmark removes the bookmarks on the line of loc, or adds a
+// bookmark without a name at loc if there are none. It returns whether a
+// bookmark was added
+func (b *Buffer) ToggleBookmark(loc Loc) bool {
+	removed := false
+	for i := len(b.Bookmarks) - 1; i >= 0; i-- {
+		if b.Bookmarks[i].Loc.Y == loc.Y {
+			b.removeBookmark(i)
+			removed = true
+		}
+	}
+	if !removed {
+		b.Bookmarks = append(b.Bookmarks, &Bookmark{Loc: clamp(loc, b.LineArray)})
+	}
+	b.updateBookmarkMessages()
+	return !removed
+}
+
+// SetBookmark places the bookmark with the given name at loc, moving it if
+// it already exists
+func (b *Buffer) SetBookmark(name string, loc Loc) {
+	loc = clamp(loc, b.LineArray)
+	if m := b.GetBookmark(name); m != nil {
+		m.Loc = loc
+	} else {
+		b.Bookmarks = append(b.Bookmarks, &Bookmark{Name: name, Loc: loc})
+	}
+	b.updateBookmarkMessages()
+}
+
+// GetBookmark returns the bookmark with the given name, or nil if there
+// is no such bookmark
+func (b *Buffer) GetBookmark(name string) *Bookmark {
+	for _, m := range b.Bookmarks {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+func (b *Buffer) removeBookmark(i int) {
+	copy(b.Bookmarks[i:], b.Bookmarks[i+1:])
+	b.Bookmarks[len(b.Bookmarks)-1] = nil
+	b.Bookmarks = b.Bookmarks[:len(b.Bookmarks)-1]
+}
+
+// ClearBookmarks removes all bookmarks
+func (b *Buffer) ClearBookmarks() {
+	b.Bookmarks = nil
+	b.updateBookmarkMessages()
+}
+
+// NextBookmark returns the first bookmark after the line of loc (dir > 0)
+// or before it (dir < 0), wrapping around the buffer. It returns nil if
+// there are no bookmarks
+func (b *Buffer) NextBookmark(loc Loc, dir int) *Bookmark {
+	marks := b.SortedBookmarks()
+	if len(marks) == 0 {
+		return nil
+	}
+	if dir > 0 {
+		for _, m := range marks {
+			if m.Loc.Y > loc.Y {
+				return m
+			}
+		}
+		return marks[0]
+	}
+	for i := len(marks) - 1; i >= 0; i-- {
+		if marks[i].Loc.Y < loc.Y {
+			return marks[i]
+		}
+	}
+	return marks[len(marks)-1]
+}
+
+// setSerializedBookmarks restores the bookmarks saved by Serialize
+func (b *Buffer) setSerializedBookmarks(marks []Bookmark) {
+	if len(b.Bookmarks) > 0 {
+		// the bookmarks are already loaded by another buffer of the file
+		return
+	}
+	for i := range marks {
+		m := marks[i]
+		m.Loc = clamp(m.Loc, b.LineArray)
+		b.Bookmarks = append(b.Bookmarks, &m)
+	}
+	b.updateBookmarkMessages()
+}
+
+// serializedBookmarks returns the bookmarks to save with the buffer
+func (b *Buffer) serializedBookmarks() []Bookmark {
+	marks := make([]Bookmark, len(b.Bookmarks))
+	for i, m := range b.Bookmarks {
+		marks[i] = *m
+	}
+	return marks
+}
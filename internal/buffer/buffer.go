@@ -97,6 +97,12 @@ type SharedBuffer struct {
 
 	Messages []*Message
 
+	// Bookmarks are the locations marked by the user
+	Bookmarks []*Bookmark
+
+	// the folds of the windows that show the buffer
+	folds []*Folds
+
 	updateDiffTimer   *time.Timer
 	diffBase          []byte
 	diffBaseLineCount int
@@ -114,8 +120,14 @@ type SharedBuffer struct {
 	// it changes based on how the buffer has changed
 	HasSuggestions bool
 
-	// The Highlighter struct actually performs the highlighting
+	// The Highlighter struct actually performs the highlighting. It is used
+	// by a goroutine that updates the highlighting in the background
 	Highlighter *highlight.Highlighter
+	// the lines waiting to be highlighted
+	highlighting highlighting
+	// textLock is held for reading while the lines are highlighted in the
+	// background, and for writing while the main goroutine changes them
+	textLock sync.RWMutex
 	// SyntaxDef represents the syntax highlighting definition being used
 	// This stores the highlighting rules and filetype detection info
 	SyntaxDef *highlight.Def
@@ -124,37 +136,58 @@ type SharedBuffer struct {
 
 	// Hash of the original buffer -- empty if fastdirty is on
 	origHash [md5.Size]byte
+
+	// LargeFile is set if the file was larger than the largefile threshold
+	// when it was opened
+	LargeFile bool
+
+	// OnChange is called before every change of the text with the range
+	// that is replaced and the new text. The language server client uses it
+	// to send the changes to the server
+	OnChange func(start, end Loc, text []byte)
+	// OnClose is called when the last buffer that shows the text is closed
+	OnClose func()
 }
 
 func (b *SharedBuffer) insert(pos Loc, value []byte) {
+	if b.OnChange != nil {
+		b.OnChange(pos, pos, value)
+	}
 	b.isModified = true
 	b.HasSuggestions = false
+	inslines := bytes.Count(value, []byte{'\n'})
+
+	b.textLock.Lock()
 	b.LineArray.insert(pos, value)
+	b.shiftHighlight(pos.Y, inslines)
+	b.textLock.Unlock()
 
-	inslines := bytes.Count(value, []byte{'\n'})
 	b.MarkModified(pos.Y, pos.Y+inslines)
 }
 func (b *SharedBuffer) remove(start, end Loc) []byte {
+	if b.OnChange != nil {
+		b.OnChange(start, end, nil)
+	}
 	b.isModified = true
 	b.HasSuggestions = false
 	defer b.MarkModified(start.Y, end.Y)
+
+	b.textLock.Lock()
+	defer b.textLock.Unlock()
+	defer b.shiftHighlight(start.Y, start.Y-end.Y)
 	return b.LineArray.remove(start, end)
 }
 
 // MarkModified marks the buffer as modified for this frame
-// and performs rehighlighting if syntax highlighting is enabled
+// and updates the syntax highlighting in the background if it is enabled
 func (b *SharedBuffer) MarkModified(start, end int) {
 	b.ModifiedThisFrame = true
 
-	start = util.Clamp(start, 0, len(b.lines)-1)
-	end = util.Clamp(end, 0, len(b.lines)-1)
+	start = util.Clamp(start, 0, b.LinesNum()-1)
+	end = util.Clamp(end, 0, b.LinesNum()-1)
 
 	if b.Settings["syntax"].(bool) && b.SyntaxDef != nil {
-		l := -1
-		for i := start; i <= end; i++ {
-			l = util.Max(b.Highlighter.ReHighlightStates(b, i), l)
-		}
-		b.Highlighter.HighlightMatches(b, start, l)
+		b.rehighlight(start, end)
 	}
 
 	for i := start; i <= end; i++ {
@@ -162,6 +195,24 @@ func (b *SharedBuffer) MarkModified(start, end int) {
 	}
 }
 
+// largeFileSettings are the option values forced for buffers over the
+// largefile threshold. They can all be changed back with setlocal
+var largeFileSettings = map[string]interface{}{
+	"backup":     false,
+	"diffgutter": false,
+	"fastdirty":  true,
+	"saveundo":   false,
+	"syntax":     false,
+}
+
+// disableLargeFileFeatures turns off the features that are too expensive
+// for very large files
+func (b *SharedBuffer) disableLargeFileFeatures() {
+	for k, v := range largeFileSettings {
+		b.Settings[k] = v
+	}
+}
+
 // DisableReload disables future reloads of this sharedbuffer
 func (b *SharedBuffer) DisableReload() {
 	b.ReloadDisabled = true
@@ -209,8 +260,19 @@ type Buffer struct {
 	// Last search stores the last successful search
 	LastSearch      string
 	LastSearchRegex bool
+	// LastSearchMatchCase and LastSearchWholeWord are the other options of
+	// the last search (see SearchFlags)
+	LastSearchMatchCase bool
+	LastSearchWholeWord bool
+	// LastSearchSelection limits the last search to the search range of
+	// each cursor (see Cursor.SearchRange)
+	LastSearchSelection bool
 	// HighlightSearch enables highlighting all instances of the last successful search
 	HighlightSearch bool
+
+	// ReplacePreview is shown by the display while a replace command is
+	// typed, or nil
+	ReplacePreview *ReplacePreview
 }
 
 // NewBufferFromFileAtLoc opens a new buffer with a given cursor location
@@ -315,6 +377,8 @@ func NewBuffer(r io.Reader, size int64, path string, startcursor Loc, btype BufT
 	}
 
 	hasBackup := false
+	// the encoding set by a modeline, which the file is decoded with
+	modelineEnc := ""
 	if !found {
 		b.SharedBuffer = new(SharedBuffer)
 		b.Type = btype
@@ -342,6 +406,13 @@ func NewBuffer(r io.Reader, size int64, path string, startcursor Loc, btype BufT
 		b.Settings["filetype"] = settings["filetype"]
 		b.Settings["syntax"] = settings["syntax"]
 
+		// size is the number of bytes on disk, the threshold is in megabytes
+		threshold := settings["largefile"].(float64)
+		if threshold > 0 && float64(size) > threshold*1024*1024 {
+			b.LargeFile = true
+			b.disableLargeFileFeatures()
+		}
+
 		enc, err := htmlindex.Get(settings["encoding"].(string))
 		if err != nil {
 			enc = unicode.UTF8
@@ -355,6 +426,21 @@ func NewBuffer(r io.Reader, size int64, path string, startcursor Loc, btype BufT
 			return NewBufferFromString("", "", btype)
 		}
 		if !hasBackup {
+			defaultEnc := config.DefaultCommonSettings()["encoding"]
+			if btype.Kind == BTDefault.Kind && settings["modeline"].(bool) && !b.LargeFile && settings["encoding"] == defaultEnc {
+				// a modeline may set the encoding of the file, unless it
+				// was set by the user, so the modelines are read before
+				// the file is decoded
+				var err error
+				modelineEnc, r, err = readModelineEncoding(r, size)
+				if err != nil {
+					screen.TermMessage(err)
+				}
+				if modelineEnc != "" {
+					enc, _ = htmlindex.Get(modelineEnc)
+				}
+			}
+
 			reader := bufio.NewReader(transform.NewReader(r, enc.NewDecoder()))
 
 			var ff FileFormat = FFAuto
@@ -370,7 +456,16 @@ func NewBuffer(r io.Reader, size int64, path string, startcursor Loc, btype BufT
 				}
 			}
 
-			b.LineArray = NewLineArray(uint64(size), ff, reader)
+			if f, ok := r.(*os.File); ok && enc == unicode.UTF8 && b.LargeFile {
+				// the lines of large utf-8 files are found when the file
+				// is opened, but their text is only read when it is needed
+				if la, err := newLazyLineArray(f, size, ff); err == nil {
+					b.LineArray = la
+				}
+			}
+			if b.LineArray == nil {
+				b.LineArray = NewLineArray(uint64(size), ff, reader)
+			}
 		}
 		b.EventHandler = NewEventHandler(b.SharedBuffer, b.cursors)
 
@@ -392,6 +487,15 @@ func NewBuffer(r io.Reader, size int64, path string, startcursor Loc, btype BufT
 	b.UpdateRules()
 	// init local settings again now that we know the filetype
 	config.InitLocalSettings(b.Settings, b.Path)
+	if !found && b.Type.Kind == BTDefault.Kind {
+		b.applyModelines()
+	}
+	if modelineEnc != "" {
+		b.Settings["encoding"] = modelineEnc
+	}
+	if b.LargeFile && !found {
+		b.disableLargeFileFeatures()
+	}
 
 	if _, err := os.Stat(filepath.Join(config.ConfigDir, "buffers")); os.IsNotExist(err) {
 		os.Mkdir(filepath.Join(config.ConfigDir, "buffers"), os.ModePerm)
@@ -447,6 +551,15 @@ func (b *Buffer) Close() {
 			copy(OpenBuffers[i:], OpenBuffers[i+1:])
 			OpenBuffers[len(OpenBuffers)-1] = nil
 			OpenBuffers = OpenBuffers[:len(OpenBuffers)-1]
+
+			if b.OnClose != nil {
+				for _, other := range OpenBuffers {
+					if other.SharedBuffer == b.SharedBuffer {
+						return
+					}
+				}
+				b.OnClose()
+			}
 			return
 		}
 	}
@@ -510,6 +623,54 @@ func (b *Buffer) Remove(start, end Loc) {
 	}
 }
 
+// SetText replaces the whole text of the buffer without recording the
+// change in the undo history. This is meant for buffers that are
+// generated by micro, and also works for readonly buffers
+func (b *Buffer) SetText(text string) {
+	b.EventHandler.cursors = b.cursors
+	b.EventHandler.active = b.curCursor
+	b.DoTextEvent(&TextEvent{
+		EventType: TextEventRemove,
+		Deltas:    []Delta{{[]byte{}, b.Start(), b.End()}},
+		Time:      time.Now(),
+	}, false)
+	if text != "" {
+		b.DoTextEvent(&TextEvent{
+			EventType: TextEventInsert,
+			Deltas:    []Delta{{[]byte(text), b.Start(), Loc{0, 0}}},
+			Time:      time.Now(),
+		}, false)
+	}
+}
+
+// AppendText inserts text at the end of the buffer without recording the
+// change in the undo history, like SetText
+func (b *Buffer) AppendText(text string) {
+	if text == "" {
+		return
+	}
+	b.EventHandler.cursors = b.cursors
+	b.EventHandler.active = b.curCursor
+	b.DoTextEvent(&TextEvent{
+		EventType: TextEventInsert,
+		Deltas:    []Delta{{[]byte(text), b.End(), Loc{0, 0}}},
+		Time:      time.Now(),
+	}, false)
+}
+
+// ReplaceEnd replaces the text from start to the end of the buffer without
+// recording the change in the undo history, like SetText
+func (b *Buffer) ReplaceEnd(start Loc, text string) {
+	b.EventHandler.cursors = b.cursors
+	b.EventHandler.active = b.curCursor
+	b.DoTextEvent(&TextEvent{
+		EventType: TextEventRemove,
+		Deltas:    []Delta{{[]byte{}, start, b.End()}},
+		Time:      time.Now(),
+	}, false)
+	b.AppendText(text)
+}
+
 // FileType returns the buffer's filetype
 func (b *Buffer) FileType() string {
 	return b.Settings["filetype"].(string)
@@ -646,28 +807,30 @@ func calcHash(b *Buffer, out *[md5.Size]byte) error {
 	h := md5.New()
 
 	size := 0
-	if len(b.lines) > 0 {
-		n, e := h.Write(b.lines[0].data)
-		if e != nil {
-			return e
-		}
-		size += n
-
-		for _, l := range b.lines[1:] {
-			n, e = h.Write([]byte{'\n'})
-			if e != nil {
-				return e
-			}
-			size += n
-			n, e = h.Write(l.data)
-			if e != nil {
-				return e
+	var err error
+	b.forEach(0, func(i int, l *Line) bool {
+		var n int
+		if i > 0 {
+			n, err = h.Write([]byte{'\n'})
+			if err != nil {
+				return false
 			}
 			size += n
 		}
+		n, err = h.Write(l.data)
+		if err != nil {
+			return false
+		}
+		size += n
+		return true
+	})
+	if err != nil {
+		return err
 	}
 
-	if size > LargeFileThreshold {
+	// large file buffers only get here if the user explicitly turned
+	// fastdirty off
+	if size > LargeFileThreshold && !b.LargeFile {
 		return ErrFileTooLarge
 	}
 
@@ -685,62 +848,57 @@ func (b *Buffer) UpdateRules() {
 	if ft == "off" {
 		return
 	}
+	headers, errors := syntaxHeaders()
+	for _, err := range errors {
+		screen.TermMessage(err)
+	}
+	if ft == "unknown" || ft == "" {
+		ft, _ = b.detectFiletype(headers)
+	}
+	matches := func(header *highlight.Header) bool {
+		if ft == "unknown" || ft == "" {
+			return highlight.MatchFiletype(header.FtDetect, b.Path, b.LineBytes(0))
+		}
+		return header.FileType == ft
+	}
+
 	syntaxFile := ""
 	foundDef := false
 	var header *highlight.Header
 	// search for the syntax file in the user's custom syntax files
-	for _, f := range config.ListRealRuntimeFiles(config.RTSyntax) {
-		data, err := f.Data()
-		if err != nil {
-			screen.TermMessage("Error loading syntax file " + f.Name() + ": " + err.Error())
+	for _, h := range headers {
+		if h.file == nil || !matches(h.Header) {
 			continue
 		}
-
-		header, err = highlight.MakeHeaderYaml(data)
-		if err != nil {
-			screen.TermMessage("Error parsing header for syntax file " + f.Name() + ": " + err.Error())
-		}
-		file, err := highlight.ParseFile(data)
+		data, err := h.file.Data()
 		if err != nil {
-			screen.TermMessage("Error parsing syntax file " + f.Name() + ": " + err.Error())
+			screen.TermMessage("Error loading syntax file " + h.name + ": " + err.Error())
 			continue
 		}
-
-		if ((ft == "unknown" || ft == "") && highlight.MatchFiletype(header.FtDetect, b.Path, b.lines[0].data)) || header.FileType == ft {
-			syndef, err := highlight.ParseDef(file, header)
-			if err != nil {
-				screen.TermMessage("Error parsing syntax file " + f.Name() + ": " + err.Error())
-				continue
-			}
-			b.SyntaxDef = syndef
-			syntaxFile = f.Name()
-			foundDef = true
-			break
-		}
-	}
-
-	// search in the default syntax files
-	for _, f := range config.ListRuntimeFiles(config.RTSyntaxHeader) {
-		data, err := f.Data()
+		file, err := highlight.ParseFile(data)
 		if err != nil {
-			screen.TermMessage("Error loading syntax header file " + f.Name() + ": " + err.Error())
+			screen.TermMessage("Error parsing syntax file " + h.name + ": " + err.Error())
 			continue
 		}
-
-		header, err = highlight.MakeHeader(data)
+		syndef, err := highlight.ParseDef(file, h.Header)
 		if err != nil {
-			screen.TermMessage("Error reading syntax header file", f.Name(), err)
+			screen.TermMessage("Error parsing syntax file " + h.name + ": " + err.Error())
 			continue
 		}
+		b.SyntaxDef = syndef
+		syntaxFile = h.name
+		foundDef = true
+		break
+	}
 
-		if ft == "unknown" || ft == "" {
-			if highlight.MatchFiletype(header.FtDetect, b.Path, b.lines[0].data) {
-				syntaxFile = f.Name()
+	// search in the default syntax files
+	if !foundDef {
+		for _, h := range headers {
+			if h.file == nil && matches(h.Header) {
+				syntaxFile = h.name
+				header = h.Header
 				break
 			}
-		} else if header.FileType == ft {
-			syntaxFile = f.Name()
-			break
 		}
 	}
 
@@ -817,18 +975,17 @@ func (b *Buffer) UpdateRules() {
 	if b.SyntaxDef != nil {
 		b.Highlighter = highlight.NewHighlighter(b.SyntaxDef)
 		if b.Settings["syntax"].(bool) {
-			go func() {
-				b.Highlighter.HighlightStates(b)
-				b.Highlighter.HighlightMatches(b, 0, b.End().Y)
-				screen.Redraw()
-			}()
+			b.setHighlighter(b.Highlighter)
 		}
 	}
 }
 
 // ClearMatches clears all of the syntax highlighting for the buffer
 func (b *Buffer) ClearMatches() {
-	for i := range b.lines {
+	b.setHighlighter(nil)
+	b.textLock.Lock()
+	defer b.textLock.Unlock()
+	for i := 0; i < b.LinesNum(); i++ {
 		b.SetMatch(i, nil)
 		b.SetState(i, nil)
 	}
@@ -943,14 +1100,14 @@ func (b *Buffer) ClearCursors() {
 
 // MoveLinesUp moves the range of lines up one row
 func (b *Buffer) MoveLinesUp(start int, end int) {
-	if start < 1 || start >= end || end > len(b.lines) {
+	if start < 1 || start >= end || end > b.LinesNum() {
 		return
 	}
 	l := string(b.LineBytes(start - 1))
-	if end == len(b.lines) {
+	if end == b.LinesNum() {
 		b.insert(
 			Loc{
-				util.CharacterCount(b.lines[end-1].data),
+				util.CharacterCount(b.LineBytes(end - 1)),
 				end - 1,
 			},
 			[]byte{'\n'},
@@ -968,7 +1125,7 @@ func (b *Buffer) MoveLinesUp(start int, end int) {
 
 // MoveLinesDown moves the range of lines down one row
 func (b *Buffer) MoveLinesDown(start int, end int) {
-	if start < 0 || start >= end || end >= len(b.lines) {
+	if start < 0 || start >= end || end >= b.LinesNum() {
 		return
 	}
 	l := string(b.LineBytes(end))
@@ -1035,7 +1192,7 @@ func (b *Buffer) FindMatchingBrace(braceType [2]rune, start Loc) (Loc, bool, boo
 		}
 	} else if startChar == braceType[1] || leftChar == braceType[1] {
 		for y := start.Y; y >= 0; y-- {
-			l := []rune(string(b.lines[y].data))
+			l := []rune(string(b.LineBytes(y)))
 			xInit := len(l) - 1
 			if y == start.Y {
 				if leftChar == braceType[1] {
@@ -1074,15 +1231,21 @@ func (b *Buffer) Retab() {
 
 		ws := util.GetLeadingWhitespace(l)
 		if len(ws) != 0 {
+			old := ws
 			if toSpaces {
 				ws = bytes.ReplaceAll(ws, []byte{'\t'}, bytes.Repeat([]byte{' '}, tabsize))
 			} else {
 				ws = bytes.ReplaceAll(ws, bytes.Repeat([]byte{' '}, tabsize), []byte{'\t'})
 			}
+			if b.OnChange != nil && !bytes.Equal(ws, old) {
+				b.OnChange(Loc{0, i}, Loc{len(old), i}, ws)
+			}
 		}
 
 		l = bytes.TrimLeft(l, " \t")
-		b.lines[i].data = append(ws, l...)
+		b.textLock.Lock()
+		b.line(i).data = append(ws, l...)
+		b.textLock.Unlock()
 		b.MarkModified(i, i)
 		dirty = true
 	}
@@ -1,15 +1,20 @@
 package buffer
 
 import (
+	"bytes"
+	"encoding/gob"
 	"math/rand"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zyedidia/micro/v2/internal/config"
 	ulua "github.com/zyedidia/micro/v2/internal/lua"
 	"github.com/zyedidia/micro/v2/internal/util"
+	"github.com/zyedidia/micro/v2/pkg/highlight"
 )
 
 type operation struct {
@@ -95,6 +100,609 @@ func check(t *testing.T, before []string, operations []operation, after []string
 	b.Close()
 }
 
+func TestLargeFile(t *testing.T) {
+	config.GlobalSettings["largefile"] = float64(0.00001)
+	defer func() {
+		config.GlobalSettings["largefile"] = float64(50)
+	}()
+
+	b := NewBufferFromString(randomText(100), "", BTDefault)
+	defer b.Close()
+
+	assert.True(t, b.LargeFile)
+	for option, value := range largeFileSettings {
+		assert.Equal(t, value, b.Settings[option], option)
+	}
+
+	b.SetOptionNative("syntax", true)
+	assert.Equal(t, true, b.Settings["syntax"])
+
+	small := NewBufferFromString("foo", "", BTDefault)
+	defer small.Close()
+	assert.False(t, small.LargeFile)
+}
+
+func TestFastDirty(t *testing.T) {
+	// a buffer that is too large to hash keeps fastdirty on
+	b := NewBufferFromString(strings.Repeat("x", LargeFileThreshold+1), "", BTDefault)
+	defer b.Close()
+	b.SetOptionNative("fastdirty", false)
+	assert.Equal(t, true, b.Settings["fastdirty"])
+
+	// unless it is in large file mode and fastdirty is turned off on purpose
+	b.LargeFile = true
+	b.SetOptionNative("fastdirty", false)
+	assert.Equal(t, false, b.Settings["fastdirty"])
+	assert.False(t, b.Modified())
+	b.Insert(b.Start(), "y")
+	assert.True(t, b.Modified())
+	b.Remove(b.Start(), Loc{1, 0})
+	assert.False(t, b.Modified())
+}
+
+func TestUndoTree(t *testing.T) {
+	b := NewBufferFromString("foo", "", BTDefault)
+	defer b.Close()
+
+	b.Insert(b.End(), "bar")
+	b.Insert(b.End(), "baz")
+	old := b.Tree.Cur
+	b.UndoOneEvent()
+	b.UndoOneEvent()
+	b.Insert(b.End(), "qux")
+	assert.Equal(t, "fooqux", string(b.Bytes()))
+	assert.Equal(t, 0, b.RedoStack.Len())
+	assert.Len(t, b.Tree.Nodes, 4)
+
+	// the undone branch can still be reached
+	b.UndoTreeGoto(old)
+	assert.Equal(t, "foobarbaz", string(b.Bytes()))
+	assert.Equal(t, old, b.Tree.Cur)
+	assert.Equal(t, 2, b.UndoStack.Len())
+
+	b.UndoTreeGoto(0)
+	assert.Equal(t, "foo", string(b.Bytes()))
+	assert.Equal(t, 0, b.UndoStack.Len())
+
+	// redo follows the most recent branch
+	b.RedoOneEvent()
+	assert.Equal(t, "fooqux", string(b.Bytes()))
+
+	// a tree is created for undo stacks saved without one
+	b.UndoOneEvent()
+	b.Insert(b.End(), "1")
+	b.Insert(b.End(), "2")
+	b.UndoOneEvent()
+	b.Tree = nil
+	b.initUndoTree()
+	assert.Len(t, b.Tree.Nodes, 3)
+	assert.Equal(t, 1, b.Tree.Cur)
+	b.RedoOneEvent()
+	assert.Equal(t, "foo12", string(b.Bytes()))
+	assert.Equal(t, 2, b.Tree.Cur)
+
+	// only the tree is serialized, and the stacks are rebuilt from it
+	var data bytes.Buffer
+	assert.NoError(t, gob.NewEncoder(&data).Encode(SerializedBuffer{EventHandler: &EventHandler{Tree: b.Tree}}))
+	var s SerializedBuffer
+	assert.NoError(t, gob.NewDecoder(&data).Decode(&s))
+	assert.Nil(t, s.EventHandler.UndoStack)
+	s.EventHandler.cursors = b.cursors
+	s.EventHandler.buf = b.SharedBuffer
+	s.EventHandler.initUndoTree()
+	b.EventHandler = s.EventHandler
+	assert.Equal(t, 2, b.UndoStack.Len())
+	b.UndoOneEvent()
+	assert.Equal(t, "foo1", string(b.Bytes()))
+	assert.Equal(t, 1, b.Tree.Cur)
+}
+
+func TestRestoreState(t *testing.T) {
+	b := NewBufferFromString("one\ntwo", "", BTDefault)
+	defer b.Close()
+
+	start := time.Now().Add(-time.Hour)
+	edits := []string{" 1", "\nthree", "\nfour"}
+	for i, s := range edits {
+		b.Insert(b.End(), s)
+		b.Tree.Nodes[b.Tree.Cur].Event.Time = start.Add(time.Duration(i) * 10 * time.Minute)
+		if i == 1 {
+			b.Tree.saved()
+		}
+	}
+	assert.Equal(t, "one\ntwo 1\nthree\nfour", string(b.Bytes()))
+
+	assert.Equal(t, 1, b.Tree.StateAt(-15*time.Minute))
+	assert.Equal(t, 2, b.Tree.StateSteps(-1))
+	assert.Equal(t, 0, b.Tree.StateSteps(-10))
+	assert.Equal(t, 2, b.Tree.SaveSteps(-1))
+	assert.Equal(t, 0, b.Tree.SaveSteps(-2))
+
+	b.RestoreState(1)
+	assert.Equal(t, "one\ntwo 1", string(b.Bytes()))
+	assert.Equal(t, 1, b.Tree.Seq)
+	assert.Equal(t, 3, b.Tree.StateAt(time.Hour))
+
+	b.RestoreState(0)
+	assert.Equal(t, "one\ntwo", string(b.Bytes()))
+
+	b.RestoreState(2)
+	assert.Equal(t, "one\ntwo 1\nthree", string(b.Bytes()))
+
+	// restoring is undoable
+	b.UndoOneEvent()
+	assert.Equal(t, "one\ntwo", string(b.Bytes()))
+	b.UndoOneEvent()
+	assert.Equal(t, "one\ntwo 1", string(b.Bytes()))
+	b.UndoOneEvent()
+	assert.Equal(t, "one\ntwo 1\nthree\nfour", string(b.Bytes()))
+}
+
+func TestAppendText(t *testing.T) {
+	b := NewBufferFromString("a", "", BTScratch)
+	defer b.Close()
+
+	b.AppendText("\nb")
+	b.AppendText("c")
+	assert.Equal(t, "a\nbc", string(b.Bytes()))
+	assert.Equal(t, 0, b.UndoStack.Len())
+}
+
+func TestStateText(t *testing.T) {
+	b := NewBufferFromString("one\ntwo", "", BTDefault)
+	defer b.Close()
+
+	b.Insert(b.End(), "\nthree")
+	b.Replace(Loc{0, 0}, Loc{3, 0}, "1")
+	for i := 0; i < 3; i++ {
+		b.UndoOneEvent()
+	}
+	b.Insert(Loc{0, 0}, "zero\n")
+	assert.Equal(t, "zero\none\ntwo", string(b.Bytes()))
+	redo := b.RedoStack.Len()
+
+	assert.Equal(t, "one\ntwo\nthree", string(b.stateText(1)))
+	assert.Equal(t, "\ntwo\nthree", string(b.stateText(2)))
+	assert.Equal(t, "1\ntwo\nthree", string(b.stateText(3)))
+	assert.Equal(t, "one\ntwo", string(b.stateText(0)))
+
+	// the buffer and its history are not changed
+	assert.Equal(t, "zero\none\ntwo", string(b.Bytes()))
+	assert.Equal(t, 4, b.Tree.Cur)
+	assert.Equal(t, redo, b.RedoStack.Len())
+	assert.False(t, b.Tree.OnPath(3))
+
+	b.UndoTreeGoto(3)
+	assert.Equal(t, "1\ntwo\nthree", string(b.Bytes()))
+}
+
+func TestBlockSelection(t *testing.T) {
+	b := NewBufferFromString("abc\tx\nde\nfghij", "", BTDefault)
+	defer b.Close()
+	b.Settings["tabsize"] = float64(4)
+
+	c := b.GetActiveCursor()
+	c.GotoLoc(Loc{1, 0})
+	c.StartBlock()
+	c.Down()
+	c.Down()
+	c.BlockRight()
+	c.BlockRight()
+	assert.True(t, c.HasSelection())
+	assert.Equal(t, []string{"bc", "e", "gh"}, c.BlockSelection())
+	assert.True(t, c.InSelection(Loc{2, 2}))
+	assert.False(t, c.InSelection(Loc{3, 2}))
+
+	// typing replaces the block on every line and continues in an
+	// empty block
+	c.BlockInsert("-")
+	assert.Equal(t, "a-\tx\nd-\nf-ij", string(b.Bytes()))
+	c.BlockInsert("+")
+	assert.Equal(t, "a-+\tx\nd-+\nf-+ij", string(b.Bytes()))
+	c.BlockDeleteChar(false)
+	c.BlockDeleteChar(false)
+	assert.Equal(t, "a\tx\nd\nfij", string(b.Bytes()))
+
+	// a block paste pads short lines and adds lines at the end
+	c.ResetSelection()
+	c.GotoLoc(Loc{2, 0})
+	c.PasteBlock([]string{"12", "34", "56", "78"})
+	assert.Equal(t, "a\t12x\nd   34\nfij 56\n    78", string(b.Bytes()))
+
+	// tabs that are partly inside the block are included
+	c.GotoLoc(Loc{0, 0})
+	c.StartBlock()
+	c.BlockRight()
+	c.BlockRight()
+	assert.Equal(t, []string{"a\t"}, c.BlockSelection())
+	c.DeleteSelection()
+	c.ResetSelection()
+	assert.Equal(t, "12x\nd   34\nfij 56\n    78", string(b.Bytes()))
+}
+
+func TestFolds(t *testing.T) {
+	text := "a {\n\tb {\n\t\tc\n\t}\n\n\td\n}\ne"
+	b := NewBufferFromString(text, "", BTDefault)
+	defer b.Close()
+
+	assert.Equal(t, []Fold{{0, 5}, {1, 2}}, b.FoldRanges())
+
+	f := new(Folds)
+	b.AddFolds(f)
+	assert.True(t, f.Fold(b, 2))
+	assert.Equal(t, []Fold{{1, 2}}, f.Ranges())
+	assert.True(t, f.Fold(b, 2))
+	assert.Equal(t, []Fold{{0, 5}, {1, 2}}, f.Ranges())
+	assert.Equal(t, 0, f.FoldStart(2))
+	assert.Equal(t, 6, f.NextVisibleLine(0))
+	assert.Equal(t, 0, f.PrevVisibleLine(6))
+	end, ok := f.FoldEnd(0)
+	assert.True(t, ok)
+	assert.Equal(t, 5, end)
+	assert.False(t, f.Fold(b, 2))
+	assert.False(t, f.IsHidden(6))
+
+	assert.True(t, f.Unfold(0))
+	assert.Equal(t, []Fold{{1, 2}}, f.Ranges())
+	assert.True(t, f.IsHidden(2))
+	assert.False(t, f.IsHidden(4))
+
+	// every window has its own folds, and they all move with edits
+	other := new(Folds)
+	b.AddFolds(other)
+	assert.False(t, other.HasFolds())
+	b.Insert(Loc{0, 0}, "x\n")
+	assert.Equal(t, []Fold{{2, 3}}, f.Ranges())
+	assert.True(t, other.Fold(b, 6))
+	b.Insert(Loc{0, 3}, "y\n")
+	assert.Equal(t, []Fold{{2, 4}}, f.Ranges())
+	assert.Equal(t, []Fold{{1, 7}}, other.Ranges())
+	b.RemoveFolds(other)
+	b.Remove(Loc{0, 0}, Loc{0, 1})
+	assert.Equal(t, []Fold{{1, 3}}, f.Ranges())
+	assert.Equal(t, []Fold{{1, 7}}, other.Ranges())
+
+	f.FoldAll(b)
+	assert.Equal(t, 2, len(f.Ranges()))
+	f.UnfoldAll()
+	assert.False(t, f.HasFolds())
+}
+
+func TestBookmarks(t *testing.T) {
+	b := NewBufferFromString("one\ntwo\nthree\nfour", "", BTDefault)
+	defer b.Close()
+
+	assert.True(t, b.ToggleBookmark(Loc{1, 1}))
+	b.SetBookmark("a", Loc{0, 3})
+	assert.Equal(t, 2, len(b.Messages))
+	assert.Equal(t, 'a', b.Messages[1].Sign)
+
+	assert.Equal(t, Loc{1, 1}, b.NextBookmark(Loc{0, 0}, 1).Loc)
+	assert.Equal(t, "a", b.NextBookmark(Loc{0, 1}, 1).Name)
+	assert.Equal(t, "a", b.NextBookmark(Loc{0, 0}, -1).Name)
+
+	// bookmarks move with edits
+	b.Insert(Loc{0, 0}, "zero\n")
+	assert.Equal(t, Loc{1, 2}, b.Bookmarks[0].Loc)
+	assert.Equal(t, Loc{0, 4}, b.GetBookmark("a").Loc)
+	assert.Equal(t, 4, b.Messages[1].Start.Y)
+	b.Insert(Loc{0, 2}, "x")
+	assert.Equal(t, Loc{2, 2}, b.Bookmarks[0].Loc)
+	b.Remove(Loc{0, 3}, Loc{0, 4})
+	assert.Equal(t, Loc{0, 3}, b.GetBookmark("a").Loc)
+
+	assert.False(t, b.ToggleBookmark(Loc{0, 2}))
+	assert.Equal(t, 1, len(b.Bookmarks))
+	b.ClearBookmarks()
+	assert.Equal(t, 0, len(b.Messages))
+	assert.Nil(t, b.NextBookmark(Loc{0, 0}, 1))
+}
+
+func TestRetabOnChange(t *testing.T) {
+	b := NewBufferFromString("\tx\n  y\n\t\tz", "", BTDefault)
+	defer b.Close()
+	b.Settings["tabsize"] = float64(2)
+	b.Settings["tabstospaces"] = true
+
+	// the changes are reported so that copies of the text can follow them
+	var ranges [][2]Loc
+	var texts []string
+	b.OnChange = func(start, end Loc, text []byte) {
+		ranges = append(ranges, [2]Loc{start, end})
+		texts = append(texts, string(text))
+	}
+	b.Retab()
+	assert.Equal(t, "  x\n  y\n    z", string(b.Bytes()))
+	assert.Equal(t, [][2]Loc{{{0, 0}, {1, 0}}, {{0, 2}, {2, 2}}}, ranges)
+	assert.Equal(t, []string{"  ", "    "}, texts)
+}
+
+func TestSearchInSelection(t *testing.T) {
+	b := NewBufferFromString("foo bar foo\nbar foo", "", BTDefault)
+	defer b.Close()
+
+	c := b.GetActiveCursor()
+	c.SearchRange = [2]Loc{{4, 0}, {3, 1}}
+	b.LastSearchSelection = true
+	start, end := b.SearchBounds(c)
+
+	// searches from outside of the range start at its edges
+	m, found, err := b.FindNext("foo", start, end, Loc{0, 0}, true, false)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, [2]Loc{{8, 0}, {11, 0}}, m)
+	_, found, _ = b.FindNext("foo", start, end, Loc{4, 1}, true, false)
+	assert.True(t, found)
+
+	assert.True(t, b.inSearchRange(Loc{5, 0}))
+	assert.False(t, b.inSearchRange(Loc{4, 1}))
+
+	// the range moves with the text
+	b.Insert(Loc{0, 0}, "xx")
+	assert.Equal(t, [2]Loc{{6, 0}, {3, 1}}, c.SearchRange)
+}
+
+func TestReplacePreview(t *testing.T) {
+	b := NewBufferFromString("foo(1) foo(2)\nbar foo(3)", "", BTDefault)
+	defer b.Close()
+
+	b.ReplacePreview = &ReplacePreview{
+		Search:   &Search{Regexp: regexp.MustCompile(`foo\((?P<n>\d)\)`)},
+		Template: []byte("${n}x"),
+		Ranges:   [][2]Loc{{{3, 0}, {10, 1}}},
+	}
+	assert.Equal(t, []PreviewMatch{{7, 13, []byte("2x")}}, b.PreviewMatches(0))
+	assert.Equal(t, []PreviewMatch{{4, 10, []byte("3x")}}, b.PreviewMatches(1))
+	n, more := b.PreviewCount(10)
+	assert.Equal(t, 2, n)
+	assert.False(t, more)
+	n, more = b.PreviewCount(1)
+	assert.Equal(t, 1, n)
+	assert.True(t, more)
+}
+
+func TestMultilineSearch(t *testing.T) {
+	assert.True(t, IsMultiline(`foo\nbar`))
+	assert.True(t, IsMultiline("foo\nbar"))
+	assert.False(t, IsMultiline(`foo\\nbar`))
+	assert.False(t, IsMultiline(`foo.bar`))
+
+	b := NewBufferFromString("a foo\nbar b\nfoo\nbaz", "", BTDefault)
+	defer b.Close()
+
+	m, found, err := b.FindNext(`foo\nba.`, b.Start(), b.End(), Loc{0, 0}, true, true)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, [2]Loc{{2, 0}, {3, 1}}, m)
+	m, found, _ = b.FindNext(`foo\nba.`, b.Start(), b.End(), Loc{3, 0}, true, true)
+	assert.True(t, found)
+	assert.Equal(t, [2]Loc{{0, 2}, {3, 3}}, m)
+	m, found, _ = b.FindNext(`foo\nba.`, b.Start(), b.End(), Loc{0, 2}, false, true)
+	assert.True(t, found)
+	assert.Equal(t, [2]Loc{{2, 0}, {3, 1}}, m)
+
+	b.LastSearch = `foo\nba.`
+	b.LastSearchRegex = true
+	assert.True(t, b.LineArray.SearchMatch(b, Loc{1, 1}))
+	assert.False(t, b.LineArray.SearchMatch(b, Loc{4, 1}))
+
+	n, _ := b.ReplaceRegex(b.Start(), b.End(), regexp.MustCompile(`(?m)foo\n(ba.)`), []byte("$1"))
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "a bar b\nbaz", string(b.Bytes()))
+
+	b.UndoOneEvent()
+	assert.Equal(t, "a foo\nbar b\nfoo\nbaz", string(b.Bytes()))
+
+	b.ReplacePreview = &ReplacePreview{
+		Search:   &Search{Regexp: regexp.MustCompile(`(?m)foo\n(ba.)`)},
+		Template: []byte("$1"),
+		Ranges:   [][2]Loc{{b.Start(), b.End()}},
+	}
+	assert.Equal(t, []PreviewMatch{{2, 6, nil}}, b.PreviewMatches(0))
+	assert.Equal(t, []PreviewMatch{{0, 3, []byte("bar")}}, b.PreviewMatches(1))
+	n, more := b.PreviewCount(10)
+	assert.Equal(t, 2, n)
+	assert.False(t, more)
+	n, more = b.PreviewCount(1)
+	assert.Equal(t, 1, n)
+	assert.True(t, more)
+}
+
+func TestSearchFlags(t *testing.T) {
+	assert.True(t, hasUpper("fooBar", true))
+	assert.False(t, hasUpper(`\S+\p{Lu}`, true))
+	assert.True(t, hasUpper(`\S+`, false))
+	assert.False(t, hasUpper(`\pLfoo`, true))
+	assert.True(t, hasUpper(`\pLFoo`, true))
+	assert.True(t, hasUpper(`\P{Lu}X`, true))
+
+	b := NewBufferFromString("foo Foo foobar\nfoo_x xfoo foo", "", BTDefault)
+	defer b.Close()
+
+	find := func(s string, flags SearchFlags) [2]Loc {
+		m, found, err := b.FindNextFlags(s, b.Start(), b.End(), Loc{1, 0}, true, flags)
+		assert.NoError(t, err)
+		assert.True(t, found)
+		return m
+	}
+	assert.Equal(t, [2]Loc{{4, 0}, {7, 0}}, find("foo", SearchFlags{}))
+	assert.Equal(t, [2]Loc{{8, 0}, {11, 0}}, find("foo", SearchFlags{MatchCase: true}))
+
+	b.Settings["smartcase"] = true
+	assert.Equal(t, [2]Loc{{4, 0}, {7, 0}}, find("Foo", SearchFlags{}))
+	assert.Equal(t, [2]Loc{{4, 0}, {7, 0}}, find(`\S?foo\b`, SearchFlags{Regex: true}))
+	assert.Equal(t, [2]Loc{{8, 0}, {11, 0}}, find("foo", SearchFlags{MatchCase: true}))
+	assert.Equal(t, [2]Loc{{4, 0}, {7, 0}}, find("foo", SearchFlags{}))
+	b.Settings["smartcase"] = false
+
+	assert.Equal(t, [2]Loc{{11, 1}, {14, 1}}, find("foo", SearchFlags{MatchCase: true, WholeWord: true}))
+	assert.Equal(t, [2]Loc{{4, 0}, {7, 0}}, find("fo+", SearchFlags{Regex: true, WholeWord: true}))
+	assert.Equal(t, [2]Loc{{8, 0}, {14, 0}}, find("foo|foobar", SearchFlags{Regex: true, MatchCase: true, WholeWord: true}))
+
+	r, err := b.CompileSearch("foo", SearchFlags{WholeWord: true})
+	assert.NoError(t, err)
+	n, _ := b.ReplaceSearch(b.Start(), b.End(), r, []byte("x"))
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "x x foobar\nfoo_x xfoo x", string(b.Bytes()))
+}
+
+func TestFindAll(t *testing.T) {
+	b := NewBufferFromString("foo bar foo\nfoofoo", "", BTDefault)
+	defer b.Close()
+
+	r, err := b.CompileSearch("foo", SearchFlags{})
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]Loc{{{0, 0}, {3, 0}}, {{8, 0}, {11, 0}}, {{0, 1}, {3, 1}}, {{3, 1}, {6, 1}}}, b.FindAll(r, b.Start(), b.End()))
+	assert.Equal(t, [][2]Loc{{{8, 0}, {11, 0}}}, b.FindAll(r, Loc{1, 0}, Loc{2, 1}))
+
+	r, err = b.CompileSearch("o*", SearchFlags{Regex: true})
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]Loc{{{0, 1}, {0, 1}}, {{1, 1}, {3, 1}}, {{4, 1}, {6, 1}}}, b.FindAll(r, Loc{0, 1}, Loc{6, 1}))
+
+	r, err = b.CompileSearch(`o\nf`, SearchFlags{Regex: true})
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]Loc{{{10, 0}, {1, 1}}}, b.FindAll(r, b.Start(), b.End()))
+
+	// anchors match where they would in the whole line
+	r, err = b.CompileSearch("^foo", SearchFlags{Regex: true})
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]Loc{{{0, 0}, {3, 0}}, {{0, 1}, {3, 1}}}, b.FindAll(r, b.Start(), b.End()))
+	assert.Equal(t, [][2]Loc(nil), b.FindAll(r, Loc{3, 1}, b.End()))
+
+	r, err = b.CompileSearch(`\bfoo`, SearchFlags{Regex: true})
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]Loc{{{0, 0}, {3, 0}}, {{8, 0}, {11, 0}}, {{0, 1}, {3, 1}}}, b.FindAll(r, b.Start(), b.End()))
+}
+
+func TestPreserveCase(t *testing.T) {
+	for _, c := range [][3]string{
+		{"foo", "barBaz", "barbaz"},
+		{"FOO", "barBaz", "BARBAZ"},
+		{"Foo", "barBaz", "BarBaz"},
+		{"F", "bar", "Bar"},
+		{"fooBar", "BazQux", "bazQux"},
+		{"FooBar", "bazQux", "BazQux"},
+		{"Foo_BAR", "baz_qux", "Baz_QUX"},
+		{"foo-Bar", "baz-qux", "baz-Qux"},
+		{"123", "Bar", "Bar"},
+		{"Foo", "", ""},
+	} {
+		assert.Equal(t, c[2], preserveCase(c[0], c[1]), c[0]+" "+c[1])
+	}
+
+	b := NewBufferFromString("foo_bar Foo_Bar FOO_BAR", "", BTDefault)
+	defer b.Close()
+
+	r, err := b.CompileSearch("foo_bar", SearchFlags{})
+	assert.NoError(t, err)
+	r.PreserveCase = true
+	b.ReplaceSearch(b.Start(), b.End(), r, []byte("baz_qux"))
+	assert.Equal(t, "baz_qux Baz_Qux BAZ_QUX", string(b.Bytes()))
+}
+
+const testSyntax = `filetype: test
+
+detect:
+    filename: "\\.test$"
+
+rules:
+    - statement: "\\bif\\b"
+    - comment:
+        start: "/\\*"
+        end: "\\*/"
+        rules: []
+`
+
+func TestBackgroundHighlighting(t *testing.T) {
+	header, err := highlight.MakeHeaderYaml([]byte(testSyntax))
+	assert.NoError(t, err)
+	f, err := highlight.ParseFile([]byte(testSyntax))
+	assert.NoError(t, err)
+	def, err := highlight.ParseDef(f, header)
+	assert.NoError(t, err)
+
+	newBuffer := func(text string) *Buffer {
+		b := NewBufferFromString(text, "", BTDefault)
+		b.SyntaxDef = def
+		b.Highlighter = highlight.NewHighlighter(def)
+		b.setHighlighter(b.Highlighter)
+		return b
+	}
+	wait := func(b *Buffer) {
+		for i := 0; b.Highlighting() && i < 5000; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		assert.False(t, b.Highlighting())
+	}
+	// check compares the highlighting with a new pass over the whole buffer
+	check := func(b *Buffer) {
+		hl := highlight.NewHighlighter(def)
+		var state highlight.State
+		for i := 0; i < b.LinesNum(); i++ {
+			var match highlight.LineMatch
+			match, state = hl.HighlightLine(b.LineBytes(i), i, state)
+			if !assert.True(t, state == b.State(i), "state of line %d", i) ||
+				!assert.Equal(t, match, b.Match(i), "match of line %d", i) {
+				return
+			}
+		}
+	}
+
+	lines := make([]string, 5000)
+	for i := range lines {
+		switch i % 100 {
+		case 10:
+			lines[i] = "/* if"
+		case 20:
+			lines[i] = "if */ if"
+		default:
+			lines[i] = "if x"
+		}
+	}
+	b := newBuffer(strings.Join(lines, "\n"))
+	defer b.Close()
+
+	// the lines change while they are highlighted
+	r := rand.New(rand.NewSource(1))
+	edits := []string{"/*", "*/", "\n", "if\n", "x"}
+	for i := 0; i < 300; i++ {
+		y := r.Intn(b.LinesNum())
+		loc := Loc{r.Intn(util.CharacterCount(b.LineBytes(y)) + 1), y}
+		if r.Intn(3) == 0 {
+			ey := util.Min(y+r.Intn(3), b.LinesNum()-1)
+			end := Loc{r.Intn(util.CharacterCount(b.LineBytes(ey)) + 1), ey}
+			if end.LessThan(loc) {
+				loc, end = end, loc
+			}
+			b.Remove(loc, end)
+		} else {
+			b.Insert(loc, edits[r.Intn(len(edits))])
+		}
+	}
+	wait(b)
+	check(b)
+
+	b = newBuffer("if\n/* if\n*/ if\nif\nif")
+	defer b.Close()
+	wait(b)
+	check(b)
+	assert.True(t, b.State(1) != nil)
+	assert.True(t, b.State(2) == nil)
+
+	// the highlighting stops at the first line whose state is unchanged
+	sentinel := highlight.LineMatch{0: 255}
+	b.SetMatch(4, sentinel)
+	b.Insert(Loc{0, 2}, "x")
+	wait(b)
+	assert.Equal(t, sentinel, b.Match(4))
+
+	// an open comment changes the state of the next lines
+	b.Remove(Loc{0, 2}, Loc{3, 2})
+	wait(b)
+	check(b)
+	assert.True(t, b.State(4) != nil)
+}
+
 const maxLineLength = 200
 
 var alphabet = []rune(" abcdeäم📚")
@@ -1,13 +1,15 @@
 package buffer
 
 import (
+	"strings"
+
 	"github.com/zyedidia/micro/v2/internal/clipboard"
 	"github.com/zyedidia/micro/v2/internal/util"
 )
 
 // InBounds returns whether the given location is a valid character position in the given buffer
 func InBounds(pos Loc, buf *Buffer) bool {
-	if pos.Y < 0 || pos.Y >= len(buf.lines) || pos.X < 0 || pos.X > util.CharacterCount(buf.LineBytes(pos.Y)) {
+	if pos.Y < 0 || pos.Y >= buf.LinesNum() || pos.X < 0 || pos.X > util.CharacterCount(buf.LineBytes(pos.Y)) {
 		return false
 	}
 
@@ -30,6 +32,15 @@ type Cursor struct {
 	// to know what the original selection was
 	OrigSelection [2]Loc
 
+	// Whether the selection is a rectangular block (see block.go) and the
+	// visual columns of the block
+	Block     bool
+	BlockCols [2]int
+
+	// The range that searches in the selection are limited to. It is the
+	// selection the search started from, and it moves with the text
+	SearchRange [2]Loc
+
 	// Which cursor index is this (for multiple cursors)
 	Num int
 }
@@ -56,6 +67,7 @@ func (c *Cursor) Buf() *Buffer {
 func (c *Cursor) Goto(b Cursor) {
 	c.X, c.Y, c.LastVisualX = b.X, b.Y, b.LastVisualX
 	c.OrigSelection, c.CurSelection = b.OrigSelection, b.CurSelection
+	c.Block, c.BlockCols = b.Block, b.BlockCols
 }
 
 // GotoLoc puts the cursor at the given cursor's location and gives
@@ -132,6 +144,10 @@ func (c *Cursor) End() {
 func (c *Cursor) CopySelection(target clipboard.Register) {
 	if c.HasSelection() {
 		if target != clipboard.PrimaryReg || c.buf.Settings["useprimary"].(bool) {
+			if c.Block {
+				c.copyBlock(target)
+				return
+			}
 			clipboard.WriteMulti(string(c.GetSelection()), target, c.Num, c.buf.NumCursors())
 		}
 	}
@@ -141,25 +157,34 @@ func (c *Cursor) CopySelection(target clipboard.Register) {
 func (c *Cursor) ResetSelection() {
 	c.CurSelection[0] = c.buf.Start()
 	c.CurSelection[1] = c.buf.Start()
+	c.Block = false
 }
 
 // SetSelectionStart sets the start of the selection
 func (c *Cursor) SetSelectionStart(pos Loc) {
 	c.CurSelection[0] = pos
+	c.Block = false
 }
 
 // SetSelectionEnd sets the end of the selection
 func (c *Cursor) SetSelectionEnd(pos Loc) {
 	c.CurSelection[1] = pos
+	c.Block = false
 }
 
 // HasSelection returns whether or not the user has selected anything
+// A block selection counts even if it is empty, since typing still
+// inserts text on all of its lines
 func (c *Cursor) HasSelection() bool {
-	return c.CurSelection[0] != c.CurSelection[1]
+	return c.Block || c.CurSelection[0] != c.CurSelection[1]
 }
 
 // DeleteSelection deletes the currently selected text
 func (c *Cursor) DeleteSelection() {
+	if c.Block {
+		c.deleteBlock()
+		return
+	}
 	if c.CurSelection[0].GreaterThan(c.CurSelection[1]) {
 		c.buf.Remove(c.CurSelection[1], c.CurSelection[0])
 		c.Loc = c.CurSelection[1]
@@ -188,6 +213,9 @@ func (c *Cursor) Deselect(start bool) {
 
 // GetSelection returns the cursor's selection
 func (c *Cursor) GetSelection() []byte {
+	if c.Block {
+		return []byte(strings.Join(c.BlockSelection(), "\n"))
+	}
 	if InBounds(c.CurSelection[0], c.buf) && InBounds(c.CurSelection[1], c.buf) {
 		if c.CurSelection[0].GreaterThan(c.CurSelection[1]) {
 			return c.buf.Substr(c.CurSelection[1], c.CurSelection[0])
@@ -202,7 +230,7 @@ func (c *Cursor) SelectLine() {
 	c.Start()
 	c.SetSelectionStart(c.Loc)
 	c.End()
-	if len(c.buf.lines)-1 > c.Y {
+	if c.buf.LinesNum()-1 > c.Y {
 		c.SetSelectionEnd(c.Loc.Move(1, c.buf))
 	} else {
 		c.SetSelectionEnd(c.Loc)
@@ -234,8 +262,8 @@ func (c *Cursor) UpN(amount int) {
 	proposedY := c.Y - amount
 	if proposedY < 0 {
 		proposedY = 0
-	} else if proposedY >= len(c.buf.lines) {
-		proposedY = len(c.buf.lines) - 1
+	} else if proposedY >= c.buf.LinesNum() {
+		proposedY = c.buf.LinesNum() - 1
 	}
 
 	bytes := c.buf.LineBytes(proposedY)
@@ -305,8 +333,8 @@ func (c *Cursor) Right() {
 func (c *Cursor) Relocate() {
 	if c.Y < 0 {
 		c.Y = 0
-	} else if c.Y >= len(c.buf.lines) {
-		c.Y = len(c.buf.lines) - 1
+	} else if c.Y >= c.buf.LinesNum() {
+		c.Y = c.buf.LinesNum() - 1
 	}
 
 	if c.X < 0 {
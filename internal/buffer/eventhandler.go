@@ -75,41 +75,62 @@ func (eh *EventHandler) DoTextEvent(t *TextEvent, useUndo bool) {
 	}
 	end := t.Deltas[0].End
 
-	for _, c := range eh.cursors {
-		move := func(loc Loc) Loc {
-			if t.EventType == TextEventInsert {
-				if start.Y != loc.Y && loc.GreaterThan(start) {
-					loc.Y += end.Y - start.Y
-				} else if loc.Y == start.Y && loc.GreaterEqual(start) {
-					loc.Y += end.Y - start.Y
-					if lastnl >= 0 {
-						loc.X += textX - start.X
-					} else {
-						loc.X += textX
-					}
-				}
-				return loc
-			} else {
-				if loc.Y != end.Y && loc.GreaterThan(end) {
-					loc.Y -= end.Y - start.Y
-				} else if loc.Y == end.Y && loc.GreaterEqual(end) {
-					loc = loc.MoveLA(-DiffLA(start, end, eh.buf.LineArray), eh.buf.LineArray)
+	move := func(loc Loc) Loc {
+		if t.EventType == TextEventInsert {
+			if start.Y != loc.Y && loc.GreaterThan(start) {
+				loc.Y += end.Y - start.Y
+			} else if loc.Y == start.Y && loc.GreaterEqual(start) {
+				loc.Y += end.Y - start.Y
+				if lastnl >= 0 {
+					loc.X += textX - start.X
+				} else {
+					loc.X += textX
 				}
-				return loc
 			}
+			return loc
+		} else {
+			if loc.Y != end.Y && loc.GreaterThan(end) {
+				loc.Y -= end.Y - start.Y
+			} else if loc.Y == end.Y && loc.GreaterEqual(end) {
+				loc = loc.MoveLA(-DiffLA(start, end, eh.buf.LineArray), eh.buf.LineArray)
+			}
+			return loc
 		}
+	}
+	for _, c := range eh.cursors {
 		c.Loc = move(c.Loc)
 		c.CurSelection[0] = move(c.CurSelection[0])
 		c.CurSelection[1] = move(c.CurSelection[1])
 		c.OrigSelection[0] = move(c.OrigSelection[0])
 		c.OrigSelection[1] = move(c.OrigSelection[1])
+		c.SearchRange[0] = move(c.SearchRange[0])
+		c.SearchRange[1] = move(c.SearchRange[1])
 		c.Relocate()
 		c.LastVisualX = c.GetVisualX()
 	}
+	eh.buf.moveFolds(t.EventType == TextEventInsert, start.Y, end.Y)
+	eh.buf.moveBookmarks(func(loc Loc) Loc {
+		if t.EventType == TextEventRemove && loc.GreaterEqual(start) && loc.LessThan(end) {
+			// the text of the bookmark was removed
+			return start
+		}
+		return move(loc)
+	})
 }
 
 // ExecuteTextEvent runs a text event
 func ExecuteTextEvent(t *TextEvent, buf *SharedBuffer) {
+	executeTextEvent(t, buf)
+}
+
+// A textEditor is the text that a text event is executed on, either the
+// text of a buffer or a copy of its lines
+type textEditor interface {
+	insert(pos Loc, value []byte)
+	remove(start, end Loc) []byte
+}
+
+func executeTextEvent(t *TextEvent, buf textEditor) {
 	if t.EventType == TextEventInsert {
 		for _, d := range t.Deltas {
 			buf.insert(d.Start, d.Text)
@@ -124,6 +145,11 @@ func ExecuteTextEvent(t *TextEvent, buf *SharedBuffer) {
 			buf.insert(d.Start, d.Text)
 			t.Deltas[i].Start = d.Start
 			t.Deltas[i].End = Loc{d.Start.X + util.CharacterCount(d.Text), d.Start.Y}
+			if nl := bytes.Count(d.Text, []byte{'\n'}); nl > 0 {
+				// the text of a multi-line replacement spans several lines
+				last := d.Text[bytes.LastIndexByte(d.Text, '\n')+1:]
+				t.Deltas[i].End = Loc{util.CharacterCount(last), d.Start.Y + nl}
+			}
 		}
 		for i, j := 0, len(t.Deltas)-1; i < j; i, j = i+1, j-1 {
 			t.Deltas[i], t.Deltas[j] = t.Deltas[j], t.Deltas[i]
@@ -138,12 +164,15 @@ func (eh *EventHandler) UndoTextEvent(t *TextEvent) {
 }
 
 // EventHandler executes text manipulations and allows undoing and redoing
+// The undo and redo stacks follow the current branch of the undo tree,
+// which also keeps the branches that can no longer be redone
 type EventHandler struct {
 	buf       *SharedBuffer
 	cursors   []*Cursor
 	active    int
 	UndoStack *TEStack
 	RedoStack *TEStack
+	Tree      *UndoTree
 }
 
 // NewEventHandler returns a new EventHandler
@@ -151,6 +180,7 @@ func NewEventHandler(buf *SharedBuffer, cursors []*Cursor) *EventHandler {
 	eh := new(EventHandler)
 	eh.UndoStack = new(TEStack)
 	eh.RedoStack = new(TEStack)
+	eh.Tree = newUndoTree()
 	eh.buf = buf
 	eh.cursors = cursors
 	return eh
@@ -236,6 +266,7 @@ func (eh *EventHandler) Execute(t *TextEvent) {
 		eh.RedoStack = new(TEStack)
 	}
 	eh.UndoStack.Push(t)
+	eh.Tree.add(t)
 
 	b, err := config.RunPluginFnBool(nil, "onBeforeTextEvent", luar.New(ulua.L, eh.buf), luar.New(ulua.L, t))
 	if err != nil {
@@ -296,6 +327,7 @@ func (eh *EventHandler) UndoOneEvent() {
 
 	// Push it to the redo stack
 	eh.RedoStack.Push(t)
+	eh.Tree.up()
 }
 
 // Redo the first event in the redo stack
@@ -341,4 +373,5 @@ func (eh *EventHandler) RedoOneEvent() {
 	eh.UndoTextEvent(t)
 
 	eh.UndoStack.Push(t)
+	eh.Tree.down(t)
 }
@@ -0,0 +1,343 @@
+package buffer
+
+import (
+	"sort"
+
+	"github.com/zyedidia/micro/v2/internal/util"
+	"github.com/zyedidia/micro/v2/pkg/highlight"
+)
+
+// A Fold is a range of lines that can be collapsed into a single row. The
+// first line of the range stays visible and the others are hidden
+type Fold struct {
+	Start, End int
+}
+
+// contains returns whether line is in the range of the fold
+func (f Fold) contains(line int) bool {
+	return line >= f.Start && line <= f.End
+}
+
+// FoldRanges returns all the ranges of lines that can be folded, sorted by
+// their first line, with outer ranges before the ranges nested in them. The
+// ranges come from the indentation or from the multi-line syntax regions,
+// depending on the foldmethod option
+func (b *Buffer) FoldRanges() []Fold {
+	var folds []Fold
+	if b.Settings["foldmethod"].(string) == "syntax" {
+		folds = b.regionFolds()
+	} else {
+		folds = b.indentFolds()
+	}
+	sort.SliceStable(folds, func(i, j int) bool {
+		if folds[i].Start != folds[j].Start {
+			return folds[i].Start < folds[j].Start
+		}
+		return folds[i].End > folds[j].End
+	})
+	return folds
+}
+
+// indentFolds returns a range for every line that is followed by lines
+// that are indented more than it. Blank lines do not end a range, but
+// blank lines at the end of a range are not part of it
+func (b *Buffer) indentFolds() []Fold {
+	type open struct {
+		start, indent int
+	}
+	var folds []Fold
+	var stack []open
+	tabsize := util.IntOpt(b.Settings["tabsize"])
+	lastLine := -1
+
+	closeAbove := func(indent int) {
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			o := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if lastLine > o.start {
+				folds = append(folds, Fold{o.start, lastLine})
+			}
+		}
+	}
+
+	for y := 0; y < b.LinesNum(); y++ {
+		line := b.LineBytes(y)
+		ws := util.GetLeadingWhitespace(line)
+		if len(ws) == len(line) {
+			continue
+		}
+		indent := util.StringWidth(ws, util.CharacterCount(ws), tabsize)
+		closeAbove(indent)
+		stack = append(stack, open{y, indent})
+		lastLine = y
+	}
+	closeAbove(0)
+	return folds
+}
+
+// regionFolds returns a range for every syntax region, such as a block
+// comment, that starts and ends on different lines
+func (b *Buffer) regionFolds() []Fold {
+	type open struct {
+		start  int
+		region highlight.State
+	}
+	var folds []Fold
+	var stack []open
+
+	// chain returns the regions that are open at the end of line y,
+	// starting with the outermost one
+	chain := func(s highlight.State) []highlight.State {
+		var c []highlight.State
+		for ; s != nil; s = highlight.ParentState(s) {
+			c = append([]highlight.State{s}, c...)
+		}
+		return c
+	}
+
+	for y := 0; y < b.LinesNum(); y++ {
+		cur := chain(b.State(y))
+		k := 0
+		for k < len(stack) && k < len(cur) && stack[k].region == cur[k] {
+			k++
+		}
+		for len(stack) > k {
+			// the region ends on this line
+			o := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if y > o.start {
+				folds = append(folds, Fold{o.start, y})
+			}
+		}
+		for _, r := range cur[k:] {
+			stack = append(stack, open{y, r})
+		}
+	}
+	for _, o := range stack {
+		if b.LinesNum()-1 > o.start {
+			folds = append(folds, Fold{o.start, b.LinesNum() - 1})
+		}
+	}
+	return folds
+}
+
+// Folds are the folded ranges of lines of a window. Every window has its
+// own folds, which move with the lines when the text of the buffer is
+// edited in any window
+type Folds struct {
+	// the folded ranges, sorted by first line and then by last line in
+	// reverse order, so that a range comes before the ranges inside it
+	folds []Fold
+	// the folded ranges that are not inside another one. They do not
+	// overlap, so that the range hiding a line is found with a binary
+	// search
+	outer []Fold
+}
+
+// update sorts the folded ranges and finds the outer ones
+func (f *Folds) update() {
+	sort.Slice(f.folds, func(i, j int) bool {
+		return foldLess(f.folds[i], f.folds[j])
+	})
+	f.outer = f.outer[:0]
+	for _, g := range f.folds {
+		if n := len(f.outer); n > 0 && g.Start <= f.outer[n-1].End {
+			// inside the previous range, or overlapping it after an edit
+			f.outer[n-1].End = util.Max(f.outer[n-1].End, g.End)
+			continue
+		}
+		f.outer = append(f.outer, g)
+	}
+}
+
+// foldLess returns whether a comes before b in the order of Folds.folds
+func foldLess(a, b Fold) bool {
+	if a.Start != b.Start {
+		return a.Start < b.Start
+	}
+	return a.End > b.End
+}
+
+// outerFold returns the outermost folded range that contains line
+func (f *Folds) outerFold(line int) (Fold, bool) {
+	i := sort.Search(len(f.outer), func(i int) bool {
+		return f.outer[i].Start > line
+	}) - 1
+	if i >= 0 && f.outer[i].contains(line) {
+		return f.outer[i], true
+	}
+	return Fold{}, false
+}
+
+// HasFolds returns whether any lines are folded
+func (f *Folds) HasFolds() bool {
+	return len(f.folds) > 0
+}
+
+// Ranges returns the folded ranges
+func (f *Folds) Ranges() []Fold {
+	return f.folds
+}
+
+// FoldStart returns the visible line that line is shown on, which is the
+// first line of the fold if line is folded
+func (f *Folds) FoldStart(line int) int {
+	if g, ok := f.outerFold(line); ok {
+		return g.Start
+	}
+	return line
+}
+
+// FoldEnd returns the last line of the fold starting at line, and false if
+// line is not the first line of a folded range
+func (f *Folds) FoldEnd(line int) (int, bool) {
+	if g, ok := f.outerFold(line); ok && g.Start == line {
+		return g.End, true
+	}
+	return line, false
+}
+
+// IsHidden returns whether line is hidden by a fold
+func (f *Folds) IsHidden(line int) bool {
+	return f.FoldStart(line) != line
+}
+
+// NextVisibleLine returns the first visible line after line. The result
+// may be past the end of the buffer
+func (f *Folds) NextVisibleLine(line int) int {
+	if g, ok := f.outerFold(line); ok {
+		return g.End + 1
+	}
+	return line + 1
+}
+
+// PrevVisibleLine returns the last visible line before line. The result
+// may be -1
+func (f *Folds) PrevVisibleLine(line int) int {
+	if line <= 0 {
+		return line - 1
+	}
+	return f.FoldStart(line - 1)
+}
+
+// isFolded returns whether the range g is folded
+func (f *Folds) isFolded(g Fold) bool {
+	i := sort.Search(len(f.folds), func(i int) bool {
+		return !foldLess(f.folds[i], g)
+	})
+	return i < len(f.folds) && f.folds[i] == g
+}
+
+// Fold folds the innermost range of b containing line that is not folded
+// yet. It returns false if there is no such range
+func (f *Folds) Fold(b *Buffer, line int) bool {
+	ranges := b.FoldRanges()
+	for i := len(ranges) - 1; i >= 0; i-- {
+		g := ranges[i]
+		if g.contains(line) && !f.isFolded(g) {
+			f.folds = append(f.folds, g)
+			f.update()
+			return true
+		}
+	}
+	return false
+}
+
+// Unfold opens all folds containing line. It returns false if line is not
+// folded
+func (f *Folds) Unfold(line int) bool {
+	folds := f.folds[:0]
+	for _, g := range f.folds {
+		if !g.contains(line) {
+			folds = append(folds, g)
+		}
+	}
+	found := len(folds) < len(f.folds)
+	f.folds = folds
+	f.update()
+	return found
+}
+
+// ToggleFold opens the folds containing line, or folds the innermost range
+// of b containing line if it is not folded
+func (f *Folds) ToggleFold(b *Buffer, line int) bool {
+	if f.Unfold(line) {
+		return true
+	}
+	return f.Fold(b, line)
+}
+
+// FoldAll folds all the ranges of b that can be folded
+func (f *Folds) FoldAll(b *Buffer) {
+	f.folds = b.FoldRanges()
+	f.update()
+}
+
+// UnfoldAll opens all folds
+func (f *Folds) UnfoldAll() {
+	f.folds = nil
+	f.update()
+}
+
+// AddFolds makes the folds f move with the lines of the buffer when it is
+// edited. A window adds its folds to the buffer it shows
+func (b *SharedBuffer) AddFolds(f *Folds) {
+	b.folds = append(b.folds, f)
+}
+
+// RemoveFolds stops moving the folds f with the lines of the buffer
+func (b *SharedBuffer) RemoveFolds(f *Folds) {
+	for i, g := range b.folds {
+		if g == f {
+			b.folds = append(b.folds[:i], b.folds[i+1:]...)
+			return
+		}
+	}
+}
+
+// moveFolds moves the folds of every window after the lines from start to
+// end were inserted (if insert is true) or removed
+func (b *SharedBuffer) moveFolds(insert bool, start, end int) {
+	if start == end {
+		return
+	}
+	for _, f := range b.folds {
+		f.move(insert, start, end, b.LinesNum())
+	}
+}
+
+// move moves the folds after the lines from start to end were inserted
+// (if insert is true) or removed. nlines is the new number of lines
+func (f *Folds) move(insert bool, start, end, nlines int) {
+	if len(f.folds) == 0 {
+		return
+	}
+
+	n := end - start
+	move := func(line int, last bool) int {
+		if insert {
+			// the text after the insertion on the first line of the
+			// fold stays on its first line, but the last line moves
+			if line > start || last && line == start {
+				return line + n
+			}
+			return line
+		}
+		if line > end {
+			return line - n
+		} else if line > start {
+			return start
+		}
+		return line
+	}
+
+	folds := f.folds[:0]
+	for _, g := range f.folds {
+		s, e := move(g.Start, false), move(g.End, true)
+		if e > s && e < nlines {
+			folds = append(folds, Fold{s, e})
+		}
+	}
+	f.folds = folds
+	f.update()
+}
@@ -0,0 +1,411 @@
+package buffer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/zyedidia/micro/v2/internal/config"
+	"github.com/zyedidia/micro/v2/pkg/highlight"
+)
+
+// detectLines is the number of lines at the start of a buffer which are
+// searched for the content signatures of filetypes
+const detectLines = 200
+
+// fileTypeAliases maps the names which interpreters, modelines and
+// .gitattributes files use for languages to filetypes, where they differ
+var fileTypeAliases = map[string]string{
+	"bash":         "shell",
+	"cperl":        "perl",
+	"cpp":          "c++",
+	"dash":         "shell",
+	"emacs-lisp":   "lisp",
+	"gawk":         "awk",
+	"js":           "javascript",
+	"js2":          "javascript",
+	"ksh":          "shell",
+	"make":         "makefile",
+	"matlab":       "octave",
+	"mawk":         "awk",
+	"md":           "markdown",
+	"node":         "javascript",
+	"nodejs":       "javascript",
+	"objc":         "objective-c",
+	"py":           "python",
+	"rb":           "ruby",
+	"rscript":      "r",
+	"runhaskell":   "haskell",
+	"sh":           "shell",
+	"shell-script": "shell",
+	"tclsh":        "tcl",
+	"ts":           "typescript",
+	"vim":          "vi",
+	"vim-script":   "vi",
+	"viml":         "vi",
+	"wish":         "tcl",
+	"yml":          "yaml",
+}
+
+// A signature is a regular expression whose match in the content of a file
+// with an ambiguous extension counts for a filetype
+type signature struct {
+	filetype string
+	// the name that is shown to explain the filetype
+	name   string
+	regex  *regexp.Regexp
+	weight int
+}
+
+func sig(filetype, name, regex string, weight int) signature {
+	return signature{filetype, name, regexp.MustCompile(regex), weight}
+}
+
+// signatures are the content signatures of the filetypes which share an
+// extension. The filetype matched by the file name is used if no
+// signature matches
+var signatures = map[string][]signature{
+	".h": {
+		sig("c++", "class", `^\s*(template\s*<.*>\s*)?class\s+\w+`, 3),
+		sig("c++", "namespace", `^\s*namespace\b`, 3),
+		sig("c++", "access specifier", `^\s*(public|private|protected)\s*:`, 2),
+		sig("c++", "std::", `\bstd::`, 2),
+		sig("c++", "C++ header", `^\s*#\s*include\s*<(iostream|string|vector|map|memory|algorithm)>`, 3),
+		sig("objective-c", "@interface", `^\s*@(interface|protocol|implementation)\b`, 4),
+		sig("objective-c", "@property", `^\s*@(property|end)\b`, 2),
+		sig("objective-c", "#import", `^\s*#\s*import\b`, 2),
+		sig("objective-c", "Foundation class", `\bNS[A-Z]\w+\s*\*`, 1),
+	},
+	".m": {
+		sig("objective-c", "@interface", `^\s*@(interface|protocol|implementation)\b`, 4),
+		sig("objective-c", "#import", `^\s*#\s*import\b`, 3),
+		sig("objective-c", "message", `\[\w+\s+\w+(:.*)?\]`, 1),
+		sig("octave", "function", `^\s*function\s+(\[?[\w, ]*\]?\s*=\s*)?\w+`, 3),
+		sig("octave", "% comment", `^\s*%`, 2),
+		sig("octave", "end", `^\s*end(function|if|for|while)?\s*;?\s*$`, 1),
+		sig("octave", "disp", `^\s*(disp|printf|fprintf)\s*\(`, 1),
+	},
+	".pl": {
+		sig("perl", "use strict", `^\s*use\s+(strict|warnings)\b`, 3),
+		sig("perl", "my variable", `\bmy\s+[$@%]\w`, 2),
+		sig("perl", "sub", `^\s*sub\s+\w+`, 1),
+		sig("raku", "use v6", `^\s*use\s+v6\b`, 5),
+		sig("raku", "class", `^\s*(unit\s+)?(class|grammar|role)\s+[\w:]+`, 2),
+		sig("raku", "twigil", `\$[.!]\w`, 2),
+		sig("prolog", ":- rule", `^\w+(\(.*\))?\s*:-`, 3),
+		sig("prolog", "directive", `^:-\s*\w+`, 3),
+	},
+}
+
+// A syntaxHeader is the header of a syntax file
+type syntaxHeader struct {
+	*highlight.Header
+	// the syntax file if it is one of the user's syntax files, whose
+	// header is in the file itself. Otherwise the header is read from a
+	// header file with the same name as the syntax file
+	file config.RuntimeFile
+	name string
+}
+
+// the headers of the syntax files and the errors from reading them, which
+// are read again when the runtime files change
+var headerCache struct {
+	read    bool
+	version int
+	headers []syntaxHeader
+	errors  []string
+}
+
+// syntaxHeaders returns the headers of the syntax files in the order in
+// which UpdateRules searches them: the user's syntax files come first.
+// They are only read again when the runtime files change, and errors
+// lists the files that could not be read
+func syntaxHeaders() (headers []syntaxHeader, errors []string) {
+	c := &headerCache
+	if c.read && c.version == config.RuntimeFilesVersion() {
+		return c.headers, c.errors
+	}
+
+	for _, f := range config.ListRealRuntimeFiles(config.RTSyntax) {
+		data, err := f.Data()
+		if err != nil {
+			errors = append(errors, "Error loading syntax file "+f.Name()+": "+err.Error())
+			continue
+		}
+		header, err := highlight.MakeHeaderYaml(data)
+		if err != nil {
+			errors = append(errors, "Error parsing header for syntax file "+f.Name()+": "+err.Error())
+			continue
+		}
+		headers = append(headers, syntaxHeader{header, f, f.Name()})
+	}
+	for _, f := range config.ListRuntimeFiles(config.RTSyntaxHeader) {
+		data, err := f.Data()
+		if err != nil {
+			errors = append(errors, "Error loading syntax header file "+f.Name()+": "+err.Error())
+			continue
+		}
+		header, err := highlight.MakeHeader(data)
+		if err != nil {
+			errors = append(errors, "Error reading syntax header file "+f.Name()+": "+err.Error())
+			continue
+		}
+		headers = append(headers, syntaxHeader{header, nil, f.Name()})
+	}
+
+	c.read, c.version = true, config.RuntimeFilesVersion()
+	c.headers, c.errors = headers, errors
+	return headers, errors
+}
+
+// knownFiletype returns the filetype of a syntax file for a language name,
+// or "" if there is none
+func knownFiletype(headers []syntaxHeader, lang string) string {
+	lang = strings.ToLower(lang)
+	for _, ft := range []string{lang, fileTypeAliases[lang], strings.ReplaceAll(lang, " ", "-")} {
+		for _, header := range headers {
+			if ft != "" && header.FileType == ft {
+				return ft
+			}
+		}
+	}
+	return ""
+}
+
+// DetectFiletype detects the filetype of the buffer, and returns it with
+// the reason why it is chosen. The filetype is unknown if no syntax file
+// matches. It is taken from the first of these that gives one:
+//   - a Vim or Emacs modeline, unless the modeline option is off
+//   - a linguist-language attribute in a .gitattributes file
+//   - the interpreter in the #! line
+//   - the file name, where the content decides between the filetypes that
+//     share an ambiguous extension
+//   - the first line
+func (b *Buffer) DetectFiletype() (string, string) {
+	headers, _ := syntaxHeaders()
+	return b.detectFiletype(headers)
+}
+
+func (b *Buffer) detectFiletype(headers []syntaxHeader) (string, string) {
+	known := func(lang string) string {
+		return knownFiletype(headers, lang)
+	}
+
+	if b.Settings["modeline"].(bool) {
+		if lang, ml := modelineFiletype(b.modelines()); ml != nil {
+			if ft := known(lang); ft != "" {
+				return ft, fmt.Sprintf("set by the modeline on line %d", ml.line+1)
+			}
+		}
+	}
+
+	if b.AbsPath != "" {
+		if lang, where := gitattributesLanguage(b.AbsPath); lang != "" {
+			if ft := known(lang); ft != "" {
+				return ft, "linguist-language=" + lang + " in " + where
+			}
+		}
+	}
+
+	firstLine := b.LineBytes(0)
+	if name := interpreter(firstLine); name != "" {
+		// the header regexes of syntax files expect a path
+		line := "#!/usr/bin/" + name
+		for _, header := range headers {
+			if header.FtDetect[1] != nil && header.FtDetect[1].MatchString(line) {
+				return header.FileType, "the #! line runs " + name
+			}
+		}
+		for _, lang := range []string{name, strings.TrimRight(name, "0123456789.")} {
+			if ft := known(lang); ft != "" {
+				return ft, "the #! line runs " + name
+			}
+		}
+	}
+
+	for _, header := range headers {
+		if header.FtDetect[0] != nil && header.FtDetect[0].MatchString(b.Path) {
+			ext := filepath.Ext(b.Path)
+			if sigs, ok := signatures[ext]; ok {
+				return b.matchSignatures(ext, sigs, header.FileType, known)
+			}
+			return header.FileType, "the file name matches"
+		}
+	}
+
+	for _, header := range headers {
+		if header.FtDetect[1] != nil && header.FtDetect[1].Match(firstLine) {
+			return header.FileType, "the first line matches"
+		}
+	}
+
+	return "unknown", "no syntax file matches"
+}
+
+// matchSignatures chooses the filetype of a file with an ambiguous
+// extension whose signatures have the highest total weight in the content,
+// or the filetype matched by the file name if none is higher
+func (b *Buffer) matchSignatures(ext string, sigs []signature, fileNameType string, known func(string) string) (string, string) {
+	scores := make(map[string]int)
+	matched := make(map[string][]string)
+	n := b.LinesNum()
+	if n > detectLines {
+		n = detectLines
+	}
+	for _, s := range sigs {
+		if known(s.filetype) == "" {
+			continue
+		}
+		for i := 0; i < n; i++ {
+			if s.regex.Match(b.LineBytes(i)) {
+				scores[s.filetype] += s.weight
+				matched[s.filetype] = append(matched[s.filetype], fmt.Sprintf("%s (+%d)", s.name, s.weight))
+				break
+			}
+		}
+	}
+
+	ft := fileNameType
+	for _, s := range sigs {
+		if scores[s.filetype] > scores[ft] {
+			ft = s.filetype
+		}
+	}
+	if scores[ft] == 0 {
+		return ft, "the file name matches and the content matches no " + ext + " signatures"
+	}
+	return ft, "the content of the " + ext + " file matches " + strings.Join(matched[ft], ", ")
+}
+
+// interpreter returns the name of the program that runs a script with the
+// given #! line. The options and variable assignments of env are skipped
+func interpreter(line []byte) string {
+	if !bytes.HasPrefix(line, []byte("#!")) {
+		return ""
+	}
+	args := strings.Fields(string(line[2:]))
+	if len(args) == 0 {
+		return ""
+	}
+	if path.Base(args[0]) != "env" {
+		return path.Base(args[0])
+	}
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-u" || arg == "--unset" || arg == "-C" || arg == "--chdir":
+			// options with an argument
+			i++
+		case strings.HasPrefix(arg, "-S") && len(arg) > 2:
+			// the command may follow -S directly
+			return path.Base(arg[2:])
+		case strings.HasPrefix(arg, "-") || strings.Contains(arg, "="):
+		default:
+			return path.Base(arg)
+		}
+	}
+	return ""
+}
+
+// gitattributesLanguage returns the linguist-language attribute of a file,
+// and where it is set, from the .gitattributes files in its directory and
+// the parent directories up to the root of the repository. As in git, the
+// files in deeper directories take precedence
+func gitattributesLanguage(absPath string) (string, string) {
+	dir := filepath.Dir(absPath)
+	for {
+		if lang, where := readGitattributes(dir, absPath); lang != "" {
+			return lang, where
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return "", ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ""
+		}
+		dir = parent
+	}
+}
+
+// readGitattributes returns the linguist-language attribute of a file in
+// the .gitattributes file of a directory, and the line which sets it. The
+// last matching line wins
+func readGitattributes(dir, absPath string) (string, string) {
+	name := filepath.Join(dir, ".gitattributes")
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", ""
+	}
+	rel, err := filepath.Rel(dir, absPath)
+	if err != nil {
+		return "", ""
+	}
+	rel = filepath.ToSlash(rel)
+
+	lang, where := "", ""
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || !gitattributesMatch(fields[0], rel) {
+			continue
+		}
+		for _, attr := range fields[1:] {
+			if v := strings.TrimPrefix(attr, "linguist-language="); v != attr {
+				lang, where = v, fmt.Sprintf("%s:%d", name, i+1)
+			}
+		}
+	}
+	return lang, where
+}
+
+// gitattributesMatch returns whether a pattern of a .gitattributes file
+// matches a path relative to the directory of the file. A pattern without
+// a slash matches the name of a file in any directory
+func gitattributesMatch(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+
+	pattern = strings.TrimPrefix(pattern, "/")
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+
+	r, err := regexp.Compile(re.String())
+	return err == nil && r.MatchString(rel)
+}
@@ -0,0 +1,110 @@
+package buffer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zyedidia/micro/v2/internal/config"
+)
+
+// addSyntaxFiles registers syntax files with only the headers of some
+// filetypes for a test, and removes them when the test ends
+func addSyntaxFiles(t *testing.T) {
+	for _, s := range [][3]string{
+		{"c", `\.(c|h)$`, ""},
+		{"objective-c", `\.(m|mm|h)$`, ""},
+		{"octave", `\.m$`, ""},
+		{"perl", `\.p[lm]$`, `^#!.*/(env +)?perl( |$)`},
+		{"raku", `\.raku$`, ""},
+		{"c++", `\.cpp$`, ""},
+		{"python", `\.py$`, `^#!.*/(env +)?python(3)?$`},
+	} {
+		name := s[0] + ".yaml"
+		config.PluginAddRuntimeFileFromMemory(config.RTSyntax, name,
+			"filetype: "+s[0]+"\ndetect:\n    filename: '"+s[1]+"'\n    header: '"+s[2]+"'\nrules: []\n")
+		t.Cleanup(func() {
+			config.RemoveRuntimeFile(config.RTSyntax, name)
+		})
+	}
+}
+
+func TestDetectFiletype(t *testing.T) {
+	addSyntaxFiles(t)
+
+	tests := []struct {
+		path, text, filetype, reason string
+	}{
+		{"script", "#!/usr/bin/env -S python3 -u\n", "python", "the #! line runs python3"},
+		{"script", "#!/usr/bin/env LANG=C perl5.30 -w\n", "perl", "the #! line runs perl5.30"},
+		{"script", "#!/usr/local/bin/python3.11\n", "python", "the #! line runs python3.11"},
+		{"a.h", "int x;\n", "c", "the file name matches and the content matches no .h signatures"},
+		{"a.h", "@interface Foo : NSObject\n@end\n", "objective-c", "the content of the .h file matches @interface (+4), @property (+2)"},
+		{"a.h", "namespace x {\nclass Foo {\npublic:\n", "c++", "the content of the .h file matches class (+3), namespace (+3), access specifier (+2)"},
+		{"a.m", "function y = f(x)\n  % double\n  y = 2 * x;\nend\n", "octave", "the content of the .m file matches function (+3), % comment (+2), end (+1)"},
+		{"a.m", "#import <Foundation/Foundation.h>\n", "objective-c", "the content of the .m file matches #import (+3)"},
+		{"a.pl", "use v6;\nclass Foo { has $.x }\n", "raku", "the content of the .pl file matches use v6 (+5), class (+2), twigil (+2)"},
+		{"a.pl", "use strict;\nmy $x = 1;\n", "perl", "the content of the .pl file matches use strict (+3), my variable (+2)"},
+		{"a.py", "x = 1\n# vim: set ft=perl:\n", "perl", "set by the modeline on line 2"},
+		{"a.txt", "# -*- mode: python -*-\n", "python", "set by the modeline on line 1"},
+		{"a.txt", "# vim: ft=cpp\n", "c++", "set by the modeline on line 1"},
+		{"a.zz", "# vim: ft=zz\n", "unknown", "no syntax file matches"},
+	}
+	for _, test := range tests {
+		b := NewBufferFromString(test.text, test.path, BTDefault)
+		ft, reason := b.DetectFiletype()
+		assert.Equal(t, test.filetype, ft, test.text)
+		assert.Equal(t, test.reason, reason, test.text)
+		b.Close()
+	}
+
+	// the headers are read again when the syntax files change
+	b := NewBufferFromString("", "a.zz", BTDefault)
+	defer b.Close()
+	ft, _ := b.DetectFiletype()
+	assert.Equal(t, "unknown", ft)
+	config.PluginAddRuntimeFileFromMemory(config.RTSyntax, "zz.yaml", "filetype: zz\ndetect:\n    filename: '\\.zz$'\nrules: []\n")
+	defer config.RemoveRuntimeFile(config.RTSyntax, "zz.yaml")
+	ft, _ = b.DetectFiletype()
+	assert.Equal(t, "zz", ft)
+}
+
+func TestDetectGitattributes(t *testing.T) {
+	addSyntaxFiles(t)
+
+	dir, err := ioutil.TempDir("", "micro-ftdetect")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, ".git"), 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "sub", "a"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".gitattributes"), []byte(
+		"*.txt linguist-language=Perl\n"+
+			"/sub/**/*.h linguist-language=Objective-C\n"+
+			"/*.h linguist-language=C++\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "sub", ".gitattributes"), []byte(
+		"b.txt linguist-language=Python\n"), 0644))
+
+	tests := []struct {
+		path, filetype, where string
+	}{
+		{"a.txt", "perl", ".gitattributes:1"},
+		{"sub/a/b.txt", "python", "sub/.gitattributes:1"},
+		{"sub/a/x.h", "objective-c", ".gitattributes:2"},
+		{"x.h", "c++", ".gitattributes:3"},
+		{"sub/x.c", "c", ""},
+	}
+	for _, test := range tests {
+		b := NewBufferFromString("", filepath.Join(dir, test.path), BTDefault)
+		ft, reason := b.DetectFiletype()
+		assert.Equal(t, test.filetype, ft, test.path)
+		if test.where != "" {
+			assert.True(t, strings.HasSuffix(reason, filepath.Join(dir, test.where)), reason)
+		}
+		b.Close()
+	}
+}
@@ -0,0 +1,188 @@
+package buffer
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zyedidia/micro/v2/internal/screen"
+	"github.com/zyedidia/micro/v2/internal/util"
+	"github.com/zyedidia/micro/v2/pkg/highlight"
+)
+
+// highlightChunk is the number of lines that are highlighted in the
+// background before the buffer can be changed again
+const highlightChunk = 200
+
+// highlightRedrawInterval is how often the screen is redrawn while the
+// highlighting of a large buffer is updated
+const highlightRedrawInterval = 100 * time.Millisecond
+
+// A highlightRange is a range of lines whose syntax highlighting must be
+// updated. The lines from start to end are highlighted, followed by the
+// next lines as long as the state at the end of a line differs from the
+// previous pass. An end of -1 is the end of the buffer
+type highlightRange struct {
+	start, end int
+}
+
+// A highlighting holds the ranges of lines of a buffer that are waiting to
+// be highlighted by a goroutine in the background. The line numbers of the
+// ranges are kept up to date when lines are inserted or removed meanwhile
+type highlighting struct {
+	lock        sync.Mutex
+	highlighter *highlight.Highlighter
+	ranges      []highlightRange
+	// whether a goroutine is highlighting the ranges
+	running bool
+}
+
+// next removes the range that starts first from the queue and merges the
+// ranges that overlap it into it
+func (h *highlighting) next() highlightRange {
+	sort.Slice(h.ranges, func(i, j int) bool {
+		return h.ranges[i].start < h.ranges[j].start
+	})
+	r := h.ranges[0]
+	i := 1
+	for ; i < len(h.ranges) && r.end >= 0 && h.ranges[i].start <= r.end+1; i++ {
+		if h.ranges[i].end < 0 || h.ranges[i].end > r.end {
+			r.end = h.ranges[i].end
+		}
+	}
+	if r.end < 0 {
+		// the range covers all the others
+		i = len(h.ranges)
+	}
+	h.ranges = append(h.ranges[:0], h.ranges[i:]...)
+	return r
+}
+
+// setHighlighter highlights the whole buffer with a new highlighter, or
+// stops highlighting if it is nil
+func (b *SharedBuffer) setHighlighter(hl *highlight.Highlighter) {
+	h := &b.highlighting
+	h.lock.Lock()
+	h.highlighter = hl
+	h.ranges = h.ranges[:0]
+	h.lock.Unlock()
+	if hl != nil {
+		b.rehighlight(0, -1)
+	}
+}
+
+// rehighlight updates the highlighting of the lines from start to end in
+// the background. The lines after them are updated until the state at the
+// end of a line is the same as before
+func (b *SharedBuffer) rehighlight(start, end int) {
+	h := &b.highlighting
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.highlighter == nil {
+		return
+	}
+	h.ranges = append(h.ranges, highlightRange{start, end})
+	if !h.running {
+		h.running = true
+		go b.highlightRanges()
+	}
+}
+
+// shiftHighlight moves the ranges waiting to be highlighted when n lines
+// are inserted after line y, or -n lines after line y are removed. It must
+// be called with textLock held, so that the ranges and the lines always
+// agree for the highlighting goroutine
+func (b *SharedBuffer) shiftHighlight(y, n int) {
+	if n == 0 {
+		return
+	}
+	shift := func(l int) int {
+		if l <= y {
+			return l
+		}
+		return util.Max(l+n, y)
+	}
+
+	h := &b.highlighting
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for i, r := range h.ranges {
+		h.ranges[i].start = shift(r.start)
+		if r.end >= 0 {
+			h.ranges[i].end = shift(r.end)
+		}
+	}
+}
+
+// highlightRanges highlights the waiting ranges until there are none left,
+// and redraws the screen. It holds textLock for reading while it works on
+// a chunk of lines, so the lines cannot change meanwhile
+func (b *SharedBuffer) highlightRanges() {
+	h := &b.highlighting
+	lastRedraw := time.Now()
+	for {
+		b.textLock.RLock()
+		h.lock.Lock()
+		if len(h.ranges) == 0 {
+			h.running = false
+			h.lock.Unlock()
+			b.textLock.RUnlock()
+			screen.Redraw()
+			return
+		}
+		r := h.next()
+		hl := h.highlighter
+		h.lock.Unlock()
+
+		rest := b.highlightLines(hl, r)
+
+		if rest.start >= 0 {
+			h.lock.Lock()
+			if h.highlighter == hl {
+				h.ranges = append(h.ranges, rest)
+			}
+			h.lock.Unlock()
+		}
+		b.textLock.RUnlock()
+
+		if time.Since(lastRedraw) > highlightRedrawInterval {
+			// show the progress on a large buffer
+			screen.Redraw()
+			lastRedraw = time.Now()
+		}
+	}
+}
+
+// highlightLines highlights at most highlightChunk lines of a range and
+// returns the rest of the range, which has a start of -1 if the range is
+// complete
+func (b *SharedBuffer) highlightLines(hl *highlight.Highlighter, r highlightRange) highlightRange {
+	for i := r.start; i < b.LinesNum(); i++ {
+		if i-r.start >= highlightChunk {
+			return highlightRange{i, r.end}
+		}
+
+		var state highlight.State
+		if i > 0 {
+			state = b.State(i - 1)
+		}
+		match, state := hl.HighlightLine(b.LineBytes(i), i, state)
+		prev := b.State(i)
+		b.SetState(i, state)
+		b.SetMatch(i, match)
+
+		if state == prev && r.end >= 0 && i >= r.end {
+			break
+		}
+	}
+	return highlightRange{-1, -1}
+}
+
+// Highlighting returns whether the syntax highlighting of the buffer is
+// being updated in the background
+func (b *SharedBuffer) Highlighting() bool {
+	h := &b.highlighting
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.running
+}
@@ -0,0 +1,134 @@
+package buffer
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+// A lazyFile is a large file whose lines are read from disk when they are
+// first accessed rather than when it is opened. The file is kept open, so
+// the lines can still be read if it is renamed. If another program
+// truncates it, the lines that are not read yet become empty
+type lazyFile struct {
+	f *os.File
+	// lock is held while a line is read, since lines are read by the
+	// background highlighter as well as by the main goroutine
+	lock sync.Mutex
+}
+
+// load reads the text of l if it was not read yet. Invalid UTF-8 is
+// replaced like when a file is read at once
+func (lf *lazyFile) load(l *Line) {
+	lf.lock.Lock()
+	defer lf.lock.Unlock()
+	if !l.unread {
+		return
+	}
+	l.unread = false
+
+	data := make([]byte, l.fileLen)
+	n, _ := lf.f.ReadAt(data, l.fileOff)
+	data = data[:n]
+	if !utf8.Valid(data) {
+		if valid, err := unicode.UTF8.NewDecoder().Bytes(data); err == nil {
+			data = valid
+		}
+	}
+	l.data = data[:len(data):len(data)]
+}
+
+// newLazyLineArray returns a new line array for the first size bytes of a
+// UTF-8 file, whose lines are read when they are accessed. Only the
+// positions of the lines are found now
+func newLazyLineArray(file *os.File, size int64, endings FileFormat) (*LineArray, error) {
+	// the file is opened again, since the caller closes it
+	f, err := os.Open(file.Name())
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err == nil {
+		var orig os.FileInfo
+		if orig, err = file.Stat(); err == nil && !os.SameFile(info, orig) {
+			err = errors.New(file.Name() + " was replaced")
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	la := new(LineArray)
+	la.initsize = uint64(size)
+	la.Endings = endings
+	la.file = &lazyFile{f: f}
+
+	// the end of a line, to detect its line ending
+	var end []byte
+	addLine := func(lines []*Line, off int64, n int) []*Line {
+		return append(lines, &Line{unread: n > 0, fileOff: off, fileLen: n, data: []byte{}})
+	}
+
+	br := bufio.NewReaderSize(io.NewSectionReader(f, 0, size), 64*1024)
+	lines := make([]*Line, 0, size/40+1)
+	var off int64
+	n := 0
+	for {
+		chunk, err := br.ReadSlice('\n')
+		n += len(chunk)
+		if len(chunk) >= 2 {
+			end = append(end[:0], chunk[len(chunk)-2:]...)
+		} else if len(chunk) == 1 {
+			end = append(end, chunk[0])
+			if len(end) > 2 {
+				end = end[len(end)-2:]
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && err != io.EOF {
+			f.Close()
+			return nil, err
+		}
+		if err == io.EOF {
+			// the last line has no line ending
+			lines = addLine(lines, off, n)
+			break
+		}
+		lines = addLine(lines, off, n-(len(end)-len(la.trimEOL(end, endings))))
+		off += int64(n)
+		n = 0
+		end = end[:0]
+	}
+	la.lines.root = buildRope(lines)
+
+	// the file is closed once nothing refers to the lines anymore
+	runtime.SetFinalizer(la, func(la *LineArray) {
+		if la.file != nil {
+			la.file.f.Close()
+		}
+	})
+
+	return la, nil
+}
+
+// detach reads the lines of a lazily read file that are not read yet and
+// closes the file. This must be done before the file is overwritten
+func (la *LineArray) detach() {
+	if la.file == nil {
+		return
+	}
+	la.forEach(0, func(i int, l *Line) bool {
+		return true
+	})
+	la.file.f.Close()
+	la.file = nil
+}
@@ -35,10 +35,13 @@ func runeToByteIndex(n int, txt []byte) int {
 // A searchState contains the search match info for a single line
 type searchState struct {
 	search     string
-	useRegex   bool
+	flags      SearchFlags
 	ignorecase bool
+	smartcase  bool
 	match      [][2]int
 	done       bool
+	// the matches may span several lines and depend on other lines
+	multiline bool
 }
 
 // A Line contains the data in bytes as well as a highlight state, match
@@ -58,6 +61,12 @@ type Line struct {
 	// which have distinct searches, so in the general case there are multiple
 	// searches per a line, one search per a Buffer containing this line.
 	search map[*Buffer]*searchState
+
+	// the position of the text of the line in a lazily read file, if it
+	// is not read yet
+	unread  bool
+	fileOff int64
+	fileLen int
 }
 
 const (
@@ -71,142 +80,120 @@ type FileFormat byte
 
 // A LineArray simply stores and array of lines and makes it easy to insert
 // and delete in it
+// The lines are kept in a rope so that inserting and deleting lines is
+// cheap even for very large files
 type LineArray struct {
-	lines    []Line
+	lines    lineRope
 	Endings  FileFormat
 	initsize uint64
+
+	// the file the lines are read from when they are accessed, if it is
+	// read lazily
+	file *lazyFile
 }
 
-// Append efficiently appends lines together
-// It allocates an additional 10000 lines if the original estimate
-// is incorrect
-func Append(slice []Line, data ...Line) []Line {
-	l := len(slice)
-	if l+len(data) > cap(slice) { // reallocate
-		newSlice := make([]Line, (l+len(data))+10000)
-		copy(newSlice, slice)
-		slice = newSlice
-	}
-	slice = slice[0 : l+len(data)]
-	for i, c := range data {
-		slice[l+i] = c
+// trimEOL removes the line ending from a line read from a file and
+// detects the file format from it if it is not known yet
+// Even if the file format is set to DOS, the '\r' is removed so
+// that all lines end with '\n'
+func (la *LineArray) trimEOL(data []byte, endings FileFormat) []byte {
+	dlen := len(data)
+	if dlen > 1 && data[dlen-2] == '\r' && data[dlen-1] == '\n' {
+		if endings == FFAuto {
+			la.Endings = FFDos
+		}
+		return data[: dlen-2 : dlen-2]
+	} else if dlen > 0 {
+		if endings == FFAuto {
+			la.Endings = FFUnix
+		}
+		if data[dlen-1] == '\n' {
+			return data[: dlen-1 : dlen-1]
+		}
 	}
-	return slice
+	return data
 }
 
 // NewLineArray returns a new line array from an array of bytes
 func NewLineArray(size uint64, endings FileFormat, reader io.Reader) *LineArray {
 	la := new(LineArray)
 
-	la.lines = make([]Line, 0, 1000)
 	la.initsize = size
 
 	br := bufio.NewReader(reader)
-	var loaded int
 
 	la.Endings = endings
 
-	n := 0
+	lines := make([]*Line, 0, 1000)
 	for {
 		data, err := br.ReadBytes('\n')
-		// Detect the line ending by checking to see if there is a '\r' char
-		// before the '\n'
-		// Even if the file format is set to DOS, the '\r' is removed so
-		// that all lines end with '\n'
-		dlen := len(data)
-		if dlen > 1 && data[dlen-2] == '\r' {
-			data = append(data[:dlen-2], '\n')
-			if endings == FFAuto {
-				la.Endings = FFDos
-			}
-			dlen = len(data)
-		} else if dlen > 0 {
-			if endings == FFAuto {
-				la.Endings = FFUnix
-			}
-		}
-
-		// If we are loading a large file (greater than 1000) we use the file
-		// size and the length of the first 1000 lines to try to estimate
-		// how many lines will need to be allocated for the rest of the file
-		// We add an extra 10000 to the original estimate to be safe and give
-		// plenty of room for expansion
-		if n >= 1000 && loaded >= 0 {
-			totalLinesNum := int(float64(size) * (float64(n) / float64(loaded)))
-			newSlice := make([]Line, len(la.lines), totalLinesNum+10000)
-			copy(newSlice, la.lines)
-			la.lines = newSlice
-			loaded = -1
-		}
-
-		// Counter for the number of bytes in the first 1000 lines
-		if loaded >= 0 {
-			loaded += dlen
-		}
-
 		if err != nil {
 			if err == io.EOF {
-				la.lines = Append(la.lines, Line{
-					data:        data,
-					state:       nil,
-					match:       nil,
-					rehighlight: false,
-				})
+				lines = append(lines, &Line{data: la.trimEOL(data, endings)})
 			}
 			// Last line was read
 			break
-		} else {
-			la.lines = Append(la.lines, Line{
-				data:        data[:dlen-1],
-				state:       nil,
-				match:       nil,
-				rehighlight: false,
-			})
 		}
-		n++
+		lines = append(lines, &Line{data: la.trimEOL(data, endings)})
 	}
+	la.lines.root = buildRope(lines)
 
 	return la
 }
 
+// line returns the line at index n
+func (la *LineArray) line(n int) *Line {
+	l := la.lines.Get(n)
+	if la.file != nil {
+		la.file.load(l)
+	}
+	return l
+}
+
+// forEach calls fn with the lines from line from until it returns false,
+// like lineRope.ForEach, after reading their text if needed
+func (la *LineArray) forEach(from int, fn func(i int, l *Line) bool) {
+	la.lines.ForEach(from, func(i int, l *Line) bool {
+		if la.file != nil {
+			la.file.load(l)
+		}
+		return fn(i, l)
+	})
+}
+
 // Bytes returns the string that should be written to disk when
 // the line array is saved
 func (la *LineArray) Bytes() []byte {
 	b := new(bytes.Buffer)
 	// initsize should provide a good estimate
 	b.Grow(int(la.initsize + 4096))
-	for i, l := range la.lines {
+	last := la.lines.Len() - 1
+	la.forEach(0, func(i int, l *Line) bool {
 		b.Write(l.data)
-		if i != len(la.lines)-1 {
+		if i != last {
 			if la.Endings == FFDos {
 				b.WriteByte('\r')
 			}
 			b.WriteByte('\n')
 		}
-	}
+		return true
+	})
 	return b.Bytes()
 }
 
 // newlineBelow adds a newline below the given line number
 func (la *LineArray) newlineBelow(y int) {
-	la.lines = append(la.lines, Line{
-		data:        []byte{' '},
-		state:       nil,
-		match:       nil,
-		rehighlight: false,
-	})
-	copy(la.lines[y+2:], la.lines[y+1:])
-	la.lines[y+1] = Line{
+	la.lines.Insert(y+1, &Line{
 		data:        []byte{},
-		state:       la.lines[y].state,
+		state:       la.line(y).state,
 		match:       nil,
 		rehighlight: false,
-	}
+	})
 }
 
 // Inserts a byte array at a given location
 func (la *LineArray) insert(pos Loc, value []byte) {
-	x, y := runeToByteIndex(pos.X, la.lines[pos.Y].data), pos.Y
+	x, y := runeToByteIndex(pos.X, la.line(pos.Y).data), pos.Y
 	for i := 0; i < len(value); i++ {
 		if value[i] == '\n' || (value[i] == '\r' && i < len(value)-1 && value[i+1] == '\n') {
 			la.split(Loc{x, y})
@@ -226,36 +213,39 @@ func (la *LineArray) insert(pos Loc, value []byte) {
 
 // InsertByte inserts a byte at a given location
 func (la *LineArray) insertByte(pos Loc, value byte) {
-	la.lines[pos.Y].data = append(la.lines[pos.Y].data, 0)
-	copy(la.lines[pos.Y].data[pos.X+1:], la.lines[pos.Y].data[pos.X:])
-	la.lines[pos.Y].data[pos.X] = value
+	l := la.line(pos.Y)
+	l.data = append(l.data, 0)
+	copy(l.data[pos.X+1:], l.data[pos.X:])
+	l.data[pos.X] = value
 }
 
 // joinLines joins the two lines a and b
 func (la *LineArray) joinLines(a, b int) {
-	la.insert(Loc{len(la.lines[a].data), a}, la.lines[b].data)
+	la.insert(Loc{len(la.line(a).data), a}, la.line(b).data)
 	la.deleteLine(b)
 }
 
 // split splits a line at a given position
 func (la *LineArray) split(pos Loc) {
 	la.newlineBelow(pos.Y)
-	la.insert(Loc{0, pos.Y + 1}, la.lines[pos.Y].data[pos.X:])
-	la.lines[pos.Y+1].state = la.lines[pos.Y].state
-	la.lines[pos.Y].state = nil
-	la.lines[pos.Y].match = nil
-	la.lines[pos.Y+1].match = nil
-	la.lines[pos.Y].rehighlight = true
+	cur, next := la.line(pos.Y), la.line(pos.Y+1)
+	la.insert(Loc{0, pos.Y + 1}, cur.data[pos.X:])
+	next.state = cur.state
+	cur.state = nil
+	cur.match = nil
+	next.match = nil
+	cur.rehighlight = true
 	la.deleteToEnd(Loc{pos.X, pos.Y})
 }
 
 // removes from start to end
 func (la *LineArray) remove(start, end Loc) []byte {
 	sub := la.Substr(start, end)
-	startX := runeToByteIndex(start.X, la.lines[start.Y].data)
-	endX := runeToByteIndex(end.X, la.lines[end.Y].data)
+	startX := runeToByteIndex(start.X, la.line(start.Y).data)
+	endX := runeToByteIndex(end.X, la.line(end.Y).data)
 	if start.Y == end.Y {
-		la.lines[start.Y].data = append(la.lines[start.Y].data[:startX], la.lines[start.Y].data[endX:]...)
+		l := la.line(start.Y)
+		l.data = append(l.data[:startX], l.data[endX:]...)
 	} else {
 		la.deleteLines(start.Y+1, end.Y-1)
 		la.deleteToEnd(Loc{startX, start.Y})
@@ -267,52 +257,60 @@ func (la *LineArray) remove(start, end Loc) []byte {
 
 // deleteToEnd deletes from the end of a line to the position
 func (la *LineArray) deleteToEnd(pos Loc) {
-	la.lines[pos.Y].data = la.lines[pos.Y].data[:pos.X]
+	l := la.line(pos.Y)
+	l.data = l.data[:pos.X]
 }
 
 // deleteFromStart deletes from the start of a line to the position
 func (la *LineArray) deleteFromStart(pos Loc) {
-	la.lines[pos.Y].data = la.lines[pos.Y].data[pos.X+1:]
+	l := la.line(pos.Y)
+	l.data = l.data[pos.X+1:]
 }
 
 // deleteLine deletes the line number
 func (la *LineArray) deleteLine(y int) {
-	la.lines = la.lines[:y+copy(la.lines[y:], la.lines[y+1:])]
+	la.lines.Delete(y, y+1)
 }
 
 func (la *LineArray) deleteLines(y1, y2 int) {
-	la.lines = la.lines[:y1+copy(la.lines[y1:], la.lines[y2+1:])]
+	la.lines.Delete(y1, y2+1)
 }
 
 // DeleteByte deletes the byte at a position
 func (la *LineArray) deleteByte(pos Loc) {
-	la.lines[pos.Y].data = la.lines[pos.Y].data[:pos.X+copy(la.lines[pos.Y].data[pos.X:], la.lines[pos.Y].data[pos.X+1:])]
+	l := la.line(pos.Y)
+	l.data = l.data[:pos.X+copy(l.data[pos.X:], l.data[pos.X+1:])]
 }
 
 // Substr returns the string representation between two locations
 func (la *LineArray) Substr(start, end Loc) []byte {
-	startX := runeToByteIndex(start.X, la.lines[start.Y].data)
-	endX := runeToByteIndex(end.X, la.lines[end.Y].data)
+	startLine, endLine := la.line(start.Y), la.line(end.Y)
+	startX := runeToByteIndex(start.X, startLine.data)
+	endX := runeToByteIndex(end.X, endLine.data)
 	if start.Y == end.Y {
-		src := la.lines[start.Y].data[startX:endX]
+		src := startLine.data[startX:endX]
 		dest := make([]byte, len(src))
 		copy(dest, src)
 		return dest
 	}
-	str := make([]byte, 0, len(la.lines[start.Y+1].data)*(end.Y-start.Y))
-	str = append(str, la.lines[start.Y].data[startX:]...)
+	str := make([]byte, 0, len(la.line(start.Y+1).data)*(end.Y-start.Y))
+	str = append(str, startLine.data[startX:]...)
 	str = append(str, '\n')
-	for i := start.Y + 1; i <= end.Y-1; i++ {
-		str = append(str, la.lines[i].data...)
+	la.forEach(start.Y+1, func(i int, l *Line) bool {
+		if i > end.Y-1 {
+			return false
+		}
+		str = append(str, l.data...)
 		str = append(str, '\n')
-	}
-	str = append(str, la.lines[end.Y].data[:endX]...)
+		return true
+	})
+	str = append(str, endLine.data[:endX]...)
 	return str
 }
 
 // LinesNum returns the number of lines in the buffer
 func (la *LineArray) LinesNum() int {
-	return len(la.lines)
+	return la.lines.Len()
 }
 
 // Start returns the start of the buffer
@@ -322,56 +320,62 @@ func (la *LineArray) Start() Loc {
 
 // End returns the location of the last character in the buffer
 func (la *LineArray) End() Loc {
-	numlines := len(la.lines)
-	return Loc{util.CharacterCount(la.lines[numlines-1].data), numlines - 1}
+	numlines := la.lines.Len()
+	return Loc{util.CharacterCount(la.line(numlines - 1).data), numlines - 1}
 }
 
 // LineBytes returns line n as an array of bytes
 func (la *LineArray) LineBytes(n int) []byte {
-	if n >= len(la.lines) || n < 0 {
+	if n >= la.lines.Len() || n < 0 {
 		return []byte{}
 	}
-	return la.lines[n].data
+	return la.line(n).data
 }
 
 // State gets the highlight state for the given line number
 func (la *LineArray) State(lineN int) highlight.State {
-	la.lines[lineN].lock.Lock()
-	defer la.lines[lineN].lock.Unlock()
-	return la.lines[lineN].state
+	l := la.line(lineN)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.state
 }
 
 // SetState sets the highlight state at the given line number
 func (la *LineArray) SetState(lineN int, s highlight.State) {
-	la.lines[lineN].lock.Lock()
-	defer la.lines[lineN].lock.Unlock()
-	la.lines[lineN].state = s
+	l := la.line(lineN)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.state = s
 }
 
 // SetMatch sets the match at the given line number
 func (la *LineArray) SetMatch(lineN int, m highlight.LineMatch) {
-	la.lines[lineN].lock.Lock()
-	defer la.lines[lineN].lock.Unlock()
-	la.lines[lineN].match = m
+	l := la.line(lineN)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.match = m
 }
 
 // Match retrieves the match for the given line number
 func (la *LineArray) Match(lineN int) highlight.LineMatch {
-	la.lines[lineN].lock.Lock()
-	defer la.lines[lineN].lock.Unlock()
-	return la.lines[lineN].match
+	l := la.line(lineN)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.match
 }
 
 func (la *LineArray) Rehighlight(lineN int) bool {
-	la.lines[lineN].lock.Lock()
-	defer la.lines[lineN].lock.Unlock()
-	return la.lines[lineN].rehighlight
+	l := la.line(lineN)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.rehighlight
 }
 
 func (la *LineArray) SetRehighlight(lineN int, on bool) {
-	la.lines[lineN].lock.Lock()
-	defer la.lines[lineN].lock.Unlock()
-	la.lines[lineN].rehighlight = on
+	l := la.line(lineN)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.rehighlight = on
 }
 
 // SearchMatch returns true if the location `pos` is within a match
@@ -390,34 +394,62 @@ func (la *LineArray) SearchMatch(b *Buffer, pos Loc) bool {
 	if b.LastSearch == "" {
 		return false
 	}
+	if b.LastSearchSelection && !b.inSearchRange(pos) {
+		return false
+	}
 
 	lineN := pos.Y
-	if la.lines[lineN].search == nil {
-		la.lines[lineN].search = make(map[*Buffer]*searchState)
+	l := la.line(lineN)
+	if l.search == nil {
+		l.search = make(map[*Buffer]*searchState)
 	}
-	s, ok := la.lines[lineN].search[b]
+	s, ok := l.search[b]
 	if !ok {
 		// Note: here is a small harmless leak: when the buffer `b` is closed,
 		// `s` is not deleted from the map. It means that the buffer
 		// will not be garbage-collected until the line array is garbage-collected,
 		// i.e. until all the buffers sharing this file are closed.
 		s = new(searchState)
-		la.lines[lineN].search[b] = s
+		l.search[b] = s
 	}
-	if !ok || s.search != b.LastSearch || s.useRegex != b.LastSearchRegex ||
-		s.ignorecase != b.Settings["ignorecase"].(bool) {
+	if !ok || s.search != b.LastSearch || s.flags != b.LastSearchFlags() ||
+		s.ignorecase != b.Settings["ignorecase"].(bool) ||
+		s.smartcase != b.Settings["smartcase"].(bool) {
 		s.search = b.LastSearch
-		s.useRegex = b.LastSearchRegex
+		s.flags = b.LastSearchFlags()
 		s.ignorecase = b.Settings["ignorecase"].(bool)
+		s.smartcase = b.Settings["smartcase"].(bool)
 		s.done = false
 	}
 
+	if !s.done && b.LastSearchRegex && IsMultiline(b.LastSearch) {
+		s.match = nil
+		s.multiline = true
+		if r, err := b.CompileSearch(b.LastSearch, s.flags); err == nil {
+			n := util.CharacterCount(l.data)
+			b.multilineMatchesOn(r, b.Start(), b.End(), lineN, func(m [2]Loc, _ []byte, _ []int) {
+				// the part of the match on this line, including the
+				// newline if the match goes on
+				x0, x1 := 0, n+1
+				if m[0].Y == lineN {
+					x0 = m[0].X
+				}
+				if m[1].Y == lineN {
+					x1 = m[1].X
+				}
+				s.match = append(s.match, [2]int{x0, x1})
+			})
+		}
+		s.done = true
+	}
+
 	if !s.done {
 		s.match = nil
+		s.multiline = false
 		start := Loc{0, lineN}
-		end := Loc{util.CharacterCount(la.lines[lineN].data), lineN}
+		end := Loc{util.CharacterCount(l.data), lineN}
 		for start.X < end.X {
-			m, found, _ := b.FindNext(b.LastSearch, start, end, start, true, b.LastSearchRegex)
+			m, found, _ := b.FindNextFlags(b.LastSearch, start, end, start, true, s.flags)
 			if !found {
 				break
 			}
@@ -443,9 +475,19 @@ func (la *LineArray) SearchMatch(b *Buffer, pos Loc) bool {
 // invalidateSearchMatches marks search matches for the given line as outdated.
 // It is called when the line is modified.
 func (la *LineArray) invalidateSearchMatches(lineN int) {
-	if la.lines[lineN].search != nil {
-		for _, s := range la.lines[lineN].search {
-			s.done = false
+	l := la.line(lineN)
+	multiline := false
+	for _, s := range l.search {
+		s.done = false
+		multiline = multiline || s.multiline
+	}
+	if multiline {
+		// the matches of the lines around may have changed too
+		end := util.Min(lineN+multilineWindow, la.LinesNum())
+		for i := util.Max(lineN-multilineWindow+1, 0); i < end; i++ {
+			for _, s := range la.line(i).search {
+				s.done = false
+			}
 		}
 	}
 }
@@ -22,7 +22,7 @@ func init() {
 
 func TestSplit(t *testing.T) {
 	la.insert(Loc{17, 1}, []byte{'\n'})
-	assert.Equal(t, len(la.lines), 6)
+	assert.Equal(t, la.LinesNum(), 6)
 	sub1 := la.Substr(Loc{0, 1}, Loc{17, 1})
 	sub2 := la.Substr(Loc{0, 2}, Loc{30, 2})
 
@@ -32,7 +32,7 @@ func TestSplit(t *testing.T) {
 
 func TestJoin(t *testing.T) {
 	la.remove(Loc{47, 1}, Loc{0, 2})
-	assert.Equal(t, len(la.lines), 5)
+	assert.Equal(t, la.LinesNum(), 5)
 	sub := la.Substr(Loc{0, 1}, Loc{47, 1})
 	bytes := la.Bytes()
 
@@ -23,6 +23,8 @@ type Message struct {
 	Kind MsgType
 	// The Owner of the message
 	Owner string
+	// The Sign is shown in the gutter instead of '>>' if it is set
+	Sign rune
 }
 
 // NewMessage creates a new gutter message
@@ -0,0 +1,343 @@
+package buffer
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// modelineLines is the number of lines at the start and at the end of a
+// buffer which are searched for Vim modelines, like Vim's modelines option
+const modelineLines = 5
+
+// A modeline is a line of a file with Vim or Emacs settings for the file
+type modeline struct {
+	// the line number, starting at 0
+	line int
+	// the options as they are named by the editor. Vim's boolean options
+	// have the value true, or false when they are prefixed with no
+	options map[string]string
+}
+
+// maxModelineTabsize is the largest tabsize that a modeline may set
+const maxModelineTabsize = 32
+
+var vimModeline = regexp.MustCompile(`(?:(?:^|[ \t])vim?(?:[<=>]?[0-9]+)?|[ \t]ex):`)
+
+var emacsModeline = regexp.MustCompile(`-\*-(.*?)-\*-`)
+
+// modelines returns the Vim modelines in the first and last lines of the
+// buffer and the Emacs modeline in its first two lines
+func (b *Buffer) modelines() []modeline {
+	return findModelines(b.LinesNum(), b.LineBytes)
+}
+
+// rawModelines returns the modelines of text which is not decoded yet,
+// like modelines
+func rawModelines(data []byte) []modeline {
+	n := bytes.Count(data, []byte{'\n'}) + 1
+	var lines [][]byte
+	if n > 2*modelineLines {
+		// only the first and the last lines are split
+		lines = bytes.SplitN(data, []byte{'\n'}, modelineLines+1)[:modelineLines]
+		start := len(data)
+		for i := 0; i < modelineLines; i++ {
+			start = bytes.LastIndexByte(data[:start], '\n')
+		}
+		lines = append(lines, bytes.Split(data[start+1:], []byte{'\n'})...)
+	} else {
+		lines = bytes.Split(data, []byte{'\n'})
+	}
+	return findModelines(n, func(i int) []byte {
+		if i >= modelineLines && n > 2*modelineLines {
+			i -= n - 2*modelineLines
+		}
+		return bytes.TrimSuffix(lines[i], []byte{'\r'})
+	})
+}
+
+// rawModelineEncoding returns the encoding set by the modelines of text
+// which is not decoded yet, or "" if they set none
+func rawModelineEncoding(data []byte) string {
+	enc, _ := modelineSettings(rawModelines(data))["encoding"].(string)
+	return enc
+}
+
+// modelineBytes is the number of bytes at the start and at the end of a
+// file that are read to find the encoding set by its modelines
+const modelineBytes = 4096
+
+// readModelineEncoding returns the encoding set by the modelines of the
+// file of the given size that r reads, or "" if they set none, and a
+// reader for the whole file. If r can read at an offset, only the first
+// and the last bytes of the file are read
+func readModelineEncoding(r io.Reader, size int64) (string, io.Reader, error) {
+	ra, ok := r.(io.ReaderAt)
+	if !ok || size <= 2*modelineBytes {
+		data, err := ioutil.ReadAll(r)
+		return rawModelineEncoding(data), bytes.NewReader(data), err
+	}
+
+	head := make([]byte, modelineBytes)
+	if _, err := ra.ReadAt(head, 0); err != nil {
+		return "", r, err
+	}
+	tail := make([]byte, modelineBytes)
+	if _, err := ra.ReadAt(tail, size-modelineBytes); err != nil {
+		return "", r, err
+	}
+
+	// the lines that are cut by the start or the end of the bytes that
+	// were read are left out
+	lines := bytes.SplitN(head, []byte{'\n'}, modelineLines+1)
+	lines = lines[:len(lines)-1]
+	last := bytes.Split(tail, []byte{'\n'})[1:]
+	if len(last) > modelineLines {
+		last = last[len(last)-modelineLines:]
+	}
+	lines = append(lines, last...)
+	return rawModelineEncoding(bytes.Join(lines, []byte{'\n'})), r, nil
+}
+
+// findModelines returns the modelines of a text with n lines, where line
+// returns line i of the text
+func findModelines(n int, line func(i int) []byte) []modeline {
+	var mls []modeline
+	for i := 0; i < n; i++ {
+		if i == modelineLines && n > 2*modelineLines {
+			i = n - modelineLines
+		}
+		line := line(i)
+		options := parseVimModeline(line)
+		if options == nil && i < 2 {
+			options = parseEmacsModeline(line)
+		}
+		if options != nil {
+			mls = append(mls, modeline{i, options})
+		}
+	}
+	return mls
+}
+
+// parseVimModeline returns the options of a Vim modeline in either form:
+//
+//	vim: ts=4 et
+//	/* vim: set ts=4 et: */
+//
+// or nil if the line is not a modeline
+func parseVimModeline(line []byte) map[string]string {
+	loc := vimModeline.FindIndex(line)
+	if loc == nil {
+		return nil
+	}
+	s := strings.TrimLeft(string(line[loc[1]:]), " \t")
+	set := false
+	for _, prefix := range []string{"set ", "se "} {
+		if strings.HasPrefix(s, prefix) {
+			s = s[len(prefix):]
+			set = true
+			break
+		}
+	}
+
+	options := make(map[string]string)
+	var opt strings.Builder
+	add := func() {
+		o := opt.String()
+		opt.Reset()
+		if i := strings.IndexByte(o, '='); i > 0 {
+			options[o[:i]] = o[i+1:]
+		} else if strings.HasPrefix(o, "no") && len(o) > 2 {
+			options[o[2:]] = "false"
+		} else if o != "" {
+			options[o] = "true"
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			opt.WriteByte(s[i])
+		case c == ':' && set:
+			// the text after the options of set is ignored
+			add()
+			return options
+		case c == ' ' || c == '\t' || c == ':':
+			add()
+		default:
+			opt.WriteByte(c)
+		}
+	}
+	add()
+	return options
+}
+
+// parseEmacsModeline returns the options of an Emacs modeline in either
+// form:
+//
+//	-*- mode: python; tab-width: 4 -*-
+//	-*- python -*-
+//
+// or nil if the line is not a modeline
+func parseEmacsModeline(line []byte) map[string]string {
+	m := emacsModeline.FindSubmatch(line)
+	if m == nil {
+		return nil
+	}
+	s := strings.TrimSpace(string(m[1]))
+	if !strings.Contains(s, ":") {
+		return map[string]string{"mode": s}
+	}
+
+	options := make(map[string]string)
+	for _, option := range strings.Split(s, ";") {
+		if i := strings.IndexByte(option, ':'); i > 0 {
+			key := strings.ToLower(strings.TrimSpace(option[:i]))
+			options[key] = strings.TrimSpace(option[i+1:])
+		}
+	}
+	return options
+}
+
+// modelineFiletype returns the language set by a modeline, and the
+// modeline which sets it
+func modelineFiletype(mls []modeline) (string, *modeline) {
+	for i, ml := range mls {
+		for _, key := range []string{"filetype", "ft", "syntax", "syn", "mode"} {
+			lang := ml.options[key]
+			if lang == "" || lang == "true" || lang == "false" {
+				continue
+			}
+			// Vim allows several filetypes such as c.doxygen
+			if i := strings.IndexByte(lang, '.'); i > 0 {
+				lang = lang[:i]
+			}
+			return strings.TrimSuffix(strings.ToLower(lang), "-mode"), &mls[i]
+		}
+	}
+	return "", nil
+}
+
+// modelineSettings returns the settings which are set by modelines, except
+// the filetype. It is an allow-list: only the options of tabsize,
+// tabstospaces, fileformat, encoding and softwrap are read, and only
+// valid values are used, so a modeline cannot do anything else
+func modelineSettings(mls []modeline) map[string]interface{} {
+	settings := make(map[string]interface{})
+	// the tab width, and the indentation widths from Vim's shiftwidth,
+	// Emacs' offsets and Vim's softtabstop in that order of precedence
+	tabstop, indents := 0, make([]int, 3)
+	size := func(value string) int {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > maxModelineTabsize {
+			return 0
+		}
+		return n
+	}
+	coding := func(value string) {
+		for _, ff := range []string{"unix", "dos"} {
+			if strings.HasSuffix(value, "-"+ff) {
+				settings["fileformat"] = ff
+				value = strings.TrimSuffix(value, "-"+ff)
+			}
+		}
+		if _, err := htmlindex.Get(value); err == nil {
+			settings["encoding"] = value
+		}
+	}
+	setSize := func(n *int, value string) {
+		if s := size(value); s > 0 {
+			*n = s
+		}
+	}
+
+	// the later modelines take precedence
+	for _, ml := range mls {
+		for key, value := range ml.options {
+			switch key {
+			case "tabstop", "ts", "tab-width":
+				setSize(&tabstop, value)
+			case "shiftwidth", "sw":
+				setSize(&indents[0], value)
+			case "softtabstop", "sts":
+				setSize(&indents[2], value)
+			case "expandtab", "et":
+				settings["tabstospaces"] = value == "true"
+			case "indent-tabs-mode":
+				settings["tabstospaces"] = value == "nil"
+			case "fileformat", "ff":
+				if value == "unix" || value == "dos" {
+					settings["fileformat"] = value
+				}
+			case "fileencoding", "fenc", "coding":
+				coding(strings.ToLower(value))
+			case "wrap":
+				settings["softwrap"] = value == "true"
+			case "truncate-lines":
+				settings["softwrap"] = value == "nil"
+			default:
+				// Emacs' indentation width of a mode, such as
+				// c-basic-offset or python-indent-offset
+				if strings.HasSuffix(key, "-offset") || strings.HasSuffix(key, "-indent-level") {
+					setSize(&indents[1], value)
+				}
+			}
+		}
+	}
+
+	indent := 0
+	for _, n := range indents {
+		if n > 0 {
+			indent = n
+			break
+		}
+	}
+	// micro indents with spaces by the tabsize, so the indentation width
+	// is used when spaces are used
+	spaces, _ := settings["tabstospaces"].(bool)
+	if indent > 0 && (spaces || tabstop == 0) {
+		settings["tabsize"] = float64(indent)
+	} else if tabstop > 0 {
+		settings["tabsize"] = float64(tabstop)
+	}
+	return settings
+}
+
+// applyModelines sets the options of the buffer which are set by its
+// modelines, unless the modeline option is off. The fileformat only changes
+// how the buffer is saved, so it is not modified by it. The encoding is not
+// set here, since NewBuffer decodes the file with it
+func (b *Buffer) applyModelines() {
+	if !b.Settings["modeline"].(bool) {
+		return
+	}
+	mls := b.modelines()
+	if len(mls) == 0 {
+		return
+	}
+
+	// the filetype comes first, since it resets the other settings to
+	// those of the filetype
+	if lang, ml := modelineFiletype(mls); ml != nil {
+		headers, _ := syntaxHeaders()
+		ft := knownFiletype(headers, lang)
+		if ft != "" && ft != b.Settings["filetype"] {
+			b.SetOptionNative("filetype", ft)
+		}
+	}
+
+	modified := b.isModified
+	settings := modelineSettings(mls)
+	for _, option := range []string{"tabsize", "tabstospaces", "fileformat", "softwrap"} {
+		if value, ok := settings[option]; ok && value != b.Settings[option] {
+			b.SetOptionNative(option, value)
+		}
+	}
+	b.isModified = modified
+}
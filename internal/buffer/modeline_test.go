@@ -0,0 +1,131 @@
+package buffer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zyedidia/micro/v2/internal/config"
+)
+
+func TestModelines(t *testing.T) {
+	tests := []struct {
+		line    string
+		options map[string]string
+	}{
+		{"/* vim: set ts=2 et: */", map[string]string{"ts": "2", "et": "true"}},
+		{"# vim:noet:sw=4", map[string]string{"et": "false", "sw": "4"}},
+		{"vi: set fdm=marker fmr={,}:", map[string]string{"fdm": "marker", "fmr": "{,}"}},
+		{"// vim600: set ft=c\\:x:", map[string]string{"ft": "c:x"}},
+		{"# -*- mode: python; indent-tabs-mode: nil -*-", map[string]string{"mode": "python", "indent-tabs-mode": "nil"}},
+		{"; -*- Lisp -*-", map[string]string{"mode": "Lisp"}},
+		{"ex: not a modeline", nil},
+		{"novim: not a modeline", nil},
+	}
+	for _, test := range tests {
+		options := parseVimModeline([]byte(test.line))
+		if options == nil {
+			options = parseEmacsModeline([]byte(test.line))
+		}
+		assert.Equal(t, test.options, options, test.line)
+	}
+}
+
+func TestModelineSettings(t *testing.T) {
+	addSyntaxFiles(t)
+
+	tests := []struct {
+		text     string
+		settings map[string]interface{}
+	}{
+		{"x\n/* vim: set ts=8 sw=2 et ff=dos: */\n", map[string]interface{}{
+			"filetype": "unknown", "tabsize": float64(2), "tabstospaces": true, "fileformat": "dos",
+		}},
+		{"x\n// vim: ts=8 sw=2 noet nowrap\n", map[string]interface{}{
+			"tabsize": float64(8), "tabstospaces": false, "softwrap": false,
+		}},
+		{"# -*- mode: python; indent-tabs-mode: nil; python-indent-offset: 2; tab-width: 8; coding: latin1-unix -*-\n", map[string]interface{}{
+			"filetype": "python", "tabsize": float64(2), "tabstospaces": true, "encoding": "latin1", "fileformat": "unix",
+		}},
+		{"-*- truncate-lines: nil; fill-column: 70 -*-\n", map[string]interface{}{
+			"softwrap": true, "tabsize": float64(4),
+		}},
+		// only the allowed options with valid values are used
+		{"vim: set ts=1000 fenc=nonsense ff=mac fdm=expr foldexpr=system('rm\\ -rf'):\n", map[string]interface{}{
+			"tabsize": float64(4), "encoding": "utf-8", "fileformat": "unix", "filetype": "unknown",
+		}},
+		{"x\n1\n2\n3\n4\n5\n6\n# vim: ts=3\n7\n8\n9\n10\n11\n12\n", map[string]interface{}{
+			"tabsize": float64(4),
+		}},
+	}
+	for _, test := range tests {
+		b := NewBufferFromString(test.text, "a.txt", BTDefault)
+		for option, value := range test.settings {
+			assert.Equal(t, value, b.Settings[option], test.text+option)
+		}
+		assert.False(t, b.Modified(), test.text)
+		b.Close()
+	}
+
+	// the file is decoded with the encoding of the modeline
+	data := []byte("# -*- coding: latin1 -*-\ncaf\xe9\n")
+	b := NewBuffer(bytes.NewReader(data), int64(len(data)), "a.txt", Loc{-1, -1}, BTDefault)
+	assert.Equal(t, "café", string(b.LineBytes(1)))
+	assert.Equal(t, "latin1", b.Settings["encoding"])
+	assert.False(t, b.Modified())
+	b.Close()
+
+	// only the start and the end of a long file are read for it
+	long := strings.Repeat("x\n", 3*modelineBytes)
+	for _, data := range [][]byte{
+		[]byte("# vim: fenc=latin1\ncaf\xe9\n" + long),
+		[]byte("caf\xe9\n" + long + "# vim: fenc=latin1\n"),
+	} {
+		enc, r, err := readModelineEncoding(bytes.NewReader(data), int64(len(data)))
+		assert.NoError(t, err)
+		assert.Equal(t, "latin1", enc)
+		rest, _ := ioutil.ReadAll(r)
+		assert.Equal(t, data, rest)
+	}
+	// a modeline in the middle of the file is not read
+	data = []byte("x\n" + long + "# vim: fenc=latin1\n" + long)
+	enc, _, _ := readModelineEncoding(bytes.NewReader(data), int64(len(data)))
+	assert.Equal(t, "", enc)
+
+	// an encoding set by the user is not overridden
+	config.GlobalSettings["encoding"] = "latin1"
+	data = []byte("# -*- coding: utf-8 -*-\ncaf\xc3\xa9\n")
+	b = NewBuffer(bytes.NewReader(data), int64(len(data)), "a.txt", Loc{-1, -1}, BTDefault)
+	config.GlobalSettings["encoding"] = "utf-8"
+	assert.Equal(t, "caf\u00c3\u00a9", string(b.LineBytes(1)))
+	assert.Equal(t, "latin1", b.Settings["encoding"])
+	b.Close()
+
+	config.GlobalSettings["modeline"] = false
+	defer func() {
+		config.GlobalSettings["modeline"] = true
+	}()
+	b = NewBufferFromString("-*- mode: python; tab-width: 8 -*-\n", "a.txt", BTDefault)
+	assert.Equal(t, "unknown", b.Settings["filetype"])
+	assert.Equal(t, float64(4), b.Settings["tabsize"])
+	b.Close()
+}
+
+func TestRawModelines(t *testing.T) {
+	var lines []string
+	for i := 0; i < 14; i++ {
+		lines = append(lines, "x")
+	}
+	lines[1] = "# vim: ts=2\r"
+	lines[5] = "# vim: ts=3"
+	lines[12] = "# vim: et"
+	text := strings.Join(lines, "\n")
+
+	b := NewBufferFromString(text, "", BTDefault)
+	defer b.Close()
+	assert.Equal(t, []modeline{{1, map[string]string{"ts": "2"}}, {12, map[string]string{"et": "true"}}}, rawModelines([]byte(text)))
+	assert.Equal(t, b.modelines(), rawModelines([]byte(text)))
+}
@@ -0,0 +1,151 @@
+package buffer
+
+import (
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+// A ReplacePreview describes a replacement that is shown in the buffer
+// window before it is made. The buffer itself is not changed
+type ReplacePreview struct {
+	Search   *Search
+	Template []byte
+	// the ranges that the replacement is limited to
+	Ranges [][2]Loc
+
+	// the matches on the lines that were already drawn. A new preview is
+	// made whenever the prompt changes, so they are never out of date
+	matches map[int][]PreviewMatch
+}
+
+// A PreviewMatch is a match of a replace preview on a line. Start and End
+// are character positions in the line and Text is the text that would
+// replace the match
+type PreviewMatch struct {
+	Start, End int
+	Text       []byte
+}
+
+// PreviewMatches returns the matches of the replace preview on line y,
+// sorted by position. The replacements are expanded in the same way as
+// by ReplaceRegex
+func (b *Buffer) PreviewMatches(y int) []PreviewMatch {
+	p := b.ReplacePreview
+	if p == nil {
+		return nil
+	}
+	if m, ok := p.matches[y]; ok {
+		return m
+	}
+	if p.matches == nil {
+		p.matches = make(map[int][]PreviewMatch)
+	}
+	p.matches[y] = b.previewMatches(y)
+	return p.matches[y]
+}
+
+func (b *Buffer) previewMatches(y int) []PreviewMatch {
+	p := b.ReplacePreview
+
+	multiline := IsMultiline(p.Search.String())
+	var matches []PreviewMatch
+	for _, r := range p.Ranges {
+		start, end := r[0], r[1]
+		if start.GreaterThan(end) {
+			start, end = end, start
+		}
+		if multiline {
+			matches = append(matches, b.multilinePreviewMatches(start, end, y)...)
+			continue
+		}
+		if y < start.Y || y > end.Y {
+			continue
+		}
+
+		l := b.LineBytes(y)
+		charpos := 0
+		if y == end.Y {
+			l = util.SliceStart(l, end.X)
+		}
+		if y == start.Y {
+			l = util.SliceEnd(l, start.X)
+			charpos = start.X
+		}
+
+		for _, m := range p.Search.FindAllIndex(l, -1) {
+			s := charpos + util.RunePos(l, m[0])
+			e := charpos + util.RunePos(l, m[1])
+			in := l[m[0]:m[1]]
+			var text []byte
+			for _, submatches := range p.Search.FindAllSubmatchIndex(in, -1) {
+				text = append(text, p.Search.expand(p.Template, in, submatches)...)
+			}
+			matches = append(matches, PreviewMatch{
+				Start: s,
+				End:   e,
+				Text:  text,
+			})
+		}
+	}
+	return matches
+}
+
+// multilinePreviewMatches returns the matches of a replace preview that
+// can match across lines on line y between start and end. The text that
+// replaces a match spanning several lines is shown on its last line
+func (b *Buffer) multilinePreviewMatches(start, end Loc, y int) []PreviewMatch {
+	p := b.ReplacePreview
+	n := util.CharacterCount(b.LineBytes(y))
+
+	var matches []PreviewMatch
+	b.multilineMatchesOn(p.Search, start, end, y, func(m [2]Loc, text []byte, submatches []int) {
+		pm := PreviewMatch{Start: 0, End: n + 1}
+		if m[0].Y == y {
+			pm.Start = m[0].X
+		}
+		if m[1].Y == y {
+			pm.End = m[1].X
+			pm.Text = p.Search.expand(p.Template, text, submatches)
+		}
+		matches = append(matches, pm)
+	})
+	return matches
+}
+
+// PreviewCount returns the number of matches of the replace preview in
+// the buffer. It stops counting after max matches, and more is true if
+// there are other matches after those
+func (b *Buffer) PreviewCount(max int) (n int, more bool) {
+	p := b.ReplacePreview
+	if p == nil {
+		return 0, false
+	}
+	if IsMultiline(p.Search.String()) {
+		for _, r := range p.Ranges {
+			start, end := r[0], r[1]
+			if start.GreaterThan(end) {
+				start, end = end, start
+			}
+			b.findAllMultiline(p.Search, start, end, func([2]Loc, []byte, []int) bool {
+				if n == max {
+					more = true
+					return false
+				}
+				n++
+				return true
+			})
+			if more {
+				break
+			}
+		}
+		return n, more
+	}
+	for y := 0; y < b.LinesNum(); y++ {
+		// the matches are not cached here, the preview only needs the
+		// visible lines
+		n += len(b.previewMatches(y))
+		if n > max {
+			return max, true
+		}
+	}
+	return n, false
+}
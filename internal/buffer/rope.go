@@ -0,0 +1,305 @@
+package buffer
+
+import "github.com/zyedidia/micro/v2/internal/util"
+
+// ropeLeafMax is the maximum number of lines stored in a single leaf of
+// the rope. Leaves are split when they grow past this size and small
+// neighbouring leaves are merged again when the tree is joined.
+const ropeLeafMax = 256
+
+// A ropeNode is a node in a height balanced (AVL) tree of lines. Leaves
+// hold a chunk of lines, inner nodes hold the total number of lines below
+// them so that indexing, inserting and deleting are all O(log n).
+type ropeNode struct {
+	left, right *ropeNode
+	lines       []*Line
+
+	length int
+	height int
+}
+
+// A lineRope stores the lines of a LineArray. It behaves like a []*Line
+// but edits in the middle of a large file do not need to move every line
+// after the edit.
+type lineRope struct {
+	root *ropeNode
+}
+
+func newRopeLeaf(lines []*Line) *ropeNode {
+	return &ropeNode{
+		lines:  lines,
+		length: len(lines),
+		height: 1,
+	}
+}
+
+func newRopeNode(left, right *ropeNode) *ropeNode {
+	n := &ropeNode{left: left, right: right}
+	n.update()
+	return n
+}
+
+func (n *ropeNode) isLeaf() bool {
+	return n.left == nil && n.right == nil
+}
+
+func (n *ropeNode) update() {
+	n.length = n.left.length + n.right.length
+	n.height = 1 + util.Max(n.left.height, n.right.height)
+}
+
+func (n *ropeNode) balance() int {
+	return n.left.height - n.right.height
+}
+
+func ropeLen(n *ropeNode) int {
+	if n == nil {
+		return 0
+	}
+	return n.length
+}
+
+func rotateRight(n *ropeNode) *ropeNode {
+	l := n.left
+	n.left = l.right
+	n.update()
+	l.right = n
+	l.update()
+	return l
+}
+
+func rotateLeft(n *ropeNode) *ropeNode {
+	r := n.right
+	n.right = r.left
+	n.update()
+	r.left = n
+	r.update()
+	return r
+}
+
+// rebalance restores the AVL invariant for a node whose children differ
+// in height by at most two
+func rebalance(n *ropeNode) *ropeNode {
+	n.update()
+	switch b := n.balance(); {
+	case b > 1:
+		if n.left.balance() < 0 {
+			n.left = rotateLeft(n.left)
+		}
+		return rotateRight(n)
+	case b < -1:
+		if n.right.balance() > 0 {
+			n.right = rotateRight(n.right)
+		}
+		return rotateLeft(n)
+	}
+	return n
+}
+
+// ropeJoin concatenates two trees. Either tree may be nil. The cost is
+// proportional to the difference in height of the two trees.
+func ropeJoin(l, r *ropeNode) *ropeNode {
+	if l == nil {
+		return r
+	}
+	if r == nil {
+		return l
+	}
+	if l.isLeaf() && r.isLeaf() && l.length+r.length <= ropeLeafMax {
+		lines := make([]*Line, 0, l.length+r.length)
+		lines = append(lines, l.lines...)
+		lines = append(lines, r.lines...)
+		return newRopeLeaf(lines)
+	}
+
+	switch {
+	case l.height > r.height+1:
+		l.right = ropeJoin(l.right, r)
+		return rebalance(l)
+	case r.height > l.height+1:
+		r.left = ropeJoin(l, r.left)
+		return rebalance(r)
+	}
+	return newRopeNode(l, r)
+}
+
+// ropeSplit splits a tree into the first i lines and the remaining lines
+func ropeSplit(n *ropeNode, i int) (*ropeNode, *ropeNode) {
+	if n == nil {
+		return nil, nil
+	}
+	if i <= 0 {
+		return nil, n
+	}
+	if i >= n.length {
+		return n, nil
+	}
+	if n.isLeaf() {
+		left := make([]*Line, i)
+		copy(left, n.lines[:i])
+		right := make([]*Line, n.length-i)
+		copy(right, n.lines[i:])
+		return newRopeLeaf(left), newRopeLeaf(right)
+	}
+	if i < n.left.length {
+		ll, lr := ropeSplit(n.left, i)
+		return ll, ropeJoin(lr, n.right)
+	}
+	rl, rr := ropeSplit(n.right, i-n.left.length)
+	return ropeJoin(n.left, rl), rr
+}
+
+// buildRope creates a balanced tree from a list of lines
+func buildRope(lines []*Line) *ropeNode {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	leaves := make([]*ropeNode, 0, len(lines)/ropeLeafMax+1)
+	for len(lines) > 0 {
+		n := len(lines)
+		if n > ropeLeafMax {
+			n = ropeLeafMax
+		}
+		// leave some room in each leaf for insertions
+		chunk := make([]*Line, n, ropeLeafMax)
+		copy(chunk, lines[:n])
+		leaves = append(leaves, newRopeLeaf(chunk))
+		lines = lines[n:]
+	}
+
+	var build func(nodes []*ropeNode) *ropeNode
+	build = func(nodes []*ropeNode) *ropeNode {
+		if len(nodes) == 1 {
+			return nodes[0]
+		}
+		mid := len(nodes) / 2
+		return newRopeNode(build(nodes[:mid]), build(nodes[mid:]))
+	}
+	return build(leaves)
+}
+
+// ropeInsert inserts lines so that the first one ends up at index i
+func ropeInsert(n *ropeNode, i int, lines []*Line) *ropeNode {
+	if n == nil {
+		return buildRope(lines)
+	}
+	if n.isLeaf() {
+		if n.length+len(lines) <= ropeLeafMax {
+			n.lines = append(n.lines, lines...)
+			copy(n.lines[i+len(lines):], n.lines[i:])
+			copy(n.lines[i:], lines)
+			n.length = len(n.lines)
+			return n
+		}
+		all := make([]*Line, 0, n.length+len(lines))
+		all = append(all, n.lines[:i]...)
+		all = append(all, lines...)
+		all = append(all, n.lines[i:]...)
+		return buildRope(all)
+	}
+	if i <= n.left.length {
+		return ropeJoin(ropeInsert(n.left, i, lines), n.right)
+	}
+	return ropeJoin(n.left, ropeInsert(n.right, i-n.left.length, lines))
+}
+
+// ropeDelete removes the lines in the range [start, end)
+func ropeDelete(n *ropeNode, start, end int) *ropeNode {
+	if n == nil || start >= end || end <= 0 || start >= n.length {
+		return n
+	}
+	if start <= 0 && end >= n.length {
+		return nil
+	}
+	if n.isLeaf() {
+		if start < 0 {
+			start = 0
+		}
+		if end > n.length {
+			end = n.length
+		}
+		k := copy(n.lines[start:], n.lines[end:])
+		for j := start + k; j < n.length; j++ {
+			n.lines[j] = nil
+		}
+		n.lines = n.lines[:start+k]
+		n.length = len(n.lines)
+		return n
+	}
+	// deleting from the left subtree may change its length in place
+	leftLen := n.left.length
+	l := ropeDelete(n.left, start, end)
+	r := ropeDelete(n.right, start-leftLen, end-leftLen)
+	return ropeJoin(l, r)
+}
+
+// Len returns the number of lines in the rope
+func (r *lineRope) Len() int {
+	return ropeLen(r.root)
+}
+
+// leaf returns the leaf containing line i and the index of the line
+// within that leaf
+func (r *lineRope) leaf(i int) (*ropeNode, int) {
+	n := r.root
+	for !n.isLeaf() {
+		if i < n.left.length {
+			n = n.left
+		} else {
+			i -= n.left.length
+			n = n.right
+		}
+	}
+	return n, i
+}
+
+// Get returns the line at index i
+func (r *lineRope) Get(i int) *Line {
+	n, j := r.leaf(i)
+	return n.lines[j]
+}
+
+// Insert inserts lines before index i
+func (r *lineRope) Insert(i int, lines ...*Line) {
+	r.root = ropeInsert(r.root, i, lines)
+}
+
+// Delete removes the lines in the range [start, end)
+func (r *lineRope) Delete(start, end int) {
+	r.root = ropeDelete(r.root, start, end)
+}
+
+// Set replaces the line at index i
+func (r *lineRope) Set(i int, l *Line) {
+	n, j := r.leaf(i)
+	n.lines[j] = l
+}
+
+// ForEach calls fn for every line starting at index from, in order, until
+// fn returns false
+func (r *lineRope) ForEach(from int, fn func(i int, l *Line) bool) {
+	var walk func(n *ropeNode, offset int) bool
+	walk = func(n *ropeNode, offset int) bool {
+		if n == nil || offset+n.length <= from {
+			return true
+		}
+		if n.isLeaf() {
+			start := 0
+			if from > offset {
+				start = from - offset
+			}
+			for j := start; j < n.length; j++ {
+				if !fn(offset+j, n.lines[j]) {
+					return false
+				}
+			}
+			return true
+		}
+		if !walk(n.left, offset) {
+			return false
+		}
+		return walk(n.right, offset+n.left.length)
+	}
+	walk(r.root, 0)
+}
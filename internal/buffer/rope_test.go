@@ -0,0 +1,122 @@
+package buffer
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+func makeLines(start, n int) []*Line {
+	lines := make([]*Line, n)
+	for i := range lines {
+		lines[i] = &Line{data: []byte(strconv.Itoa(start + i))}
+	}
+	return lines
+}
+
+func checkRope(t *testing.T, r *lineRope, expected []*Line) {
+	assert.Equal(t, len(expected), r.Len())
+	for i, l := range expected {
+		if r.Get(i) != l {
+			t.Fatalf("line %d: expected %q, got %q", i, l.data, r.Get(i).data)
+		}
+	}
+	r.ForEach(0, func(i int, l *Line) bool {
+		assert.Equal(t, expected[i], l)
+		return true
+	})
+}
+
+func checkBalanced(t *testing.T, n *ropeNode) int {
+	if n == nil || n.isLeaf() {
+		return ropeLen(n)
+	}
+	length := checkBalanced(t, n.left) + checkBalanced(t, n.right)
+	assert.Equal(t, length, n.length)
+	b := n.balance()
+	if b < -1 || b > 1 {
+		t.Fatalf("unbalanced node: %d", b)
+	}
+	return length
+}
+
+func TestRope(t *testing.T) {
+	r := new(lineRope)
+	var expected []*Line
+
+	rnd := rand.New(rand.NewSource(1))
+	next := 0
+	for i := 0; i < 2000; i++ {
+		if len(expected) == 0 || rnd.Intn(3) > 0 {
+			n := 1 + rnd.Intn(10)
+			if rnd.Intn(20) == 0 {
+				n = rnd.Intn(2000)
+			}
+			lines := makeLines(next, n)
+			next += n
+
+			at := rnd.Intn(len(expected) + 1)
+			r.Insert(at, lines...)
+			expected = append(expected[:at], append(lines, expected[at:]...)...)
+		} else {
+			start := rnd.Intn(len(expected))
+			end := start + rnd.Intn(util.Min(len(expected)-start, 300)) + 1
+			r.Delete(start, end)
+			expected = append(expected[:start], expected[end:]...)
+		}
+		checkBalanced(t, r.root)
+	}
+	checkRope(t, r, expected)
+}
+
+func TestLazyLineArray(t *testing.T) {
+	f, err := ioutil.TempFile("", "micro-test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(f.Name())
+	// a line longer than the read buffer, with its \r and \n read apart
+	long := strings.Repeat("x", 64*1024-1)
+	text := "foo\r\nb\xffr\r\n" + long + "\r\nbaz"
+	f.WriteString(text)
+	f.Seek(0, io.SeekStart)
+
+	la, err := newLazyLineArray(f, int64(len(text)), FFAuto)
+	f.Close()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, FileFormat(FFDos), la.Endings)
+	assert.Equal(t, 4, la.LinesNum())
+	assert.Equal(t, long, string(la.LineBytes(2)))
+	// invalid UTF-8 is replaced
+	assert.Equal(t, "b\uFFFDr", string(la.LineBytes(1)))
+
+	la.insert(Loc{3, 0}, []byte("d"))
+	la.insert(Loc{1, 3}, []byte("\n"))
+	assert.Equal(t, "food\r\nb\uFFFDr\r\n"+long+"\r\nb\r\naz", string(la.Bytes()))
+
+	la.detach()
+	data, _ := ioutil.ReadFile(f.Name())
+	assert.Equal(t, text, string(data))
+
+	// the lines that are not read yet are empty once the file is truncated
+	f, _ = os.Open(f.Name())
+	la, err = newLazyLineArray(f, int64(len(text)), FFAuto)
+	f.Close()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "foo", string(la.LineBytes(0)))
+	assert.NoError(t, os.Truncate(f.Name(), 4))
+	assert.Equal(t, "", string(la.LineBytes(3)))
+	assert.Equal(t, "foo", string(la.LineBytes(0)))
+	la.detach()
+}
@@ -118,10 +118,11 @@ func (b *Buffer) saveToFile(filename string, withSudo bool) error {
 	}
 
 	if b.Settings["rmtrailingws"].(bool) {
-		for i, l := range b.lines {
-			leftover := util.CharacterCount(bytes.TrimRightFunc(l.data, unicode.IsSpace))
+		for i := 0; i < b.LinesNum(); i++ {
+			l := b.LineBytes(i)
+			leftover := util.CharacterCount(bytes.TrimRightFunc(l, unicode.IsSpace))
 
-			linelen := util.CharacterCount(l.data)
+			linelen := util.CharacterCount(l)
 			b.Remove(Loc{leftover, i}, Loc{linelen, i})
 		}
 
@@ -168,8 +169,14 @@ func (b *Buffer) saveToFile(filename string, withSudo bool) error {
 		return err
 	}
 
+	// the file is truncated when it is written, so the lines that are not
+	// read yet must be read first
+	b.textLock.Lock()
+	b.detach()
+	b.textLock.Unlock()
+
 	fwriter := func(file io.Writer) (e error) {
-		if len(b.lines) == 0 {
+		if b.LinesNum() == 0 {
 			return
 		}
 
@@ -182,19 +189,19 @@ func (b *Buffer) saveToFile(filename string, withSudo bool) error {
 		}
 
 		// write lines
-		if fileSize, e = file.Write(b.lines[0].data); e != nil {
-			return
-		}
-
-		for _, l := range b.lines[1:] {
-			if _, e = file.Write(eol); e != nil {
-				return
+		b.forEach(0, func(i int, l *Line) bool {
+			if i > 0 {
+				if _, e = file.Write(eol); e != nil {
+					return false
+				}
+				fileSize += len(eol)
 			}
 			if _, e = file.Write(l.data); e != nil {
-				return
+				return false
 			}
-			fileSize += len(eol) + len(l.data)
-		}
+			fileSize += len(l.data)
+			return true
+		})
 		return
 	}
 
@@ -203,7 +210,7 @@ func (b *Buffer) saveToFile(filename string, withSudo bool) error {
 	}
 
 	if !b.Settings["fastdirty"].(bool) {
-		if fileSize > LargeFileThreshold {
+		if fileSize > LargeFileThreshold && !b.LargeFile {
 			// For large files 'fastdirty' needs to be on
 			b.Settings["fastdirty"] = true
 		} else {
@@ -215,6 +222,7 @@ func (b *Buffer) saveToFile(filename string, withSudo bool) error {
 	absPath, _ := filepath.Abs(filename)
 	b.AbsPath = absPath
 	b.isModified = false
+	b.Tree.saved()
 	b.UpdateRules()
 	return err
 }
@@ -2,11 +2,252 @@ package buffer
 
 import (
 	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/zyedidia/micro/v2/internal/util"
 )
 
-func (b *Buffer) findDown(r *regexp.Regexp, start, end Loc) ([2]Loc, bool) {
+// multilineWindow is the number of lines in a block of a multi-line
+// search. The matches that start in a block are searched for in the block
+// and the next one, so a match can span at most this many lines
+const multilineWindow = 100
+
+// SearchFlags are the options of a search besides the ignorecase and
+// smartcase options of the buffer
+type SearchFlags struct {
+	// Regex is set if the pattern is a regular expression rather than a
+	// literal string
+	Regex bool
+	// MatchCase makes the search case sensitive even if it would ignore
+	// case because of the options
+	MatchCase bool
+	// WholeWord limits the matches to whole words: a match must start and
+	// end at a word boundary, as \b in a regular expression
+	WholeWord bool
+}
+
+// A Search is a compiled search pattern
+type Search struct {
+	*regexp.Regexp
+	// PreserveCase makes a replacement take the case of the text that it
+	// replaces (see preserveCase)
+	PreserveCase bool
+}
+
+// expand returns the replacement template expanded for the match of r in
+// src given by submatches
+func (r *Search) expand(template, src []byte, submatches []int) []byte {
+	result := r.Expand([]byte{}, template, src, submatches)
+	if r.PreserveCase {
+		return []byte(preserveCase(string(src[submatches[0]:submatches[1]]), string(result)))
+	}
+	return result
+}
+
+// preserveCase returns repl with the case of match: all lower case, all
+// upper case, or starting with an upper case letter for Title and
+// PascalCase or with a lower case one for camelCase. The parts of a
+// snake_case or kebab-case match each keep their case if repl has as many
+// parts
+func preserveCase(match, repl string) string {
+	if repl == "" {
+		return repl
+	}
+	lower, upper := 0, 0
+	for _, r := range match {
+		if unicode.IsLower(r) {
+			lower++
+		} else if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+	switch {
+	case lower > 0 && upper == 0:
+		return strings.ToLower(repl)
+	case upper > 1 && lower == 0:
+		return strings.ToUpper(repl)
+	}
+
+	for _, sep := range []string{"_", "-"} {
+		mparts := strings.Split(match, sep)
+		rparts := strings.Split(repl, sep)
+		if len(mparts) > 1 && len(mparts) == len(rparts) {
+			for i := range rparts {
+				rparts[i] = preserveCase(mparts[i], rparts[i])
+			}
+			return strings.Join(rparts, sep)
+		}
+	}
+
+	first, _ := utf8.DecodeRuneInString(match)
+	r, size := utf8.DecodeRuneInString(repl)
+	if unicode.IsUpper(first) {
+		return string(unicode.ToUpper(r)) + repl[size:]
+	} else if unicode.IsLower(first) {
+		return string(unicode.ToLower(r)) + repl[size:]
+	}
+	return repl
+}
+
+// IsMultiline returns whether a regular expression can match across line
+// boundaries, which is the case when it contains a newline, usually
+// written as \n. Such an expression is matched against several lines at
+// once, joined by newlines, rather than against each line on its own
+func IsMultiline(expr string) bool {
+	for i := 0; i < len(expr); i++ {
+		switch expr[i] {
+		case '\n':
+			return true
+		case '\\':
+			if i+1 < len(expr) && expr[i+1] == 'n' {
+				return true
+			}
+			i++
+		}
+	}
+	return false
+}
+
+// A searchWindow is the text between two locations of the buffer, with
+// the lines joined by newlines, that a multi-line search matches against
+type searchWindow struct {
+	text  []byte
+	start Loc
+	// the byte offsets in text where each line starts
+	lines []int
+}
+
+func (b *Buffer) searchWindow(start, end Loc) *searchWindow {
+	w := &searchWindow{start: start}
+	for y := start.Y; y <= end.Y; y++ {
+		l := b.LineBytes(y)
+		if y == end.Y {
+			l = util.SliceStart(l, end.X)
+		}
+		if y == start.Y {
+			l = util.SliceEnd(l, start.X)
+		} else {
+			w.text = append(w.text, '\n')
+		}
+		w.lines = append(w.lines, len(w.text))
+		w.text = append(w.text, l...)
+	}
+	return w
+}
+
+// loc returns the location of byte offset i of the window text
+func (w *searchWindow) loc(i int) Loc {
+	n := sort.Search(len(w.lines), func(k int) bool {
+		return w.lines[k] > i
+	}) - 1
+	x := util.RunePos(w.text[w.lines[n]:], i-w.lines[n])
+	if n == 0 {
+		x += w.start.X
+	}
+	return Loc{x, w.start.Y + n}
+}
+
+// blockMatches returns the matches of r between start and end that start
+// in the block of lines from y, as submatch indices in the returned window
+func (b *Buffer) blockMatches(r *Search, start, end Loc, y int) (*searchWindow, [][]int) {
+	from := Loc{0, y}
+	if from.LessThan(start) {
+		from = start
+	}
+	last := util.Min(y+2*multilineWindow-1, b.LinesNum()-1)
+	to := Loc{util.CharacterCount(b.LineBytes(last)), last}
+	if end.LessThan(to) {
+		to = end
+	}
+
+	w := b.searchWindow(from, to)
+	var matches [][]int
+	for _, m := range r.FindAllSubmatchIndex(w.text, -1) {
+		loc := [2]Loc{w.loc(m[0]), w.loc(m[1])}
+		if loc[0].Y >= y+multilineWindow {
+			break
+		}
+		matches = append(matches, m)
+	}
+	return w, matches
+}
+
+// findAllMultiline calls fn with the matches of r between start and end,
+// from the top, until it returns false. fn is also given the text that
+// was matched against and the submatch indices of the match in it
+func (b *Buffer) findAllMultiline(r *Search, start, end Loc, fn func(m [2]Loc, text []byte, submatches []int) bool) {
+	// the end of the last match, as a match of one block may overlap
+	// with a match of the next one
+	prev := start
+	for y := start.Y; y <= end.Y; y += multilineWindow {
+		w, matches := b.blockMatches(r, start, end, y)
+		for _, m := range matches {
+			loc := [2]Loc{w.loc(m[0]), w.loc(m[1])}
+			if loc[0].LessThan(prev) {
+				continue
+			}
+			if !fn(loc, w.text, m) {
+				return
+			}
+			prev = loc[1]
+		}
+	}
+}
+
+// multilineMatchesOn calls fn with the matches of r between start and end
+// that are at least partly on line y
+func (b *Buffer) multilineMatchesOn(r *Search, start, end Loc, y int, fn func(m [2]Loc, text []byte, submatches []int)) {
+	from := Loc{0, util.Max(y-multilineWindow+1, 0)}
+	if from.LessThan(start) {
+		from = start
+	}
+	last := util.Min(y+multilineWindow-1, b.LinesNum()-1)
+	to := Loc{util.CharacterCount(b.LineBytes(last)), last}
+	if end.LessThan(to) {
+		to = end
+	}
+	if to.LessThan(from) {
+		return
+	}
+
+	b.findAllMultiline(r, from, to, func(m [2]Loc, text []byte, submatches []int) bool {
+		if m[0].Y > y {
+			return false
+		}
+		if m[1].Y >= y {
+			fn(m, text, submatches)
+		}
+		return true
+	})
+}
+
+func (b *Buffer) findDownMultiline(r *Search, start, end Loc) ([2]Loc, bool) {
+	var match [2]Loc
+	found := false
+	b.findAllMultiline(r, start, end, func(m [2]Loc, _ []byte, _ []int) bool {
+		match, found = m, true
+		return false
+	})
+	return match, found
+}
+
+func (b *Buffer) findUpMultiline(r *Search, start, end Loc) ([2]Loc, bool) {
+	// look at the blocks from the bottom
+	y := start.Y + (end.Y-start.Y)/multilineWindow*multilineWindow
+	for ; y >= start.Y; y -= multilineWindow {
+		w, matches := b.blockMatches(r, start, end, y)
+		if len(matches) > 0 {
+			m := matches[len(matches)-1]
+			return [2]Loc{w.loc(m[0]), w.loc(m[1])}, true
+		}
+	}
+	return [2]Loc{}, false
+}
+
+func (b *Buffer) findDown(r *Search, start, end Loc) ([2]Loc, bool) {
 	lastcn := util.CharacterCount(b.LineBytes(b.LinesNum() - 1))
 	if start.Y > b.LinesNum()-1 {
 		start.X = lastcn - 1
@@ -20,6 +261,9 @@ func (b *Buffer) findDown(r *regexp.Regexp, start, end Loc) ([2]Loc, bool) {
 	if start.GreaterThan(end) {
 		start, end = end, start
 	}
+	if IsMultiline(r.String()) {
+		return b.findDownMultiline(r, start, end)
+	}
 
 	for i := start.Y; i <= end.Y; i++ {
 		l := b.LineBytes(i)
@@ -43,9 +287,7 @@ func (b *Buffer) findDown(r *regexp.Regexp, start, end Loc) ([2]Loc, bool) {
 			l = util.SliceStart(l, end.X)
 		}
 
-		match := r.FindIndex(l)
-
-		if match != nil {
+		for _, match := range r.FindAllIndex(l, -1) {
 			start := Loc{charpos + util.RunePos(l, match[0]), i}
 			end := Loc{charpos + util.RunePos(l, match[1]), i}
 			return [2]Loc{start, end}, true
@@ -54,7 +296,7 @@ func (b *Buffer) findDown(r *regexp.Regexp, start, end Loc) ([2]Loc, bool) {
 	return [2]Loc{}, false
 }
 
-func (b *Buffer) findUp(r *regexp.Regexp, start, end Loc) ([2]Loc, bool) {
+func (b *Buffer) findUp(r *Search, start, end Loc) ([2]Loc, bool) {
 	lastcn := util.CharacterCount(b.LineBytes(b.LinesNum() - 1))
 	if start.Y > b.LinesNum()-1 {
 		start.X = lastcn - 1
@@ -68,6 +310,9 @@ func (b *Buffer) findUp(r *regexp.Regexp, start, end Loc) ([2]Loc, bool) {
 	if start.GreaterThan(end) {
 		start, end = end, start
 	}
+	if IsMultiline(r.String()) {
+		return b.findUpMultiline(r, start, end)
+	}
 
 	for i := end.Y; i >= start.Y; i-- {
 		l := b.LineBytes(i)
@@ -93,8 +338,8 @@ func (b *Buffer) findUp(r *regexp.Regexp, start, end Loc) ([2]Loc, bool) {
 
 		allMatches := r.FindAllIndex(l, -1)
 
-		if allMatches != nil {
-			match := allMatches[len(allMatches)-1]
+		for k := len(allMatches) - 1; k >= 0; k-- {
+			match := allMatches[k]
 			start := Loc{charpos + util.RunePos(l, match[0]), i}
 			end := Loc{charpos + util.RunePos(l, match[1]), i}
 			return [2]Loc{start, end}, true
@@ -103,30 +348,103 @@ func (b *Buffer) findUp(r *regexp.Regexp, start, end Loc) ([2]Loc, bool) {
 	return [2]Loc{}, false
 }
 
+// IgnoreCase returns whether a search for s ignores case. It does if the
+// ignorecase option is on, unless the smartcase option is also on and s
+// contains an upper case letter
+func (b *Buffer) IgnoreCase(s string, flags SearchFlags) bool {
+	if flags.MatchCase || !b.Settings["ignorecase"].(bool) {
+		return false
+	}
+	return !b.Settings["smartcase"].(bool) || !hasUpper(s, flags.Regex)
+}
+
+// hasUpper returns whether s contains an upper case letter. The letters of
+// escapes such as \S, \pL or \p{Lu} in a regular expression are not
+// counted
+func hasUpper(s string, regex bool) bool {
+	escaped := false
+	// class is set after \p or \P, whose class name is either one letter
+	// or enclosed in braces; inBraces is set inside the braces
+	class := false
+	inBraces := false
+	for _, r := range s {
+		switch {
+		case inBraces:
+			inBraces = r != '}'
+		case class:
+			class = false
+			inBraces = r == '{'
+		case escaped:
+			escaped = false
+			class = r == 'p' || r == 'P'
+		case regex && r == '\\':
+			escaped = true
+		case unicode.IsUpper(r):
+			return true
+		}
+	}
+	return false
+}
+
+// CompileSearch compiles a search with the options of the buffer. In a
+// multi-line search, ^ and $ match at the start and end of every line
+func (b *Buffer) CompileSearch(s string, flags SearchFlags) (*Search, error) {
+	expr := s
+	if !flags.Regex {
+		expr = regexp.QuoteMeta(s)
+	}
+	if flags.WholeWord {
+		expr = `\b(?:` + expr + `)\b`
+	}
+	if flags.Regex && IsMultiline(s) {
+		expr = "(?m)" + expr
+	}
+	if b.IgnoreCase(s, flags) {
+		expr = "(?i)" + expr
+	}
+
+	r, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Search{Regexp: r}, nil
+}
+
+// LastSearchFlags returns the options of the last search
+func (b *Buffer) LastSearchFlags() SearchFlags {
+	return SearchFlags{
+		Regex:     b.LastSearchRegex,
+		MatchCase: b.LastSearchMatchCase,
+		WholeWord: b.LastSearchWholeWord,
+	}
+}
+
 // FindNext finds the next occurrence of a given string in the buffer
 // It returns the start and end location of the match (if found) and
 // a boolean indicating if it was found
 // May also return an error if the search regex is invalid
 func (b *Buffer) FindNext(s string, start, end, from Loc, down bool, useRegex bool) ([2]Loc, bool, error) {
+	return b.FindNextFlags(s, start, end, from, down, SearchFlags{Regex: useRegex})
+}
+
+// FindNextFlags is FindNext with more search options
+func (b *Buffer) FindNextFlags(s string, start, end, from Loc, down bool, flags SearchFlags) ([2]Loc, bool, error) {
 	if s == "" {
 		return [2]Loc{}, false, nil
 	}
 
-	var r *regexp.Regexp
-	var err error
-
-	if !useRegex {
-		s = regexp.QuoteMeta(s)
+	r, err := b.CompileSearch(s, flags)
+	if err != nil {
+		return [2]Loc{}, false, err
 	}
 
-	if b.Settings["ignorecase"].(bool) {
-		r, err = regexp.Compile("(?i)" + s)
-	} else {
-		r, err = regexp.Compile(s)
+	if start.GreaterThan(end) {
+		start, end = end, start
 	}
-
-	if err != nil {
-		return [2]Loc{}, false, err
+	if from.LessThan(start) {
+		from = start
+	} else if from.GreaterThan(end) {
+		from = end
 	}
 
 	var found bool
@@ -145,20 +463,79 @@ func (b *Buffer) FindNext(s string, start, end, from Loc, down bool, useRegex bo
 	return l, found, nil
 }
 
+// FindAll returns the matches of r between start and end, from the top
+func (b *Buffer) FindAll(r *Search, start, end Loc) [][2]Loc {
+	if start.GreaterThan(end) {
+		start, end = end, start
+	}
+
+	var matches [][2]Loc
+	if IsMultiline(r.String()) {
+		b.findAllMultiline(r, start, end, func(m [2]Loc, _ []byte, _ []int) bool {
+			matches = append(matches, m)
+			return true
+		})
+		return matches
+	}
+
+	// match against whole lines, so that anchors such as ^ and \b only
+	// match where they would in the line
+	for y := start.Y; y <= end.Y && y < b.LinesNum(); y++ {
+		l := b.LineBytes(y)
+		for _, m := range r.FindAllIndex(l, -1) {
+			loc := [2]Loc{{util.RunePos(l, m[0]), y}, {util.RunePos(l, m[1]), y}}
+			if loc[0].LessThan(start) || end.LessThan(loc[1]) {
+				continue
+			}
+			matches = append(matches, loc)
+		}
+	}
+	return matches
+}
+
+// SearchBounds returns the range that searches from the cursor are limited
+// to, which is its search range if the last search was in the selection
+// and the whole buffer otherwise
+func (b *Buffer) SearchBounds(c *Cursor) (Loc, Loc) {
+	if b.LastSearchSelection {
+		return c.SearchRange[0], c.SearchRange[1]
+	}
+	return b.Start(), b.End()
+}
+
+// inSearchRange returns whether loc is in the search range of a cursor
+func (b *Buffer) inSearchRange(loc Loc) bool {
+	for _, c := range b.cursors {
+		if loc.GreaterEqual(c.SearchRange[0]) && loc.LessThan(c.SearchRange[1]) {
+			return true
+		}
+	}
+	return false
+}
+
 // ReplaceRegex replaces all occurrences of 'search' with 'replace' in the given area
 // and returns the number of replacements made and the number of runes
 // added or removed on the last line of the range
 func (b *Buffer) ReplaceRegex(start, end Loc, search *regexp.Regexp, replace []byte) (int, int) {
+	return b.ReplaceSearch(start, end, &Search{Regexp: search}, replace)
+}
+
+// ReplaceSearch is ReplaceRegex for a compiled search, whose matches may
+// be limited to whole words
+func (b *Buffer) ReplaceSearch(start, end Loc, search *Search, replace []byte) (int, int) {
 	if start.GreaterThan(end) {
 		start, end = end, start
 	}
+	if IsMultiline(search.String()) {
+		return b.replaceMultiline(start, end, search, replace)
+	}
 
 	netrunes := 0
 
 	found := 0
 	var deltas []Delta
 	for i := start.Y; i <= end.Y; i++ {
-		l := b.lines[i].data
+		l := b.LineBytes(i)
 		charpos := 0
 
 		if start.Y == end.Y && i == start.Y {
@@ -174,7 +551,7 @@ func (b *Buffer) ReplaceRegex(start, end Loc, search *regexp.Regexp, replace []b
 		newText := search.ReplaceAllFunc(l, func(in []byte) []byte {
 			result := []byte{}
 			for _, submatches := range search.FindAllSubmatchIndex(in, -1) {
-				result = search.Expand(result, replace, in, submatches)
+				result = append(result, search.expand(replace, in, submatches)...)
 			}
 			found++
 			if i == end.Y {
@@ -192,3 +569,32 @@ func (b *Buffer) ReplaceRegex(start, end Loc, search *regexp.Regexp, replace []b
 
 	return found, netrunes
 }
+
+// replaceMultiline is ReplaceRegex for an expression that can match across
+// lines
+func (b *Buffer) replaceMultiline(start, end Loc, search *Search, replace []byte) (int, int) {
+	var deltas []Delta
+	b.findAllMultiline(search, start, end, func(m [2]Loc, text []byte, submatches []int) bool {
+		result := search.expand(replace, text, submatches)
+		deltas = append(deltas, Delta{result, m[0], m[1]})
+		return true
+	})
+	if len(deltas) == 0 {
+		return 0, 0
+	}
+
+	// the text after end is not changed, so the number of characters
+	// after end on its line stays the same
+	after := util.CharacterCount(b.LineBytes(end.Y)) - end.X
+	fromBottom := b.LinesNum() - end.Y
+
+	// replace from the bottom so that the locations of the remaining
+	// matches stay valid when a replacement adds or removes lines
+	for i, j := 0, len(deltas)-1; i < j; i, j = i+1, j-1 {
+		deltas[i], deltas[j] = deltas[j], deltas[i]
+	}
+	b.MultipleReplace(deltas)
+
+	y := b.LinesNum() - fromBottom
+	return len(deltas), util.CharacterCount(b.LineBytes(y)) - after - end.X
+}
@@ -20,6 +20,7 @@ type SerializedBuffer struct {
 	EventHandler *EventHandler
 	Cursor       Loc
 	ModTime      time.Time
+	Bookmarks    []Bookmark
 }
 
 // Serialize serializes the buffer to config.ConfigDir/buffers
@@ -34,10 +35,18 @@ func (b *Buffer) Serialize() error {
 	name := filepath.Join(config.ConfigDir, "buffers", util.EscapePath(b.AbsPath))
 
 	return overwriteFile(name, encoding.Nop, func(file io.Writer) error {
+		var marks []Bookmark
+		if b.Settings["savecursor"].(bool) {
+			marks = b.serializedBookmarks()
+		}
+		// the undo and redo stacks are rebuilt from the undo tree when the
+		// buffer is loaded, so only the tree is stored to write every
+		// event once
 		err := gob.NewEncoder(file).Encode(SerializedBuffer{
-			b.EventHandler,
+			&EventHandler{Tree: b.EventHandler.Tree},
 			b.GetActiveCursor().Loc,
 			b.ModTime,
+			marks,
 		})
 		return err
 	}, false)
@@ -61,6 +70,7 @@ func (b *Buffer) Unserialize() error {
 		}
 		if b.Settings["savecursor"].(bool) {
 			b.StartCursor = buffer.Cursor
+			b.setSerializedBookmarks(buffer.Bookmarks)
 		}
 
 		if b.Settings["saveundo"].(bool) {
@@ -69,6 +79,7 @@ func (b *Buffer) Unserialize() error {
 				b.EventHandler = buffer.EventHandler
 				b.EventHandler.cursors = b.cursors
 				b.EventHandler.buf = b.SharedBuffer
+				b.EventHandler.initUndoTree()
 			}
 		}
 	}
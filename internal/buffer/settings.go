@@ -1,19 +1,24 @@
 package buffer
 
 import (
+	luar "layeh.com/gopher-luar"
+
 	"github.com/zyedidia/micro/v2/internal/config"
+	ulua "github.com/zyedidia/micro/v2/internal/lua"
 	"github.com/zyedidia/micro/v2/internal/screen"
 )
 
 func (b *Buffer) SetOptionNative(option string, nativeValue interface{}) error {
+	oldValue := b.Settings[option]
 	b.Settings[option] = nativeValue
 
 	if option == "fastdirty" {
 		if !nativeValue.(bool) {
-			if !b.Modified() {
+			// the hash is not up to date yet, so Modified cannot be used
+			if !b.isModified {
 				e := calcHash(b, &b.origHash)
 				if e == ErrFileTooLarge {
-					b.Settings["fastdirty"] = false
+					b.Settings["fastdirty"] = true
 				}
 			}
 		}
@@ -80,6 +85,13 @@ func (b *Buffer) SetOptionNative(option string, nativeValue interface{}) error {
 		b.OptionCallback(option, nativeValue)
 	}
 
+	err := config.RunPluginFn("onBufferOptionChanged",
+		luar.New(ulua.L, b), luar.New(ulua.L, option),
+		luar.New(ulua.L, oldValue), luar.New(ulua.L, nativeValue))
+	if err != nil {
+		screen.TermMessage(err)
+	}
+
 	return nil
 }
 
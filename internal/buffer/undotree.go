@@ -0,0 +1,384 @@
+package buffer
+
+import (
+	"bytes"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+// An UndoNode is a state of the buffer in the undo tree. Every node except
+// the root is reached from its parent by executing Event
+type UndoNode struct {
+	Event    *TextEvent
+	Parent   int
+	Children []int
+}
+
+// An UndoTree keeps every state the buffer has been in, so that undoing
+// some changes and then making a new edit does not lose the undone changes
+// Nodes refer to each other by index so that the tree can be serialized
+// for the saveundo option
+type UndoTree struct {
+	Nodes []*UndoNode
+	// Cur is the index of the node for the current state of the buffer
+	Cur int
+	// Seq is the state that the text of the buffer corresponds to in the
+	// history. It is the same as Cur unless an older state was restored
+	// with RestoreState
+	Seq int
+	// Saves lists the states that were written to disk
+	Saves []int
+}
+
+func newUndoTree() *UndoTree {
+	return &UndoTree{
+		Nodes: []*UndoNode{{Parent: -1}},
+	}
+}
+
+// add records a new event as a child of the current state and makes it
+// the current state
+func (t *UndoTree) add(e *TextEvent) {
+	n := len(t.Nodes)
+	t.Nodes = append(t.Nodes, &UndoNode{Event: e, Parent: t.Cur})
+	t.Nodes[t.Cur].Children = append(t.Nodes[t.Cur].Children, n)
+	t.Cur = n
+	t.Seq = n
+}
+
+// up moves the current state to its parent, after its event was undone
+func (t *UndoTree) up() {
+	if t.Cur > 0 {
+		t.Cur = t.Nodes[t.Cur].Parent
+		t.Seq = t.Cur
+	}
+}
+
+// down moves the current state to the child that is reached by the
+// given event, after the event was redone
+func (t *UndoTree) down(e *TextEvent) {
+	for _, c := range t.Nodes[t.Cur].Children {
+		if t.Nodes[c].Event == e {
+			t.Cur = c
+			t.Seq = c
+			return
+		}
+	}
+}
+
+// Path returns the indices of the nodes from the root to node n
+func (t *UndoTree) Path(n int) []int {
+	var path []int
+	for ; n >= 0; n = t.Nodes[n].Parent {
+		path = append(path, n)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// IsAncestor returns whether node a is node n or one of its ancestors
+func (t *UndoTree) IsAncestor(a, n int) bool {
+	for ; n >= 0; n = t.Nodes[n].Parent {
+		if n == a {
+			return true
+		}
+	}
+	return false
+}
+
+// OnPath returns whether the event of node n is currently applied to
+// the buffer, i.e. whether n is the current state or one of its ancestors
+func (t *UndoTree) OnPath(n int) bool {
+	return t.IsAncestor(n, t.Cur)
+}
+
+// Time returns the time at which the state n was created
+func (t *UndoTree) Time(n int) time.Time {
+	if e := t.Nodes[n].Event; e != nil {
+		return e.Time
+	}
+	return time.Time{}
+}
+
+// Merged returns whether node n and its only child are shown as a single
+// state, because Undo would undo both of them at once
+func (t *UndoTree) Merged(n int) bool {
+	if n == 0 || n == t.Cur || len(t.Nodes[n].Children) != 1 {
+		return false
+	}
+	c := t.Nodes[n].Children[0]
+	return undoGroup(t.Time(n)) == undoGroup(t.Time(c))
+}
+
+// undoGroup returns the interval of undoThreshold milliseconds that the
+// given time is in. Undo undoes all the events of an interval together
+func undoGroup(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond) / undoThreshold
+}
+
+// redoPath returns the nodes below n obtained by always following the
+// most recent child
+func (t *UndoTree) redoPath(n int) []int {
+	var path []int
+	for len(t.Nodes[n].Children) > 0 {
+		children := t.Nodes[n].Children
+		n = children[len(children)-1]
+		path = append(path, n)
+	}
+	return path
+}
+
+// setRedoPath makes the redo stack redo the events of the given nodes
+// in order
+func (eh *EventHandler) setRedoPath(path []int) {
+	eh.RedoStack = new(TEStack)
+	for i := len(path) - 1; i >= 0; i-- {
+		eh.RedoStack.Push(eh.Tree.Nodes[path[i]].Event)
+	}
+}
+
+// initUndoTree makes sure the event handler has an undo tree matching its
+// undo and redo stacks. If the event handler already has a tree (it was
+// unserialized), the stacks are rebuilt from it instead, since only the
+// tree is serialized
+func (eh *EventHandler) initUndoTree() {
+	if eh.Tree != nil && len(eh.Tree.Nodes) > 0 {
+		eh.UndoStack = new(TEStack)
+		for _, n := range eh.Tree.Path(eh.Tree.Cur)[1:] {
+			eh.UndoStack.Push(eh.Tree.Nodes[n].Event)
+		}
+		eh.setRedoPath(eh.Tree.redoPath(eh.Tree.Cur))
+		return
+	}
+
+	eh.Tree = newUndoTree()
+
+	var undo []*TextEvent
+	for e := eh.UndoStack.Top; e != nil; e = e.Next {
+		undo = append(undo, e.Value)
+	}
+	for i := len(undo) - 1; i >= 0; i-- {
+		eh.Tree.add(undo[i])
+	}
+
+	cur := eh.Tree.Cur
+	for e := eh.RedoStack.Top; e != nil; e = e.Next {
+		eh.Tree.add(e.Value)
+	}
+	eh.Tree.Cur = cur
+	eh.Tree.Seq = cur
+}
+
+// UndoTreeGoto changes the buffer to the state n of the undo tree, by
+// undoing changes up to the closest common ancestor and then redoing the
+// changes leading to n
+func (eh *EventHandler) UndoTreeGoto(n int) {
+	t := eh.Tree
+	if n < 0 || n >= len(t.Nodes) {
+		return
+	}
+
+	for !t.IsAncestor(t.Cur, n) && eh.UndoStack.Len() > 0 {
+		eh.UndoOneEvent()
+	}
+
+	path := t.Path(n)
+	for i, p := range path {
+		if p == t.Cur {
+			path = path[i+1:]
+			break
+		}
+	}
+	eh.setRedoPath(path)
+	for range path {
+		eh.RedoOneEvent()
+	}
+
+	eh.setRedoPath(t.redoPath(t.Cur))
+}
+
+// saved records that the current state was written to disk
+func (t *UndoTree) saved() {
+	if len(t.Saves) == 0 || t.Saves[len(t.Saves)-1] != t.Seq {
+		t.Saves = append(t.Saves, t.Seq)
+	}
+}
+
+// StateAt returns the most recent state that existed d after the time of
+// the current state. A negative d looks for an earlier state. States that
+// are older than the buffer are all the same as the original text
+func (t *UndoTree) StateAt(d time.Duration) int {
+	if t.Seq == 0 && d < 0 {
+		return 0
+	}
+	var target time.Time
+	if t.Seq == 0 {
+		target = t.Time(util.Min(1, len(t.Nodes)-1)).Add(d)
+	} else {
+		target = t.Time(t.Seq).Add(d)
+	}
+
+	// nodes are created in chronological order
+	n := 0
+	for i := 1; i < len(t.Nodes); i++ {
+		if t.Time(i).After(target) {
+			break
+		}
+		n = i
+	}
+	return n
+}
+
+// StateSteps returns the state that was created count states after the
+// current state in chronological order, or before it if count is negative
+func (t *UndoTree) StateSteps(count int) int {
+	return util.Clamp(t.Seq+count, 0, len(t.Nodes)-1)
+}
+
+// SaveSteps returns the state that was written to disk count saves after
+// the current state, or before it if count is negative. Going before the
+// first save returns the original text and going past the last save
+// returns the most recent state
+func (t *UndoTree) SaveSteps(count int) int {
+	saves := make([]int, len(t.Saves))
+	copy(saves, t.Saves)
+	sort.Ints(saves)
+
+	if count < 0 {
+		for i := len(saves) - 1; i >= 0; i-- {
+			if saves[i] < t.Seq {
+				count++
+				if count == 0 {
+					return saves[i]
+				}
+			}
+		}
+		return 0
+	}
+	for _, s := range saves {
+		if s > t.Seq {
+			count--
+			if count == 0 {
+				return s
+			}
+		}
+	}
+	return len(t.Nodes) - 1
+}
+
+// stateText returns the text of the buffer at state n, with lines
+// separated by '\n'. The events between the current state and n are
+// executed on a copy of the lines, so the buffer itself is not changed
+func (eh *EventHandler) stateText(n int) []byte {
+	t := eh.Tree
+	lines := make([]*Line, 0, eh.buf.LinesNum())
+	eh.buf.LineArray.forEach(0, func(i int, l *Line) bool {
+		lines = append(lines, &Line{data: append([]byte{}, l.data...)})
+		return true
+	})
+	la := &LineArray{Endings: eh.buf.Endings}
+	la.lines.root = buildRope(lines)
+
+	// undo the events up to the common ancestor of the current state and
+	// n, then redo the events down to n
+	cur := t.Cur
+	for ; !t.IsAncestor(cur, n); cur = t.Nodes[cur].Parent {
+		toggleEvent(la, t.Nodes[cur].Event)
+	}
+	path := t.Path(n)
+	for i, p := range path {
+		if p == cur {
+			path = path[i+1:]
+			break
+		}
+	}
+	for _, p := range path {
+		toggleEvent(la, t.Nodes[p].Event)
+	}
+	return joinLines(la)
+}
+
+// toggleEvent undoes e on la if it is applied, or redoes it if it was
+// undone, like UndoTextEvent does, but without modifying e
+func toggleEvent(la *LineArray, e *TextEvent) {
+	c := *e
+	c.EventType = -e.EventType
+	c.Deltas = append([]Delta(nil), e.Deltas...)
+	executeTextEvent(&c, la)
+}
+
+func joinLines(la *LineArray) []byte {
+	var b bytes.Buffer
+	la.forEach(0, func(i int, l *Line) bool {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.Write(l.data)
+		return true
+	})
+	return b.Bytes()
+}
+
+// RestoreState changes the text of the buffer to what it was at state n
+// of the undo tree. Unlike UndoTreeGoto, the change is a new edit that
+// can be undone, so the states that were undone are not lost
+func (eh *EventHandler) RestoreState(n int) {
+	t := eh.Tree
+	if n < 0 || n >= len(t.Nodes) || n == t.Seq {
+		return
+	}
+
+	old := joinLines(eh.buf.LineArray)
+	new := eh.stateText(n)
+
+	// only replace the part of the text that changed
+	prefix := 0
+	for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+		prefix++
+	}
+	for prefix > 0 && prefix < len(old) && !utf8.RuneStart(old[prefix]) {
+		prefix--
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(new)-prefix && old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+		suffix++
+	}
+	for suffix > 0 && !utf8.RuneStart(old[len(old)-suffix]) {
+		suffix--
+	}
+
+	if prefix != len(old) || prefix != len(new) {
+		start := offsetLoc(old, prefix)
+		end := offsetLoc(old, len(old)-suffix)
+		text := make([]byte, len(new)-suffix-prefix)
+		copy(text, new[prefix:])
+		// replace events only support text on a single line
+		if start != end {
+			eh.Remove(start, end)
+		}
+		if len(text) > 0 {
+			eh.InsertBytes(start, text)
+		}
+
+		if eh.active >= 0 && eh.active < len(eh.cursors) {
+			eh.cursors[eh.active].ResetSelection()
+			eh.cursors[eh.active].GotoLoc(start)
+		}
+	}
+	// the new state has the same text as n, so further steps through
+	// the history continue from n
+	t.Seq = n
+}
+
+// offsetLoc returns the location of the given byte offset in text
+func offsetLoc(text []byte, offset int) Loc {
+	text = text[:offset]
+	y := bytes.Count(text, []byte{'\n'})
+	x := util.CharacterCount(text[bytes.LastIndexByte(text, '\n')+1:])
+	return Loc{x, y}
+}
@@ -0,0 +1,30 @@
+package clipboard
+
+// For remembering which multi cursor clipboard contents were copied from
+// a block selection, so that pasting them inserts a block again
+type blockClipboard map[Register][]bool
+
+var blocks blockClipboard
+
+func (c blockClipboard) isBlock(r Register, num int, ncursors int) bool {
+	content := c[r]
+	return len(content) == ncursors && num < ncursors && content[num]
+}
+
+func (c blockClipboard) set(r Register, num int, ncursors int, block bool) {
+	content := c[r]
+	if content == nil || len(content) != ncursors {
+		content = make([]bool, ncursors)
+		c[r] = content
+	}
+
+	if num >= ncursors {
+		return
+	}
+
+	content[num] = block
+}
+
+func init() {
+	blocks = make(blockClipboard)
+}
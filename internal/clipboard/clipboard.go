@@ -2,6 +2,7 @@ package clipboard
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/zyedidia/clipper"
 )
@@ -75,6 +76,7 @@ func Read(r Register) (string, error) {
 
 // Write writes text to a clipboard register
 func Write(text string, r Register) error {
+	delete(blocks, r)
 	return write(text, r, CurrentMethod)
 }
 
@@ -92,9 +94,58 @@ func ReadMulti(r Register, num, ncursors int) (string, error) {
 
 // WriteMulti writes text to a clipboard register for a certain multi-cursor
 func WriteMulti(text string, r Register, num int, ncursors int) error {
+	blocks.set(r, num, ncursors, false)
+	if r == ClipboardReg {
+		history.add(text, num, ncursors, false, false)
+	}
+	return writeMulti(text, r, num, ncursors, CurrentMethod)
+}
+
+// AppendMulti writes text to a clipboard register for a certain
+// multi-cursor like WriteMulti, where text is the text of the register with
+// more text appended. The newest entry of the clipboard history is
+// replaced rather than a new one added
+func AppendMulti(text string, r Register, num int, ncursors int) error {
+	blocks.set(r, num, ncursors, false)
+	if r == ClipboardReg {
+		history.add(text, num, ncursors, false, true)
+	}
 	return writeMulti(text, r, num, ncursors, CurrentMethod)
 }
 
+// ReadBlock reads text from a clipboard register for a certain multi-cursor
+// like ReadMulti, and also returns whether the text was copied from a block
+// selection with WriteBlock
+func ReadBlock(r Register, num, ncursors int) (string, bool, error) {
+	clip, err := Read(r)
+	if err != nil {
+		return "", false, err
+	}
+	text, block := BlockText(r, clip, num, ncursors)
+	return text, block, nil
+}
+
+// BlockText returns the part of the text that was read from a clipboard
+// register which is for a certain multi-cursor, and whether it was copied
+// from a block selection, like ReadBlock
+func BlockText(r Register, clip string, num, ncursors int) (string, bool) {
+	if ValidMulti(r, clip, ncursors) {
+		return multi.getText(r, num), blocks.isBlock(r, num, ncursors)
+	}
+	return clip, false
+}
+
+// WriteBlock writes the lines of a block selection to a clipboard register
+// for a certain multi-cursor. Other programs receive the lines separated
+// by newlines
+func WriteBlock(rows []string, r Register, num int, ncursors int) error {
+	blocks.set(r, num, ncursors, true)
+	if r == ClipboardReg {
+		history.add(strings.Join(rows, "\n"), num, ncursors, true, false)
+	}
+	return writeMulti(strings.Join(rows, "\n"), r, num, ncursors, CurrentMethod)
+}
+
 // ValidMulti checks if the internal multi-clipboard is valid and up-to-date
 // with the system clipboard
 func ValidMulti(r Register, clip string, ncursors int) bool {
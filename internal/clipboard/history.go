@@ -0,0 +1,80 @@
+package clipboard
+
+import (
+	"strings"
+)
+
+// HistorySize is the maximum number of entries kept in the clipboard history
+var HistorySize = 30
+
+// A historyEntry is the text that was copied to the clipboard at once. Like
+// the multi-cursor clipboard it stores the text of every cursor separately
+type historyEntry struct {
+	text  []string
+	block []bool
+}
+
+// For remembering the text previously copied to the clipboard. The newest
+// entry is first
+type clipHistory []*historyEntry
+
+var history clipHistory
+
+// add stores the text copied by a certain multi-cursor. The text copied by
+// the first cursor starts a new entry and the text of the other cursors is
+// added to it. If appending is true, the text is the newest entry with more
+// text appended, which happens when cutting several lines in a row, so the
+// newest entry is replaced instead
+func (h *clipHistory) add(text string, num, ncursors int, block, appending bool) {
+	if num >= ncursors {
+		return
+	}
+
+	var e *historyEntry
+	if len(*h) > 0 {
+		e = (*h)[0]
+		if len(e.text) != ncursors || num == 0 && !appending {
+			e = nil
+		}
+	}
+	if e == nil {
+		e = &historyEntry{
+			text:  make([]string, ncursors),
+			block: make([]bool, ncursors),
+		}
+		*h = append(clipHistory{e}, *h...)
+		if len(*h) > HistorySize {
+			*h = (*h)[:HistorySize]
+		}
+	}
+
+	e.text[num] = text
+	e.block[num] = block
+}
+
+// HistoryLen returns the number of entries in the clipboard history
+func HistoryLen() int {
+	return len(history)
+}
+
+// ReadHistory returns the text of entry i of the clipboard history for a
+// certain multi-cursor, and whether it was copied from a block selection.
+// Entry 0 is the most recent one. If the entry was copied with a different
+// number of cursors, the text of all cursors is returned
+func ReadHistory(i, num, ncursors int) (string, bool) {
+	if i < 0 || i >= len(history) {
+		return "", false
+	}
+	e := history[i]
+	if len(e.text) == ncursors && num < ncursors {
+		return e.text[num], e.block[num]
+	}
+	return strings.Join(e.text, ""), false
+}
+
+// HistoryText returns the text of entry i of the clipboard history as it
+// is stored in the clipboard
+func HistoryText(i int) string {
+	text, _ := ReadHistory(i, -1, -1)
+	return text
+}
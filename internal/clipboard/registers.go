@@ -0,0 +1,139 @@
+package clipboard
+
+import (
+	"encoding/gob"
+	"errors"
+	"os"
+)
+
+const (
+	// SearchReg holds the last search (read-only)
+	SearchReg Register = '/'
+	// CommandReg holds the last command (read-only)
+	CommandReg Register = ':'
+	// FilenameReg holds the path of the current buffer (read-only)
+	FilenameReg Register = '%'
+)
+
+// ErrReadOnly is returned when writing to a read-only register
+var ErrReadOnly = errors.New("Register is read-only")
+
+// ParseRegister returns the register with the given name. The named
+// registers are 'a' to 'z', and using the uppercase letter appends to the
+// register instead of replacing it. '+' is the system clipboard, '*' the
+// primary clipboard, and '/', ':' and '%' are the read-only registers
+func ParseRegister(name string) (r Register, appendText bool, err error) {
+	runes := []rune(name)
+	if len(runes) != 1 {
+		return 0, false, errors.New("Invalid register name: " + name)
+	}
+	switch c := runes[0]; {
+	case c >= 'a' && c <= 'z':
+		return Register(c), false, nil
+	case c >= 'A' && c <= 'Z':
+		return Register(c - 'A' + 'a'), true, nil
+	case c == '+':
+		return ClipboardReg, false, nil
+	case c == '*':
+		return PrimaryReg, false, nil
+	case c == '/' || c == ':' || c == '%':
+		return Register(c), false, nil
+	}
+	return 0, false, errors.New("Invalid register name: " + name)
+}
+
+// Name returns the name of a register as accepted by ParseRegister
+func (r Register) Name() string {
+	switch r {
+	case ClipboardReg:
+		return "+"
+	case PrimaryReg:
+		return "*"
+	}
+	return string(rune(r))
+}
+
+// IsNamed returns whether r is one of the registers 'a' to 'z'
+func (r Register) IsNamed() bool {
+	return r >= 'a' && r <= 'z'
+}
+
+// ReadOnly returns whether the register can only be changed by micro
+func (r Register) ReadOnly() bool {
+	return r == SearchReg || r == CommandReg || r == FilenameReg
+}
+
+// NamedRegisters returns the registers that can be listed to the user, in
+// the order they should be listed
+func NamedRegisters() []Register {
+	regs := []Register{ClipboardReg, PrimaryReg}
+	for c := 'a'; c <= 'z'; c++ {
+		regs = append(regs, Register(c))
+	}
+	return append(regs, SearchReg, CommandReg, FilenameReg)
+}
+
+// SetReadOnly sets the contents of a read-only register
+func SetReadOnly(r Register, text string) {
+	if r.ReadOnly() {
+		internal.write(text, r)
+	}
+}
+
+// WriteRegister writes text to a register for a certain multi-cursor. If
+// appendText is true the text is added to the current contents of the
+// register
+func WriteRegister(text string, r Register, appendText bool, num, ncursors int) error {
+	if r.ReadOnly() {
+		return ErrReadOnly
+	}
+	if appendText {
+		clip, err := ReadMulti(r, num, ncursors)
+		if err != nil {
+			return err
+		}
+		text = clip + text
+	}
+	return WriteMulti(text, r, num, ncursors)
+}
+
+// LoadRegisters reads the named registers from a file written by
+// SaveRegisters
+func LoadRegisters(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	var regs map[Register]string
+	if err := gob.NewDecoder(file).Decode(&regs); err != nil {
+		return err
+	}
+	for r, text := range regs {
+		if r.IsNamed() {
+			internal.write(text, r)
+		}
+	}
+	return nil
+}
+
+// SaveRegisters writes the contents of the named registers to a file
+func SaveRegisters(filename string) error {
+	regs := make(map[Register]string)
+	for c := 'a'; c <= 'z'; c++ {
+		if text := internal.read(Register(c)); text != "" {
+			regs[Register(c)] = text
+		}
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return gob.NewEncoder(file).Encode(regs)
+}
@@ -0,0 +1,85 @@
+package clipboard
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRegister(t *testing.T) {
+	r, appendText, err := ParseRegister("a")
+	assert.NoError(t, err)
+	assert.Equal(t, Register('a'), r)
+	assert.False(t, appendText)
+
+	r, appendText, err = ParseRegister("A")
+	assert.NoError(t, err)
+	assert.Equal(t, Register('a'), r)
+	assert.True(t, appendText)
+
+	r, _, err = ParseRegister("+")
+	assert.NoError(t, err)
+	assert.Equal(t, ClipboardReg, r)
+	assert.Equal(t, "+", r.Name())
+
+	_, _, err = ParseRegister("ab")
+	assert.Error(t, err)
+	_, _, err = ParseRegister("!")
+	assert.Error(t, err)
+}
+
+func TestWriteRegister(t *testing.T) {
+	assert.NoError(t, WriteRegister("foo", 'b', false, 0, 1))
+	assert.NoError(t, WriteRegister("bar", 'b', true, 0, 1))
+	text, err := Read('b')
+	assert.NoError(t, err)
+	assert.Equal(t, "foobar", text)
+
+	assert.NoError(t, WriteRegister("baz", 'b', false, 0, 1))
+	text, _ = Read('b')
+	assert.Equal(t, "baz", text)
+
+	// the read-only registers can only be set by micro
+	for _, r := range []Register{SearchReg, CommandReg, FilenameReg} {
+		assert.True(t, r.ReadOnly())
+		assert.Equal(t, ErrReadOnly, WriteRegister("foo", r, false, 0, 1))
+		assert.Equal(t, ErrReadOnly, WriteRegister("foo", r, true, 0, 1))
+	}
+	SetReadOnly(SearchReg, "query")
+	text, _ = Read(SearchReg)
+	assert.Equal(t, "query", text)
+	SetReadOnly('b', "ignored")
+	text, _ = Read('b')
+	assert.Equal(t, "baz", text)
+}
+
+func TestSaveRegisters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "micro_registers")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "registers")
+
+	// a missing file is not an error
+	assert.NoError(t, LoadRegisters(filename))
+
+	assert.NoError(t, Write("saved", 'c'))
+	assert.NoError(t, Write("", 'd'))
+	SetReadOnly(CommandReg, "not saved")
+	assert.NoError(t, SaveRegisters(filename))
+
+	assert.NoError(t, Write("changed", 'c'))
+	SetReadOnly(CommandReg, "")
+	assert.NoError(t, LoadRegisters(filename))
+	text, _ := Read('c')
+	assert.Equal(t, "saved", text)
+	text, _ = Read('d')
+	assert.Equal(t, "", text)
+	text, _ = Read(CommandReg)
+	assert.Equal(t, "", text)
+
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("garbage"), 0644))
+	assert.Error(t, LoadRegisters(filename))
+}
@@ -9,7 +9,9 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 
+	"github.com/zyedidia/micro/v2/pkg/highlight"
 	rt "github.com/zyedidia/micro/v2/runtime"
 )
 
@@ -39,6 +41,10 @@ type RuntimeFile interface {
 var allFiles [][]RuntimeFile
 var realFiles [][]RuntimeFile
 
+// runtimeFilesVersion changes whenever runtime files are registered,
+// removed or changed, so that what is read from them can be cached
+var runtimeFilesVersion int
+
 func init() {
 	allFiles = make([][]RuntimeFile, NumTypes)
 	realFiles = make([][]RuntimeFile, NumTypes)
@@ -99,15 +105,138 @@ func (nf namedFile) Name() string {
 	return nf.name
 }
 
-// AddRuntimeFile registers a file for the given filetype
+// a TextMate or Sublime Text grammar, which is converted to a syntax file
+// the first time it is read
+type grammarFile struct {
+	RuntimeFile
+	filename string
+
+	once    sync.Once
+	grammar *highlight.Grammar
+	err     error
+}
+
+// the header of a grammar, made from the converted syntax file
+type grammarHeader struct {
+	*grammarFile
+}
+
+func (gf *grammarFile) Name() string {
+	return highlight.GrammarName(gf.filename)
+}
+
+func (gf *grammarFile) Data() ([]byte, error) {
+	gf.once.Do(func() {
+		data, err := gf.RuntimeFile.Data()
+		if err != nil {
+			gf.err = err
+			return
+		}
+		gf.grammar, gf.err = highlight.ConvertGrammar(gf.filename, "", data)
+		if gf.err == nil {
+			for _, w := range gf.grammar.Warnings {
+				log.Println(gf.filename + ": " + w)
+			}
+		}
+	})
+	if gf.err != nil {
+		return nil, gf.err
+	}
+	return gf.grammar.Data, nil
+}
+
+func (gh grammarHeader) Data() ([]byte, error) {
+	data, err := gh.grammarFile.Data()
+	if err != nil {
+		return nil, err
+	}
+	header, err := highlight.MakeHeaderYaml(data)
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{header.FileType, "", ""}
+	for i, r := range header.FtDetect {
+		if r != nil {
+			lines[i+1] = r.String()
+		}
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+// fileName returns the name of a runtime file with its extension
+func fileName(file RuntimeFile) string {
+	switch f := file.(type) {
+	case realFile:
+		return filepath.Base(string(f))
+	case namedFile:
+		return filepath.Base(string(f.realFile))
+	case assetFile:
+		return path.Base(string(f))
+	case memoryFile:
+		return f.name
+	}
+	return file.Name()
+}
+
+// syntaxFile returns a syntax file that converts a runtime file if it is
+// a grammar, or the runtime file itself
+func syntaxFile(fileType RTFiletype, file RuntimeFile) RuntimeFile {
+	if fileType == RTSyntax {
+		if name := fileName(file); highlight.IsGrammar(name) {
+			return &grammarFile{RuntimeFile: file, filename: name}
+		}
+	}
+	return file
+}
+
+// AddRuntimeFile registers a file for the given filetype. TextMate
+// (.tmLanguage.json) and Sublime Text (.sublime-syntax) grammars are
+// converted when they are registered as syntax files, and get a header
+// so that their filetype can be detected
 func AddRuntimeFile(fileType RTFiletype, file RuntimeFile) {
+	file = syntaxFile(fileType, file)
+	if gf, ok := file.(*grammarFile); ok {
+		allFiles[RTSyntaxHeader] = append(allFiles[RTSyntaxHeader], grammarHeader{gf})
+	}
 	allFiles[fileType] = append(allFiles[fileType], file)
+	runtimeFilesVersion++
 }
 
 // AddRealRuntimeFile registers a file for the given filetype
 func AddRealRuntimeFile(fileType RTFiletype, file RuntimeFile) {
+	file = syntaxFile(fileType, file)
 	allFiles[fileType] = append(allFiles[fileType], file)
 	realFiles[fileType] = append(realFiles[fileType], file)
+	runtimeFilesVersion++
+}
+
+// RemoveRuntimeFile unregisters the files of the given filetype with the
+// given name
+func RemoveRuntimeFile(fileType RTFiletype, name string) {
+	remove := func(files []RuntimeFile) []RuntimeFile {
+		kept := files[:0]
+		for _, f := range files {
+			if f.Name() != name {
+				kept = append(kept, f)
+			}
+		}
+		return kept
+	}
+	allFiles[fileType] = remove(allFiles[fileType])
+	realFiles[fileType] = remove(realFiles[fileType])
+	runtimeFilesVersion++
+}
+
+// RuntimeFilesChanged records that the content of a registered runtime
+// file changed, so that it is read again where it was cached
+func RuntimeFilesChanged() {
+	runtimeFilesVersion++
+}
+
+// RuntimeFilesVersion returns a number that changes whenever the runtime
+// files are registered, removed or changed
+func RuntimeFilesVersion() int {
+	return runtimeFilesVersion
 }
 
 // AddRuntimeFilesFromDirectory registers each file from the given directory for
@@ -158,6 +287,20 @@ func ListRealRuntimeFiles(fileType RTFiletype) []RuntimeFile {
 	return realFiles[fileType]
 }
 
+// HasRealRuntimeFile returns whether the file at the given path is
+// registered for the filetype
+func HasRealRuntimeFile(fileType RTFiletype, filename string) bool {
+	for _, f := range realFiles[fileType] {
+		if gf, ok := f.(*grammarFile); ok {
+			f = gf.RuntimeFile
+		}
+		if rf, ok := f.(realFile); ok && string(rf) == filename {
+			return true
+		}
+	}
+	return false
+}
+
 // InitRuntimeFiles initializes all assets file and the config directory
 func InitRuntimeFiles() {
 	add := func(fileType RTFiletype, dir, pattern string) {
@@ -167,6 +310,8 @@ func InitRuntimeFiles() {
 
 	add(RTColorscheme, "colorschemes", "*.micro")
 	add(RTSyntax, "syntax", "*.yaml")
+	add(RTSyntax, "syntax", "*.tmLanguage.json")
+	add(RTSyntax, "syntax", "*.sublime-syntax")
 	add(RTSyntaxHeader, "syntax", "*.hdr")
 	add(RTHelp, "help", "*.md")
 
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -29,6 +30,31 @@ func TestAddFile(t *testing.T) {
 	assert.Equal(t, []byte("some syntax file\n"), data)
 }
 
+func TestRemoveFile(t *testing.T) {
+	version := RuntimeFilesVersion()
+	AddRealRuntimeFile(RTSyntax, memoryFile{"qux", []byte("some syntax file\n")})
+	assert.NotNil(t, FindRuntimeFile(RTSyntax, "qux"))
+	assert.NotEqual(t, version, RuntimeFilesVersion())
+
+	version = RuntimeFilesVersion()
+	RemoveRuntimeFile(RTSyntax, "qux")
+	assert.Nil(t, FindRuntimeFile(RTSyntax, "qux"))
+	for _, f := range ListRealRuntimeFiles(RTSyntax) {
+		assert.NotEqual(t, "qux", f.Name())
+	}
+	assert.NotNil(t, FindRuntimeFile(RTSyntax, "go"))
+	assert.NotEqual(t, version, RuntimeFilesVersion())
+}
+
+func TestHasRealFile(t *testing.T) {
+	AddRealRuntimeFile(RTSyntax, realFile(filepath.Join("syntax", "quux.yaml")))
+	defer RemoveRuntimeFile(RTSyntax, "quux")
+
+	assert.True(t, HasRealRuntimeFile(RTSyntax, filepath.Join("syntax", "quux.yaml")))
+	assert.False(t, HasRealRuntimeFile(RTSyntax, filepath.Join("other", "quux.yaml")))
+	assert.False(t, HasRealRuntimeFile(RTSyntaxHeader, filepath.Join("syntax", "quux.yaml")))
+}
+
 func TestFindFile(t *testing.T) {
 	f := FindRuntimeFile(RTSyntax, "go")
 	assert.NotNil(t, f)
@@ -40,3 +66,26 @@ func TestFindFile(t *testing.T) {
 	e := FindRuntimeFile(RTSyntax, "foobar")
 	assert.Nil(t, e)
 }
+
+func TestAddGrammar(t *testing.T) {
+	AddRuntimeFile(RTSyntax, memoryFile{"baz.sublime-syntax", []byte(`%YAML 1.2
+---
+file_extensions: [baz]
+contexts:
+  main:
+    - match: \bbaz\b
+      scope: keyword.baz
+`)})
+
+	f := FindRuntimeFile(RTSyntax, "baz")
+	assert.NotNil(t, f)
+	data, err := f.Data()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("filetype: baz"), data[:13])
+
+	h := FindRuntimeFile(RTSyntaxHeader, "baz")
+	assert.NotNil(t, h)
+	data, err = h.Data()
+	assert.Nil(t, err)
+	assert.Equal(t, "baz\n(^|[/.])(baz)$\n\n", string(data))
+}
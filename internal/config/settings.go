@@ -49,9 +49,11 @@ var optionValidators = map[string]optionValidator{
 	"scrollspeed":  validateNonNegativeValue,
 	"colorscheme":  validateColorscheme,
 	"colorcolumn":  validateNonNegativeValue,
+	"largefile":    validateNonNegativeValue,
 	"fileformat":   validateLineEnding,
 	"encoding":     validateEncoding,
 	"multiopen":    validateMultiOpen,
+	"foldmethod":   validateFoldMethod,
 }
 
 func ReadSettings() error {
@@ -285,13 +287,17 @@ var defaultCommonSettings = map[string]interface{}{
 	"fastdirty":      false,
 	"fileformat":     "unix",
 	"filetype":       "unknown",
+	"foldmethod":     "indent",
 	"hlsearch":       false,
 	"incsearch":      true,
 	"ignorecase":     true,
 	"indentchar":     " ",
 	"keepautoindent": false,
+	"largefile":      float64(50),
+	"lspserver":      "",
 	"matchbrace":     true,
 	"mkparents":      false,
+	"modeline":       true,
 	"permbackup":     false,
 	"readonly":       false,
 	"rmtrailingws":   false,
@@ -302,6 +308,7 @@ var defaultCommonSettings = map[string]interface{}{
 	"scrollbar":      false,
 	"scrollmargin":   float64(3),
 	"scrollspeed":    float64(2),
+	"smartcase":      false,
 	"smartpaste":     true,
 	"softwrap":       false,
 	"splitbottom":    true,
@@ -511,6 +518,22 @@ func validateEncoding(option string, value interface{}) error {
 	return err
 }
 
+func validateFoldMethod(option string, value interface{}) error {
+	val, ok := value.(string)
+
+	if !ok {
+		return errors.New("Expected string type for foldmethod")
+	}
+
+	switch val {
+	case "indent", "syntax":
+	default:
+		return errors.New(option + " must be 'indent' or 'syntax'")
+	}
+
+	return nil
+}
+
 func validateMultiOpen(option string, value interface{}) error {
 	val, ok := value.(string)
 
@@ -17,6 +17,8 @@ type BufWindow struct {
 
 	// Buffer being shown in this window
 	Buf *buffer.Buffer
+	// the folded lines of the buffer in this window
+	folds *buffer.Folds
 
 	active bool
 
@@ -35,6 +37,7 @@ func NewBufWindow(x, y, width, height int, buf *buffer.Buffer) *BufWindow {
 	w := new(BufWindow)
 	w.View = new(View)
 	w.X, w.Y, w.Width, w.Height = x, y, width, height
+	w.folds = new(buffer.Folds)
 	w.SetBuffer(buf)
 	w.active = true
 
@@ -45,6 +48,11 @@ func NewBufWindow(x, y, width, height int, buf *buffer.Buffer) *BufWindow {
 
 // SetBuffer sets this window's buffer.
 func (w *BufWindow) SetBuffer(b *buffer.Buffer) {
+	if w.Buf != nil {
+		w.Buf.RemoveFolds(w.folds)
+	}
+	w.folds.UnfoldAll()
+	b.AddFolds(w.folds)
 	w.Buf = b
 	b.OptionCallback = func(option string, nativeValue interface{}) {
 		if option == "softwrap" {
@@ -67,6 +75,11 @@ func (w *BufWindow) SetBuffer(b *buffer.Buffer) {
 	}
 }
 
+// Folds returns the folded lines of the window
+func (w *BufWindow) Folds() *buffer.Folds {
+	return w.folds
+}
+
 // GetView gets the view.
 func (w *BufWindow) GetView() *View {
 	return w.View
@@ -212,6 +225,17 @@ func (w *BufWindow) Relocate() bool {
 	activeC := w.Buf.GetActiveCursor()
 	scrollmargin := int(b.Settings["scrollmargin"].(float64))
 
+	if w.folds.HasFolds() {
+		// open the folds that hide the cursor
+		if w.folds.IsHidden(activeC.Y) {
+			w.folds.Unfold(activeC.Y)
+		}
+		w.StartLine.Line = w.folds.FoldStart(w.StartLine.Line)
+		if !w.wrapped(w.StartLine.Line) {
+			w.StartLine.Row = 0
+		}
+	}
+
 	c := w.SLocFromLoc(activeC.Loc)
 	bStart := SLoc{0, 0}
 	bEnd := w.SLocFromLoc(b.End())
@@ -268,18 +292,21 @@ func (w *BufWindow) LocFromVisual(svloc buffer.Loc) buffer.Loc {
 }
 
 func (w *BufWindow) drawGutter(vloc *buffer.Loc, bloc *buffer.Loc) {
-	char := ' '
+	char, char2 := ' ', ' '
 	s := config.DefStyle
 	for _, m := range w.Buf.Messages {
 		if m.Start.Y == bloc.Y || m.End.Y == bloc.Y {
 			s = m.Style()
-			char = '>'
+			char, char2 = '>', '>'
+			if m.Sign != 0 {
+				char, char2 = m.Sign, ' '
+			}
 			break
 		}
 	}
 	screen.SetContent(w.X+vloc.X, w.Y+vloc.Y, char, nil, s)
 	vloc.X++
-	screen.SetContent(w.X+vloc.X, w.Y+vloc.Y, char, nil, s)
+	screen.SetContent(w.X+vloc.X, w.Y+vloc.Y, char2, nil, s)
 	vloc.X++
 }
 
@@ -439,13 +466,13 @@ func (w *BufWindow) displayBuffer() {
 	// this represents the current draw position
 	// within the current window
 	vloc := buffer.Loc{X: 0, Y: 0}
-	if softwrap {
+	if softwrap && w.wrapped(w.StartLine.Line) {
 		// the start line may be partially out of the current window
 		vloc.Y = -w.StartLine.Row
 	}
 
 	// this represents the current draw position in the buffer (char positions)
-	bloc := buffer.Loc{X: -1, Y: w.StartLine.Line}
+	bloc := buffer.Loc{X: -1, Y: w.folds.FoldStart(w.StartLine.Line)}
 
 	cursors := b.GetCursors()
 
@@ -453,6 +480,10 @@ func (w *BufWindow) displayBuffer() {
 	for ; vloc.Y < w.bufHeight; vloc.Y++ {
 		vloc.X = 0
 
+		// a folded line is drawn on a single row followed by a summary
+		// of the fold
+		foldEnd, folded := w.folds.FoldEnd(bloc.Y)
+
 		currentLine := false
 		for _, c := range cursors {
 			if bloc.Y == c.Y && w.active {
@@ -506,9 +537,7 @@ func (w *BufWindow) displayBuffer() {
 					dontOverrideBackground := origBg != defBg
 
 					for _, c := range cursors {
-						if c.HasSelection() &&
-							(bloc.GreaterEqual(c.CurSelection[0]) && bloc.LessThan(c.CurSelection[1]) ||
-								bloc.LessThan(c.CurSelection[0]) && bloc.GreaterEqual(c.CurSelection[1])) {
+						if c.HasSelection() && c.InSelection(bloc) {
 							// The current character is selected
 							style = config.DefStyle.Reverse(true)
 
@@ -566,7 +595,7 @@ func (w *BufWindow) displayBuffer() {
 
 				if showcursor {
 					for _, c := range cursors {
-						if c.X == bloc.X && c.Y == bloc.Y && !c.HasSelection() {
+						if c.X == bloc.X && c.Y == bloc.Y && (!c.HasSelection() || c.Block) {
 							w.showCursor(w.X+vloc.X, w.Y+vloc.Y, c.Num == 0)
 						}
 					}
@@ -598,6 +627,9 @@ func (w *BufWindow) displayBuffer() {
 			combc []rune
 			style tcell.Style
 			width int
+			// the glyph is part of the text of a replace preview and is
+			// not in the buffer
+			virtual bool
 		}
 
 		var word []glyph
@@ -607,14 +639,51 @@ func (w *BufWindow) displayBuffer() {
 			word = make([]glyph, 0, 1)
 		}
 		wordwidth := 0
+		// the number of glyphs of word that are in the buffer
+		nreal := 0
+
+		// the replacements previewed on this line are drawn after the
+		// text they replace, which is struck through
+		previews := b.PreviewMatches(bloc.Y)
+		for len(previews) > 0 && previews[0].End < bslice {
+			previews = previews[1:]
+		}
+		var virtual []byte
+		nextVirtual := func() {
+			for len(virtual) == 0 && len(previews) > 0 && previews[0].End <= bloc.X+nreal {
+				virtual = previews[0].Text
+				previews = previews[1:]
+			}
+		}
+		previewStyle := config.DefStyle.Reverse(true)
+		if s, ok := config.Colorscheme["hlsearch"]; ok {
+			previewStyle = s
+		}
 
 		totalwidth := w.StartCol - nColsBeforeStart
-		for len(line) > 0 {
-			r, combc, size := util.DecodeCharacter(line)
-			line = line[size:]
-
-			loc := buffer.Loc{X: bloc.X + len(word), Y: bloc.Y}
-			curStyle, _ = w.getStyle(curStyle, loc)
+		nextVirtual()
+		for len(line) > 0 || len(virtual) > 0 {
+			var r rune
+			var combc []rune
+			var size int
+			var style tcell.Style
+			isVirtual := len(virtual) > 0
+			if isVirtual {
+				r, combc, size = util.DecodeCharacter(virtual)
+				virtual = virtual[size:]
+				style = previewStyle
+			} else {
+				r, combc, size = util.DecodeCharacter(line)
+				line = line[size:]
+
+				loc := buffer.Loc{X: bloc.X + nreal, Y: bloc.Y}
+				curStyle, _ = w.getStyle(curStyle, loc)
+				style = curStyle
+				if len(previews) > 0 && loc.X >= previews[0].Start {
+					style = style.StrikeThrough(true)
+				}
+				nreal++
+			}
 
 			width := 0
 
@@ -628,13 +697,14 @@ func (w *BufWindow) displayBuffer() {
 				totalwidth += width
 			}
 
-			word = append(word, glyph{r, combc, curStyle, width})
+			word = append(word, glyph{r, combc, style, width, isVirtual})
 			wordwidth += width
+			nextVirtual()
 
 			// Collect a complete word to know its width.
 			// If wordwrap is off, every single character is a complete "word".
 			if wordwrap {
-				if !util.IsWhitespace(r) && len(line) > 0 && wordwidth < w.bufWidth {
+				if !util.IsWhitespace(r) && (len(line) > 0 || len(virtual) > 0) && wordwidth < w.bufWidth {
 					continue
 				}
 			}
@@ -646,7 +716,7 @@ func (w *BufWindow) displayBuffer() {
 				}
 
 				// We either stop or we wrap to draw the word in the next line
-				if !softwrap {
+				if !softwrap || folded {
 					break
 				} else {
 					vloc.Y++
@@ -658,7 +728,7 @@ func (w *BufWindow) displayBuffer() {
 			}
 
 			for _, r := range word {
-				draw(r.r, r.combc, r.style, true, true)
+				draw(r.r, r.combc, r.style, !r.virtual, !r.virtual)
 
 				// Draw any extra characters either spaces for tabs or @ for incomplete wide runes
 				if r.width > 1 {
@@ -668,18 +738,21 @@ func (w *BufWindow) displayBuffer() {
 					}
 
 					for i := 1; i < r.width; i++ {
-						draw(char, nil, r.style, true, false)
+						draw(char, nil, r.style, !r.virtual, false)
 					}
 				}
-				bloc.X++
+				if !r.virtual {
+					bloc.X++
+				}
 			}
 
 			word = word[:0]
 			wordwidth = 0
+			nreal = 0
 
 			// If we reach the end of the window then we either stop or we wrap for softwrap
 			if vloc.X >= maxWidth {
-				if !softwrap {
+				if !softwrap || folded {
 					break
 				} else {
 					vloc.Y++
@@ -717,6 +790,11 @@ func (w *BufWindow) displayBuffer() {
 			draw(' ', nil, config.DefStyle, true, true)
 		}
 
+		if folded {
+			w.drawFoldSummary(&vloc, maxWidth, foldEnd-bloc.Y)
+			bloc.Y = foldEnd
+		}
+
 		bloc.X = w.StartCol
 		bloc.Y++
 		if bloc.Y >= b.LinesNum() {
@@ -725,6 +803,27 @@ func (w *BufWindow) displayBuffer() {
 	}
 }
 
+// drawFoldSummary draws the number of lines hidden by a fold after the
+// first line of the fold
+func (w *BufWindow) drawFoldSummary(vloc *buffer.Loc, maxWidth, hidden int) {
+	style := config.DefStyle.Reverse(true)
+	if s, ok := config.Colorscheme["fold"]; ok {
+		style = s
+	}
+
+	summary := " +" + strconv.Itoa(hidden) + " lines "
+	if hidden == 1 {
+		summary = " +1 line "
+	}
+	for _, r := range summary {
+		if vloc.X >= maxWidth {
+			break
+		}
+		screen.SetContent(w.X+vloc.X, w.Y+vloc.Y, r, nil, style)
+		vloc.X++
+	}
+}
+
 func (w *BufWindow) displayStatusLine() {
 	if w.Buf.Settings["statusline"].(bool) {
 		w.sline.Display()
@@ -15,6 +15,8 @@ type InfoWindow struct {
 	*View
 
 	hscroll int
+	// the info bar never has folded lines
+	folds buffer.Folds
 }
 
 func (i *InfoWindow) errStyle() tcell.Style {
@@ -65,6 +67,8 @@ func (i *InfoWindow) SetView(v *View)  {}
 func (i *InfoWindow) SetActive(b bool) {}
 func (i *InfoWindow) IsActive() bool   { return true }
 
+func (i *InfoWindow) Folds() *buffer.Folds { return &i.folds }
+
 func (i *InfoWindow) LocFromVisual(vloc buffer.Loc) buffer.Loc {
 	c := i.Buffer.GetActiveCursor()
 	l := i.Buffer.LineBytes(0)
@@ -250,6 +254,15 @@ func (i *InfoWindow) Display() {
 
 		if i.HasPrompt {
 			i.displayBuffer()
+
+			if i.PromptStatus != "" {
+				status := " " + i.PromptStatus
+				x := i.Width - runewidth.StringWidth(status)
+				for _, c := range status {
+					screen.SetContent(x, i.Y, c, nil, style)
+					x += runewidth.RuneWidth(c)
+				}
+			}
 		}
 	}
 
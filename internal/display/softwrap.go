@@ -223,7 +223,24 @@ func (w *BufWindow) getLocFromVLoc(svloc VLoc) buffer.Loc {
 	return loc
 }
 
+// wrapped returns whether line may be displayed on several rows. This is
+// the case when softwrap is on, unless the line is folded
+func (w *BufWindow) wrapped(line int) bool {
+	if !w.Buf.Settings["softwrap"].(bool) {
+		return false
+	}
+	if w.folds.HasFolds() {
+		if _, folded := w.folds.FoldEnd(line); folded || w.folds.IsHidden(line) {
+			return false
+		}
+	}
+	return true
+}
+
 func (w *BufWindow) getRowCount(line int) int {
+	if !w.wrapped(line) {
+		return 1
+	}
 	eol := buffer.Loc{X: util.CharacterCount(w.Buf.LineBytes(line)), Y: line}
 	return w.getVLocFromLoc(eol).Row + 1
 }
@@ -234,7 +251,7 @@ func (w *BufWindow) scrollUp(s SLoc, n int) SLoc {
 			s.Row -= n
 			n = 0
 		} else if s.Line > 0 {
-			s.Line--
+			s.Line = w.folds.PrevVisibleLine(s.Line)
 			n -= s.Row + 1
 			s.Row = w.getRowCount(s.Line) - 1
 		} else {
@@ -251,8 +268,8 @@ func (w *BufWindow) scrollDown(s SLoc, n int) SLoc {
 		if n < rc-s.Row {
 			s.Row += n
 			n = 0
-		} else if s.Line < w.Buf.LinesNum()-1 {
-			s.Line++
+		} else if next := w.folds.NextVisibleLine(s.Line); next < w.Buf.LinesNum() {
+			s.Line = next
 			n -= rc - s.Row
 			s.Row = 0
 		} else {
@@ -275,7 +292,7 @@ func (w *BufWindow) diff(s1, s2 SLoc) int {
 	for s1.LessThan(s2) {
 		if s1.Line < s2.Line {
 			n += w.getRowCount(s1.Line) - s1.Row
-			s1.Line++
+			s1.Line = w.folds.NextVisibleLine(s1.Line)
 			s1.Row = 0
 		} else {
 			n += s2.Row - s1.Row
@@ -290,7 +307,7 @@ func (w *BufWindow) diff(s1, s2 SLoc) int {
 // which means scrolling up. The returned location is guaranteed to be
 // within the buffer boundaries.
 func (w *BufWindow) Scroll(s SLoc, n int) SLoc {
-	if !w.Buf.Settings["softwrap"].(bool) {
+	if !w.Buf.Settings["softwrap"].(bool) && !w.folds.HasFolds() {
 		s.Line += n
 		if s.Line < 0 {
 			s.Line = 0
@@ -300,14 +317,17 @@ func (w *BufWindow) Scroll(s SLoc, n int) SLoc {
 		}
 		return s
 	}
+	s.Line = w.folds.FoldStart(s.Line)
 	return w.scroll(s, n)
 }
 
 // Diff returns the difference (the vertical distance) between two SLocs.
 func (w *BufWindow) Diff(s1, s2 SLoc) int {
-	if !w.Buf.Settings["softwrap"].(bool) {
+	if !w.Buf.Settings["softwrap"].(bool) && !w.folds.HasFolds() {
 		return s2.Line - s1.Line
 	}
+	s1.Line = w.folds.FoldStart(s1.Line)
+	s2.Line = w.folds.FoldStart(s2.Line)
 	if s1.GreaterThan(s2) {
 		return -w.diff(s2, s1)
 	}
@@ -317,8 +337,8 @@ func (w *BufWindow) Diff(s1, s2 SLoc) int {
 // SLocFromLoc takes a position in the buffer and returns the location
 // of the visual line containing this position.
 func (w *BufWindow) SLocFromLoc(loc buffer.Loc) SLoc {
-	if !w.Buf.Settings["softwrap"].(bool) {
-		return SLoc{loc.Y, 0}
+	if !w.wrapped(loc.Y) {
+		return SLoc{w.folds.FoldStart(loc.Y), 0}
 	}
 	return w.getVLocFromLoc(loc).SLoc
 }
@@ -326,7 +346,11 @@ func (w *BufWindow) SLocFromLoc(loc buffer.Loc) SLoc {
 // VLocFromLoc takes a position in the buffer and returns the corresponding
 // visual location in the linewrapped buffer.
 func (w *BufWindow) VLocFromLoc(loc buffer.Loc) VLoc {
-	if !w.Buf.Settings["softwrap"].(bool) {
+	if !w.wrapped(loc.Y) {
+		if start := w.folds.FoldStart(loc.Y); start != loc.Y {
+			// the location is hidden by a fold
+			return VLoc{SLoc{start, 0}, 0}
+		}
 		tabsize := util.IntOpt(w.Buf.Settings["tabsize"])
 
 		visualx := util.StringWidth(w.Buf.LineBytes(loc.Y), loc.X, tabsize)
@@ -338,7 +362,7 @@ func (w *BufWindow) VLocFromLoc(loc buffer.Loc) VLoc {
 // LocFromVLoc takes a visual location in the linewrapped buffer and returns
 // the position in the buffer corresponding to this visual location.
 func (w *BufWindow) LocFromVLoc(vloc VLoc) buffer.Loc {
-	if !w.Buf.Settings["softwrap"].(bool) {
+	if !w.wrapped(vloc.Line) {
 		tabsize := util.IntOpt(w.Buf.Settings["tabsize"])
 
 		x := util.GetCharPosInLine(w.Buf.LineBytes(vloc.Line), vloc.VisualX, tabsize)
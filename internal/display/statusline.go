@@ -39,13 +39,16 @@ var statusInfo = map[string]func(*buffer.Buffer) string{
 		return strconv.Itoa(b.GetActiveCursor().X + 1)
 	},
 	"modified": func(b *buffer.Buffer) string {
+		s := ""
 		if b.Modified() {
-			return "+ "
+			s = "+ "
+		} else if b.Type.Readonly {
+			s = "[ro] "
 		}
-		if b.Type.Readonly {
-			return "[ro] "
+		if b.LargeFile {
+			s += "[large] "
 		}
-		return ""
+		return s
 	},
 	"lines": func(b *buffer.Buffer) string {
 		return strconv.Itoa(b.LinesNum())
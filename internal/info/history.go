@@ -6,15 +6,21 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/zyedidia/micro/v2/internal/clipboard"
 	"github.com/zyedidia/micro/v2/internal/config"
 	"github.com/zyedidia/micro/v2/internal/util"
 )
 
 // LoadHistory attempts to load user history from configDir/buffers/history
-// into the history map
+// into the history map, and the named clipboard registers from
+// configDir/buffers/registers
 // The savehistory option must be on
 func (i *InfoBuf) LoadHistory() {
 	if config.GetGlobalOption("savehistory").(bool) {
+		if err := clipboard.LoadRegisters(filepath.Join(config.ConfigDir, "buffers", "registers")); err != nil {
+			i.Error("Error loading registers:", err)
+		}
+
 		file, err := os.Open(filepath.Join(config.ConfigDir, "buffers", "history"))
 		var decodedMap map[string][]string
 		if err == nil {
@@ -39,9 +45,14 @@ func (i *InfoBuf) LoadHistory() {
 }
 
 // SaveHistory saves the user's command history to configDir/buffers/history
+// and the named clipboard registers to configDir/buffers/registers
 // only if the savehistory option is on
 func (i *InfoBuf) SaveHistory() {
 	if config.GetGlobalOption("savehistory").(bool) {
+		if err := clipboard.SaveRegisters(filepath.Join(config.ConfigDir, "buffers", "registers")); err != nil {
+			i.Error("Error saving registers:", err)
+		}
+
 		// Don't save history past 100
 		for k, v := range i.History {
 			if len(v) > 100 {
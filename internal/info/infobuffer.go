@@ -17,6 +17,9 @@ type InfoBuf struct {
 	HasYN      bool
 
 	PromptType string
+	// PromptStatus is shown at the right end of the prompt, for example
+	// to give the number of matches of what is typed
+	PromptStatus string
 
 	Msg    string
 	YNResp bool
@@ -109,6 +112,7 @@ func (i *InfoBuf) Prompt(prompt string, msg string, ptype string, eventcb func(s
 	i.HistorySearch = false
 
 	i.PromptType = ptype
+	i.PromptStatus = ""
 	i.Msg = prompt
 	i.HasPrompt = true
 	i.HasMessage, i.HasError, i.HasYN = false, false, false
@@ -136,7 +140,10 @@ func (i *InfoBuf) YNPrompt(prompt string, donecb func(bool, bool)) {
 // DonePrompt finishes the current prompt and indicates whether or not it was canceled
 func (i *InfoBuf) DonePrompt(canceled bool) {
 	hadYN := i.HasYN
+	// the callback may open another prompt, which changes the prompt type
+	ptype := i.PromptType
 	i.HasPrompt = false
+	i.PromptStatus = ""
 	i.HasYN = false
 	i.HasGutter = false
 	if !hadYN {
@@ -144,19 +151,19 @@ func (i *InfoBuf) DonePrompt(canceled bool) {
 			if canceled {
 				i.Replace(i.Start(), i.End(), "")
 				i.PromptCallback("", true)
-				h := i.History[i.PromptType]
-				i.History[i.PromptType] = h[:len(h)-1]
+				h := i.History[ptype]
+				i.History[ptype] = h[:len(h)-1]
 			} else {
 				resp := string(i.LineBytes(0))
 				i.Replace(i.Start(), i.End(), "")
 				i.PromptCallback(resp, false)
-				h := i.History[i.PromptType]
+				h := i.History[ptype]
 				h[len(h)-1] = resp
 
 				// avoid duplicates
 				for j := len(h) - 2; j >= 0; j-- {
 					if h[j] == h[len(h)-1] {
-						i.History[i.PromptType] = append(h[:j], h[j+1:]...)
+						i.History[ptype] = append(h[:j], h[j+1:]...)
 						break
 					}
 				}
@@ -0,0 +1,475 @@
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// A Handler receives the notifications and requests that a server sends
+// to the client. Its methods are called from the goroutine that reads the
+// messages of the server, so they must not block
+type Handler interface {
+	// Diagnostics receives the diagnostics of a document
+	Diagnostics(params PublishDiagnosticsParams)
+	// ApplyEdit must apply an edit to the workspace and call reply with
+	// whether it was applied
+	ApplyEdit(edit WorkspaceEdit, reply func(applied bool))
+	// ShowMessage receives a message for the user
+	ShowMessage(params ShowMessageParams)
+}
+
+// InitializeTimeout is how long Start waits for the server to answer the
+// initialize request
+var InitializeTimeout = 30 * time.Second
+
+// A Client is a connection to a language server that runs as a process
+// and talks over its standard input and output
+type Client struct {
+	// Capabilities are the features that the server supports
+	Capabilities ServerCapabilities
+
+	conn    *conn
+	cmd     *exec.Cmd
+	handler Handler
+
+	done chan struct{}
+	err  error
+}
+
+// Start runs a language server and initializes it for the workspace in the
+// root directory. It blocks until the server answers, and fails if it does
+// not within InitializeTimeout
+func Start(command []string, root string, handler Handler) (*Client, error) {
+	if len(command) == 0 {
+		return nil, errors.New("no language server command")
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = root
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:    newConn(stdout, stdin),
+		cmd:     cmd,
+		handler: handler,
+		done:    make(chan struct{}),
+	}
+	go func() {
+		c.err = c.conn.run(c.handle)
+		cmd.Wait()
+		close(c.done)
+	}()
+
+	if err := c.initialize(root); err != nil {
+		cmd.Process.Kill()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) initialize(root string) error {
+	rootURI := URIFromPath(root)
+	params := map[string]interface{}{
+		"processId": os.Getpid(),
+		"clientInfo": map[string]interface{}{
+			"name": "micro",
+		},
+		"rootUri":  rootURI,
+		"rootPath": root,
+		"workspaceFolders": []interface{}{
+			map[string]interface{}{"uri": rootURI, "name": filepath.Base(root)},
+		},
+		"capabilities": clientCapabilities,
+	}
+
+	type response struct {
+		result json.RawMessage
+		err    error
+	}
+	ch := make(chan response, 1)
+	c.conn.call("initialize", params, func(result json.RawMessage, err error) {
+		ch <- response{result, err}
+	})
+	var r response
+	select {
+	case r = <-ch:
+	case <-c.done:
+		return errors.New("exited before it was initialized")
+	case <-time.After(InitializeTimeout):
+		return errors.New("no answer to the initialize request after " + InitializeTimeout.String())
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	var result struct {
+		Capabilities ServerCapabilities `json:"capabilities"`
+	}
+	if err := json.Unmarshal(r.result, &result); err != nil {
+		return err
+	}
+	c.Capabilities = result.Capabilities
+	return c.conn.notify("initialized", struct{}{})
+}
+
+// clientCapabilities are the features of the protocol that the client
+// supports
+var clientCapabilities = map[string]interface{}{
+	"general": map[string]interface{}{
+		"positionEncodings": []string{"utf-16"},
+	},
+	"workspace": map[string]interface{}{
+		"applyEdit": true,
+		"workspaceEdit": map[string]interface{}{
+			"documentChanges": true,
+		},
+	},
+	"textDocument": map[string]interface{}{
+		"synchronization": map[string]interface{}{
+			"didSave": true,
+		},
+		"hover": map[string]interface{}{
+			"contentFormat": []string{"plaintext", "markdown"},
+		},
+		"definition": map[string]interface{}{
+			"linkSupport": true,
+		},
+		"references": map[string]interface{}{},
+		"rename":     map[string]interface{}{},
+		"codeAction": map[string]interface{}{
+			"codeActionLiteralSupport": map[string]interface{}{
+				"codeActionKind": map[string]interface{}{
+					"valueSet": []string{"", "quickfix", "refactor", "refactor.extract",
+						"refactor.inline", "refactor.rewrite", "source", "source.organizeImports"},
+				},
+			},
+			"dataSupport": true,
+			"resolveSupport": map[string]interface{}{
+				"properties": []string{"edit"},
+			},
+		},
+		"publishDiagnostics": map[string]interface{}{},
+	},
+}
+
+// handle answers the requests and notifications of the server
+func (c *Client) handle(m *message) {
+	switch m.Method {
+	case "textDocument/publishDiagnostics":
+		var params PublishDiagnosticsParams
+		if json.Unmarshal(m.Params, &params) == nil {
+			c.handler.Diagnostics(params)
+		}
+	case "window/showMessage":
+		var params ShowMessageParams
+		if json.Unmarshal(m.Params, &params) == nil {
+			c.handler.ShowMessage(params)
+		}
+	case "workspace/applyEdit":
+		var params struct {
+			Edit WorkspaceEdit `json:"edit"`
+		}
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			c.conn.reply(m.ID, nil, &ResponseError{Code: -32602, Message: err.Error()})
+			return
+		}
+		id := m.ID
+		c.handler.ApplyEdit(params.Edit, func(applied bool) {
+			c.conn.reply(id, map[string]bool{"applied": applied}, nil)
+		})
+	case "workspace/configuration":
+		// no settings are given to servers
+		var params struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		json.Unmarshal(m.Params, &params)
+		c.conn.reply(m.ID, make([]interface{}, len(params.Items)), nil)
+	case "window/workDoneProgress/create", "client/registerCapability", "client/unregisterCapability":
+		c.conn.reply(m.ID, nil, nil)
+	default:
+		// notifications that the client does not know are ignored
+		if m.ID != nil {
+			c.conn.reply(m.ID, nil, &ResponseError{Code: methodNotFound, Message: "method not found: " + m.Method})
+		}
+	}
+}
+
+// Done returns a channel that is closed when the server has exited
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error that ended the connection to the server, if any,
+// once it has exited
+func (c *Client) Err() error {
+	return c.err
+}
+
+// Shutdown asks the server to exit and kills it if it does not within a
+// second
+func (c *Client) Shutdown() {
+	deadline := time.NewTimer(time.Second)
+	defer deadline.Stop()
+
+	shutdown := make(chan struct{})
+	c.conn.call("shutdown", nil, func(json.RawMessage, error) {
+		close(shutdown)
+	})
+	select {
+	case <-shutdown:
+		c.conn.notify("exit", nil)
+	case <-c.done:
+		return
+	case <-deadline.C:
+		c.cmd.Process.Kill()
+		return
+	}
+
+	select {
+	case <-c.done:
+	case <-deadline.C:
+		c.cmd.Process.Kill()
+	}
+}
+
+type textDocumentIdentifier struct {
+	URI DocumentURI `json:"uri"`
+}
+
+type textDocumentPositionParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+}
+
+func positionParams(uri DocumentURI, pos Position) textDocumentPositionParams {
+	return textDocumentPositionParams{textDocumentIdentifier{uri}, pos}
+}
+
+// DidOpen tells the server that a document was opened with the given text
+func (c *Client) DidOpen(uri DocumentURI, languageID string, version int, text string) error {
+	return c.conn.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri":        uri,
+			"languageId": languageID,
+			"version":    version,
+			"text":       text,
+		},
+	})
+}
+
+// DidChange sends the changes made to a document, which is then at the
+// given version
+func (c *Client) DidChange(uri DocumentURI, version int, changes []TextDocumentContentChangeEvent) error {
+	return c.conn.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri":     uri,
+			"version": version,
+		},
+		"contentChanges": changes,
+	})
+}
+
+// DidSave tells the server that a document was saved
+func (c *Client) DidSave(uri DocumentURI) error {
+	return c.conn.notify("textDocument/didSave", map[string]interface{}{
+		"textDocument": textDocumentIdentifier{uri},
+	})
+}
+
+// DidClose tells the server that a document was closed
+func (c *Client) DidClose(uri DocumentURI) error {
+	return c.conn.notify("textDocument/didClose", map[string]interface{}{
+		"textDocument": textDocumentIdentifier{uri},
+	})
+}
+
+// The following requests give their response to a function, which is
+// called from another goroutine
+
+// Definition asks for the locations where the symbol at a position is
+// defined
+func (c *Client) Definition(uri DocumentURI, pos Position, fn func([]Location, error)) {
+	c.conn.call("textDocument/definition", positionParams(uri, pos), func(result json.RawMessage, err error) {
+		if err != nil {
+			fn(nil, err)
+			return
+		}
+		fn(parseLocations(result))
+	})
+}
+
+// References asks for the locations where the symbol at a position is
+// used, including its declaration
+func (c *Client) References(uri DocumentURI, pos Position, fn func([]Location, error)) {
+	params := map[string]interface{}{
+		"textDocument": textDocumentIdentifier{uri},
+		"position":     pos,
+		"context":      map[string]bool{"includeDeclaration": true},
+	}
+	c.conn.call("textDocument/references", params, func(result json.RawMessage, err error) {
+		if err != nil {
+			fn(nil, err)
+			return
+		}
+		fn(parseLocations(result))
+	})
+}
+
+// parseLocations reads a result that is null, a location, or a list of
+// locations or location links
+func parseLocations(result json.RawMessage) ([]Location, error) {
+	if len(result) == 0 || string(result) == "null" {
+		return nil, nil
+	}
+	var loc Location
+	if result[0] == '{' {
+		err := json.Unmarshal(result, &loc)
+		return []Location{loc}, err
+	}
+
+	var links []json.RawMessage
+	if err := json.Unmarshal(result, &links); err != nil {
+		return nil, err
+	}
+	locs := make([]Location, 0, len(links))
+	for _, l := range links {
+		var link locationLink
+		if err := json.Unmarshal(l, &link); err == nil && link.TargetURI != "" {
+			locs = append(locs, Location{link.TargetURI, link.TargetSelectionRange})
+		} else if err := json.Unmarshal(l, &loc); err == nil {
+			locs = append(locs, loc)
+		}
+	}
+	return locs, nil
+}
+
+// Hover asks for the information about the symbol at a position. The text
+// is empty if there is none
+func (c *Client) Hover(uri DocumentURI, pos Position, fn func(string, error)) {
+	c.conn.call("textDocument/hover", positionParams(uri, pos), func(result json.RawMessage, err error) {
+		if err != nil {
+			fn("", err)
+			return
+		}
+		var hover struct {
+			Contents json.RawMessage `json:"contents"`
+		}
+		if string(result) != "null" {
+			if err := json.Unmarshal(result, &hover); err != nil {
+				fn("", err)
+				return
+			}
+		}
+		fn(hoverText(hover.Contents), nil)
+	})
+}
+
+// Rename asks for the edit that renames the symbol at a position
+func (c *Client) Rename(uri DocumentURI, pos Position, newName string, fn func(*WorkspaceEdit, error)) {
+	params := map[string]interface{}{
+		"textDocument": textDocumentIdentifier{uri},
+		"position":     pos,
+		"newName":      newName,
+	}
+	c.conn.call("textDocument/rename", params, func(result json.RawMessage, err error) {
+		if err != nil {
+			fn(nil, err)
+			return
+		}
+		var edit *WorkspaceEdit
+		err = json.Unmarshal(result, &edit)
+		fn(edit, err)
+	})
+}
+
+// CodeActions asks for the code actions of a range, with the diagnostics
+// of the range
+func (c *Client) CodeActions(uri DocumentURI, r Range, diagnostics []Diagnostic, fn func([]CodeAction, error)) {
+	if diagnostics == nil {
+		diagnostics = []Diagnostic{}
+	}
+	params := map[string]interface{}{
+		"textDocument": textDocumentIdentifier{uri},
+		"range":        r,
+		"context":      map[string]interface{}{"diagnostics": diagnostics},
+	}
+	c.conn.call("textDocument/codeAction", params, func(result json.RawMessage, err error) {
+		if err != nil {
+			fn(nil, err)
+			return
+		}
+		var actions []CodeAction
+		err = json.Unmarshal(result, &actions)
+		fn(actions, err)
+	})
+}
+
+// ResolveCodeAction asks for the edit of a code action that has none
+func (c *Client) ResolveCodeAction(action CodeAction, fn func(CodeAction, error)) {
+	c.conn.call("codeAction/resolve", action, func(result json.RawMessage, err error) {
+		if err != nil {
+			fn(action, err)
+			return
+		}
+		var resolved CodeAction
+		err = json.Unmarshal(result, &resolved)
+		fn(resolved, err)
+	})
+}
+
+// ExecuteCommand asks the server to execute a command. The server usually
+// makes its changes with a workspace/applyEdit request before it answers
+func (c *Client) ExecuteCommand(cmd Command, fn func(error)) {
+	params := map[string]interface{}{
+		"command":   cmd.Command,
+		"arguments": cmd.Arguments,
+	}
+	c.conn.call("workspace/executeCommand", params, func(result json.RawMessage, err error) {
+		fn(err)
+	})
+}
+
+// URIFromPath returns the file:// URI of a path
+func URIFromPath(path string) DocumentURI {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		// a Windows path such as C:/dir
+		path = "/" + path
+	}
+	u := url.URL{Scheme: "file", Path: path}
+	return DocumentURI(u.String())
+}
+
+// Path returns the path of a file:// URI, or the empty string if the URI
+// is not a file
+func (u DocumentURI) Path() string {
+	parsed, err := url.Parse(string(u))
+	if err != nil || parsed.Scheme != "file" {
+		return ""
+	}
+	path := parsed.Path
+	if runtime.GOOS == "windows" {
+		path = strings.TrimPrefix(path, "/")
+	}
+	return filepath.FromSlash(path)
+}
@@ -0,0 +1,64 @@
+package lsp
+
+import (
+	"bytes"
+	"sort"
+)
+
+// before returns whether p is before q in a document
+func (p Position) before(q Position) bool {
+	return p.Line < q.Line || p.Line == q.Line && p.Character < q.Character
+}
+
+// EditsFromEnd returns the edits of a document in the order in which they
+// can be applied one after the other: the positions of an edit are those
+// of the original text, so the edits are applied from the end of the text.
+// Edits at the same position keep their order in the text
+func EditsFromEnd(edits []TextEdit) []TextEdit {
+	sorted := make([]TextEdit, len(edits))
+	for i, e := range edits {
+		sorted[len(edits)-1-i] = e
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[j].Range.Start.before(sorted[i].Range.Start)
+	})
+	return sorted
+}
+
+// ApplyTextEdits returns the text with the edits applied. The lines of the
+// text may end with \r\n, which is not counted in the positions
+func ApplyTextEdits(text []byte, edits []TextEdit) []byte {
+	var starts []int
+	for i := 0; ; {
+		starts = append(starts, i)
+		n := bytes.IndexByte(text[i:], '\n')
+		if n < 0 {
+			break
+		}
+		i += n + 1
+	}
+
+	offset := func(p Position) int {
+		if p.Line >= len(starts) {
+			return len(text)
+		}
+		line := text[starts[p.Line]:]
+		if n := bytes.IndexByte(line, '\n'); n >= 0 {
+			line = line[:n]
+		}
+		line = bytes.TrimSuffix(line, []byte{'\r'})
+		return starts[p.Line] + ByteIndex(line, p.Character)
+	}
+
+	for _, e := range EditsFromEnd(edits) {
+		start, end := offset(e.Range.Start), offset(e.Range.End)
+		if end < start {
+			end = start
+		}
+		edited := make([]byte, 0, len(text)-(end-start)+len(e.NewText))
+		edited = append(edited, text[:start]...)
+		edited = append(edited, e.NewText...)
+		text = append(edited, text[end:]...)
+	}
+	return text
+}
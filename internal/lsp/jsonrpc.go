@@ -0,0 +1,221 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A message is a JSON-RPC 2.0 request, notification or response. A request
+// has an ID and a method, a notification only a method and a response only
+// an ID
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *ResponseError   `json:"error,omitempty"`
+}
+
+// A ResponseError is an error that a server returned for a request
+type ResponseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ResponseError) Error() string {
+	return e.Message
+}
+
+// The code of the error replied to requests that the client does not know
+const methodNotFound = -32601
+
+// ErrClosed is the error of the requests that were still waiting for a
+// response when the connection to the server was closed
+var ErrClosed = errors.New("language server closed the connection")
+
+// A responseFunc receives the result or the error of a request
+type responseFunc func(result json.RawMessage, err error)
+
+// A conn is a JSON-RPC connection over a stream, in which every message
+// has a header with its Content-Length
+type conn struct {
+	w io.Writer
+	r *bufio.Reader
+
+	// wlock is held while a message is written
+	wlock sync.Mutex
+
+	lock    sync.Mutex
+	nextID  int
+	pending map[int]responseFunc
+	closed  bool
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{
+		w:       w,
+		r:       bufio.NewReader(r),
+		pending: make(map[int]responseFunc),
+	}
+}
+
+func (c *conn) write(m *message) error {
+	m.JSONRPC = "2.0"
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+	_, err = c.w.Write(data)
+	return err
+}
+
+// read reads the next message from the stream
+func (c *conn) read() (*message, error) {
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(header.Get("Content-Length")))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return nil, err
+	}
+	m := new(message)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// call sends a request. The response is given to fn in the goroutine that
+// reads the messages
+func (c *conn) call(method string, params interface{}, fn responseFunc) {
+	p, err := marshalParams(params)
+	if err != nil {
+		fn(nil, err)
+		return
+	}
+
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		fn(nil, ErrClosed)
+		return
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = fn
+	c.lock.Unlock()
+
+	raw := json.RawMessage(strconv.Itoa(id))
+	if err := c.write(&message{ID: &raw, Method: method, Params: p}); err != nil {
+		if fn := c.take(id); fn != nil {
+			fn(nil, err)
+		}
+	}
+}
+
+// notify sends a notification
+func (c *conn) notify(method string, params interface{}) error {
+	p, err := marshalParams(params)
+	if err != nil {
+		return err
+	}
+	return c.write(&message{Method: method, Params: p})
+}
+
+// marshalParams returns the JSON of the params of a message, which are
+// left out if they are nil
+func marshalParams(params interface{}) (json.RawMessage, error) {
+	if params == nil {
+		return nil, nil
+	}
+	return json.Marshal(params)
+}
+
+// reply sends the response to the request with the given ID
+func (c *conn) reply(id *json.RawMessage, result interface{}, rerr *ResponseError) error {
+	m := &message{ID: id, Error: rerr}
+	if rerr == nil {
+		r, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		m.Result = r
+	}
+	return c.write(m)
+}
+
+// take removes and returns the function waiting for the response with the
+// given ID
+func (c *conn) take(id int) responseFunc {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	fn := c.pending[id]
+	delete(c.pending, id)
+	return fn
+}
+
+// run reads messages until the stream is closed. Responses are given to
+// the functions waiting for them and requests and notifications to handle
+func (c *conn) run(handle func(m *message)) error {
+	var err error
+	for {
+		var m *message
+		m, err = c.read()
+		if err != nil {
+			break
+		}
+
+		if m.Method != "" {
+			handle(m)
+			continue
+		}
+		if m.ID == nil {
+			continue
+		}
+		id, perr := strconv.Atoi(string(*m.ID))
+		if perr != nil {
+			continue
+		}
+		if fn := c.take(id); fn != nil {
+			if m.Error != nil {
+				fn(nil, m.Error)
+			} else {
+				fn(m.Result, nil)
+			}
+		}
+	}
+
+	c.lock.Lock()
+	c.closed = true
+	pending := c.pending
+	c.pending = make(map[int]responseFunc)
+	c.lock.Unlock()
+	for _, fn := range pending {
+		fn(nil, ErrClosed)
+	}
+
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
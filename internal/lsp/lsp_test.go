@@ -0,0 +1,375 @@
+package lsp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The test binary runs itself as a fake language server when this variable
+// is set
+const fakeServerEnv = "MICRO_LSP_FAKE_SERVER"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(fakeServerEnv) {
+	case "1":
+		fakeServer(false)
+		os.Exit(0)
+	case "stuck":
+		// a server that never answers the shutdown request
+		fakeServer(true)
+		os.Exit(0)
+	case "silent":
+		// a server that never answers
+		ioutil.ReadAll(os.Stdin)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeServer is a language server that keeps the text of one document,
+// reports every "bad" in it as an error and gives fixed answers to the
+// other requests. A stuck server does not answer the shutdown request
+func fakeServer(stuck bool) {
+	c := newConn(os.Stdin, os.Stdout)
+	var uri DocumentURI
+	var text string
+
+	publish := func() {
+		diagnostics := []Diagnostic{}
+		for i, line := range strings.Split(text, "\n") {
+			if x := strings.Index(line, "bad"); x >= 0 {
+				start := UTF16Offset([]byte(line), len([]rune(line[:x])))
+				diagnostics = append(diagnostics, Diagnostic{
+					Range:    Range{Position{i, start}, Position{i, start + 3}},
+					Severity: SeverityError,
+					Message:  "bad word",
+				})
+			}
+		}
+		c.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics})
+	}
+
+	c.run(func(m *message) {
+		switch m.Method {
+		case "initialize":
+			c.reply(m.ID, json.RawMessage(`{"capabilities": {
+				"textDocumentSync": {"openClose": true, "change": 2},
+				"hoverProvider": true,
+				"definitionProvider": true,
+				"renameProvider": {"prepareProvider": false},
+				"codeActionProvider": {"resolveProvider": true}
+			}}`), nil)
+		case "textDocument/didOpen":
+			var params struct {
+				TextDocument struct {
+					URI  DocumentURI `json:"uri"`
+					Text string      `json:"text"`
+				} `json:"textDocument"`
+			}
+			json.Unmarshal(m.Params, &params)
+			uri, text = params.TextDocument.URI, params.TextDocument.Text
+			publish()
+		case "textDocument/didChange":
+			var params struct {
+				ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+			}
+			json.Unmarshal(m.Params, &params)
+			for _, change := range params.ContentChanges {
+				text = applyChange(text, change)
+			}
+			publish()
+		case "test/text":
+			c.reply(m.ID, text, nil)
+		case "textDocument/definition":
+			c.reply(m.ID, json.RawMessage(`[{"targetUri": "`+uri+`",
+				"targetRange": {"start": {"line": 0, "character": 0}, "end": {"line": 2, "character": 0}},
+				"targetSelectionRange": {"start": {"line": 0, "character": 4}, "end": {"line": 0, "character": 7}}}]`), nil)
+		case "textDocument/hover":
+			c.reply(m.ID, json.RawMessage(`{"contents": [{"language": "go", "value": "func foo()"}, "Foo does nothing."]}`), nil)
+		case "textDocument/rename":
+			var params struct {
+				NewName string `json:"newName"`
+			}
+			json.Unmarshal(m.Params, &params)
+			c.reply(m.ID, WorkspaceEdit{Changes: map[DocumentURI][]TextEdit{
+				uri: {{Range{Position{0, 0}, Position{0, 3}}, params.NewName}},
+			}}, nil)
+		case "textDocument/codeAction":
+			c.reply(m.ID, json.RawMessage(`[
+				{"title": "Fix it", "kind": "quickfix", "data": 1},
+				{"title": "Run it", "command": "fake.run", "arguments": [42]}
+			]`), nil)
+		case "codeAction/resolve":
+			var action CodeAction
+			json.Unmarshal(m.Params, &action)
+			action.Edit = &WorkspaceEdit{Changes: map[DocumentURI][]TextEdit{
+				uri: {{Range{Position{0, 0}, Position{0, 0}}, "fixed "}},
+			}}
+			c.reply(m.ID, action, nil)
+		case "workspace/executeCommand":
+			id := m.ID
+			edit := WorkspaceEdit{Changes: map[DocumentURI][]TextEdit{
+				uri: {{Range{Position{1, 0}, Position{1, 0}}, "ran "}},
+			}}
+			c.call("workspace/applyEdit", map[string]interface{}{"edit": edit}, func(result json.RawMessage, err error) {
+				c.reply(id, result, nil)
+			})
+		case "shutdown":
+			if !stuck {
+				c.reply(m.ID, nil, nil)
+			}
+		case "exit":
+			os.Exit(0)
+		default:
+			if m.ID != nil {
+				c.reply(m.ID, nil, &ResponseError{Code: methodNotFound, Message: "unknown " + m.Method})
+			}
+		}
+	})
+}
+
+// applyChange applies a change to a text
+func applyChange(text string, change TextDocumentContentChangeEvent) string {
+	if change.Range == nil {
+		return change.Text
+	}
+	return string(ApplyTextEdits([]byte(text), []TextEdit{{*change.Range, change.Text}}))
+}
+
+type testHandler struct {
+	diagnostics chan PublishDiagnosticsParams
+	edits       chan WorkspaceEdit
+}
+
+func (h *testHandler) Diagnostics(params PublishDiagnosticsParams) {
+	h.diagnostics <- params
+}
+
+func (h *testHandler) ApplyEdit(edit WorkspaceEdit, reply func(bool)) {
+	h.edits <- edit
+	reply(true)
+}
+
+func (h *testHandler) ShowMessage(params ShowMessageParams) {}
+
+func startFakeServer(t *testing.T, mode string) (*Client, *testHandler) {
+	os.Setenv(fakeServerEnv, mode)
+	defer os.Unsetenv(fakeServerEnv)
+
+	h := &testHandler{
+		diagnostics: make(chan PublishDiagnosticsParams, 16),
+		edits:       make(chan WorkspaceEdit, 16),
+	}
+	c, err := Start([]string{os.Args[0], "-test.run=^$"}, t.TempDir(), h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, h
+}
+
+func receive(t *testing.T, ch chan PublishDiagnosticsParams) PublishDiagnosticsParams {
+	select {
+	case d := <-ch:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("no diagnostics received")
+	}
+	return PublishDiagnosticsParams{}
+}
+
+func TestClient(t *testing.T) {
+	c, h := startFakeServer(t, "1")
+	defer c.Shutdown()
+
+	assert.Equal(t, SyncIncremental, c.Capabilities.SyncKind())
+	assert.True(t, Supports(c.Capabilities.HoverProvider))
+	assert.True(t, Supports(c.Capabilities.RenameProvider))
+	assert.False(t, Supports(c.Capabilities.ReferencesProvider))
+	assert.True(t, c.Capabilities.CanResolveCodeActions())
+
+	uri := URIFromPath(filepath.Join(t.TempDir(), "a.go"))
+	assert.NoError(t, c.DidOpen(uri, "go", 1, "foo bar\nbaz\n"))
+	d := receive(t, h.diagnostics)
+	assert.Equal(t, uri, d.URI)
+	assert.Len(t, d.Diagnostics, 0)
+
+	// "héllo 😀 " is 9 UTF-16 code units long
+	assert.NoError(t, c.DidChange(uri, 2, []TextDocumentContentChangeEvent{
+		{Range: &Range{Position{1, 0}, Position{1, 0}}, Text: "héllo 😀 bad\n"},
+		{Range: &Range{Position{1, 9}, Position{1, 12}}, Text: "worse"},
+		{Range: &Range{Position{2, 0}, Position{2, 3}}, Text: "bad"},
+	}))
+	d = receive(t, h.diagnostics)
+	if assert.Len(t, d.Diagnostics, 1) {
+		assert.Equal(t, Range{Position{2, 0}, Position{2, 3}}, d.Diagnostics[0].Range)
+		assert.Equal(t, SeverityError, d.Diagnostics[0].Severity)
+	}
+
+	text := make(chan string, 1)
+	c.conn.call("test/text", nil, func(result json.RawMessage, err error) {
+		var s string
+		json.Unmarshal(result, &s)
+		text <- s
+	})
+	assert.Equal(t, "foo bar\nhéllo 😀 worse\nbad\n", <-text)
+
+	locs := make(chan []Location, 1)
+	c.Definition(uri, Position{1, 0}, func(l []Location, err error) {
+		assert.NoError(t, err)
+		locs <- l
+	})
+	assert.Equal(t, []Location{{uri, Range{Position{0, 4}, Position{0, 7}}}}, <-locs)
+
+	hover := make(chan string, 1)
+	c.Hover(uri, Position{0, 0}, func(s string, err error) {
+		assert.NoError(t, err)
+		hover <- s
+	})
+	assert.Equal(t, "```go\nfunc foo()\n```\n\nFoo does nothing.", <-hover)
+
+	errs := make(chan error, 1)
+	c.References(uri, Position{0, 0}, func(l []Location, err error) {
+		errs <- err
+	})
+	if err, ok := (<-errs).(*ResponseError); assert.True(t, ok) {
+		assert.Equal(t, methodNotFound, err.Code)
+	}
+
+	edits := make(chan *WorkspaceEdit, 1)
+	c.Rename(uri, Position{0, 0}, "qux", func(e *WorkspaceEdit, err error) {
+		assert.NoError(t, err)
+		edits <- e
+	})
+	assert.Equal(t, map[DocumentURI][]TextEdit{
+		uri: {{Range{Position{0, 0}, Position{0, 3}}, "qux"}},
+	}, (<-edits).Edits())
+
+	actions := make(chan []CodeAction, 1)
+	c.CodeActions(uri, Range{}, nil, func(a []CodeAction, err error) {
+		assert.NoError(t, err)
+		actions <- a
+	})
+	a := <-actions
+	if assert.Len(t, a, 2) {
+		assert.Equal(t, "Fix it", a[0].Title)
+		assert.Nil(t, a[0].Edit)
+		assert.Nil(t, a[0].Command)
+		assert.Equal(t, "Run it", a[1].Title)
+		if assert.NotNil(t, a[1].Command) {
+			assert.Equal(t, "fake.run", a[1].Command.Command)
+		}
+
+		resolved := make(chan CodeAction, 1)
+		c.ResolveCodeAction(a[0], func(r CodeAction, err error) {
+			assert.NoError(t, err)
+			resolved <- r
+		})
+		if r := <-resolved; assert.NotNil(t, r.Edit) {
+			assert.Equal(t, "fixed ", r.Edit.Edits()[uri][0].NewText)
+		}
+
+		c.ExecuteCommand(*a[1].Command, func(err error) {
+			errs <- err
+		})
+		e := <-h.edits
+		assert.Equal(t, "ran ", e.Edits()[uri][0].NewText)
+		assert.NoError(t, <-errs)
+	}
+
+	c.Shutdown()
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server did not exit")
+	}
+
+	// requests fail once the server has exited
+	c.Hover(uri, Position{0, 0}, func(s string, err error) {
+		errs <- err
+	})
+	assert.Equal(t, ErrClosed, <-errs)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	c, _ := startFakeServer(t, "stuck")
+	start := time.Now()
+	c.Shutdown()
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server was not killed")
+	}
+	assert.Less(t, int64(time.Since(start)), int64(3*time.Second))
+}
+
+func TestInitializeTimeout(t *testing.T) {
+	os.Setenv(fakeServerEnv, "silent")
+	defer os.Unsetenv(fakeServerEnv)
+	timeout := InitializeTimeout
+	InitializeTimeout = 100 * time.Millisecond
+	defer func() {
+		InitializeTimeout = timeout
+	}()
+
+	_, err := Start([]string{os.Args[0], "-test.run=^$"}, t.TempDir(), &testHandler{})
+	assert.EqualError(t, err, "no answer to the initialize request after 100ms")
+}
+
+func TestPositions(t *testing.T) {
+	line := []byte("a😀é́b")
+
+	assert.Equal(t, 0, UTF16Offset(line, 0))
+	assert.Equal(t, 1, UTF16Offset(line, 1))
+	assert.Equal(t, 3, UTF16Offset(line, 2))
+	// é with a combining accent is one character of two code units
+	assert.Equal(t, 5, UTF16Offset(line, 3))
+	assert.Equal(t, 6, UTF16Offset(line, 4))
+	assert.Equal(t, 6, UTF16Offset(line, 10))
+
+	assert.Equal(t, 0, CharacterIndex(line, 0))
+	assert.Equal(t, 1, CharacterIndex(line, 1))
+	assert.Equal(t, 1, CharacterIndex(line, 2))
+	assert.Equal(t, 2, CharacterIndex(line, 3))
+	assert.Equal(t, 3, CharacterIndex(line, 5))
+	assert.Equal(t, 4, CharacterIndex(line, 6))
+	assert.Equal(t, 4, CharacterIndex(line, 100))
+
+	assert.Equal(t, 1, ByteIndex(line, 1))
+	assert.Equal(t, 5, ByteIndex(line, 3))
+	assert.Equal(t, len(line), ByteIndex(line, 6))
+}
+
+func TestApplyTextEdits(t *testing.T) {
+	text := []byte("func foo() {\r\n\tfoo()\r\n}\r\n")
+	edits := []TextEdit{
+		{Range{Position{1, 1}, Position{1, 4}}, "bar"},
+		{Range{Position{0, 5}, Position{0, 8}}, "bar"},
+		{Range{Position{3, 0}, Position{3, 0}}, "// a\n"},
+		{Range{Position{3, 0}, Position{3, 0}}, "// b\n"},
+		{Range{Position{1, 6}, Position{2, 0}}, ""},
+	}
+	assert.Equal(t, "func bar() {\r\n\tbar()}\r\n// a\n// b\n", string(ApplyTextEdits(text, edits)))
+
+	sorted := EditsFromEnd(edits)
+	assert.Equal(t, "// b\n", sorted[0].NewText)
+	assert.Equal(t, "// a\n", sorted[1].NewText)
+	assert.Equal(t, edits[1], sorted[4])
+
+	assert.Equal(t, "😀x", string(ApplyTextEdits([]byte("😀é"), []TextEdit{{Range{Position{0, 2}, Position{0, 3}}, "x"}})))
+}
+
+func TestURIs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a b", "c#.go")
+	uri := URIFromPath(path)
+	assert.True(t, strings.HasPrefix(string(uri), "file:///"))
+	assert.Contains(t, string(uri), "a%20b/c%23.go")
+	assert.Equal(t, path, uri.Path())
+	assert.Equal(t, "", DocumentURI("https://example.com/a").Path())
+}
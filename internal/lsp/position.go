@@ -0,0 +1,69 @@
+package lsp
+
+import (
+	"unicode/utf8"
+
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+// Positions are sent in UTF-16 code units, while micro counts characters,
+// that is runes with their combining marks, in a line
+
+// UTF16Offset returns the offset in UTF-16 code units of the character at
+// index x of a line
+func UTF16Offset(line []byte, x int) int {
+	n := 0
+	for i := 0; i < x && len(line) > 0; i++ {
+		r, combc, size := util.DecodeCharacter(line)
+		n += utf16Len(r)
+		for _, c := range combc {
+			n += utf16Len(c)
+		}
+		line = line[size:]
+	}
+	return n
+}
+
+// CharacterIndex returns the index of the character of a line at the
+// offset n in UTF-16 code units. An offset in the middle of a character
+// gives that character and an offset past the end gives the end of the line
+func CharacterIndex(line []byte, n int) int {
+	x := 0
+	for n > 0 && len(line) > 0 {
+		r, combc, size := util.DecodeCharacter(line)
+		n -= utf16Len(r)
+		for _, c := range combc {
+			n -= utf16Len(c)
+		}
+		if n < 0 {
+			break
+		}
+		line = line[size:]
+		x++
+	}
+	return x
+}
+
+// ByteIndex returns the index in bytes of the offset n in UTF-16 code units
+// of a line, with the same rules as CharacterIndex
+func ByteIndex(line []byte, n int) int {
+	i := 0
+	for n > 0 && i < len(line) {
+		r, size := utf8.DecodeRune(line[i:])
+		n -= utf16Len(r)
+		if n < 0 {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+// utf16Len returns the number of UTF-16 code units of a rune. An invalid
+// rune is encoded as U+FFFD
+func utf16Len(r rune) int {
+	if r >= 0x10000 && r <= utf8.MaxRune {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,256 @@
+package lsp
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// The types of this file are the parts of the Language Server Protocol
+// that the client uses. See
+// https://microsoft.github.io/language-server-protocol/specification
+
+// A DocumentURI identifies a document, usually with a file:// URI
+type DocumentURI string
+
+// A Position is a location in a document. Line is 0-based and Character
+// is an offset in UTF-16 code units in the line
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// A Range is the text between two positions
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// A Location is a range in a document
+type Location struct {
+	URI   DocumentURI `json:"uri"`
+	Range Range       `json:"range"`
+}
+
+// A locationLink is a definition that a server may return instead of a
+// Location
+type locationLink struct {
+	TargetURI            DocumentURI `json:"targetUri"`
+	TargetSelectionRange Range       `json:"targetSelectionRange"`
+}
+
+// A TextEdit replaces the text of a range
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+// A WorkspaceEdit is a set of edits to several documents. The edits may be
+// given in Changes or in DocumentChanges, of which only the edits to
+// documents are supported
+type WorkspaceEdit struct {
+	Changes         map[DocumentURI][]TextEdit `json:"changes,omitempty"`
+	DocumentChanges []textDocumentEdit         `json:"documentChanges,omitempty"`
+}
+
+type textDocumentEdit struct {
+	TextDocument struct {
+		URI DocumentURI `json:"uri"`
+	} `json:"textDocument"`
+	Edits []TextEdit `json:"edits"`
+}
+
+// Edits returns the edits of every document
+func (e *WorkspaceEdit) Edits() map[DocumentURI][]TextEdit {
+	edits := make(map[DocumentURI][]TextEdit)
+	for uri, te := range e.Changes {
+		edits[uri] = append(edits[uri], te...)
+	}
+	for _, dc := range e.DocumentChanges {
+		// file operations such as renames have no text document
+		if dc.TextDocument.URI != "" {
+			edits[dc.TextDocument.URI] = append(edits[dc.TextDocument.URI], dc.Edits...)
+		}
+	}
+	return edits
+}
+
+// The severities of a diagnostic
+const (
+	SeverityError       = 1
+	SeverityWarning     = 2
+	SeverityInformation = 3
+	SeverityHint        = 4
+)
+
+// A Diagnostic is an error or a warning about a range of a document
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Message  string `json:"message"`
+	// the other fields are kept to be sent back with code action requests
+	Code json.RawMessage `json:"code,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// PublishDiagnosticsParams are the diagnostics of a document that a server
+// sends whenever they change
+type PublishDiagnosticsParams struct {
+	URI         DocumentURI  `json:"uri"`
+	Version     int          `json:"version,omitempty"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// A Command is a command that a server can execute, such as the command of
+// a code action
+type Command struct {
+	Title     string            `json:"title"`
+	Command   string            `json:"command"`
+	Arguments []json.RawMessage `json:"arguments,omitempty"`
+}
+
+// A CodeAction is a change that a server offers for a range of a
+// document, such as a fix for a diagnostic. It has an edit, a command that
+// makes the change, or both, which are applied in this order. If it has
+// neither, it must be resolved first
+type CodeAction struct {
+	Title   string         `json:"title"`
+	Kind    string         `json:"kind,omitempty"`
+	Edit    *WorkspaceEdit `json:"edit,omitempty"`
+	Command *Command       `json:"command,omitempty"`
+	// Data is kept to resolve the action
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// UnmarshalJSON reads a CodeAction or a Command, which servers may return
+// instead of a code action
+func (a *CodeAction) UnmarshalJSON(data []byte) error {
+	var fields struct {
+		Title   string          `json:"title"`
+		Kind    string          `json:"kind"`
+		Edit    *WorkspaceEdit  `json:"edit"`
+		Command json.RawMessage `json:"command"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*a = CodeAction{Title: fields.Title, Kind: fields.Kind, Edit: fields.Edit, Data: fields.Data}
+
+	var name string
+	if json.Unmarshal(fields.Command, &name) == nil {
+		// a bare command
+		var cmd Command
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			return err
+		}
+		a.Command = &cmd
+	} else if len(fields.Command) > 0 && string(fields.Command) != "null" {
+		a.Command = new(Command)
+		return json.Unmarshal(fields.Command, a.Command)
+	}
+	return nil
+}
+
+// ShowMessageParams is a message that a server asks to show to the user
+type ShowMessageParams struct {
+	Type    int    `json:"type"`
+	Message string `json:"message"`
+}
+
+// The message types of ShowMessageParams
+const (
+	MessageError   = 1
+	MessageWarning = 2
+	MessageInfo    = 3
+	MessageLog     = 4
+)
+
+// A TextDocumentContentChangeEvent is a change to a document. The text
+// between the positions of Range is replaced by Text, or the whole text of
+// the document if Range is nil
+type TextDocumentContentChangeEvent struct {
+	Range *Range `json:"range,omitempty"`
+	Text  string `json:"text"`
+}
+
+// The ways of syncing the text of documents with a server
+const (
+	SyncNone        = 0
+	SyncFull        = 1
+	SyncIncremental = 2
+)
+
+// ServerCapabilities are the features of a server. A provider is present
+// if the server supports the feature
+type ServerCapabilities struct {
+	TextDocumentSync   json.RawMessage `json:"textDocumentSync,omitempty"`
+	HoverProvider      json.RawMessage `json:"hoverProvider,omitempty"`
+	DefinitionProvider json.RawMessage `json:"definitionProvider,omitempty"`
+	ReferencesProvider json.RawMessage `json:"referencesProvider,omitempty"`
+	RenameProvider     json.RawMessage `json:"renameProvider,omitempty"`
+	CodeActionProvider json.RawMessage `json:"codeActionProvider,omitempty"`
+}
+
+// Supports returns whether a provider of the capabilities is present. It
+// may be true or an object with the options of the feature
+func Supports(provider json.RawMessage) bool {
+	s := string(provider)
+	return s != "" && s != "null" && s != "false"
+}
+
+// SyncKind returns how the server wants the text of documents to be synced
+func (c *ServerCapabilities) SyncKind() int {
+	var kind int
+	if json.Unmarshal(c.TextDocumentSync, &kind) == nil {
+		return kind
+	}
+	var options struct {
+		Change int `json:"change"`
+	}
+	json.Unmarshal(c.TextDocumentSync, &options)
+	return options.Change
+}
+
+// CanResolveCodeActions returns whether the server can fill in the edit of
+// a code action that has none
+func (c *ServerCapabilities) CanResolveCodeActions() bool {
+	var options struct {
+		ResolveProvider bool `json:"resolveProvider"`
+	}
+	json.Unmarshal(c.CodeActionProvider, &options)
+	return options.ResolveProvider
+}
+
+// hoverText returns the text of the contents of a hover result, which may
+// be markup, a string, a code block or a list of strings and code blocks
+func hoverText(contents json.RawMessage) string {
+	var s string
+	if json.Unmarshal(contents, &s) == nil {
+		return s
+	}
+
+	var block struct {
+		Language string `json:"language"`
+		Kind     string `json:"kind"`
+		Value    string `json:"value"`
+	}
+	if json.Unmarshal(contents, &block) == nil && block.Value != "" {
+		if block.Language != "" {
+			return "```" + block.Language + "\n" + block.Value + "\n```"
+		}
+		return block.Value
+	}
+
+	var list []json.RawMessage
+	if json.Unmarshal(contents, &list) == nil {
+		parts := make([]string, 0, len(list))
+		for _, c := range list {
+			if t := hoverText(c); t != "" {
+				parts = append(parts, t)
+			}
+		}
+		return strings.Join(parts, "\n\n")
+	}
+	return ""
+}
@@ -0,0 +1,257 @@
+package project
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+// The scores of fuzzy matches follow those of fzf. Every matched character
+// scores points, gaps between matched characters cost points, and matched
+// characters at the start of a word or path segment score a bonus
+const (
+	scoreMatch        = 16
+	scoreGapStart     = -3
+	scoreGapExtension = -1
+
+	bonusBoundary            = scoreMatch / 2
+	bonusBoundaryWhite       = bonusBoundary + 2
+	bonusBoundaryDelimiter   = bonusBoundary + 1
+	bonusNonWord             = scoreMatch / 2
+	bonusCamel123            = bonusBoundary + scoreGapExtension
+	bonusConsecutive         = -(scoreGapStart + scoreGapExtension)
+	bonusFirstCharMultiplier = 2
+)
+
+type charClass int
+
+const (
+	charWhite charClass = iota
+	charNonWord
+	charDelimiter
+	charLower
+	charUpper
+	charLetter
+	charNumber
+)
+
+func classOf(r rune) charClass {
+	switch {
+	case unicode.IsLower(r):
+		return charLower
+	case unicode.IsUpper(r):
+		return charUpper
+	case unicode.IsLetter(r):
+		return charLetter
+	case unicode.IsNumber(r):
+		return charNumber
+	case unicode.IsSpace(r):
+		return charWhite
+	case strings.ContainsRune(`/\,:;|`, r):
+		return charDelimiter
+	}
+	return charNonWord
+}
+
+// bonusFor returns the bonus of a matched character of class class that
+// follows a character of class prev
+func bonusFor(prev, class charClass) int {
+	if class > charDelimiter {
+		switch prev {
+		case charWhite:
+			return bonusBoundaryWhite
+		case charDelimiter:
+			return bonusBoundaryDelimiter
+		case charNonWord:
+			return bonusBoundary
+		}
+	}
+	if prev == charLower && class == charUpper || prev != charNumber && class == charNumber {
+		return bonusCamel123
+	}
+	switch class {
+	case charNonWord, charDelimiter:
+		return bonusNonWord
+	case charWhite:
+		return bonusBoundaryWhite
+	}
+	return 0
+}
+
+// A FuzzyMatch is a string that matches a fuzzy pattern
+type FuzzyMatch struct {
+	Str   string
+	Score int
+	// the index of Str in the strings passed to FuzzyFilter
+	Index int
+	// the character offsets in Str of the matched characters, in order
+	Positions []int
+}
+
+// Fuzzy matches pattern against s in the way of fzf. The characters of
+// each space separated term of pattern must appear in s in the same order,
+// but not necessarily next to each other. The case of letters is ignored
+// unless pattern contains an upper case letter. The higher the score, the
+// better the match
+func Fuzzy(pattern, s string) (FuzzyMatch, bool) {
+	terms := strings.Fields(pattern)
+	caseSensitive := strings.IndexFunc(pattern, unicode.IsUpper) >= 0
+
+	m := FuzzyMatch{Str: s}
+	text := []rune(s)
+	for _, t := range terms {
+		term := []rune(t)
+		if !caseSensitive {
+			for i, r := range term {
+				term[i] = unicode.ToLower(r)
+			}
+		}
+		score, positions, ok := fuzzyTerm(term, text, caseSensitive)
+		if !ok {
+			return FuzzyMatch{}, false
+		}
+		m.Score += score
+		m.Positions = append(m.Positions, positions...)
+	}
+	sort.Ints(m.Positions)
+	return m, true
+}
+
+// fuzzyTerm looks for the shortest match of term in text that ends first,
+// and returns its score and positions
+func fuzzyTerm(term, text []rune, caseSensitive bool) (int, []int, bool) {
+	fold := func(r rune) rune {
+		if caseSensitive {
+			return r
+		}
+		return unicode.ToLower(r)
+	}
+
+	// find the end of the first match from the left
+	end := -1
+	pidx := 0
+	for i, r := range text {
+		if fold(r) == term[pidx] {
+			pidx++
+			if pidx == len(term) {
+				end = i + 1
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return 0, nil, false
+	}
+
+	// go back from there to find the start of the shortest match
+	start := 0
+	pidx = len(term) - 1
+	for i := end - 1; i >= 0; i-- {
+		if fold(text[i]) == term[pidx] {
+			pidx--
+			if pidx < 0 {
+				start = i
+				break
+			}
+		}
+	}
+
+	score := 0
+	positions := make([]int, 0, len(term))
+	inGap := false
+	consecutive := 0
+	firstBonus := 0
+	prev := charWhite
+	if start > 0 {
+		prev = classOf(text[start-1])
+	}
+	pidx = 0
+	for i := start; i < end; i++ {
+		class := classOf(text[i])
+		if pidx < len(term) && fold(text[i]) == term[pidx] {
+			positions = append(positions, i)
+			score += scoreMatch
+			bonus := bonusFor(prev, class)
+			if consecutive == 0 {
+				firstBonus = bonus
+			} else {
+				// a run of consecutive matches keeps the bonus of its
+				// first character
+				if bonus >= bonusBoundary && bonus > firstBonus {
+					firstBonus = bonus
+				}
+				bonus = util.Max(util.Max(bonus, firstBonus), bonusConsecutive)
+			}
+			if pidx == 0 {
+				score += bonus * bonusFirstCharMultiplier
+			} else {
+				score += bonus
+			}
+			inGap = false
+			consecutive++
+			pidx++
+		} else {
+			if inGap {
+				score += scoreGapExtension
+			} else {
+				score += scoreGapStart
+			}
+			inGap = true
+			consecutive = 0
+			firstBonus = 0
+		}
+		prev = class
+	}
+	return score, positions, true
+}
+
+// FuzzyFilter returns the strings that match pattern, best match first.
+// Matches with equal scores are sorted from the shortest to the longest
+// string. An empty pattern matches all strings, which keep their order
+func FuzzyFilter(pattern string, strs []string) []FuzzyMatch {
+	var matches []FuzzyMatch
+	for i, s := range strs {
+		if m, ok := Fuzzy(pattern, s); ok {
+			m.Index = i
+			matches = append(matches, m)
+		}
+	}
+	if strings.TrimSpace(pattern) != "" {
+		SortFuzzyMatches(matches)
+	}
+	return matches
+}
+
+// SortFuzzyMatches sorts matches from the best to the worst
+func SortFuzzyMatches(matches []FuzzyMatch) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		return betterFuzzyMatch(matches[i], matches[j])
+	})
+}
+
+// MergeFuzzyMatches merges two lists of matches that are both sorted from
+// the best to the worst into a sorted list, as if they were sorted together
+// with SortFuzzyMatches. Of equal matches, those of a come first
+func MergeFuzzyMatches(a, b []FuzzyMatch) []FuzzyMatch {
+	merged := make([]FuzzyMatch, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if betterFuzzyMatch(b[0], a[0]) {
+			merged, b = append(merged, b[0]), b[1:]
+		} else {
+			merged, a = append(merged, a[0]), a[1:]
+		}
+	}
+	merged = append(merged, a...)
+	return append(merged, b...)
+}
+
+// betterFuzzyMatch returns whether a is a better match than b
+func betterFuzzyMatch(a, b FuzzyMatch) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return utf8.RuneCountInString(a.Str) < utf8.RuneCountInString(b.Str)
+}
@@ -0,0 +1,144 @@
+package project
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sync"
+	"unicode/utf8"
+)
+
+// A Match is an occurrence of a regular expression in a file. Line is
+// 0-based and Start and End are character offsets in the line
+type Match struct {
+	Path       string
+	Line       int
+	Start, End int
+	Text       string // the line containing the match
+
+	// the byte offsets of the match and its submatches in Text, as
+	// returned by regexp.FindSubmatchIndex
+	Submatches []int
+}
+
+// maxGrepSize is the size of the files in bytes above which Grep skips
+// them
+var maxGrepSize int64 = 32 * 1024 * 1024
+
+// GrepFile returns the matches of re in the file at path, which is
+// relative to root. Binary files and files larger than maxGrepSize have
+// no matches. The file is read line by line
+func GrepFile(root, path string, re *regexp.Regexp) ([]Match, error) {
+	f, err := os.Open(filepath.Join(root, path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxGrepSize {
+		return nil, nil
+	}
+	r := bufio.NewReaderSize(f, 64*1024)
+	if head, _ := r.Peek(binaryCheckSize); IsBinary(head) {
+		return nil, nil
+	}
+
+	var matches []Match
+	for y := 0; ; y++ {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			line = bytes.TrimSuffix(line, []byte{'\n'})
+			matches = grepLine(matches, path, y, line, re)
+		}
+		if err == io.EOF {
+			return matches, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// GrepBytes returns the matches of re in data, which are the contents of
+// the file at path
+func GrepBytes(path string, data []byte, re *regexp.Regexp) []Match {
+	var matches []Match
+	for y := 0; len(data) > 0; y++ {
+		var line []byte
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			line, data = data, nil
+		}
+		matches = grepLine(matches, path, y, line, re)
+	}
+	return matches
+}
+
+// grepLine appends the matches of re in line y of the file at path to
+// matches
+func grepLine(matches []Match, path string, y int, line []byte, re *regexp.Regexp) []Match {
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+
+	var text string
+	for _, loc := range re.FindAllSubmatchIndex(line, -1) {
+		if text == "" {
+			text = string(line)
+		}
+		start := utf8.RuneCount(line[:loc[0]])
+		matches = append(matches, Match{
+			Path:  path,
+			Line:  y,
+			Start: start,
+			End:   start + utf8.RuneCount(line[loc[0]:loc[1]]),
+			Text:  text,
+
+			Submatches: loc,
+		})
+	}
+	return matches
+}
+
+// Grep searches the files under root for re with one goroutine per CPU.
+// Files that are ignored, binary or larger than maxGrepSize are skipped.
+// The matches of each file are sent to results together, in no particular
+// order, and results is closed when the search is complete or canceled by
+// closing done
+func Grep(root string, re *regexp.Regexp, done <-chan struct{}, results chan<- []Match) {
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range paths {
+				matches, err := GrepFile(root, p, re)
+				if err != nil || len(matches) == 0 {
+					continue
+				}
+				select {
+				case results <- matches:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	Walk(root, done, func(p string) {
+		select {
+		case paths <- p:
+		case <-done:
+		}
+	})
+	close(paths)
+	wg.Wait()
+	close(results)
+}
@@ -0,0 +1,137 @@
+package project
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// An ignoreRule is a pattern read from a .gitignore file
+type ignoreRule struct {
+	pattern  string
+	negate   bool // the pattern starts with '!' and re-includes paths
+	dirOnly  bool // the pattern ends with '/' and only matches directories
+	anchored bool // the pattern is matched against the whole path instead of the base name
+}
+
+// An ignoreList holds the rules of the .gitignore file of a directory.
+// The rules of the .gitignore files in the parent directories are
+// reached through parent
+type ignoreList struct {
+	dir    string // the directory relative to the root, with slashes
+	rules  []ignoreRule
+	parent *ignoreList
+}
+
+// parseIgnoreRule parses a line of a .gitignore file. It returns false
+// for blank lines and comments
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	var r ignoreRule
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || line[0] == '#' {
+		return r, false
+	}
+	if line[0] == '!' {
+		r.negate = true
+		line = line[1:]
+	} else if line[0] == '\\' {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.HasPrefix(line, "/") {
+		r.anchored = true
+		line = strings.TrimLeft(line, "/")
+	} else if strings.Contains(line, "/") {
+		r.anchored = true
+	}
+	if line == "" {
+		return r, false
+	}
+	r.pattern = line
+	return r, true
+}
+
+// readIgnoreList reads the .gitignore file of dir, which is relative to
+// root. It returns parent if the directory has no .gitignore file
+func readIgnoreList(root, dir string, parent *ignoreList) *ignoreList {
+	f, err := os.Open(filepath.Join(root, filepath.FromSlash(dir), ".gitignore"))
+	if err != nil {
+		return parent
+	}
+	defer f.Close()
+
+	l := &ignoreList{dir: dir, parent: parent}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if r, ok := parseIgnoreRule(scanner.Text()); ok {
+			l.rules = append(l.rules, r)
+		}
+	}
+	if len(l.rules) == 0 {
+		return parent
+	}
+	return l
+}
+
+// ignored returns whether the path, which is relative to the root and
+// uses slashes, is ignored. Like in git, the last matching rule decides
+// and the rules of deeper directories take precedence
+func (l *ignoreList) ignored(p string, isDir bool) bool {
+	for ; l != nil; l = l.parent {
+		rel := p
+		if l.dir != "" {
+			if !strings.HasPrefix(p, l.dir+"/") {
+				continue
+			}
+			rel = p[len(l.dir)+1:]
+		}
+		for i := len(l.rules) - 1; i >= 0; i-- {
+			if l.rules[i].match(rel, isDir) {
+				return !l.rules[i].negate
+			}
+		}
+	}
+	return false
+}
+
+// match returns whether the rule matches the path, which is relative to
+// the directory of the .gitignore file
+func (r ignoreRule) match(p string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		ok, _ := path.Match(r.pattern, path.Base(p))
+		return ok
+	}
+	return matchSegments(strings.Split(r.pattern, "/"), strings.Split(p, "/"))
+}
+
+// matchSegments matches the slash separated segments of a path against the
+// segments of a pattern, where a "**" segment matches any number of
+// segments
+func matchSegments(pattern, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pattern[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
@@ -0,0 +1,85 @@
+package project
+
+import (
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// IndexFiles sends batches of the paths of the files under root to
+// batches. Like in Walk, ignored files are skipped and the paths are
+// relative to root; binary files are skipped too. A batch is sent when it
+// is large or when some time passed since the last one, so that the files
+// can be used while the index is built. batches is closed when the index
+// is complete or canceled by closing done
+func IndexFiles(root string, done <-chan struct{}, batches chan<- []string) {
+	const (
+		batchSize     = 1000
+		batchInterval = 50 * time.Millisecond
+	)
+
+	paths := make(chan string)
+	files := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range paths {
+				if IsBinaryFile(filepath.Join(root, p)) {
+					continue
+				}
+				select {
+				case files <- p:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		Walk(root, done, func(p string) {
+			select {
+			case paths <- p:
+			case <-done:
+			}
+		})
+		close(paths)
+		wg.Wait()
+		close(files)
+	}()
+
+	var batch []string
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		select {
+		case batches <- batch:
+		case <-done:
+		}
+		batch = nil
+	}
+
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case p, ok := <-files:
+			if !ok {
+				if !canceled(done) {
+					flush()
+				}
+				close(batches)
+				return
+			}
+			batch = append(batch, p)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
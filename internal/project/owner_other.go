@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !dragonfly && !solaris && !openbsd && !netbsd && !freebsd
+// +build !linux,!darwin,!dragonfly,!solaris,!openbsd,!netbsd,!freebsd
+
+package project
+
+import "os"
+
+// chownLike does nothing on systems without unix file owners
+func chownLike(name string, info os.FileInfo) {}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || dragonfly || solaris || openbsd || netbsd || freebsd
+// +build linux darwin dragonfly solaris openbsd netbsd freebsd
+
+package project
+
+import (
+	"os"
+	"syscall"
+)
+
+// chownLike gives the file name the owner and group of the file described
+// by info. Errors are ignored, since only root may give a file away
+func chownLike(name string, info os.FileInfo) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		os.Chown(name, int(st.Uid), int(st.Gid))
+	}
+}
@@ -0,0 +1,242 @@
+package project
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	parse := func(lines ...string) *ignoreList {
+		l := &ignoreList{}
+		for _, line := range lines {
+			if r, ok := parseIgnoreRule(line); ok {
+				l.rules = append(l.rules, r)
+			}
+		}
+		return l
+	}
+
+	l := parse("# comment", "", "*.o", "!keep.o", "build/", "/root.txt", "doc/*.html", "a/**/z")
+	assert.Len(t, l.rules, 6)
+
+	assert.True(t, l.ignored("x.o", false))
+	assert.True(t, l.ignored("src/x.o", false))
+	assert.False(t, l.ignored("src/keep.o", false))
+	assert.True(t, l.ignored("build", true))
+	assert.True(t, l.ignored("src/build", true))
+	assert.False(t, l.ignored("build", false))
+	assert.True(t, l.ignored("root.txt", false))
+	assert.False(t, l.ignored("src/root.txt", false))
+	assert.True(t, l.ignored("doc/index.html", false))
+	assert.False(t, l.ignored("doc/api/index.html", false))
+	assert.True(t, l.ignored("a/z", false))
+	assert.True(t, l.ignored("a/b/c/z", false))
+	assert.False(t, l.ignored("b/a/z", false))
+
+	sub := parse("!x.o")
+	sub.dir = "src"
+	sub.parent = l
+	assert.False(t, sub.ignored("src/x.o", false))
+	assert.True(t, sub.ignored("lib/x.o", false))
+}
+
+func TestIsBinary(t *testing.T) {
+	assert.False(t, IsBinary([]byte("hello\nworld\n")))
+	assert.True(t, IsBinary([]byte("hello\x00world")))
+	assert.False(t, IsBinary(nil))
+}
+
+func TestWalkAndGrep(t *testing.T) {
+	root, err := ioutil.TempDir("", "micro-project")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		".gitignore":        "*.log\nvendor/\n",
+		"main.go":           "package main\n\nfunc main() {\n\tfoo()\n}\n",
+		"lib/foo.go":        "package lib\r\n\r\n// héllo foo foo\r\n",
+		"lib/.gitignore":    "gen.go\n",
+		"lib/gen.go":        "foo\n",
+		"debug.log":         "foo\n",
+		"vendor/dep/dep.go": "foo\n",
+		"data.bin":          "foo\x00\n",
+		".git/config":       "foo\n",
+	}
+	for name, text := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		assert.NoError(t, ioutil.WriteFile(p, []byte(text), 0644))
+	}
+
+	var paths []string
+	Walk(root, nil, func(p string) {
+		paths = append(paths, filepath.ToSlash(p))
+	})
+	sort.Strings(paths)
+	assert.Equal(t, []string{".gitignore", "data.bin", "lib/.gitignore", "lib/foo.go", "main.go"}, paths)
+	assert.True(t, Ignored(root, filepath.FromSlash("lib/gen.go")))
+	assert.True(t, Ignored(root, filepath.FromSlash("vendor/dep/dep.go")))
+	assert.False(t, Ignored(root, "main.go"))
+
+	batches := make(chan []string)
+	go IndexFiles(root, make(chan struct{}), batches)
+	var indexed []string
+	for b := range batches {
+		for _, p := range b {
+			indexed = append(indexed, filepath.ToSlash(p))
+		}
+	}
+	sort.Strings(indexed)
+	assert.Equal(t, []string{".gitignore", "lib/.gitignore", "lib/foo.go", "main.go"}, indexed)
+
+	results := make(chan []Match)
+	go Grep(root, regexp.MustCompile("foo"), make(chan struct{}), results)
+	var matches []Match
+	for m := range results {
+		matches = append(matches, m...)
+	}
+	for i := range matches {
+		matches[i].Submatches = nil
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Path != matches[j].Path {
+			return matches[i].Path < matches[j].Path
+		}
+		return matches[i].Start < matches[j].Start
+	})
+	assert.Equal(t, []Match{
+		{Path: filepath.FromSlash("lib/foo.go"), Line: 2, Start: 9, End: 12, Text: "// héllo foo foo"},
+		{Path: filepath.FromSlash("lib/foo.go"), Line: 2, Start: 13, End: 16, Text: "// héllo foo foo"},
+		{Path: "main.go", Line: 3, Start: 1, End: 4, Text: "\tfoo()"},
+	}, matches)
+}
+
+func TestReplace(t *testing.T) {
+	root, err := ioutil.TempDir("", "micro-project")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(root)
+
+	name := filepath.Join(root, "a.txt")
+	assert.NoError(t, ioutil.WriteFile(name, []byte("foo(1) bar\r\nfoo(2) foo(3)\r\n"), 0600))
+
+	re := regexp.MustCompile(`foo\((\d)\)`)
+	matches, err := GrepFile(root, "a.txt", re)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 3)
+	assert.Equal(t, GrepBytes("a.txt", []byte("foo(1) bar\r\nfoo(2) foo(3)\r\n"), re), matches)
+
+	// binary files and files above the size limit are skipped
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "b.bin"), []byte("foo(1)\x00"), 0600))
+	matches, err = GrepFile(root, "b.bin", re)
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+	maxGrepSize = 8
+	matches, err = GrepFile(root, "a.txt", re)
+	maxGrepSize = 32 * 1024 * 1024
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+	assert.NoError(t, os.Remove(filepath.Join(root, "b.bin")))
+
+	matches, err = GrepFile(root, "a.txt", re)
+	assert.NoError(t, err)
+
+	var reps []Replacement
+	for _, m := range matches {
+		if m.Start != 7 {
+			reps = append(reps, Replacement{Match: m, New: m.Expand(re, []byte("baz[$1]"))})
+		}
+	}
+	assert.Equal(t, "baz[1] bar", ReplaceLine(reps[0].Text, reps[:1]))
+
+	assert.NoError(t, ReplaceInFile(root, "a.txt", reps))
+	data, err := ioutil.ReadFile(name)
+	assert.NoError(t, err)
+	assert.Equal(t, "baz[1] bar\r\nbaz[2] foo(3)\r\n", string(data))
+	info, err := os.Stat(name)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// the file changed since the search
+	assert.Error(t, ReplaceInFile(root, "a.txt", reps))
+
+	// a symlink is written through
+	if os.Symlink("a.txt", filepath.Join(root, "link.txt")) == nil {
+		matches, err = GrepFile(root, "link.txt", re)
+		assert.NoError(t, err)
+		reps = []Replacement{{Match: matches[0], New: "qux"}}
+		assert.NoError(t, ReplaceInFile(root, "link.txt", reps))
+		info, err = os.Lstat(filepath.Join(root, "link.txt"))
+		assert.NoError(t, err)
+		assert.True(t, info.Mode()&os.ModeSymlink != 0)
+		data, err = ioutil.ReadFile(name)
+		assert.NoError(t, err)
+		assert.Equal(t, "baz[1] bar\r\nbaz[2] qux\r\n", string(data))
+		info, err = os.Stat(name)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// no temporary files are left behind
+	entries, err := ioutil.ReadDir(root)
+	assert.NoError(t, err)
+	for _, e := range entries {
+		assert.False(t, strings.Contains(e.Name(), ".tmp"), e.Name())
+	}
+}
+
+func TestFuzzy(t *testing.T) {
+	m, ok := Fuzzy("fb", "foo/bar.go")
+	assert.True(t, ok)
+	assert.Equal(t, []int{0, 4}, m.Positions)
+
+	_, ok = Fuzzy("bf", "foo/bar.go")
+	assert.False(t, ok)
+	_, ok = Fuzzy("Foo", "foo/bar.go")
+	assert.False(t, ok)
+	_, ok = Fuzzy("bar foo", "foo/bar.go")
+	assert.True(t, ok)
+
+	// the shortest match is scored
+	m, _ = Fuzzy("ab", "a/xab")
+	assert.Equal(t, []int{3, 4}, m.Positions)
+
+	matches := FuzzyFilter("buf", []string{
+		"internal/display/bufwindow.go",
+		"internal/bufpane.go",
+		"cmd/micro/debug.go",
+		"b/u/f",
+		"README.md",
+	})
+	var strs []string
+	for _, m := range matches {
+		strs = append(strs, m.Str)
+	}
+	assert.Equal(t, 1, matches[0].Index)
+	assert.Equal(t, []string{"internal/bufpane.go", "internal/display/bufwindow.go", "b/u/f"}, strs)
+
+	assert.Len(t, FuzzyFilter(" ", []string{"a", "b"}), 2)
+
+	// merging gives the same order as filtering all the strings at once
+	files := []string{"internal/display/bufwindow.go", "b/u/f", "internal/bufpane.go", "buf", "a/buf.go", "xbuf"}
+	merged := MergeFuzzyMatches(FuzzyFilter("buf", files[:3]), FuzzyFilter("buf", files[3:]))
+	strs = nil
+	for _, m := range merged {
+		strs = append(strs, m.Str)
+	}
+	var all []string
+	for _, m := range FuzzyFilter("buf", files) {
+		all = append(all, m.Str)
+	}
+	assert.Equal(t, all, strs)
+}
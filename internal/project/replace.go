@@ -0,0 +1,123 @@
+package project
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A Replacement is a match together with the text that replaces it
+type Replacement struct {
+	Match
+	New string
+}
+
+// Expand returns the text that replaces the match, which is template with
+// the submatches expanded like in regexp.Expand
+func (m Match) Expand(re *regexp.Regexp, template []byte) string {
+	return string(re.Expand(nil, template, []byte(m.Text), m.Submatches))
+}
+
+// ReplaceLine returns line with the replacements applied. The replacements
+// must be on that line and must not overlap
+func ReplaceLine(line string, reps []Replacement) string {
+	reps = append([]Replacement(nil), reps...)
+	sort.Slice(reps, func(i, j int) bool {
+		return reps[i].Start < reps[j].Start
+	})
+
+	runes := []rune(line)
+	var b strings.Builder
+	pos := 0
+	for _, r := range reps {
+		b.WriteString(string(runes[pos:r.Start]))
+		b.WriteString(r.New)
+		pos = r.End
+	}
+	b.WriteString(string(runes[pos:]))
+	return b.String()
+}
+
+// ReplaceInFile applies the replacements to the file at path, which is
+// relative to root. The file is left unchanged if one of the lines of the
+// replacements was modified since the search
+func ReplaceInFile(root, path string, reps []Replacement) error {
+	name := filepath.Join(root, path)
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	byLine := make(map[int][]Replacement)
+	for _, r := range reps {
+		byLine[r.Line] = append(byLine[r.Line], r)
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for y, reps := range byLine {
+		if y >= len(lines) {
+			return errors.New(path + " was modified since the search")
+		}
+		line := lines[y]
+		cr := strings.HasSuffix(line, "\r")
+		line = strings.TrimSuffix(line, "\r")
+		if line != reps[0].Text {
+			return errors.New(path + " was modified since the search")
+		}
+		line = ReplaceLine(line, reps)
+		if cr {
+			line += "\r"
+		}
+		lines[y] = line
+	}
+	return WriteFileAtomic(name, []byte(strings.Join(lines, "\n")))
+}
+
+// WriteFileAtomic writes data to a temporary file in the directory of name
+// and renames it to name, so that the file is never left half written. A
+// symlink is followed, so that the file it points to is replaced, and the
+// permissions and owner of the existing file are kept
+func WriteFileAtomic(name string, data []byte) (err error) {
+	if target, err := filepath.EvalSymlinks(name); err == nil {
+		name = target
+	}
+	perm := os.FileMode(0644)
+	info, statErr := os.Stat(name)
+	if statErr == nil {
+		perm = info.Mode().Perm()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if statErr == nil {
+		chownLike(f.Name(), info)
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
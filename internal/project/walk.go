@@ -0,0 +1,116 @@
+// Package project finds and searches the files of the directory tree that
+// micro was started in
+package project
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// binaryCheckSize is the number of bytes at the start of a file that are
+// checked for a NUL byte to detect binary files
+const binaryCheckSize = 8000
+
+// IsBinary returns whether data looks like the contents of a binary file,
+// which is the case when there is a NUL byte near the start
+func IsBinary(data []byte) bool {
+	if len(data) > binaryCheckSize {
+		data = data[:binaryCheckSize]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// IsBinaryFile returns whether the file at path is a binary file
+func IsBinaryFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	buf := make([]byte, binaryCheckSize)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false
+	}
+	return IsBinary(buf[:n])
+}
+
+// canceled returns whether the done channel is closed
+func canceled(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Walk calls fn with the path of every regular file under root that is not
+// ignored by a .gitignore file. The paths are relative to root and use the
+// separator of the OS. The .git directory is skipped and symbolic links to
+// directories are not followed. The walk stops early when done is closed;
+// done may be nil
+func Walk(root string, done <-chan struct{}, fn func(path string)) {
+	walkDir(root, "", readIgnoreList(root, "", nil), done, fn)
+}
+
+func walkDir(root, dir string, ignore *ignoreList, done <-chan struct{}, fn func(path string)) bool {
+	entries, err := os.ReadDir(filepath.Join(root, filepath.FromSlash(dir)))
+	if err != nil {
+		return true
+	}
+	for _, e := range entries {
+		if canceled(done) {
+			return false
+		}
+
+		name := e.Name()
+		rel := path.Join(dir, name)
+		mode := e.Type()
+		if mode&os.ModeSymlink != 0 {
+			info, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel)))
+			if err != nil || info.IsDir() {
+				continue
+			}
+			mode = info.Mode().Type()
+		}
+
+		if mode.IsDir() {
+			if name == ".git" || ignore.ignored(rel, true) {
+				continue
+			}
+			if !walkDir(root, rel, readIgnoreList(root, rel, ignore), done, fn) {
+				return false
+			}
+		} else if mode.IsRegular() && !ignore.ignored(rel, false) {
+			fn(filepath.FromSlash(rel))
+		}
+	}
+	return true
+}
+
+// Ignored returns whether Walk skips the file at path, which is relative to
+// root, because it or one of its directories is ignored by a .gitignore
+// file or is the .git directory
+func Ignored(root, p string) bool {
+	segs := strings.Split(filepath.ToSlash(p), "/")
+	ignore := readIgnoreList(root, "", nil)
+	dir := ""
+	for i, s := range segs {
+		rel := path.Join(dir, s)
+		isDir := i < len(segs)-1
+		if isDir && s == ".git" || ignore.ignored(rel, isDir) {
+			return true
+		}
+		if isDir {
+			ignore = readIgnoreList(root, rel, ignore)
+			dir = rel
+		}
+	}
+	return false
+}
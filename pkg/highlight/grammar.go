@@ -0,0 +1,812 @@
+package highlight
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	textMateExt = ".tmLanguage.json"
+	sublimeExt  = ".sublime-syntax"
+)
+
+// maxRegionDepth is how deeply the regions of a converted grammar may be
+// nested, since grammars often include the same rules in many places
+const maxRegionDepth = 8
+
+// A Grammar is a syntax file converted from a TextMate grammar
+// (.tmLanguage.json) or a Sublime Text syntax (.sublime-syntax)
+type Grammar struct {
+	// FileType is the filetype of the syntax file
+	FileType string
+	// Data is the syntax file in micro's yaml format
+	Data []byte
+	// Warnings describe the parts of the grammar that could not be
+	// converted and are left out of the syntax file
+	Warnings []string
+}
+
+// IsGrammar returns whether the file name is that of a grammar which can
+// be converted with ConvertGrammar
+func IsGrammar(filename string) bool {
+	return strings.HasSuffix(filename, textMateExt) || strings.HasSuffix(filename, sublimeExt)
+}
+
+// GrammarName returns the name of a grammar file without its directory
+// and extension
+func GrammarName(filename string) string {
+	name := filepath.Base(filename)
+	name = strings.TrimSuffix(name, textMateExt)
+	return strings.TrimSuffix(name, sublimeExt)
+}
+
+// ConvertGrammar converts a TextMate or Sublime Text grammar, whose format
+// is given by the extension of the file name, to a syntax file. If the
+// filetype is empty, the name of the file in lower case is used.
+// Constructs that syntax files cannot express, such as backreferences or
+// contexts which are not simply pushed and popped, are left out with a
+// warning
+func ConvertGrammar(filename, filetype string, data []byte) (*Grammar, error) {
+	if filetype == "" {
+		filetype = strings.ToLower(GrammarName(filename))
+	}
+	c := &converter{filetype: filetype, warned: make(map[string]bool)}
+
+	var err error
+	switch {
+	case strings.HasSuffix(filename, textMateExt):
+		err = c.textMate(data)
+	case strings.HasSuffix(filename, sublimeExt):
+		err = c.sublime(data)
+	default:
+		err = errors.New(filename + " is not a TextMate or Sublime Text grammar")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	detect := yaml.MapSlice{}
+	if c.detect[0] != "" {
+		detect = append(detect, yaml.MapItem{Key: "filename", Value: c.detect[0]})
+	}
+	if c.detect[1] != "" {
+		detect = append(detect, yaml.MapItem{Key: "header", Value: c.detect[1]})
+	}
+	out, err := yaml.Marshal(yaml.MapSlice{
+		{Key: "filetype", Value: filetype},
+		{Key: "detect", Value: detect},
+		{Key: "rules", Value: c.rules.list()},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Grammar{
+		FileType: filetype,
+		Data:     out,
+		Warnings: c.warnings,
+	}, nil
+}
+
+// A converter holds the state shared by the conversion of TextMate and
+// Sublime Text grammars
+type converter struct {
+	filetype string
+	// the regular expressions that detect the filetype from the file name
+	// and from the first line
+	detect [2]string
+	rules  ruleList
+	// how deeply the region being converted is nested
+	depth    int
+	warnings []string
+	warned   map[string]bool
+}
+
+// warn records a warning about the rule of the grammar at where, once
+func (c *converter) warn(where, format string, args ...interface{}) {
+	w := where + ": " + fmt.Sprintf(format, args...)
+	if !c.warned[w] {
+		c.warned[w] = true
+		c.warnings = append(c.warnings, w)
+	}
+}
+
+// setDetect sets the regular expressions that detect the filetype from
+// the file extensions (or names) and the regular expression matching the
+// first line
+func (c *converter) setDetect(exts []string, where, firstLine string) {
+	if len(exts) > 0 {
+		quoted := make([]string, len(exts))
+		for i, ext := range exts {
+			quoted[i] = regexp.QuoteMeta(ext)
+		}
+		c.detect[0] = `(^|[/.])(` + strings.Join(quoted, "|") + `)$`
+	}
+	if firstLine != "" {
+		if re, ok := c.regex(where, firstLine); ok {
+			c.detect[1] = re
+		}
+	}
+}
+
+// regex translates a regular expression of a grammar to Go's syntax. If it
+// uses features that Go does not support, a warning is recorded and false
+// is returned
+func (c *converter) regex(where, re string) (string, bool) {
+	re, unsupported := translateRegex(re)
+	if unsupported != "" {
+		c.warn(where, "%s is not supported, so the rule is left out", unsupported)
+		return "", false
+	}
+	if _, err := regexp.Compile(re); err != nil {
+		c.warn(where, "%v, so the rule is left out", err)
+		return "", false
+	}
+	return re, true
+}
+
+// enterRegion increases the depth of nested regions, or returns false with
+// a warning if the regions are too deep
+func (c *converter) enterRegion(where string) bool {
+	if c.depth >= maxRegionDepth {
+		c.warn(where, "regions are nested more than %d levels deep, so the rule is left out", maxRegionDepth)
+		return false
+	}
+	c.depth++
+	return true
+}
+
+// A ruleList collects the patterns, regions and includes of a list of
+// rules of a syntax file
+type ruleList struct {
+	patterns []interface{}
+	regions  []interface{}
+	includes []interface{}
+	// the patterns of escape sequences, which are skipped when looking for
+	// the end of a region
+	escapes []string
+}
+
+func (l *ruleList) pattern(group, regex string) {
+	l.patterns = append(l.patterns, yaml.MapSlice{{Key: group, Value: regex}})
+	if group == "constant.specialChar" && strings.HasPrefix(regex, `\\`) {
+		l.escapes = append(l.escapes, regex)
+	}
+}
+
+func (l *ruleList) region(group, start, end string, rules *ruleList) {
+	r := yaml.MapSlice{
+		{Key: "start", Value: start},
+		{Key: "end", Value: end},
+	}
+	if len(rules.escapes) > 0 {
+		r = append(r, yaml.MapItem{Key: "skip", Value: alternation(rules.escapes)})
+	}
+	r = append(r, yaml.MapItem{Key: "rules", Value: rules.list()})
+	l.regions = append(l.regions, yaml.MapSlice{{Key: group, Value: r}})
+}
+
+func (l *ruleList) include(filetype string) {
+	l.includes = append(l.includes, yaml.MapSlice{{Key: "include", Value: filetype}})
+}
+
+// list returns the rules in the order of a syntax file. The patterns are
+// reversed, because the last matching pattern wins in a syntax file while
+// the first one does in a grammar
+func (l *ruleList) list() []interface{} {
+	rules := make([]interface{}, 0, len(l.patterns)+len(l.regions)+len(l.includes))
+	for i := len(l.patterns) - 1; i >= 0; i-- {
+		rules = append(rules, l.patterns[i])
+	}
+	rules = append(rules, l.regions...)
+	return append(rules, l.includes...)
+}
+
+// alternation returns a regular expression matching any of the given ones
+func alternation(res []string) string {
+	if len(res) == 1 {
+		return res[0]
+	}
+	return "(?:" + strings.Join(res, ")|(?:") + ")"
+}
+
+// translateRegex translates an Oniguruma regular expression, as used by
+// grammars, to Go's syntax. If the expression uses a feature that cannot
+// be translated, the feature is returned
+func translateRegex(re string) (string, string) {
+	// extended mode, which ignores whitespace and comments, is only
+	// supported for the whole expression
+	extended := strings.HasPrefix(re, "(?x)")
+	if extended {
+		re = re[len("(?x)"):]
+	}
+
+	var b strings.Builder
+	class := 0
+	quantifier := false
+	for i := 0; i < len(re); i++ {
+		c := re[i]
+		afterQuantifier := quantifier
+		quantifier = false
+
+		switch {
+		case c == '\\' && i+1 < len(re):
+			i++
+			e := re[i]
+			switch {
+			case e >= '1' && e <= '9' && class == 0, e == 'k' && strings.HasPrefix(re[i+1:], "<"):
+				return "", "a backreference"
+			case e == 'G':
+				return "", `the \G anchor`
+			case e == 'h' && class > 0:
+				b.WriteString("0-9a-fA-F")
+			case e == 'h':
+				b.WriteString("[0-9a-fA-F]")
+			case e == 'H' && class == 0:
+				b.WriteString("[^0-9a-fA-F]")
+			case e == 'Z':
+				b.WriteString("$")
+			case e == 'n' && class == 0:
+				// lines are highlighted without their line ending
+				b.WriteString("$")
+			case e == 'u' && i+4 < len(re):
+				b.WriteString(`\x{` + re[i+1:i+5] + "}")
+				i += 4
+			case e == ' ':
+				b.WriteByte(' ')
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(e)
+			}
+		case class > 0 && c == '[' && strings.HasPrefix(re[i+1:], ":"):
+			// a POSIX class such as [:alpha:]
+			end := strings.Index(re[i:], ":]")
+			if end < 0 {
+				end = len(re) - i - 2
+			}
+			b.WriteString(re[i : i+end+2])
+			i += end + 1
+		case class > 0 && c == '[':
+			return "", "a nested character class"
+		case class > 0 && strings.HasPrefix(re[i:], "&&"):
+			return "", "a character class intersection"
+		case c == '[':
+			class++
+			b.WriteByte(c)
+			if strings.HasPrefix(re[i+1:], "^") {
+				b.WriteByte('^')
+				i++
+			}
+			if strings.HasPrefix(re[i+1:], "]") {
+				b.WriteString(`\]`)
+				i++
+			}
+		case c == ']' && class > 0:
+			class--
+			b.WriteByte(c)
+		case class > 0:
+			b.WriteByte(c)
+		case strings.HasPrefix(re[i:], "(?=") || strings.HasPrefix(re[i:], "(?!"):
+			return "", "a lookahead"
+		case strings.HasPrefix(re[i:], "(?<=") || strings.HasPrefix(re[i:], "(?<!"):
+			return "", "a lookbehind"
+		case strings.HasPrefix(re[i:], "(?>"):
+			// an atomic group only changes how the expression backtracks
+			b.WriteString("(?:")
+			i += 2
+		case strings.HasPrefix(re[i:], "(?<"):
+			b.WriteString("(?P<")
+			i += 2
+		case strings.HasPrefix(re[i:], "(?#"):
+			end := strings.IndexByte(re[i:], ')')
+			if end < 0 {
+				end = len(re) - i - 1
+			}
+			i += end
+		case extended && (c == ' ' || c == '\t' || c == '\n' || c == '\r'):
+		case extended && c == '#':
+			end := strings.IndexByte(re[i:], '\n')
+			if end < 0 {
+				end = len(re) - i - 1
+			}
+			i += end
+		case c == '+' && afterQuantifier:
+			// a possessive quantifier, which Go does not have
+		case c == '*' || c == '+' || c == '?':
+			quantifier = true
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), ""
+}
+
+// scopeGroups maps the scopes of grammars to the groups of syntax files
+var scopeGroups = map[string]string{
+	"comment":                        "comment",
+	"constant":                       "constant",
+	"constant.character.escape":      "constant.specialChar",
+	"constant.language":              "constant.bool",
+	"constant.numeric":               "constant.number",
+	"entity.name":                    "identifier",
+	"entity.name.class":              "type",
+	"entity.name.section":            "special",
+	"entity.name.tag":                "symbol.tag",
+	"entity.name.type":               "type",
+	"entity.other.attribute-name":    "special",
+	"entity.other.inherited-class":   "type",
+	"invalid":                        "error",
+	"keyword":                        "statement",
+	"keyword.control.directive":      "preproc",
+	"keyword.operator":               "symbol.operator",
+	"keyword.other.preprocessor":     "preproc",
+	"markup.changed":                 "diff-modified",
+	"markup.deleted":                 "diff-deleted",
+	"markup.heading":                 "special",
+	"markup.inserted":                "diff-added",
+	"markup.quote":                   "comment",
+	"markup.raw":                     "constant",
+	"markup.underline":               "underlined",
+	"meta.preprocessor":              "preproc",
+	"punctuation":                    "symbol",
+	"punctuation.definition.comment": "comment",
+	"punctuation.definition.string":  "constant.string",
+	"punctuation.definition.tag":     "symbol.tag",
+	"punctuation.section":            "symbol.brackets",
+	"storage":                        "type.keyword",
+	"storage.type":                   "type",
+	"string":                         "constant.string",
+	"support.class":                  "type",
+	"support.constant":               "constant",
+	"support.function":               "identifier",
+	"support.type":                   "type",
+	"variable.language":              "special",
+}
+
+// scopeGroup returns the group of the most specific scope of a space
+// separated list of scopes, or "" if none of them has a group
+func scopeGroup(scopes string) string {
+	group, length := "", 0
+	for _, scope := range strings.Fields(scopes) {
+		for prefix, g := range scopeGroups {
+			if (scope == prefix || strings.HasPrefix(scope, prefix+".")) && len(prefix) > length {
+				group, length = g, len(prefix)
+			}
+		}
+	}
+	return group
+}
+
+// scopeFileTypes maps the names in the scopes of common grammars to
+// filetypes where they differ
+var scopeFileTypes = map[string]string{
+	"cs":   "csharp",
+	"js":   "javascript",
+	"objc": "objective-c",
+	"sh":   "shell",
+	"ts":   "typescript",
+}
+
+// scopeFileType returns the filetype of a grammar with the given scope,
+// such as javascript for source.js
+func scopeFileType(scope string) string {
+	name := strings.TrimPrefix(strings.TrimPrefix(scope, "source."), "text.")
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	if ft, ok := scopeFileTypes[name]; ok {
+		return ft
+	}
+	return name
+}
+
+// A tmRule is a rule of a TextMate grammar
+type tmRule struct {
+	Name        string               `json:"name"`
+	ContentName string               `json:"contentName"`
+	Match       string               `json:"match"`
+	Begin       string               `json:"begin"`
+	End         string               `json:"end"`
+	While       string               `json:"while"`
+	Captures    map[string]tmCapture `json:"captures"`
+	Include     string               `json:"include"`
+	Patterns    []tmRule             `json:"patterns"`
+}
+
+type tmCapture struct {
+	Name string `json:"name"`
+}
+
+// A tmGrammar is a TextMate grammar in JSON
+type tmGrammar struct {
+	ScopeName      string            `json:"scopeName"`
+	FileTypes      []string          `json:"fileTypes"`
+	FirstLineMatch string            `json:"firstLineMatch"`
+	Patterns       []tmRule          `json:"patterns"`
+	Repository     map[string]tmRule `json:"repository"`
+}
+
+// A tmConverter converts the rules of a TextMate grammar
+type tmConverter struct {
+	*converter
+	grammar *tmGrammar
+	// the repository entries that are being included, and $self for the
+	// grammar itself
+	including []string
+}
+
+func (c *converter) textMate(data []byte) error {
+	var g tmGrammar
+	if err := json.Unmarshal(data, &g); err != nil {
+		return err
+	}
+	c.setDetect(g.FileTypes, "firstLineMatch", g.FirstLineMatch)
+
+	t := &tmConverter{converter: c, grammar: &g}
+	t.rules("patterns", g.Patterns, &c.rules, false)
+	return nil
+}
+
+func (t *tmConverter) rules(where string, rules []tmRule, l *ruleList, inRegion bool) {
+	for i, r := range rules {
+		t.rule(fmt.Sprintf("%s[%d]", where, i), r, l, inRegion)
+	}
+}
+
+func (t *tmConverter) rule(where string, r tmRule, l *ruleList, inRegion bool) {
+	switch {
+	case r.Include != "":
+		t.include(where, r.Include, l, inRegion)
+	case r.Match != "":
+		group := scopeGroup(r.Name)
+		if group == "" {
+			for _, capture := range r.Captures {
+				if scopeGroup(capture.Name) != "" {
+					t.warn(where, "the scopes of captures are not supported, so the rule is left out")
+					break
+				}
+			}
+			return
+		}
+		if re, ok := t.regex(where, r.Match); ok {
+			l.pattern(group, re)
+		}
+	case r.Begin != "" && r.End == "":
+		t.warn(where, "begin/while rules are not supported, so the rule is left out")
+	case r.Begin != "":
+		start, ok := t.regex(where+".begin", r.Begin)
+		if !ok {
+			return
+		}
+		end, ok := t.regex(where+".end", r.End)
+		if !ok || !t.enterRegion(where) {
+			return
+		}
+		var inner ruleList
+		t.rules(where+".patterns", r.Patterns, &inner, true)
+		t.depth--
+
+		group := scopeGroup(r.Name)
+		if group == "" {
+			group = scopeGroup(r.ContentName)
+		}
+		if group == "" {
+			group = "default"
+		}
+		l.region(group, start, end, &inner)
+	default:
+		// a rule that only groups other rules
+		t.rules(where+".patterns", r.Patterns, l, inRegion)
+	}
+}
+
+func (t *tmConverter) include(where, include string, l *ruleList, inRegion bool) {
+	if include == t.grammar.ScopeName {
+		include = "$self"
+	}
+	switch {
+	case include == "$self" || include == "$base":
+		// at the top level, the rules of the grammar are already there
+		if inRegion && t.enter(where, "$self") {
+			t.rules("patterns", t.grammar.Patterns, l, inRegion)
+			t.leave()
+		}
+	case strings.HasPrefix(include, "#"):
+		name := include[1:]
+		r, ok := t.grammar.Repository[name]
+		if !ok {
+			t.warn(where, "the repository has no rule %q", name)
+		} else if t.enter(where, name) {
+			t.rule("repository."+name, r, l, inRegion)
+			t.leave()
+		}
+	case strings.Contains(include, "#"):
+		t.warn(where, "including a rule of another grammar (%s) is not supported", include)
+	default:
+		l.include(scopeFileType(include))
+	}
+}
+
+// enter adds a repository entry to those being included, or returns false
+// with a warning if it is already included
+func (t *tmConverter) enter(where, name string) bool {
+	for _, n := range t.including {
+		if n == name {
+			t.warn(where, "the recursive include of %s is not supported", name)
+			return false
+		}
+	}
+	t.including = append(t.including, name)
+	return true
+}
+
+func (t *tmConverter) leave() {
+	t.including = t.including[:len(t.including)-1]
+}
+
+// A sublimeSyntax is a Sublime Text syntax
+type sublimeSyntax struct {
+	FileExtensions []string                 `yaml:"file_extensions"`
+	FirstLineMatch string                   `yaml:"first_line_match"`
+	Scope          string                   `yaml:"scope"`
+	Variables      map[string]string        `yaml:"variables"`
+	Contexts       map[string][]interface{} `yaml:"contexts"`
+}
+
+// A sublimeConverter converts the contexts of a Sublime Text syntax
+type sublimeConverter struct {
+	*converter
+	syntax *sublimeSyntax
+	// the contexts that are being included or pushed
+	including []string
+}
+
+var yamlDirective = regexp.MustCompile(`^\s*%YAML[^\n]*\n`)
+
+var sublimeVariable = regexp.MustCompile(`\{\{(\w+)\}\}`)
+
+func (c *converter) sublime(data []byte) error {
+	// the yaml package only reads YAML 1.1 documents, but the 1.2 features
+	// are not used by syntaxes
+	data = yamlDirective.ReplaceAll(data, nil)
+
+	var s sublimeSyntax
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	main, ok := s.Contexts["main"]
+	if !ok {
+		return errors.New("the syntax has no main context")
+	}
+
+	sc := &sublimeConverter{converter: c, syntax: &s}
+	c.setDetect(s.FileExtensions, "first_line_match", sc.expand(s.FirstLineMatch))
+	sc.including = []string{"main"}
+	sc.context("contexts.main", main, &c.rules)
+	return nil
+}
+
+// expand replaces the variables in a regular expression
+func (s *sublimeConverter) expand(re string) string {
+	// variables may refer to other variables
+	for i := 0; i < 10 && strings.Contains(re, "{{"); i++ {
+		re = sublimeVariable.ReplaceAllStringFunc(re, func(v string) string {
+			return s.syntax.Variables[v[2:len(v)-2]]
+		})
+	}
+	return re
+}
+
+// context converts the rules of a context which is entered, preceded by
+// the rules of the prototype context unless the context excludes them
+func (s *sublimeConverter) context(where string, items []interface{}, l *ruleList) {
+	prototype := true
+	for _, item := range items {
+		if m, ok := item.(map[interface{}]interface{}); ok && m["meta_include_prototype"] == false {
+			prototype = false
+		}
+	}
+	if p, ok := s.syntax.Contexts["prototype"]; ok && prototype && s.enter(where, "prototype") {
+		s.rules("contexts.prototype", p, l)
+		s.leave()
+	}
+	s.rules(where, items, l)
+}
+
+func (s *sublimeConverter) rules(where string, items []interface{}, l *ruleList) {
+	for i, item := range items {
+		m, ok := item.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		w := fmt.Sprintf("%s[%d]", where, i)
+		if include, ok := m["include"].(string); ok {
+			s.include(w, include, l)
+		} else if _, ok := m["match"]; ok {
+			s.match(w, m, l)
+		}
+		// the meta keys of the context are used by the region pushing it
+	}
+}
+
+func (s *sublimeConverter) match(where string, m map[interface{}]interface{}, l *ruleList) {
+	for _, key := range []string{"set", "embed", "branch", "fail"} {
+		if _, ok := m[key]; ok {
+			s.warn(where, "%s is not supported, so the rule is left out", key)
+			return
+		}
+	}
+	push, isPush := m["push"]
+	if pop, ok := m["pop"]; ok {
+		if pop != true || isPush {
+			s.warn(where, "popping more than one context is not supported, so the rule is left out")
+		}
+		// the rules that pop a context end the region pushing it
+		return
+	}
+
+	match, _ := m["match"].(string)
+	re, ok := s.regex(where, s.expand(match))
+	if !ok {
+		return
+	}
+	scope, _ := m["scope"].(string)
+	if isPush {
+		s.push(where, re, scope, push, l)
+		return
+	}
+
+	group := scopeGroup(scope)
+	if group == "" {
+		if captures, ok := m["captures"].(map[interface{}]interface{}); ok {
+			for _, capture := range captures {
+				if c, ok := capture.(string); ok && scopeGroup(c) != "" {
+					s.warn(where, "the scopes of captures are not supported, so the rule is left out")
+					break
+				}
+			}
+		}
+		return
+	}
+	l.pattern(group, re)
+}
+
+// push converts a rule that pushes a context to a region, which starts
+// with the match of the rule and ends with a match of a rule that pops
+// the context
+func (s *sublimeConverter) push(where, start, scope string, push interface{}, l *ruleList) {
+	var name string
+	var items []interface{}
+	switch p := push.(type) {
+	case string:
+		var ok bool
+		if items, ok = s.syntax.Contexts[p]; !ok {
+			s.warn(where, "pushing %s, which is not a context of the syntax, is not supported, so the rule is left out", p)
+			return
+		}
+		name = p
+	case []interface{}:
+		if len(p) > 0 {
+			if _, ok := p[0].(string); ok {
+				s.warn(where, "pushing several contexts at once is not supported, so the rule is left out")
+				return
+			}
+		}
+		items = p
+	}
+	cw := "contexts." + name
+	if name == "" {
+		cw = where + ".push"
+	} else if !s.enter(where, name) {
+		return
+	}
+	defer func() {
+		if name != "" {
+			s.leave()
+		}
+	}()
+
+	var ends []string
+	s.pops(cw, items, &ends)
+	if len(ends) == 0 {
+		s.warn(where, "the pushed context is never popped by a supported rule, so the rule is left out")
+		return
+	}
+	if !s.enterRegion(where) {
+		return
+	}
+	var inner ruleList
+	s.context(cw, items, &inner)
+	s.depth--
+
+	group := ""
+	for _, item := range items {
+		m, _ := item.(map[interface{}]interface{})
+		if meta, ok := m["meta_scope"].(string); ok && group == "" {
+			group = scopeGroup(meta)
+		}
+		if meta, ok := m["meta_content_scope"].(string); ok && group == "" {
+			group = scopeGroup(meta)
+		}
+	}
+	if group == "" {
+		group = scopeGroup(scope)
+	}
+	if group == "" {
+		group = "default"
+	}
+	l.region(group, start, alternation(ends), &inner)
+}
+
+// pops collects the regular expressions of the rules that pop a context,
+// including the rules of the contexts it includes
+func (s *sublimeConverter) pops(where string, items []interface{}, ends *[]string) {
+	for i, item := range items {
+		m, _ := item.(map[interface{}]interface{})
+		w := fmt.Sprintf("%s[%d]", where, i)
+		if include, ok := m["include"].(string); ok {
+			if inc, ok := s.syntax.Contexts[include]; ok && s.enter(w, include) {
+				s.pops("contexts."+include, inc, ends)
+				s.leave()
+			}
+			continue
+		}
+		match, ok := m["match"].(string)
+		if !ok || m["pop"] != true {
+			continue
+		}
+		if _, ok := m["push"]; ok {
+			continue
+		}
+		if re, ok := s.regex(w, s.expand(match)); ok {
+			*ends = append(*ends, re)
+		}
+	}
+}
+
+func (s *sublimeConverter) include(where, include string, l *ruleList) {
+	if scope := strings.TrimPrefix(include, "scope:"); scope != include {
+		if scope == s.syntax.Scope {
+			include = "main"
+		} else if strings.Contains(scope, "#") {
+			s.warn(where, "including a context of another syntax (%s) is not supported", include)
+			return
+		} else {
+			l.include(scopeFileType(scope))
+			return
+		}
+	}
+	items, ok := s.syntax.Contexts[include]
+	if !ok {
+		s.warn(where, "including %s, which is not a context of the syntax, is not supported", include)
+		return
+	}
+	if s.enter(where, include) {
+		s.rules("contexts."+include, items, l)
+		s.leave()
+	}
+}
+
+// enter adds a context to those being included, or returns false with a
+// warning if it is already included
+func (s *sublimeConverter) enter(where, name string) bool {
+	for _, n := range s.including {
+		if n == name {
+			s.warn(where, "the recursive use of the %s context is not supported", name)
+			return false
+		}
+	}
+	s.including = append(s.including, name)
+	return true
+}
+
+func (s *sublimeConverter) leave() {
+	s.including = s.including[:len(s.including)-1]
+}
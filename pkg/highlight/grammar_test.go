@@ -0,0 +1,162 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testTextMate = `{
+	"name": "Test",
+	"scopeName": "source.test",
+	"fileTypes": ["tst"],
+	"patterns": [
+		{ "include": "#keywords" },
+		{ "include": "#strings" },
+		{ "match": "\\b\\h+h\\b", "name": "constant.numeric.hex.test" },
+		{ "match": "(?x) \\b fn \\s+ (\\w+)", "captures": { "1": { "name": "entity.name.function.test" } } },
+		{ "begin": "<<(\\w+)", "end": "^\\1$", "name": "string.unquoted.heredoc.test" },
+		{ "match": "\\w+(?=\\()", "name": "entity.name.function.test" }
+	],
+	"repository": {
+		"keywords": { "match": "\\b(if|else)\\b", "name": "keyword.control.test" },
+		"strings": {
+			"begin": "\"",
+			"end": "\"",
+			"name": "string.quoted.double.test",
+			"patterns": [
+				{ "match": "\\\\.", "name": "constant.character.escape.test" },
+				{ "include": "#strings" }
+			]
+		}
+	}
+}`
+
+const testSublime = `%YAML 1.2
+---
+name: Test
+file_extensions: [tst]
+scope: source.test
+variables:
+  ident: '[a-z]+'
+  keyword: 'if|{{ident}}_kw'
+contexts:
+  prototype:
+    - match: '#.*'
+      scope: comment.line.test
+  main:
+    - match: '\b(?:{{keyword}})\b'
+      scope: keyword.control.test
+    - match: "'"
+      push: string
+    - match: '\('
+      set: parens
+    - match: '\{'
+      push: [block, block]
+  string:
+    - meta_include_prototype: false
+    - meta_scope: string.quoted.single.test
+    - match: '\\.'
+      scope: constant.character.escape.test
+    - match: "'"
+      pop: true
+  parens:
+    - match: '\)'
+      pop: true
+  block:
+    - match: '(?=\})'
+      pop: true
+`
+
+func highlightGroups(t *testing.T, g *Grammar, line string) string {
+	f, err := ParseFile(g.Data)
+	assert.NoError(t, err)
+	header, err := MakeHeaderYaml(g.Data)
+	assert.NoError(t, err)
+	def, err := ParseDef(f, header)
+	assert.NoError(t, err)
+
+	matches := NewHighlighter(def).HighlightString(line)[0]
+	groups := make([]string, len(line))
+	var cur Group
+	for i := range line {
+		if g, ok := matches[i]; ok {
+			cur = g
+		}
+		groups[i] = cur.String()
+	}
+	// one letter per character, or - for none
+	letters := map[string]byte{
+		"":                     '-',
+		"comment":              'c',
+		"constant.number":      'n',
+		"constant.specialChar": 'e',
+		"constant.string":      's',
+		"statement":            'k',
+	}
+	var b strings.Builder
+	for _, g := range groups {
+		b.WriteByte(letters[g])
+	}
+	return b.String()
+}
+
+func TestConvertTextMate(t *testing.T) {
+	g, err := ConvertGrammar("dir/Test.tmLanguage.json", "", []byte(testTextMate))
+	assert.NoError(t, err)
+	assert.Equal(t, "test", g.FileType)
+	assert.Equal(t, []string{
+		"repository.strings.patterns[1]: the recursive include of strings is not supported",
+		"patterns[3]: the scopes of captures are not supported, so the rule is left out",
+		"patterns[4].end: a backreference is not supported, so the rule is left out",
+		"patterns[5]: a lookahead is not supported, so the rule is left out",
+	}, g.Warnings)
+
+	header, err := MakeHeaderYaml(g.Data)
+	assert.NoError(t, err)
+	assert.Equal(t, "test", header.FileType)
+	assert.True(t, MatchFiletype(header.FtDetect, "src/main.tst", nil))
+
+	assert.Equal(t,
+		"kkkk-kk-nnnn-sseess-----",
+		highlightGroups(t, g, `else if 0fah "a\"b" fn x`))
+}
+
+func TestConvertSublime(t *testing.T) {
+	g, err := ConvertGrammar("Test.sublime-syntax", "tst", []byte(testSublime))
+	assert.NoError(t, err)
+	assert.Equal(t, "tst", g.FileType)
+	assert.Equal(t, []string{
+		"contexts.main[2]: set is not supported, so the rule is left out",
+		"contexts.main[3]: pushing several contexts at once is not supported, so the rule is left out",
+	}, g.Warnings)
+
+	assert.Equal(t,
+		"-kkkkkk-kk-sseess-cccc",
+		highlightGroups(t, g, ` foo_kw if 'a\'#' # if`))
+}
+
+func TestTranslateRegex(t *testing.T) {
+	tests := []struct {
+		re, translated, unsupported string
+	}{
+		{`(?x) a b # comment`, `ab`, ""},
+		{`[\h_]\h`, `[0-9a-fA-F_][0-9a-fA-F]`, ""},
+		{`[[:alpha:]]++`, `[[:alpha:]]+`, ""},
+		{`(?<name>a)(?>b)`, `(?P<name>a)(?:b)`, ""},
+		{`a$\n?`, `a$$?`, ""},
+		{`[]\n]`, `[\]\n]`, ""},
+		{`(a)\1`, "", "a backreference"},
+		{`(?<=a)b`, "", "a lookbehind"},
+		{`\Gab`, "", `the \G anchor`},
+		{`[a-z[0-9]]`, "", "a nested character class"},
+		{`[a-z&&[^aeiou]]`, "", "a character class intersection"},
+		{`[&]&&`, `[&]&&`, ""},
+	}
+	for _, test := range tests {
+		translated, unsupported := translateRegex(test.re)
+		assert.Equal(t, test.translated, translated, test.re)
+		assert.Equal(t, test.unsupported, unsupported, test.re)
+	}
+}
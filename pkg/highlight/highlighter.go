@@ -67,6 +67,15 @@ func combineLineMatch(src, dst LineMatch) LineMatch {
 // A State represents the region at the end of a line
 type State *region
 
+// ParentState returns the state of the region that a region is nested in,
+// or nil if it is not nested in another region
+func ParentState(s State) State {
+	if s == nil {
+		return nil
+	}
+	return s.parent
+}
+
 // EmptyDef is an empty definition.
 var EmptyDef = Def{nil, &rules{}}
 
@@ -396,3 +405,19 @@ func (h *Highlighter) ReHighlightLine(input LineStates, lineN int) {
 	input.SetMatch(lineN, match)
 	input.SetState(lineN, curState)
 }
+
+// HighlightLine highlights a line that starts in the given state and
+// returns its matches and the state at its end. The highlighter must not be
+// used by several goroutines at once
+func (h *Highlighter) HighlightLine(line []byte, lineN int, state State) (LineMatch, State) {
+	highlights := make(LineMatch)
+	h.lastRegion = nil
+
+	var match LineMatch
+	if lineN == 0 || state == nil {
+		match = h.highlightEmptyRegion(highlights, 0, true, lineN, line, false)
+	} else {
+		match = h.highlightRegion(highlights, 0, true, lineN, line, state, false)
+	}
+	return match, h.lastRegion
+}
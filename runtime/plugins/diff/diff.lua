@@ -4,8 +4,8 @@ local os = import("os")
 local filepath = import("path/filepath")
 local shell = import("micro/shell")
 
-function onBufferOpen(buf)
-	if buf.Settings["diffgutter"] and (not buf.Type.Scratch) and (buf.Path ~= "") then
+function updateDiffBase(buf)
+	if (not buf.Type.Scratch) and (buf.Path ~= "") then
 		-- check that file exists
 		local _, err = os.Stat(buf.AbsPath)
 		if err == nil then
@@ -18,3 +18,17 @@ function onBufferOpen(buf)
 		end
 	end
 end
+
+function onBufferOpen(buf)
+	if buf.Settings["diffgutter"] then
+		updateDiffBase(buf)
+	end
+end
+
+function onBufferOptionChanged(buf, option, oldValue, newValue)
+	-- the diff base is not loaded for buffers that were opened with
+	-- diffgutter off (for example large files)
+	if option == "diffgutter" and newValue and not oldValue then
+		updateDiffBase(buf)
+	end
+end